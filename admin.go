@@ -0,0 +1,140 @@
+package roth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/kvantetore/rothTouchline/protocol"
+)
+
+//ErrNotConfirmed is returned by administrative operations called without confirmation
+var ErrNotConfirmed = errors.New("operation not confirmed")
+
+//controller items for administrative commands. No documentation of the items of the controller
+//is published, and these names are unconfirmed on real firmware, so commands check that the
+//controller knows their item before writing it, see Admin.command.
+const (
+	rebootItem       = "R0.Reboot"
+	saveSettingsItem = "R0.SaveSettings"
+)
+
+//controllerItems are the known items of the controller itself, read by DumpDatapoints
+var controllerItems = []string{
+	"totalNumberOfDevices",
+	pairedDevicesItem,
+	pairingStateItem,
+	"R0.SystemStatus",
+	"STELL-APP",
+	"STELL-BL",
+	"hw.IP",
+	"hw.NM",
+	"hw.GW",
+	"hw.HostName",
+	testModeItem,
+	pumpTestItem,
+}
+
+//Admin performs administrative operations on the controller. Operations changing the controller
+//state require an explicit confirmation argument, as they are disruptive.
+type Admin struct {
+	client *Client
+}
+
+//Admin returns the administrative operations of the controller
+func (c *Client) Admin() *Admin {
+	return &Admin{client: c}
+}
+
+//Reboot restarts the controller, e.g. when its web interface stops responding. The controller is
+//unreachable for up to a minute afterwards. confirm must be true.
+func (a *Admin) Reboot(ctx context.Context, confirm bool) error {
+	if !confirm {
+		return ErrNotConfirmed
+	}
+	//the controller restarts rather than reporting the item, so it is not read back
+	a.client.logf(LogInfo, "rebooting controller")
+	err := a.command(ctx, rebootItem, "1", false)
+	a.client.InvalidateCache()
+	return err
+}
+
+//SaveSettings makes the controller store its current settings permanently, on firmware which
+//keeps changes in volatile memory until saved. confirm must be true.
+func (a *Admin) SaveSettings(ctx context.Context, confirm bool) error {
+	if !confirm {
+		return ErrNotConfirmed
+	}
+	return a.command(ctx, saveSettingsItem, "1", false)
+}
+
+//command writes an item of the controller, after checking that the controller knows it: unknown
+//items are reported empty, and writes to them are acknowledged like any other, so the command
+//would silently do nothing. If readBack is set, the item is read back after the write, and a
+//*WriteError returned if the controller did not apply the value.
+func (a *Admin) command(ctx context.Context, item string, value string, readBack bool) error {
+	return a.write(ctx, item, value, readBack, func() error {
+		return a.client.writeControllerValue(ctx, item, value)
+	})
+}
+
+//write performs a write of command, see there
+func (a *Admin) write(ctx context.Context, item string, value string, readBack bool, write func() error) error {
+	values, err := a.client.ReadRaw(ctx, item)
+	var respErr *ResponseError
+	if err != nil && !errors.As(err, &respErr) {
+		return err
+	}
+	if values[item] == "" {
+		return fmt.Errorf("%v: %w", item, ErrUnsupported)
+	}
+	if err := write(); err != nil || !readBack || a.client.DryRun {
+		return err
+	}
+
+	select {
+	case <-time.After(a.client.verifyDelay()):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	values, err = a.client.ReadRaw(ctx, item)
+	if err != nil && !errors.As(err, &respErr) {
+		return fmt.Errorf("error verifying write: %w", err)
+	}
+	if actual := values[item]; !sameValue(actual, value) {
+		sensorID := -1
+		if id, _, ok := protocol.SplitItemName(item); ok {
+			sensorID, _ = strconv.Atoi(id)
+		}
+		return &WriteError{SensorID: sensorID, Datapoint: item, Written: value, Actual: actual}
+	}
+	return nil
+}
+
+//DumpDatapoints reads every known item of the controller and of each sensor, unparsed, for
+//support requests and backups. Items the firmware does not have are left out.
+func (a *Admin) DumpDatapoints(ctx context.Context, sensorCount int) (map[string]string, error) {
+	names := append([]string{}, controllerItems...)
+	for _, id := range a.client.sensorIDs(sensorCount) {
+		for _, d := range a.client.datapoints() {
+			names = append(names, fmt.Sprintf("G%v.%v", id, d.Name))
+		}
+		for _, datapoint := range []string{offsetDatapoint, "SWVersion", "HWVersion", "kurzID", "ownerKurzID"} {
+			names = append(names, fmt.Sprintf("G%v.%v", id, datapoint))
+		}
+	}
+
+	values, err := a.client.ReadRaw(ctx, names...)
+	var respErr *ResponseError
+	if err != nil && !errors.As(err, &respErr) {
+		return nil, err
+	}
+	for name, value := range values {
+		if value == "" {
+			delete(values, name)
+		}
+	}
+	return values, nil
+}
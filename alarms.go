@@ -0,0 +1,104 @@
+package roth
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//alarmDatapoint is the error code of a thermostat, read if Client.ReadAlarms is set
+const alarmDatapoint = "ErrorCode"
+
+//error bits reported by the controller, for thermostats and the controller itself
+const (
+	alarmBitRadio       = 0
+	alarmBitSensorFault = 1
+)
+
+//Alarms are the error conditions reported by the controller, for a thermostat or, in
+//ControllerState, for the controller itself
+type Alarms struct {
+	//Radio is set on radio communication errors, e.g. a thermostat out of range or with empty
+	//batteries, or for the controller, any thermostat it lost contact with
+	Radio bool `json:"radio"`
+	//SensorFault is set when a temperature sensor fails, e.g. a broken floor sensor
+	SensorFault bool `json:"sensorFault"`
+	//Code holds all error bits as reported, including bits without a field of their own
+	Code ErrorFlags `json:"code"`
+}
+
+//AlarmsOf interprets the error bits reported by the controller
+func AlarmsOf(code ErrorFlags) Alarms {
+	return Alarms{
+		Radio:       code.Has(alarmBitRadio),
+		SensorFault: code.Has(alarmBitSensorFault),
+		Code:        code,
+	}
+}
+
+//Active returns whether any error bit is set
+func (a Alarms) Active() bool {
+	return a.Code != 0
+}
+
+//String lists the active alarms, e.g. "radio|bit4", or "none"
+func (a Alarms) String() string {
+	var names []string
+	for bit := uint(0); bit < 32; bit++ {
+		if !a.Code.Has(bit) {
+			continue
+		}
+		switch bit {
+		case alarmBitRadio:
+			names = append(names, "radio")
+		case alarmBitSensorFault:
+			names = append(names, "sensorFault")
+		default:
+			names = append(names, "bit"+strconv.Itoa(int(bit)))
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+func parseAlarms(s *Sensor, value string) error {
+	code, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return errors.New("invalid numeric value")
+	}
+	alarms := AlarmsOf(ErrorFlags(code))
+	s.Alarms = &alarms
+	return nil
+}
+
+//AlarmsChanged is published by a Watcher when the alarms of a thermostat, or with
+//WatchControllerState of the controller, change
+type AlarmsChanged struct {
+	Time time.Time
+	//SensorID is the thermostat, or -1 for the controller
+	SensorID int
+	Previous Alarms
+	Current  Alarms
+}
+
+//EventTime returns when the change was seen
+func (e AlarmsChanged) EventTime() time.Time { return e.Time }
+
+//alarmChanges returns the alarm changes between two polls
+func alarmChanges(poll Poll, last map[int]Sensor, lastController *ControllerState) []AlarmsChanged {
+	var changes []AlarmsChanged
+	for _, s := range poll.Sensors {
+		previous, ok := last[s.Id]
+		if !ok || previous.Alarms == nil || s.Alarms == nil || *previous.Alarms == *s.Alarms {
+			continue
+		}
+		changes = append(changes, AlarmsChanged{Time: poll.Time, SensorID: s.Id, Previous: *previous.Alarms, Current: *s.Alarms})
+	}
+	if poll.Controller != nil && lastController != nil && lastController.Alarms != poll.Controller.Alarms {
+		changes = append(changes, AlarmsChanged{Time: poll.Time, SensorID: -1, Previous: lastController.Alarms, Current: poll.Controller.Alarms})
+	}
+	return changes
+}
@@ -0,0 +1,368 @@
+//Package alert raises alerts on conditions held for a period of time, like a room staying below
+//a frost protection threshold or the controller being unreachable, and delivers them through
+//pluggable notifiers. Conditions on other datapoints, like the battery state on firmware exposing
+//it, can be expressed with a custom Rule.Check, and conditions given in configuration files with
+//Expression. Notifications are delivered in the background once the monitor is started.
+package alert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/expr"
+)
+
+//Rule describes a condition which raises an alert when it has held for a period of time
+type Rule struct {
+	Name string
+	//For is how long the condition must hold before the alert triggers
+	For time.Duration
+	//Check tests the condition against a poll, and returns a message describing the situation
+	Check func(p roth.Poll) (active bool, message string)
+	//EvaluateOnError makes the rule evaluated on failed polls too. Rules on sensor values
+	//keep their state while the controller can not be read.
+	EvaluateOnError bool
+}
+
+//RoomBelow alerts when the room temperature of a sensor stays below threshold for duration d.
+//The threshold is in the unit of the client.
+func RoomBelow(name string, sensorID int, threshold float32, d time.Duration) Rule {
+	return Rule{Name: name, For: d, Check: func(p roth.Poll) (bool, string) {
+		for _, s := range p.Sensors {
+			if s.Id == sensorID && s.Valid.Has(roth.FieldRoomTemperature) {
+				return s.RoomTemperature < threshold,
+					fmt.Sprintf("%v is %.1f %v, threshold %.1f %v", s.Name, s.RoomTemperature, s.Unit, threshold, s.Unit)
+			}
+		}
+		return false, ""
+	}}
+}
+
+//RoomAbove alerts when the room temperature of a sensor stays above threshold for duration d.
+//The threshold is in the unit of the client.
+func RoomAbove(name string, sensorID int, threshold float32, d time.Duration) Rule {
+	return Rule{Name: name, For: d, Check: func(p roth.Poll) (bool, string) {
+		for _, s := range p.Sensors {
+			if s.Id == sensorID && s.Valid.Has(roth.FieldRoomTemperature) {
+				return s.RoomTemperature > threshold,
+					fmt.Sprintf("%v is %.1f %v, threshold %.1f %v", s.Name, s.RoomTemperature, s.Unit, threshold, s.Unit)
+			}
+		}
+		return false, ""
+	}}
+}
+
+//Expression alerts when an expression of package expr holds for duration d, e.g.
+//"sensor('Bath').Room < sensor('Bath').Target - 2 && hour() >= 22". An expression which can not
+//be evaluated, e.g. as a sensor it refers to was not read, does not hold, and is reported in the
+//message.
+func Expression(name string, expression string, d time.Duration) (Rule, error) {
+	compiled, err := expr.Compile(expression)
+	if err != nil {
+		return Rule{}, err
+	}
+	return Rule{Name: name, For: d, Check: func(p roth.Poll) (bool, string) {
+		active, err := compiled.Bool(expr.Env{Sensors: p.Sensors, Time: p.Time})
+		if err != nil {
+			return false, fmt.Sprintf("%v: %v", expression, err)
+		}
+		return active, expression
+	}}, nil
+}
+
+//Unreachable alerts when the controller can not be read for duration d
+func Unreachable(name string, d time.Duration) Rule {
+	return Rule{Name: name, For: d, EvaluateOnError: true, Check: func(p roth.Poll) (bool, string) {
+		if p.Err != nil {
+			return true, fmt.Sprintf("controller unreachable: %v", p.Err)
+		}
+		return false, "controller reachable"
+	}}
+}
+
+//State is the state of an alert
+type State int
+
+const (
+	//Triggered means the condition has held for the configured duration
+	Triggered State = iota
+	//Resolved means the condition of a triggered alert no longer holds
+	Resolved
+)
+
+func (s State) String() string {
+	if s == Triggered {
+		return "triggered"
+	}
+	return "resolved"
+}
+
+//Notification is delivered to notifiers when an alert triggers or resolves
+type Notification struct {
+	Alert   string
+	State   State
+	Message string
+	Time    time.Time
+	//Since is when the condition started to hold
+	Since time.Time
+}
+
+func (n Notification) String() string {
+	return fmt.Sprintf("[%v] %v: %v", n.State, n.Alert, n.Message)
+}
+
+//AlertTriggered is published on the event bus of the client when an alert of an attached
+//monitor triggers
+type AlertTriggered struct {
+	Notification
+}
+
+//AlertResolved is published on the event bus of the client when an alert of an attached
+//monitor resolves
+type AlertResolved struct {
+	Notification
+}
+
+//EventTime returns when the alert triggered
+func (e AlertTriggered) EventTime() time.Time { return e.Time }
+
+//EventTime returns when the alert resolved
+func (e AlertResolved) EventTime() time.Time { return e.Time }
+
+//Notifier delivers notifications, e.g. by mail or to a webhook
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+//NotifierFunc adapts an ordinary function to the Notifier interface
+type NotifierFunc func(ctx context.Context, n Notification) error
+
+//Notify calls f(ctx, n)
+func (f NotifierFunc) Notify(ctx context.Context, n Notification) error {
+	return f(ctx, n)
+}
+
+type ruleState struct {
+	since     time.Time
+	triggered bool
+}
+
+//Monitor evaluates alert rules on every poll and notifies on transitions. Notifications of
+//attached watchers and of frost protection are delivered from a background queue, see Start, so
+//slow notifiers do not hold up polling.
+type Monitor struct {
+	notifiers []Notifier
+
+	//OnError is called when a notifier fails, or a notification was dropped because the queue
+	//was full or the monitor stopped
+	OnError func(n Notification, err error)
+	//Timeout limits the time each notifier may take. If zero, 30 seconds are used.
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	rules []Rule
+	state map[string]*ruleState
+	//bus receives alert events once the monitor is attached to a watcher
+	bus *roth.Bus
+
+	queue chan Notification
+	//queued counts the notifications queued and not yet delivered, for draining the queue on Stop
+	queued  sync.WaitGroup
+	runner  roth.Runner
+	stopped bool
+}
+
+//NewMonitor creates a monitor delivering notifications to all given notifiers
+func NewMonitor(notifiers ...Notifier) *Monitor {
+	return &Monitor{
+		notifiers: notifiers,
+		state:     make(map[string]*ruleState),
+		queue:     make(chan Notification, 100),
+	}
+}
+
+//Add adds an alert rule
+func (m *Monitor) Add(r Rule) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rules = append(m.rules, r)
+	m.state[r.Name] = &ruleState{}
+}
+
+//Active returns the names of the currently triggered alerts
+func (m *Monitor) Active() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var names []string
+	for _, r := range m.rules {
+		if m.state[r.Name].triggered {
+			names = append(names, r.Name)
+		}
+	}
+	return names
+}
+
+//Attach evaluates the rules on every poll of the watcher, and queues the notifications for
+//delivery by Start
+func (m *Monitor) Attach(w *roth.Watcher) {
+	m.mu.Lock()
+	m.bus = w.Client().Events()
+	m.mu.Unlock()
+	w.Subscribe(func(p roth.Poll) {
+		for _, n := range m.evaluate(p) {
+			m.Enqueue(n)
+		}
+	})
+}
+
+//NotifyFrostProtection notifies about every roth.FrostProtection event of the client as an
+//alert, so a script trying to set a room below its frost minimum does not go unnoticed.
+//Notifications are queued for delivery by Start, as the events are published by writes.
+func (m *Monitor) NotifyFrostProtection(client *roth.Client) (unsubscribe func()) {
+	return client.Events().Subscribe(func(e roth.Event) {
+		if e, ok := e.(roth.FrostProtection); ok {
+			m.Enqueue(Notification{Alert: "frost protection", State: Triggered, Message: e.String(), Time: e.Time, Since: e.Time})
+		}
+	})
+}
+
+//Evaluate checks all rules against a poll, and sends notifications for alerts triggering or
+//resolving. Unlike the notifications of an attached watcher, they are sent before it returns.
+func (m *Monitor) Evaluate(ctx context.Context, p roth.Poll) []Notification {
+	notifications := m.evaluate(p)
+	for _, n := range notifications {
+		m.notify(ctx, n)
+	}
+	return notifications
+}
+
+//evaluate checks all rules against a poll, and publishes events for alerts triggering or
+//resolving
+func (m *Monitor) evaluate(p roth.Poll) []Notification {
+	var notifications []Notification
+
+	m.mu.Lock()
+	for _, r := range m.rules {
+		if p.Err != nil && !r.EvaluateOnError {
+			continue
+		}
+
+		st := m.state[r.Name]
+		active, message := r.Check(p)
+		switch {
+		case active && st.since.IsZero():
+			st.since = p.Time
+		case !active && st.triggered:
+			notifications = append(notifications, Notification{Alert: r.Name, State: Resolved, Message: message, Time: p.Time, Since: st.since})
+			st.triggered = false
+		}
+		if !active {
+			st.since = time.Time{}
+			continue
+		}
+		if !st.triggered && p.Time.Sub(st.since) >= r.For {
+			st.triggered = true
+			notifications = append(notifications, Notification{Alert: r.Name, State: Triggered, Message: message, Time: p.Time, Since: st.since})
+		}
+	}
+	bus := m.bus
+	m.mu.Unlock()
+
+	if bus != nil {
+		for _, n := range notifications {
+			if n.State == Triggered {
+				bus.Publish(AlertTriggered{n})
+			} else {
+				bus.Publish(AlertResolved{n})
+			}
+		}
+	}
+	return notifications
+}
+
+//Enqueue queues a notification for delivery by Run. The notification is dropped if the queue is
+//full, or the monitor was stopped.
+func (m *Monitor) Enqueue(n Notification) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		if m.OnError != nil {
+			m.OnError(n, roth.ErrStopped)
+		}
+		return
+	}
+	m.queued.Add(1)
+	select {
+	case m.queue <- n:
+	default:
+		m.queued.Done()
+		if m.OnError != nil {
+			m.OnError(n, errors.New("alert queue full, notification dropped"))
+		}
+	}
+}
+
+//Run delivers queued notifications until the context is cancelled
+func (m *Monitor) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-m.queue:
+			m.notify(ctx, n)
+			m.queued.Done()
+		}
+	}
+}
+
+//Start delivers queued notifications in the background, see Run. It accepts notifications again
+//after Stop.
+func (m *Monitor) Start(ctx context.Context) error {
+	m.mu.Lock()
+	m.stopped = false
+	m.mu.Unlock()
+	return m.runner.Start(ctx, m.Run)
+}
+
+//Stop drops further notifications, waits until the queued ones are delivered, and stops
+//delivering. Notifications still queued when ctx expires are not delivered.
+func (m *Monitor) Stop(ctx context.Context) error {
+	m.mu.Lock()
+	m.stopped = true
+	m.mu.Unlock()
+
+	if m.runner.Running() {
+		delivered := make(chan struct{})
+		go func() {
+			m.queued.Wait()
+			close(delivered)
+		}()
+		select {
+		case <-delivered:
+		case <-ctx.Done():
+			m.runner.Stop(ctx)
+			return ctx.Err()
+		}
+	}
+	return m.runner.Stop(ctx)
+}
+
+func (m *Monitor) notify(ctx context.Context, n Notification) {
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	for _, notifier := range m.notifiers {
+		notifyCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := notifier.Notify(notifyCtx, n)
+		cancel()
+		if err != nil && m.OnError != nil {
+			m.OnError(n, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package alert
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/kvantetore/rothTouchline/internal/mqttwire"
+)
+
+//WebhookNotifier posts notifications as json to a url
+type WebhookNotifier struct {
+	URL string
+	//HTTPClient is used for the requests. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+}
+
+type webhookPayload struct {
+	Alert   string    `json:"alert"`
+	State   string    `json:"state"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+	Since   time.Time `json:"since"`
+}
+
+//Notify posts the notification
+func (w *WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(webhookPayload{n.Alert, n.State.String(), n.Message, n.Time, n.Since})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := w.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %v", resp.Status)
+	}
+	return nil
+}
+
+//SMTPNotifier sends notifications by mail
+type SMTPNotifier struct {
+	//Addr is the host:port of the mail server
+	Addr string
+	//Auth is used if the server requires authentication, e.g. smtp.PlainAuth
+	Auth smtp.Auth
+	From string
+	To   []string
+}
+
+//Notify sends the notification as a plain text mail. The context is not honored by net/smtp.
+func (s *SMTPNotifier) Notify(ctx context.Context, n Notification) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %v\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %v\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&msg, "Subject: [%v] %v\r\n", n.State, n.Alert)
+	fmt.Fprintf(&msg, "Date: %v\r\n", n.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%v\r\n\r\nCondition holding since %v\r\n", n.Message, n.Since.Format(time.RFC1123Z))
+
+	return smtp.SendMail(s.Addr, s.Auth, s.From, s.To, msg.Bytes())
+}
+
+//MQTTNotifier publishes notifications as json to an MQTT topic. It implements the minimal
+//subset of MQTT 3.1.1 needed: each notification opens a connection, publishes with QoS 0 and
+//disconnects.
+type MQTTNotifier struct {
+	//Addr is the host:port of the broker
+	Addr     string
+	Topic    string
+	ClientID string
+	Username string
+	Password string
+	//Retain sets the retain flag on published messages
+	Retain bool
+}
+
+//Notify publishes the notification
+func (m *MQTTNotifier) Notify(ctx context.Context, n Notification) error {
+	payload, err := json.Marshal(webhookPayload{n.Alert, n.State.String(), n.Message, n.Time, n.Since})
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	clientID := m.ClientID
+	if clientID == "" {
+		clientID = fmt.Sprintf("roth-alert-%d", time.Now().UnixNano()%1000000)
+	}
+
+	err = mqttwire.WritePacket(conn, mqttwire.PacketConnect, mqttwire.ConnectPacket(mqttwire.ConnectOptions{
+		ClientID:  clientID,
+		Username:  m.Username,
+		Password:  m.Password,
+		KeepAlive: 30,
+	}))
+	if err != nil {
+		return err
+	}
+	header, body, err := mqttwire.ReadPacket(bufio.NewReader(conn))
+	if err != nil {
+		return err
+	}
+	if err := mqttwire.CheckConnAck(header, body); err != nil {
+		return err
+	}
+
+	header, body = mqttwire.PublishPacket(m.Topic, payload, m.Retain)
+	if err := mqttwire.WritePacket(conn, header, body); err != nil {
+		return err
+	}
+	return mqttwire.WritePacket(conn, mqttwire.PacketDisconnect, nil)
+}
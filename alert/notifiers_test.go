@@ -0,0 +1,72 @@
+package alert
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kvantetore/rothTouchline/internal/mqttwire"
+)
+
+func TestMQTTNotifier(t *testing.T) {
+	for _, code := range []byte{0, 5} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen: %v", err)
+		}
+		defer l.Close()
+
+		//the broker acknowledges with code, and returns the packets received after the connect
+		received := make(chan []byte, 10)
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			defer close(received)
+			r := bufio.NewReader(conn)
+			for {
+				header, body, err := mqttwire.ReadPacket(r)
+				if err != nil {
+					return
+				}
+				if header == mqttwire.PacketConnect {
+					mqttwire.WritePacket(conn, mqttwire.PacketConnAck, []byte{0, code})
+					continue
+				}
+				received <- append([]byte{header}, body...)
+			}
+		}()
+
+		n := &MQTTNotifier{Addr: l.Addr().String(), Topic: "roth/alerts", Retain: true}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err = n.Notify(ctx, Notification{Alert: "cold", State: Triggered, Message: "Bedroom is at 15.0°C", Time: time.Now(), Since: time.Now()})
+		if code != 0 {
+			if err == nil {
+				t.Errorf("Notify succeeded after the broker refused with code %v", code)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Notify: %v", err)
+		}
+
+		publish := <-received
+		topic, payload, err := mqttwire.ParsePublish(publish[0], publish[1:])
+		if publish[0] != mqttwire.PacketPublish|0x01 || err != nil || topic != "roth/alerts" {
+			t.Fatalf("got packet % x, want a retained publish to roth/alerts", publish)
+		}
+		var message map[string]interface{}
+		if err := json.Unmarshal(payload, &message); err != nil || message["alert"] != "cold" || message["state"] != "triggered" {
+			t.Errorf("got payload %s (%v)", payload, err)
+		}
+		if disconnect := <-received; len(disconnect) != 1 || disconnect[0] != mqttwire.PacketDisconnect {
+			t.Errorf("got packet % x, want disconnect", disconnect)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package roth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kvantetore/rothTouchline/protocol"
+)
+
+//builtinAliases maps English names to the German datapoint names used by the controller. Sensor
+//datapoints are used without the sensor prefix, e.g. G3.targetTemperature for G3.SollTemp;
+//controller items are used as is, e.g. deviceCount for totalNumberOfDevices.
+var builtinAliases = map[string]string{
+	//sensor datapoints
+	"roomTemperature":   "RaumTemp",
+	"targetTemperature": "SollTemp",
+	"weekProgram":       "WeekProg",
+	"operatingMode":     "OPMode",
+	"temperatureUnit":   "TempSIUnit",
+	"temperatureOffset": offsetDatapoint,
+	"softwareVersion":   "SWVersion",
+	"hardwareVersion":   "HWVersion",
+	"uniqueId":          uniqueIDDatapoint,
+	"ownerUniqueId":     "ownerKurzID",
+
+	//controller items
+	"deviceCount":   deviceCountItem,
+	"pairedDevices": pairedDevicesItem,
+	"pairingState":  pairingStateItem,
+	"systemStatus":  systemStatusItem,
+	"errorCode":     errorFlagsItem,
+	"dateTime":      controllerTimeItem,
+	"testMode":      testModeItem,
+	"pumpTest":      pumpTestItem,
+}
+
+//builtinEnglish maps the datapoint names to their English names, for messages
+var builtinEnglish = func() map[string]string {
+	english := make(map[string]string, len(builtinAliases))
+	for alias, name := range builtinAliases {
+		english[strings.ToLower(name)] = alias
+	}
+	return english
+}()
+
+//RegisterAlias adds an alternative name of a datapoint or controller item, accepted by ReadRaw,
+//ReadDecoded and WriteDatapoint in place of the controller name, e.g.
+//RegisterAlias("floorTemperature", "BodenTemp") to read G2.floorTemperature. Aliases are matched
+//case insensitively, and replace built-in aliases of the same name. Like the other client
+//settings, aliases must be registered before first use of the client.
+func (c *Client) RegisterAlias(alias string, name string) error {
+	if alias == "" || name == "" || strings.ContainsAny(alias, ". ") {
+		return fmt.Errorf("invalid alias %q for %q", alias, name)
+	}
+	if c.aliases == nil {
+		c.aliases = make(map[string]string)
+	}
+	c.aliases[strings.ToLower(alias)] = name
+	return nil
+}
+
+//Aliases returns all aliases known to the client, built-in and registered, mapped to the
+//controller names
+func (c *Client) Aliases() map[string]string {
+	aliases := make(map[string]string, len(builtinAliases)+len(c.aliases))
+	for alias, name := range builtinAliases {
+		aliases[alias] = name
+	}
+	for alias, name := range c.aliases {
+		for builtin := range builtinAliases {
+			if strings.EqualFold(builtin, alias) {
+				delete(aliases, builtin)
+			}
+		}
+		aliases[alias] = name
+	}
+	return aliases
+}
+
+//resolveAlias returns the controller name of a datapoint or controller item, which is the name
+//itself if it is not an alias
+func (c *Client) resolveAlias(name string) string {
+	lower := strings.ToLower(name)
+	if resolved, ok := c.aliases[lower]; ok {
+		return resolved
+	}
+	for alias, resolved := range builtinAliases {
+		if strings.ToLower(alias) == lower {
+			return resolved
+		}
+	}
+	return name
+}
+
+//resolveItem translates the aliases in an item name, e.g. G3.targetTemperature to G3.SollTemp
+func (c *Client) resolveItem(name string) string {
+	if id, datapoint, ok := protocol.SplitItemName(name); ok {
+		return "G" + id + "." + c.resolveAlias(datapoint)
+	}
+	return c.resolveAlias(name)
+}
+
+//EnglishName returns the English name of a datapoint or controller item, e.g. targetTemperature
+//for SollTemp, or "" if it has none
+func EnglishName(name string) string {
+	if _, datapoint, ok := protocol.SplitItemName(name); ok {
+		name = datapoint
+	}
+	return builtinEnglish[strings.ToLower(name)]
+}
+
+//describeItem returns an item name followed by its English name if it has one, e.g.
+//G3.SollTemp [targetTemperature], for messages
+func describeItem(name string) string {
+	if english := EnglishName(name); english != "" {
+		return fmt.Sprintf("%v [%v]", name, english)
+	}
+	return name
+}
@@ -0,0 +1,319 @@
+//Package anomaly flags rooms behaving unlike a working floor heating: a room not warming up
+//although its valve has been open for hours (stuck actuator), a sensor reporting the same value
+//for a long time (dead battery or lost radio link), and a runaway temperature far above the
+//target or rising unusually fast.
+package anomaly
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/alert"
+)
+
+//Kind is a kind of anomaly
+type Kind int
+
+const (
+	//StuckActuator means the room has not warmed although its valve has been open for a while
+	StuckActuator Kind = iota
+	//Flatline means the sensor has reported the same room temperature for a long time
+	Flatline
+	//Runaway means the room temperature is far above the target, or rising too fast
+	Runaway
+)
+
+func (k Kind) String() string {
+	switch k {
+	case StuckActuator:
+		return "stuck actuator"
+	case Flatline:
+		return "flatline"
+	case Runaway:
+		return "runaway"
+	}
+	return fmt.Sprintf("kind %d", int(k))
+}
+
+//Tunables configure the detection for a room. Zero durations and thresholds disable a check.
+type Tunables struct {
+	//StuckAfter is how long the valve must be open without the room warming by MinRise
+	StuckAfter time.Duration
+	MinRise    float32
+	//FlatlineAfter is how long the room temperature must be unchanged
+	FlatlineAfter time.Duration
+	//RunawayMargin is how far above the target the room temperature may rise
+	RunawayMargin float32
+	//MaxRate is the fastest plausible rise of the room temperature, in degrees per hour,
+	//measured over RateWindow
+	MaxRate    float32
+	RateWindow time.Duration
+}
+
+//DefaultTunables suit a typical floor heating, which warms a room by a few tenths of a degree
+//per hour
+var DefaultTunables = Tunables{
+	StuckAfter:    4 * time.Hour,
+	MinRise:       0.3,
+	FlatlineAfter: 12 * time.Hour,
+	RunawayMargin: 3,
+	MaxRate:       2,
+	RateWindow:    30 * time.Minute,
+}
+
+//Anomaly is a detected anomaly of a room
+type Anomaly struct {
+	SensorID int
+	Name     string
+	Kind     Kind
+	//Since is when the anomaly was detected
+	Since   time.Time
+	Message string
+}
+
+func (a Anomaly) String() string {
+	return fmt.Sprintf("%v: %v", a.Kind, a.Message)
+}
+
+//Detected is published on the event bus of the client when an anomaly is detected
+type Detected struct {
+	Time time.Time
+	Anomaly
+}
+
+//Cleared is published on the event bus of the client when an anomaly no longer holds
+type Cleared struct {
+	Time time.Time
+	Anomaly
+}
+
+//EventTime returns when the anomaly was detected
+func (e Detected) EventTime() time.Time { return e.Time }
+
+//EventTime returns when the anomaly cleared
+func (e Cleared) EventTime() time.Time { return e.Time }
+
+//room is the detection state of a sensor
+type room struct {
+	valveOpenSince    time.Time
+	temperatureAtOpen float32
+
+	lastTemperature float32
+	lastChange      time.Time
+
+	rateStart       time.Time
+	rateTemperature float32
+	rate            float32
+}
+
+type key struct {
+	sensorID int
+	kind     Kind
+}
+
+//Detector finds anomalies in polls
+type Detector struct {
+	defaults Tunables
+
+	//OnDetected and OnCleared are called when an anomaly is detected or cleared
+	OnDetected func(a Anomaly)
+	OnCleared  func(a Anomaly)
+
+	mu       sync.Mutex
+	tunables map[int]Tunables
+	rooms    map[int]*room
+	active   map[key]Anomaly
+	bus      *roth.Bus
+}
+
+//NewDetector creates a detector using the given tunables for all rooms
+func NewDetector(defaults Tunables) *Detector {
+	return &Detector{
+		defaults: defaults,
+		tunables: make(map[int]Tunables),
+		rooms:    make(map[int]*room),
+		active:   make(map[key]Anomaly),
+	}
+}
+
+//SetTunables overrides the tunables of a room, e.g. for a bathroom warming faster than other
+//rooms
+func (d *Detector) SetTunables(sensorID int, t Tunables) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.tunables[sensorID] = t
+}
+
+//Attach evaluates every successful poll of the watcher, and publishes Detected and Cleared
+//events on the event bus of its client
+func (d *Detector) Attach(w *roth.Watcher) {
+	d.mu.Lock()
+	d.bus = w.Client().Events()
+	d.mu.Unlock()
+	w.Subscribe(func(p roth.Poll) {
+		d.Evaluate(p)
+	})
+}
+
+//Evaluate updates the detection state with a poll, and returns the active anomalies. Failed
+//polls are ignored.
+func (d *Detector) Evaluate(p roth.Poll) []Anomaly {
+	if p.Err != nil {
+		return d.Active()
+	}
+
+	d.mu.Lock()
+	var detected, cleared []Anomaly
+	for _, s := range p.Sensors {
+		if !s.Valid.Has(roth.FieldRoomTemperature) {
+			continue
+		}
+		for kind, message := range d.update(p.Time, s) {
+			k := key{s.Id, kind}
+			a, isActive := d.active[k]
+			switch {
+			case message != "" && !isActive:
+				a = Anomaly{SensorID: s.Id, Name: s.Name, Kind: kind, Since: p.Time, Message: message}
+				d.active[k] = a
+				detected = append(detected, a)
+			case message != "":
+				a.Message = message
+				d.active[k] = a
+			case isActive:
+				delete(d.active, k)
+				cleared = append(cleared, a)
+			}
+		}
+	}
+	bus := d.bus
+	d.mu.Unlock()
+
+	for _, a := range detected {
+		if bus != nil {
+			bus.Publish(Detected{Time: p.Time, Anomaly: a})
+		}
+		if d.OnDetected != nil {
+			d.OnDetected(a)
+		}
+	}
+	for _, a := range cleared {
+		if bus != nil {
+			bus.Publish(Cleared{Time: p.Time, Anomaly: a})
+		}
+		if d.OnCleared != nil {
+			d.OnCleared(a)
+		}
+	}
+	return d.Active()
+}
+
+//update checks a reading against the state of its room. The caller must hold d.mu.
+func (d *Detector) update(now time.Time, s roth.Sensor) map[Kind]string {
+	t, ok := d.tunables[s.Id]
+	if !ok {
+		t = d.defaults
+	}
+	r := d.rooms[s.Id]
+	if r == nil {
+		r = &room{lastTemperature: s.RoomTemperature, lastChange: now, rateStart: now, rateTemperature: s.RoomTemperature}
+		d.rooms[s.Id] = r
+	}
+	return r.update(now, s, t)
+}
+
+//Learn updates the detection state with past polls, e.g. replayed from imported history with
+//history.Polls, so checks spanning hours work from the first live poll. Anomalies are not
+//reported while learning; those still present are reported by the next poll evaluated.
+func (d *Detector) Learn(polls ...roth.Poll) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, p := range polls {
+		if p.Err != nil {
+			continue
+		}
+		for _, s := range p.Sensors {
+			if s.Valid.Has(roth.FieldRoomTemperature) {
+				d.update(p.Time, s)
+			}
+		}
+	}
+}
+
+//update checks a reading, and returns a message for each kind of anomaly, empty if the room
+//behaves normally
+func (r *room) update(now time.Time, s roth.Sensor, t Tunables) map[Kind]string {
+	result := map[Kind]string{StuckActuator: "", Flatline: "", Runaway: ""}
+	temperature := s.RoomTemperature
+
+	valveKnown := s.Valid.Has(roth.FieldTargetTemperature)
+	if valveKnown && s.GetValveState() == roth.ValveOpen {
+		if r.valveOpenSince.IsZero() {
+			r.valveOpenSince, r.temperatureAtOpen = now, temperature
+		}
+		open := now.Sub(r.valveOpenSince)
+		if t.StuckAfter > 0 && open >= t.StuckAfter && temperature-r.temperatureAtOpen < t.MinRise {
+			result[StuckActuator] = fmt.Sprintf("%v warmed %.1f °C in %v with the valve open", s.Name, temperature-r.temperatureAtOpen, open.Round(time.Minute))
+		}
+	} else {
+		r.valveOpenSince = time.Time{}
+	}
+
+	if temperature != r.lastTemperature {
+		r.lastTemperature, r.lastChange = temperature, now
+	}
+	if unchanged := now.Sub(r.lastChange); t.FlatlineAfter > 0 && unchanged >= t.FlatlineAfter {
+		result[Flatline] = fmt.Sprintf("%v has reported %.2f °C for %v", s.Name, temperature, unchanged.Round(time.Minute))
+	}
+
+	if t.RateWindow > 0 && now.Sub(r.rateStart) >= t.RateWindow {
+		r.rate = (temperature - r.rateTemperature) / float32(now.Sub(r.rateStart).Hours())
+		r.rateStart, r.rateTemperature = now, temperature
+	}
+	var runaway []string
+	if t.RunawayMargin > 0 && valveKnown && temperature > s.TargetTemperature+t.RunawayMargin {
+		runaway = append(runaway, fmt.Sprintf("%.1f °C above the target of %.1f °C", temperature-s.TargetTemperature, s.TargetTemperature))
+	}
+	if t.MaxRate > 0 && r.rate > t.MaxRate {
+		runaway = append(runaway, fmt.Sprintf("rising %.1f °C per hour", r.rate))
+	}
+	if len(runaway) > 0 {
+		result[Runaway] = fmt.Sprintf("%v is %v", s.Name, strings.Join(runaway, " and "))
+	}
+	return result
+}
+
+//Active returns the active anomalies, ordered by sensor and kind
+func (d *Detector) Active() []Anomaly {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	active := make([]Anomaly, 0, len(d.active))
+	for _, a := range d.active {
+		active = append(active, a)
+	}
+	sort.Slice(active, func(i, j int) bool {
+		if active[i].SensorID != active[j].SensorID {
+			return active[i].SensorID < active[j].SensorID
+		}
+		return active[i].Kind < active[j].Kind
+	})
+	return active
+}
+
+//AlertRule alerts while the detector reports any anomaly, so anomalies are delivered through
+//the notifiers of an alert monitor. Attach the detector before the monitor, so the rule sees
+//the anomalies of the current poll.
+func AlertRule(name string, d *Detector) alert.Rule {
+	return alert.Rule{Name: name, Check: func(p roth.Poll) (bool, string) {
+		active := d.Active()
+		messages := make([]string, len(active))
+		for i, a := range active {
+			messages[i] = a.String()
+		}
+		return len(active) > 0, strings.Join(messages, "; ")
+	}}
+}
@@ -0,0 +1,158 @@
+package roth
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+//AuditEntry records a write to the controller. Values are raw controller values, e.g. 2150
+//for a target temperature of 21.5 °C.
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	//Item is the canonical item name, e.g. G3.SollTemp, or R0.Reboot for items of the
+	//controller itself
+	Item string `json:"item"`
+	//SensorID is the id of the written sensor, or -1 for items of the controller
+	SensorID int `json:"sensor"`
+	//OldValue is the last value read from the controller, empty if unknown
+	OldValue string `json:"oldValue,omitempty"`
+	NewValue string `json:"newValue"`
+	//Origin is the origin of the write, see WithOrigin
+	Origin string `json:"origin,omitempty"`
+	//DryRun is set for writes skipped in dry run mode
+	DryRun bool `json:"dryRun,omitempty"`
+	//Err is the error of the write, empty if it succeeded
+	Err string `json:"error,omitempty"`
+}
+
+//AuditSink stores audit entries, e.g. in a file or a database
+type AuditSink interface {
+	Audit(e AuditEntry) error
+}
+
+//AuditFunc adapts a function to an AuditSink, e.g. to insert entries into a database
+type AuditFunc func(e AuditEntry) error
+
+//Audit calls f
+func (f AuditFunc) Audit(e AuditEntry) error {
+	return f(e)
+}
+
+//FileAuditSink appends audit entries to a file as json lines
+type FileAuditSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+//OpenAuditFile opens an audit file for appending, creating it if necessary
+func OpenAuditFile(path string) (*FileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileAuditSink{file: file}, nil
+}
+
+//Audit appends an entry to the file
+func (f *FileAuditSink) Audit(e AuditEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err = f.file.Write(append(line, '\n'))
+	return err
+}
+
+//Close closes the file
+func (f *FileAuditSink) Close() error {
+	return f.file.Close()
+}
+
+//ReadAuditLog reads the entries of an audit file written by FileAuditSink
+func ReadAuditLog(r io.Reader) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+type originKey struct{}
+
+//WithOrigin tags the writes made with the returned context with an origin, e.g.
+//"rule:morning-warmup" or "user:alice", so automation writes can be told from manual ones. It is
+//recorded in the audit log, set on ValueWritten, WriteFailed and DryRunWrite events, and on the
+//changes a Watcher sees after the write, along with the webhook payloads for them. Rules and
+//scenes set an origin of their own, unless the context has one.
+func WithOrigin(ctx context.Context, origin string) context.Context {
+	return context.WithValue(ctx, originKey{}, origin)
+}
+
+//OriginFromContext returns the origin set by WithOrigin, or an empty string
+func OriginFromContext(ctx context.Context) string {
+	origin, _ := ctx.Value(originKey{}).(string)
+	return origin
+}
+
+//lastValues holds the last raw value read or written for each item, for the old values of
+//audit entries
+type lastValues struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (l *lastValues) get(item string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.values[item]
+}
+
+func (l *lastValues) set(items []responseItem) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.values == nil {
+		l.values = make(map[string]string)
+	}
+	for _, item := range items {
+		l.values[item.Name] = item.Value
+	}
+}
+
+//audit records a write in the audit sink, if any
+func (c *Client) audit(ctx context.Context, item string, sensorID int, value string, err error) {
+	if c.Audit == nil {
+		return
+	}
+	e := AuditEntry{
+		Time:     time.Now(),
+		Item:     item,
+		SensorID: sensorID,
+		OldValue: c.lastValues.get(item),
+		NewValue: value,
+		Origin:   OriginFromContext(ctx),
+		DryRun:   c.DryRun,
+	}
+	if err != nil {
+		e.Err = err.Error()
+	} else if !c.DryRun {
+		c.lastValues.set([]responseItem{{Name: item, Value: value}})
+	}
+	if err := c.Audit.Audit(e); err != nil {
+		c.logf(LogWarning, "error writing audit entry for %v: %v", item, err)
+	}
+}
@@ -0,0 +1,54 @@
+package roth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+//authenticate adds the configured credentials to a request
+func (c *Client) authenticate(req *http.Request) {
+	if c.Username != "" || c.Password != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+}
+
+//tlsClient is the http client used for https controllers with custom tls settings, created on
+//first use from the settings of the client
+type tlsClient struct {
+	once   sync.Once
+	client *http.Client
+}
+
+func (c *Client) customTLS() bool {
+	return c.RootCAs != nil || c.InsecureSkipVerify
+}
+
+func (c *Client) tlsHTTPClient() *http.Client {
+	c.tls.once.Do(func() {
+		transport := defaultHTTPClient.Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{
+			RootCAs:            c.RootCAs,
+			InsecureSkipVerify: c.InsecureSkipVerify,
+		}
+		c.tls.client = &http.Client{Transport: transport}
+	})
+	return c.tls.client
+}
+
+//LoadCertPool reads a pem file with one or more certificates, e.g. the certificate authority of
+//a reverse proxy in front of the controller, for use as Client.RootCAs
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no certificates found in " + path)
+	}
+	return pool, nil
+}
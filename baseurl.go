@@ -0,0 +1,52 @@
+package roth
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+//NormalizeURL converts a controller address, e.g. ROTH-10A6D5, 192.168.1.20:8080, fe80::1 or
+//https://proxy/roth/, to a base url without trailing slash. The scheme defaults to http, and
+//IPv6 literals are bracketed.
+func NormalizeURL(address string) (string, error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return "", errors.New("empty controller url")
+	}
+	if !strings.Contains(address, "://") {
+		//a bare IPv6 literal, possibly with zone, has several colons and no brackets
+		if ip := net.ParseIP(strings.SplitN(address, "%", 2)[0]); ip != nil && strings.Contains(address, ":") {
+			address = "[" + strings.Replace(address, "%", "%25", 1) + "]"
+		}
+		address = "http://" + address
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid controller url %q: %v", address, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid controller url %q: unsupported scheme %v", address, u.Scheme)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid controller url %q: no host", address)
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String(), nil
+}
+
+//endpoint returns the url of a cgi path, e.g. /cgi-bin/writeVal.cgi?..., on the controller at
+//the given address
+func endpoint(address string, path string) (string, error) {
+	base, err := NormalizeURL(address)
+	if err != nil {
+		return "", err
+	}
+	return base + path, nil
+}
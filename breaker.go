@@ -0,0 +1,155 @@
+package roth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+//maxBreakerCooldown bounds the cool-down of the circuit breaker, doubled for every failed probe
+const maxBreakerCooldown = 10 * time.Minute
+
+//CircuitState is the state of the circuit breaker of a client, see Client.BreakerThreshold
+type CircuitState int
+
+const (
+	//CircuitClosed passes requests to the controller
+	CircuitClosed CircuitState = iota
+	//CircuitOpen rejects requests with ErrCircuitOpen until the cool-down has passed
+	CircuitOpen
+	//CircuitHalfOpen passes a single request to the controller as a probe, and rejects the
+	//others. The circuit closes if the probe succeeds, and opens again otherwise.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+//breaker is the circuit breaker of a client
+type breaker struct {
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	//degradedAt is when the circuit opened after being closed
+	degradedAt time.Time
+	cooldown   time.Duration
+	probing    bool
+}
+
+//breakerKey marks requests whose outcome is recorded by the caller, as the response may still
+//turn out to be garbage when parsed
+type breakerKey struct{}
+
+//CircuitState returns the state of the circuit breaker
+func (c *Client) CircuitState() CircuitState {
+	c.breaker.mu.Lock()
+	defer c.breaker.mu.Unlock()
+	return c.breaker.state
+}
+
+//allowRequest returns ErrCircuitOpen, wrapped, if the circuit breaker rejects a request
+func (c *Client) allowRequest() error {
+	if c.BreakerThreshold <= 0 {
+		return nil
+	}
+	b := &c.breaker
+	b.mu.Lock()
+	var err error
+	probe := false
+	switch b.state {
+	case CircuitOpen:
+		if until := b.openedAt.Add(b.cooldown); time.Now().Before(until) {
+			err = fmt.Errorf("%w until %v", ErrCircuitOpen, until.Format("15:04:05"))
+			break
+		}
+		b.state, b.probing, probe = CircuitHalfOpen, true, true
+	case CircuitHalfOpen:
+		if b.probing {
+			err = fmt.Errorf("%w, probing the controller", ErrCircuitOpen)
+			break
+		}
+		b.probing = true
+	}
+	b.mu.Unlock()
+
+	if err != nil {
+		c.stats.update(func(s *Stats) { s.CircuitRejected++ })
+	}
+	if probe {
+		c.logf(LogInfo, "circuit half-open, probing the controller")
+	}
+	return err
+}
+
+//recordOutcome updates the circuit breaker with the outcome of a request. Requests cancelled by
+//their caller, rejected for credentials or by the breaker itself say nothing about the health of
+//the controller. Requests running out of time do count as failures, as a hanging controller is
+//what the breaker is for.
+func (c *Client) recordOutcome(ctx context.Context, err error) {
+	if c.BreakerThreshold <= 0 {
+		return
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		return
+	}
+	neutral := err != nil && (errors.Is(ctx.Err(), context.Canceled) || errors.Is(err, context.Canceled) || errors.Is(err, ErrUnauthorized))
+
+	b := &c.breaker
+	b.mu.Lock()
+	var event Event
+	var logLevel LogLevel
+	var logMessage string
+	switch {
+	case neutral:
+		//a probe without outcome is repeated by the next request
+		b.probing = false
+	case err == nil:
+		if b.state != CircuitClosed {
+			logLevel, logMessage = LogInfo, "circuit closed, controller answers again"
+			event = ControllerRecovered{Time: time.Now(), Degraded: time.Since(b.degradedAt)}
+		}
+		b.state, b.failures, b.cooldown, b.probing = CircuitClosed, 0, 0, false
+	case b.state == CircuitHalfOpen:
+		b.cooldown *= 2
+		if b.cooldown > maxBreakerCooldown {
+			b.cooldown = maxBreakerCooldown
+		}
+		b.state, b.openedAt, b.probing = CircuitOpen, time.Now(), false
+		logLevel, logMessage = LogWarning, fmt.Sprintf("probe failed, circuit open for %v: %v", b.cooldown, err)
+	default:
+		b.failures++
+		if b.state == CircuitClosed && b.failures >= c.BreakerThreshold {
+			b.cooldown = c.breakerCooldown()
+			b.state, b.openedAt, b.degradedAt = CircuitOpen, time.Now(), time.Now()
+			logLevel, logMessage = LogWarning, fmt.Sprintf("%v consecutive requests failed, circuit open for %v: %v", b.failures, b.cooldown, err)
+			event = ControllerDegraded{Time: b.openedAt, Err: err, Failures: b.failures, Cooldown: b.cooldown}
+		}
+	}
+	b.mu.Unlock()
+
+	if logMessage != "" {
+		c.logf(logLevel, "%v", logMessage)
+	}
+	if event != nil {
+		c.Events().Publish(event)
+	}
+}
+
+func (c *Client) breakerCooldown() time.Duration {
+	if c.BreakerCooldown > 0 {
+		return c.BreakerCooldown
+	}
+	return DefaultBreakerCooldown
+}
@@ -0,0 +1,180 @@
+package roth_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/rothtest"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	tests := []struct {
+		name  string
+		fault rothtest.Fault
+		//context returns the context of every read
+		context func() (context.Context, context.CancelFunc)
+		reads   int
+		//pause between reads, for shared reads to record their outcome after the caller gave up
+		pause time.Duration
+		//wantRequests is the number of reads reaching the controller
+		wantRequests int64
+		wantState    roth.CircuitState
+	}{
+		{
+			name:         "healthy",
+			fault:        rothtest.FaultNone,
+			reads:        5,
+			wantRequests: 5,
+			wantState:    roth.CircuitClosed,
+		},
+		{
+			name:         "malformed responses open the circuit",
+			fault:        rothtest.FaultMalformedXML,
+			reads:        5,
+			wantRequests: 3,
+			wantState:    roth.CircuitOpen,
+		},
+		{
+			name:  "timeouts open the circuit",
+			fault: rothtest.FaultTimeout,
+			context: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 20*time.Millisecond)
+			},
+			pause:        10 * time.Millisecond,
+			reads:        5,
+			wantRequests: 3,
+			wantState:    roth.CircuitOpen,
+		},
+		{
+			name:  "cancelled reads are neutral",
+			fault: rothtest.FaultTimeout,
+			context: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(20*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			reads:        5,
+			wantRequests: 5,
+			wantState:    roth.CircuitClosed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller := rothtest.NewController(testSensors()...)
+			var requests int64
+			srv := countingServer(controller, &requests)
+			defer srv.Close()
+			defer controller.Close()
+			controller.SetFault(test.fault)
+
+			c := roth.NewClient(srv.URL, roth.WithLogger(roth.DiscardLogger), roth.WithCircuitBreaker(3, time.Minute))
+			for i := 0; i < test.reads; i++ {
+				ctx, cancel := context.Background(), context.CancelFunc(func() {})
+				if test.context != nil {
+					ctx, cancel = test.context()
+				}
+				_, err := c.GetSensors(ctx, 2)
+				cancel()
+				time.Sleep(test.pause)
+				if test.fault == rothtest.FaultNone && err != nil {
+					t.Fatalf("read %v: %v", i, err)
+				}
+				if int64(i) >= test.wantRequests && !errors.Is(err, roth.ErrCircuitOpen) {
+					t.Errorf("read %v: got error %v, want ErrCircuitOpen", i, err)
+				}
+			}
+
+			if got := atomic.LoadInt64(&requests); got != test.wantRequests {
+				t.Errorf("got %v requests, want %v", got, test.wantRequests)
+			}
+			if got := c.CircuitState(); got != test.wantState {
+				t.Errorf("got circuit %v, want %v", got, test.wantState)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerRecovery(t *testing.T) {
+	srv := rothtest.NewServer(testSensors()...)
+	defer srv.Close()
+
+	const cooldown = 50 * time.Millisecond
+	c := newTestClient(srv, roth.WithCircuitBreaker(2, cooldown))
+	var events eventRecorder
+	defer c.Events().Subscribe(events.record)()
+
+	srv.SetFault(rothtest.FaultMalformedXML)
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetSensors(context.Background(), 2); err == nil {
+			t.Fatalf("read %v of a malformed response succeeded", i)
+		}
+	}
+	if got := c.CircuitState(); got != roth.CircuitOpen {
+		t.Fatalf("got circuit %v, want %v", got, roth.CircuitOpen)
+	}
+	if !events.has(func(e roth.Event) bool {
+		degraded, ok := e.(roth.ControllerDegraded)
+		return ok && degraded.Failures == 2
+	}) {
+		t.Error("no ControllerDegraded event published")
+	}
+
+	//a failing probe keeps the circuit open
+	time.Sleep(cooldown)
+	if _, err := c.GetSensors(context.Background(), 2); err == nil || errors.Is(err, roth.ErrCircuitOpen) {
+		t.Fatalf("got error %v from the probe, want the malformed response", err)
+	}
+	if got := c.CircuitState(); got != roth.CircuitOpen {
+		t.Fatalf("got circuit %v after a failed probe, want %v", got, roth.CircuitOpen)
+	}
+
+	//the cooldown doubles after a failed probe, and a successful probe closes the circuit
+	srv.SetFault(rothtest.FaultNone)
+	time.Sleep(cooldown)
+	if _, err := c.GetSensors(context.Background(), 2); !errors.Is(err, roth.ErrCircuitOpen) {
+		t.Fatalf("got error %v before the doubled cooldown, want ErrCircuitOpen", err)
+	}
+	time.Sleep(cooldown)
+	if _, err := c.GetSensors(context.Background(), 2); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if got := c.CircuitState(); got != roth.CircuitClosed {
+		t.Fatalf("got circuit %v, want %v", got, roth.CircuitClosed)
+	}
+	if !events.has(func(e roth.Event) bool {
+		_, ok := e.(roth.ControllerRecovered)
+		return ok
+	}) {
+		t.Error("no ControllerRecovered event published")
+	}
+}
+
+//eventRecorder keeps the events published on a bus
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []roth.Event
+}
+
+func (r *eventRecorder) record(e roth.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+//has returns whether any event recorded matches the predicate
+func (r *eventRecorder) has(match func(roth.Event) bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.events {
+		if match(e) {
+			return true
+		}
+	}
+	return false
+}
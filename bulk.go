@@ -0,0 +1,220 @@
+package roth
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//DefaultWriteBatchSize is the number of values per write request used unless
+//Client.WriteBatchSize is set
+const DefaultWriteBatchSize = 10
+
+//datapointWrite is a single value to write to a sensor datapoint
+type datapointWrite struct {
+	sensorID  int
+	datapoint string
+	value     string
+}
+
+//Batch collects writes to several sensors, which are sent to the controller in as few requests
+//as possible. Writes to the same datapoint replace each other, so only the last value is sent.
+type Batch struct {
+	client *Client
+	writes []datapointWrite
+	//invalid holds the sensors with a rejected value, which are reported but not written
+	invalid map[int]error
+}
+
+//NewBatch creates an empty batch of writes
+func (c *Client) NewBatch() *Batch {
+	return &Batch{client: c}
+}
+
+func (b *Batch) add(sensorID int, datapoint string, value string) *Batch {
+	for i, w := range b.writes {
+		if w.sensorID == sensorID && w.datapoint == datapoint {
+			b.writes[i].value = value
+			return b
+		}
+	}
+	b.writes = append(b.writes, datapointWrite{sensorID, datapoint, value})
+	return b
+}
+
+//SetTargetTemperature adds a change of the target temperature of a given sensor. An invalid
+//target fails the sensor when the batch is sent.
+func (b *Batch) SetTargetTemperature(sensorID int, targetTemperature float32) *Batch {
+	value, err := b.client.formatTarget(sensorID, targetTemperature)
+	if err != nil {
+		return b.reject(sensorID, err)
+	}
+	return b.add(sensorID, "SollTemp", value)
+}
+
+//SetProgram adds a change of the active week program of the thermostat. An invalid program
+//fails the sensor when the batch is sent.
+func (b *Batch) SetProgram(sensorID int, program Program) *Batch {
+	if err := program.check(); err != nil {
+		return b.reject(sensorID, err)
+	}
+	return b.add(sensorID, "WeekProg", strconv.Itoa(int(program)))
+}
+
+//SetMode adds a change of the active operating mode. An invalid mode fails the sensor when
+//the batch is sent.
+func (b *Batch) SetMode(sensorID int, mode Mode) *Batch {
+	if err := mode.check(); err != nil {
+		return b.reject(sensorID, err)
+	}
+	return b.add(sensorID, "OPMode", strconv.Itoa(int(mode)))
+}
+
+func (b *Batch) reject(sensorID int, err error) *Batch {
+	if b.invalid == nil {
+		b.invalid = make(map[int]error)
+	}
+	b.invalid[sensorID] = err
+	return b
+}
+
+//Len returns the number of values in the batch
+func (b *Batch) Len() int {
+	return len(b.writes)
+}
+
+//Send writes all values in the batch, and reports the outcome per sensor. Values for one sensor
+//are always sent in the same request, in the order they were added.
+func (b *Batch) Send(ctx context.Context) BulkResult {
+	size := b.client.WriteBatchSize
+	if size <= 0 {
+		size = DefaultWriteBatchSize
+	}
+
+	//group the writes per sensor, keeping the sensors in the order they were first added
+	var ids []int
+	perSensor := make(map[int][]datapointWrite)
+	for _, w := range b.writes {
+		if _, ok := b.invalid[w.sensorID]; ok {
+			continue
+		}
+		if _, ok := perSensor[w.sensorID]; !ok {
+			ids = append(ids, w.sensorID)
+		}
+		perSensor[w.sensorID] = append(perSensor[w.sensorID], w)
+	}
+
+	result := make(BulkResult, len(ids))
+	var request []datapointWrite
+	var requestIDs []int
+	flush := func() {
+		if len(request) == 0 {
+			return
+		}
+		err := b.client.writeValues(ctx, request)
+		for _, id := range requestIDs {
+			result[id] = err
+		}
+		request, requestIDs = nil, nil
+	}
+
+	for _, id := range ids {
+		writes := perSensor[id]
+		if len(request) > 0 && len(request)+len(writes) > size {
+			flush()
+		}
+		request = append(request, writes...)
+		requestIDs = append(requestIDs, id)
+	}
+	flush()
+
+	for id, err := range b.invalid {
+		result[id] = err
+	}
+	return result
+}
+
+//BulkResult holds the outcome of a bulk operation per sensor id. A nil error means the sensor
+//was updated successfully.
+type BulkResult map[int]error
+
+//Failed returns the ids of the sensors which could not be updated, sorted
+func (r BulkResult) Failed() []int {
+	var ids []int
+	for id, err := range r {
+		if err != nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+//Err returns an error describing all failed sensors, or nil if all sensors were updated
+func (r BulkResult) Err() error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+
+	parts := make([]string, len(failed))
+	for i, id := range failed {
+		parts[i] = fmt.Sprintf("sensor %v: %v", id, r[id])
+	}
+	return fmt.Errorf("%v of %v sensors failed (%v)", len(failed), len(r), strings.Join(parts, ", "))
+}
+
+//SetAllModes changes the operating mode of every sensor on the controller
+func (c *Client) SetAllModes(ctx context.Context, mode Mode) (BulkResult, error) {
+	if err := mode.check(); err != nil {
+		return nil, err
+	}
+	sensorCount, err := c.GetSensorCount(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := c.NewBatch()
+	for _, id := range c.sensorIDs(sensorCount) {
+		batch.SetMode(id, mode)
+	}
+	return batch.Send(ctx), nil
+}
+
+//SetAllTargetTemperatures changes the target temperature of every sensor on the controller
+func (c *Client) SetAllTargetTemperatures(ctx context.Context, targetTemperature float32) (BulkResult, error) {
+	sensorCount, err := c.GetSensorCount(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := c.NewBatch()
+	for _, id := range c.sensorIDs(sensorCount) {
+		batch.SetTargetTemperature(id, targetTemperature)
+	}
+	return batch.Send(ctx), nil
+}
+
+//SetGlobalSetback lowers the target temperature of every sensor by delta degrees. A negative
+//delta raises the target temperatures, e.g. to undo an earlier setback. Sensors whose current
+//target temperature could not be read are left unchanged, and are not in the result.
+func (c *Client) SetGlobalSetback(ctx context.Context, delta float32) (BulkResult, error) {
+	sensorCount, err := c.GetSensorCount(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sensors, err := c.ForceRefresh(ctx, sensorCount)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := c.NewBatch()
+	for _, sensor := range sensors {
+		if sensor.Valid.Has(FieldTargetTemperature) {
+			batch.SetTargetTemperature(sensor.Id, sensor.TargetTemperature-delta)
+		}
+	}
+	return batch.Send(ctx), nil
+}
@@ -0,0 +1,93 @@
+package roth_test
+
+import (
+	"context"
+	"testing"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/rothtest"
+)
+
+//batchSensors are the thermostats of the batch tests, all in day mode with program 1 at 20°C
+func batchSensors() []roth.Sensor {
+	sensors := make([]roth.Sensor, 3)
+	for id := range sensors {
+		sensors[id] = roth.Sensor{Id: id, TargetTemperature: 20, Program: roth.Program1, Mode: roth.ModeDay}
+	}
+	return sensors
+}
+
+func TestBatchSend(t *testing.T) {
+	tests := []struct {
+		name  string
+		batch func(b *roth.Batch)
+		//wantFailed are the sensors reported as failed
+		wantFailed []int
+		//wantValues are the raw values after the batch, of the datapoints checked
+		wantValues map[string]string
+	}{
+		{
+			name: "all valid",
+			batch: func(b *roth.Batch) {
+				b.SetTargetTemperature(0, 21).SetMode(1, roth.ModeNight).SetProgram(2, roth.Program3)
+			},
+			wantValues: map[string]string{"G0.SollTemp": "2100", "G1.OPMode": "1", "G2.WeekProg": "3"},
+		},
+		{
+			name: "invalid values fail their sensor only",
+			batch: func(b *roth.Batch) {
+				b.SetMode(0, roth.Mode(9)).SetTargetTemperature(1, 50).SetTargetTemperature(2, 21)
+			},
+			wantFailed: []int{0, 1},
+			wantValues: map[string]string{"G1.SollTemp": "2000", "G2.SollTemp": "2100"},
+		},
+		{
+			name: "invalid value blocks the valid values of the sensor",
+			batch: func(b *roth.Batch) {
+				b.SetTargetTemperature(0, 22).SetProgram(0, roth.Program(4)).SetMode(0, roth.ModeHoliday)
+			},
+			wantFailed: []int{0},
+			wantValues: map[string]string{"G0.SollTemp": "2000", "G0.WeekProg": "1", "G0.OPMode": "0"},
+		},
+		{
+			name: "later value replaces earlier",
+			batch: func(b *roth.Batch) {
+				b.SetTargetTemperature(0, 22).SetTargetTemperature(0, 23)
+			},
+			wantValues: map[string]string{"G0.SollTemp": "2300"},
+		},
+		{
+			name: "only invalid values",
+			batch: func(b *roth.Batch) {
+				b.SetMode(2, roth.Mode(-1))
+			},
+			wantFailed: []int{2},
+			wantValues: map[string]string{"G2.OPMode": "0"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := rothtest.NewServer(batchSensors()...)
+			defer srv.Close()
+
+			batch := newTestClient(srv).NewBatch()
+			test.batch(batch)
+			result := batch.Send(context.Background())
+			if !equalInts(result.Failed(), test.wantFailed) {
+				t.Errorf("got failed sensors %v, want %v: %v", result.Failed(), test.wantFailed, result.Err())
+			}
+			compareValues(t, srv, test.wantValues)
+		})
+	}
+}
+
+//compareValues reports the raw datapoint values of the controller differing from want
+func compareValues(t *testing.T, srv *rothtest.Server, want map[string]string) {
+	t.Helper()
+	for name, value := range want {
+		if got, _ := srv.Value(name); got != value {
+			t.Errorf("got %v = %v, want %v", name, got, value)
+		}
+	}
+}
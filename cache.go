@@ -0,0 +1,94 @@
+package roth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//sensorCache holds the result of the last sensor read, for clients with a CacheTTL
+type sensorCache struct {
+	mu       sync.Mutex
+	sensors  []Sensor
+	warnings []ParseWarning
+	fetched  time.Time
+	//dirty holds the fields written since the last read, per sensor id
+	dirty map[int]Field
+
+	sensorCount  int
+	countFetched time.Time
+}
+
+//get returns the cached sensors if they are fresher than ttl, and no field has been written since
+func (sc *sensorCache) get(sensorCount int, ttl time.Duration) ([]Sensor, []ParseWarning, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.sensors == nil || len(sc.sensors) != sensorCount || len(sc.dirty) > 0 || time.Since(sc.fetched) > ttl {
+		return nil, nil, false
+	}
+
+	sensors := make([]Sensor, len(sc.sensors))
+	copy(sensors, sc.sensors)
+	warnings := make([]ParseWarning, len(sc.warnings))
+	copy(warnings, sc.warnings)
+	return sensors, warnings, true
+}
+
+func (sc *sensorCache) put(sensors []Sensor, warnings []ParseWarning) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.sensors = make([]Sensor, len(sensors))
+	copy(sc.sensors, sensors)
+	sc.warnings = make([]ParseWarning, len(warnings))
+	copy(sc.warnings, warnings)
+	sc.fetched = time.Now()
+	sc.dirty = nil
+}
+
+func (sc *sensorCache) getCount(ttl time.Duration) (int, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.countFetched.IsZero() || time.Since(sc.countFetched) > ttl {
+		return 0, false
+	}
+	return sc.sensorCount, true
+}
+
+func (sc *sensorCache) putCount(sensorCount int) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.sensorCount = sensorCount
+	sc.countFetched = time.Now()
+}
+
+//invalidate marks a field of a sensor as written, so the next read goes to the controller
+func (sc *sensorCache) invalidate(sensorID int, field Field) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.dirty == nil {
+		sc.dirty = make(map[int]Field)
+	}
+	sc.dirty[sensorID] |= field
+}
+
+//InvalidateCache discards all cached values, so the next read goes to the controller
+func (c *Client) InvalidateCache() {
+	c.cache.mu.Lock()
+	defer c.cache.mu.Unlock()
+
+	c.cache.sensors = nil
+	c.cache.warnings = nil
+	c.cache.dirty = nil
+	c.cache.countFetched = time.Time{}
+}
+
+//ForceRefresh reads current sensor data from the controller, bypassing and updating the cache
+func (c *Client) ForceRefresh(ctx context.Context, sensorCount int) ([]Sensor, error) {
+	sensors, _, err := c.fetchSensors(ctx, sensorCount)
+	return sensors, err
+}
@@ -0,0 +1,143 @@
+package roth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/kvantetore/rothTouchline/protocol"
+)
+
+//Capabilities describes the datapoints a controller supports, as detected by probing it
+type Capabilities struct {
+	//Detected is false until the controller was probed successfully
+	Detected bool
+
+	//Datapoints maps the datapoint names used by this library to the names used by the
+	//controller, for datapoints which differ, e.g. OPMode to OPmode on some firmware
+	Datapoints map[string]string
+
+	//TemperatureUnit is whether the thermostats report their unit (datapoint TempSIUnit)
+	TemperatureUnit bool
+	//TemperatureOffset is whether the thermostats have a measurement offset setting
+	TemperatureOffset bool
+	//DeviceInfo is whether the thermostats report their software and hardware versions
+	DeviceInfo bool
+}
+
+//datapointVariants lists alternative names of datapoints seen on different firmware revisions
+var datapointVariants = map[string][]string{
+	"OPMode": {"OPmode"},
+}
+
+//capabilityState holds the capabilities detected for a client
+type capabilityState struct {
+	mu   sync.Mutex
+	caps Capabilities
+	//reverse maps controller datapoint names back to the names used by this library
+	reverse map[string]string
+}
+
+//Capabilities returns the capabilities detected by the last successful Detect, or the zero
+//value if the controller was not probed yet
+func (c *Client) Capabilities() Capabilities {
+	c.capabilities.mu.Lock()
+	defer c.capabilities.mu.Unlock()
+	return c.capabilities.caps
+}
+
+//Detect probes the controller for supported datapoints and features, and adapts the datapoint
+//names used by the client to the controller. The probe reads the datapoints of the first
+//sensor, so at least one thermostat must be paired. See also Client.AutoDetect.
+func (c *Client) Detect(ctx context.Context) (Capabilities, error) {
+	probes := []string{"TempSIUnit", offsetDatapoint, "SWVersion"}
+	for _, sf := range sensorFields {
+		probes = append(probes, sf.Name)
+		probes = append(probes, datapointVariants[sf.Name]...)
+	}
+
+	req := readRequest{}
+	for _, datapoint := range probes {
+		req.Items = append(req.Items, readRequestItem{Name: fmt.Sprintf("G0.%v", datapoint)})
+	}
+	//probe without translation, as the names are what is being detected
+	resp, err := c.readChunks(ctx, req)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	supported := func(datapoint string) bool {
+		value, ok := resp.Value(fmt.Sprintf("G0.%v", datapoint))
+		return ok && value != ""
+	}
+	if !supported("name") && !supported("RaumTemp") {
+		return Capabilities{}, errors.New("no thermostat to probe")
+	}
+
+	caps := Capabilities{
+		Detected:          true,
+		Datapoints:        make(map[string]string),
+		TemperatureUnit:   supported("TempSIUnit"),
+		TemperatureOffset: supported(offsetDatapoint),
+		DeviceInfo:        supported("SWVersion"),
+	}
+	reverse := make(map[string]string)
+	for datapoint, variants := range datapointVariants {
+		if supported(datapoint) {
+			continue
+		}
+		for _, variant := range variants {
+			if supported(variant) {
+				caps.Datapoints[datapoint] = variant
+				reverse[variant] = datapoint
+				c.logf(LogInfo, "controller uses datapoint %v for %v", variant, datapoint)
+				break
+			}
+		}
+	}
+
+	c.capabilities.mu.Lock()
+	c.capabilities.caps = caps
+	c.capabilities.reverse = reverse
+	c.capabilities.mu.Unlock()
+	return caps, nil
+}
+
+//ensureCapabilities probes the controller before the first request if AutoDetect is set. A
+//failed probe is logged and retried on the next request.
+func (c *Client) ensureCapabilities(ctx context.Context) {
+	if !c.AutoDetect || c.Capabilities().Detected {
+		return
+	}
+	if _, err := c.Detect(ctx); err != nil {
+		c.logf(LogWarning, "error detecting controller capabilities: %v", err)
+	}
+}
+
+//controllerName translates an item name, e.g. G0.OPMode, to the name used by the controller
+func (c *Client) controllerName(name string) string {
+	c.capabilities.mu.Lock()
+	defer c.capabilities.mu.Unlock()
+	return translateItem(name, c.capabilities.caps.Datapoints)
+}
+
+//canonicalName translates an item name used by the controller back to the library name
+func (c *Client) canonicalName(name string) string {
+	c.capabilities.mu.Lock()
+	defer c.capabilities.mu.Unlock()
+	return translateItem(name, c.capabilities.reverse)
+}
+
+func translateItem(name string, datapoints map[string]string) string {
+	if len(datapoints) == 0 {
+		return name
+	}
+	id, datapoint, ok := protocol.SplitItemName(name)
+	if !ok {
+		return name
+	}
+	if translated, ok := datapoints[datapoint]; ok {
+		return "G" + id + "." + translated
+	}
+	return name
+}
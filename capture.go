@@ -0,0 +1,81 @@
+package roth
+
+import (
+	"sync"
+	"time"
+)
+
+//captureBodyLimit is the length up to which captured request and response bodies are kept
+const captureBodyLimit = 64 << 10
+
+//Exchange is a request to the controller and its response, kept by the debug capture of a
+//client. The bodies are kept as sent and received, before any parsing.
+type Exchange struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	//Path is the path and query of the request, without the address of the controller
+	Path       string `json:"path"`
+	Request    string `json:"request,omitempty"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Response   string `json:"response,omitempty"`
+	Duration   string `json:"duration"`
+	//Err is set if the request failed
+	Err string `json:"err,omitempty"`
+}
+
+//captureLog keeps the most recent exchanges of a client
+type captureLog struct {
+	mu      sync.Mutex
+	entries []Exchange
+	next    int
+}
+
+func (l *captureLog) add(size int, e Exchange) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) < size {
+		l.entries = append(l.entries, e)
+		return
+	}
+	//the size may have been lowered since
+	l.entries = l.entries[:size]
+	l.next %= size
+	l.entries[l.next] = e
+	l.next = (l.next + 1) % size
+}
+
+//CapturedExchanges returns the most recent requests and responses kept with
+//Client.DebugCapture, oldest first
+func (c *Client) CapturedExchanges() []Exchange {
+	l := &c.captured
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append(append([]Exchange{}, l.entries[l.next:]...), l.entries[:l.next]...)
+}
+
+//capture records an exchange, if enabled
+func (c *Client) capture(method string, path string, request []byte, statusCode int, response []byte, start time.Time, err error) {
+	if c.DebugCapture <= 0 {
+		return
+	}
+	e := Exchange{
+		Time:       start,
+		Method:     method,
+		Path:       path,
+		Request:    captureBody(request),
+		StatusCode: statusCode,
+		Response:   captureBody(response),
+		Duration:   time.Since(start).String(),
+	}
+	if err != nil {
+		e.Err = err.Error()
+	}
+	c.captured.add(c.DebugCapture, e)
+}
+
+func captureBody(body []byte) string {
+	if len(body) > captureBodyLimit {
+		return string(body[:captureBodyLimit]) + "..."
+	}
+	return string(body)
+}
@@ -0,0 +1,676 @@
+package roth
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kvantetore/rothTouchline/protocol"
+)
+
+//Client communicates with a single Roth Touchline controller.
+//
+//A Client is safe for concurrent use by multiple goroutines, as long as its fields are not
+//modified after first use. Concurrent GetSensors calls for the same sensors share a single
+//controller request, and writes to the same sensor are sent one at a time.
+type Client struct {
+	//ManagementURL is the base url of the controller, e.g. http://ROTH-10A6D5
+	ManagementURL string
+
+	//FallbackURLs are alternative base urls of the same controller, e.g. its static ip address.
+	//When a request fails, the next address is tried. The last address to work is preferred for
+	//subsequent requests, and addresses which failed recently are tried last.
+	FallbackURLs []string
+
+	//HTTPClient is used for all requests to the controller. If nil, a shared client keeping
+	//connections to the controller alive between requests is used.
+	//Replace the transport to record or replay controller traffic, see the rothtest package.
+	HTTPClient *http.Client
+
+	//Username and Password are sent as http basic auth credentials with every request, for
+	//controllers with password protection or behind a reverse proxy requiring authentication
+	Username string
+	Password string
+
+	//RootCAs are the certificate authorities trusted for https controller urls, e.g. the one of
+	//a reverse proxy, see LoadCertPool. If nil, the system roots are used.
+	//Ignored if HTTPClient is set.
+	RootCAs *x509.CertPool
+
+	//InsecureSkipVerify disables verification of the certificate of https controller urls.
+	//Only use it for self-signed proxies on a trusted network. Ignored if HTTPClient is set.
+	InsecureSkipVerify bool
+
+	//Timeout limits the duration of each request to the controller, including reading the
+	//response. If zero, requests are only limited by their context.
+	Timeout time.Duration
+
+	//Retries is the number of times a failed request is repeated, after trying all addresses
+	//of the controller. Requests rejected for missing credentials are not retried.
+	Retries int
+
+	//RetryBackoff is the delay before the first retry, doubled for every following retry. If
+	//zero, DefaultRetryBackoff is used.
+	RetryBackoff time.Duration
+
+	//BreakerThreshold opens the circuit breaker after this many consecutive requests failed,
+	//timed out or returned garbage. While open, requests fail immediately with ErrCircuitOpen
+	//instead of burdening the controller further. After BreakerCooldown, or
+	//DefaultBreakerCooldown if zero, a single request probes the controller: it closes the
+	//circuit if it succeeds, and opens it again for twice as long otherwise, up to 10 minutes.
+	//ControllerDegraded and ControllerRecovered events are published. If zero, there is no
+	//circuit breaker.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	//Tracer and Meter receive a span and measurements for every read and write, e.g. through
+	//an adapter to OpenTelemetry. Both are optional.
+	Tracer Tracer
+	Meter  Meter
+
+	//Middleware wraps every http request to the controller, the first middleware being the
+	//outermost. See also Hooks.
+	Middleware []Middleware
+
+	//Logger receives diagnostic messages. If nil, warnings and errors are printed to stdout;
+	//set it to DiscardLogger to silence the client.
+	Logger Logger
+
+	//Strict makes GetSensors fail with a *ParseError if any value in the response can not be
+	//parsed. By default the client is lenient, and returns partial results along with warnings.
+	Strict bool
+
+	//ChunkSize is the maximum number of items read in a single request to the controller.
+	//Larger reads are split into several requests and merged. If zero, DefaultChunkSize is used.
+	ChunkSize int
+
+	//ReadConcurrency is the number of chunks of a large read requested in parallel. If zero,
+	//chunks are requested one at a time.
+	ReadConcurrency int
+
+	//ReadDeadline limits the total duration of a read split into chunks, including retries of
+	//its chunks. If zero, only Timeout limits each request.
+	ReadDeadline time.Duration
+
+	//CoalesceReads merges reads issued while another read is in flight into a single request,
+	//sent as soon as the in-flight read completes. This keeps the load on the controller down
+	//when several goroutines poll through the same client.
+	CoalesceReads bool
+
+	//CacheTTL makes GetSensors and GetSensorCount return the result of the previous read if it
+	//is younger than the given duration. Writes through the client invalidate the cache.
+	//If zero, every call reads from the controller.
+	CacheTTL time.Duration
+
+	//WriteInterval is the minimum time between two writes. Writes issued in a burst are
+	//delayed to respect it. If zero, writes are sent immediately. See also WriteQueue.
+	WriteInterval time.Duration
+
+	//WriteBatchSize is the maximum number of values sent in a single write request by Batch and
+	//the bulk operations. If zero, DefaultWriteBatchSize is used. Set it to 1 for firmware
+	//which only accepts a single value per request.
+	WriteBatchSize int
+
+	//Unit is the unit of all temperatures read and written through the client. Values are
+	//converted from and to the unit configured on each thermostat. The default is Celsius.
+	Unit Unit
+
+	//SoftwareOffsets are added to the room temperature of the given sensor ids when read, in the
+	//client unit. Use them for thermostats whose firmware has no offset setting; otherwise
+	//prefer SetTemperatureOffset, which also corrects the control loop of the thermostat.
+	SoftwareOffsets map[int]float32
+
+	//AutoDetect probes the controller with Detect before the first request, and adapts the
+	//datapoint names used to the controller firmware
+	AutoDetect bool
+
+	//KeepRawValues makes GetSensors fill Sensor.Raw with the unparsed datapoint values
+	KeepRawValues bool
+
+	//MaxResponseSize is the maximum size in bytes of a controller response. Larger responses
+	//fail rather than being read into memory. If zero, DefaultMaxResponseSize is used.
+	MaxResponseSize int64
+
+	//VerifyWrites reads every written value back, and rewrites values the controller did not
+	//apply. Writes still not applied after VerifyRetries attempts fail with a *WriteError.
+	VerifyWrites bool
+
+	//VerifyRetries is the number of times a write is repeated when verification fails
+	VerifyRetries int
+
+	//VerifyDelay is the time between a write and reading it back, giving the controller time to
+	//apply it. If zero, DefaultVerifyDelay is used.
+	VerifyDelay time.Duration
+
+	//DryRun logs writes and publishes them as DryRunWrite events, instead of sending them to
+	//the controller. Reads are unaffected.
+	DryRun bool
+
+	//Audit records every write, with the previous value if it has been read before
+	Audit AuditSink
+
+	//RampStep is the setpoint change of each step of SetTargetTemperatureRamped. If zero,
+	//DefaultRampStep is used.
+	RampStep float32
+
+	//LastKnownGood makes GetSensors and GetSensorCount return the values of the last successful
+	//read when reading from the controller fails, as long as they are no older than the given
+	//duration. The sensors returned are marked Stale. If zero, failed reads return an error.
+	LastKnownGood time.Duration
+
+	//ReadAlarms makes GetSensors read the error code of every thermostat into Sensor.Alarms.
+	//It is off by default, as not every firmware reports it.
+	ReadAlarms bool
+
+	//TemperatureResolution is the step target temperatures are rounded to before writing, in
+	//degrees of the unit of the thermostat, e.g. 0.5 for the wall units or 0.1. If zero, they
+	//are written in hundredths of a degree, which some firmware truncates.
+	TemperatureResolution float32
+	//Rounding is how target temperatures are rounded to TemperatureResolution
+	Rounding Rounding
+
+	//DebugCapture keeps the given number of most recent requests and responses in memory, as
+	//sent and received, see CapturedExchanges. They are included in diagnostics bundles.
+	DebugCapture int
+
+	//NameFallback reads the names of sensors the controller returns without one from the
+	//device list page of its web interface, at DeviceListPath, see GetDeviceList. Some firmware
+	//answers ILRReadValues.cgi with empty names, or no values at all, now and then.
+	NameFallback   bool
+	DeviceListPath string
+
+	coalescer    coalescer
+	cache        sensorCache
+	writeLimiter rateLimiter
+	sensorReads  flightGroup
+	writeLocks   sensorLocks
+	failover     failover
+	units        controllerUnits
+	capabilities capabilityState
+	tls          tlsClient
+	errors       errorLog
+	stats        statsCounter
+	bus          *Bus
+	busOnce      sync.Once
+
+	customDatapoints []Datapoint
+	codecs           []codecEntry
+	aliases          map[string]string
+	lastValues       lastValues
+	ramps            rampState
+	lastGood         lastKnownGood
+	requestBodies    requestCache
+	metadata         metadataStore
+	virtual          virtualSensors
+	frost            frostGuard
+	indices          sensorIndices
+	captured         captureLog
+	snapshots        sequence
+	deviceList       deviceList
+	origins          writeOrigins
+	breaker          breaker
+}
+
+//DefaultChunkSize is the number of items per read request used unless Client.ChunkSize is set.
+//Controllers have been observed to truncate requests much larger than this.
+const DefaultChunkSize = 50
+
+//DefaultRetryBackoff is the delay before retrying a request, unless Client.RetryBackoff is set
+const DefaultRetryBackoff = 500 * time.Millisecond
+
+//DefaultBreakerCooldown is how long the circuit breaker stays open at first, unless
+//Client.BreakerCooldown is set
+const DefaultBreakerCooldown = 30 * time.Second
+
+//DefaultMaxResponseSize is the response size limit used unless Client.MaxResponseSize is set.
+//A full read of a large installation is well below it.
+const DefaultMaxResponseSize = 1 << 20
+
+//NewClient creates a client for the controller at the given base url, configured by the given
+//options. The url is normalized with NormalizeURL, so a host name or ip address alone is
+//accepted as well. An invalid url is reported by the first request.
+func NewClient(managementURL string, options ...Option) *Client {
+	if normalized, err := NormalizeURL(managementURL); err == nil {
+		managementURL = normalized
+	}
+	c := &Client{ManagementURL: managementURL}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	if c.customTLS() {
+		return c.tlsHTTPClient()
+	}
+	return defaultHTTPClient
+}
+
+func (c *Client) logf(level LogLevel, format string, args ...interface{}) {
+	logger := c.Logger
+	if logger == nil {
+		logger = defaultLogger
+	}
+	message := fmt.Sprintf(format, args...)
+	if level >= LogWarning {
+		c.errors.add(level, message)
+	}
+	logger.Log(level, message)
+}
+
+//readValues reads the requested items, and validates that the response matches the request
+func (c *Client) readValues(ctx context.Context, req readRequest) (resp response, err error) {
+	c.ensureCapabilities(ctx)
+	req = c.translateRequest(req)
+
+	c.stats.update(func(s *Stats) { s.Reads++ })
+	ctx, done := c.instrument(ctx, "read", "ILRReadValues.cgi", len(req.Items))
+	defer func() { done(err) }()
+
+	if c.CoalesceReads {
+		resp, err = c.coalescedRead(ctx, req)
+	} else {
+		resp, err = c.readChunks(ctx, req)
+	}
+	if err != nil {
+		return response{}, err
+	}
+
+	//the response is returned along with a validation error, so callers may use partial results
+	respErr := validateResponse(req, resp)
+	for i := range resp.Items {
+		resp.Items[i].Name = c.canonicalName(resp.Items[i].Name)
+	}
+	if c.Audit != nil {
+		c.lastValues.set(resp.Items)
+	}
+	if respErr != nil {
+		return resp, respErr
+	}
+
+	return resp, nil
+}
+
+//translateRequest returns the request with item names as used by the controller
+func (c *Client) translateRequest(req readRequest) readRequest {
+	if len(c.Capabilities().Datapoints) == 0 {
+		return req
+	}
+	translated := readRequest{Items: make([]readRequestItem, len(req.Items))}
+	for i, item := range req.Items {
+		translated.Items[i] = readRequestItem{Name: c.controllerName(item.Name)}
+	}
+	return translated
+}
+
+func (c *Client) maxResponseSize() int64 {
+	if c.MaxResponseSize > 0 {
+		return c.MaxResponseSize
+	}
+	return DefaultMaxResponseSize
+}
+
+func (c *Client) chunkSize() int {
+	if c.ChunkSize > 0 {
+		return c.ChunkSize
+	}
+	return DefaultChunkSize
+}
+
+//readChunk performs a single ILRReadValues request
+func (c *Client) readChunk(ctx context.Context, req readRequest) (resp response, err error) {
+	//Serialize request, or reuse the body of an identical earlier request
+	requestData, err := c.requestBodies.body(req)
+	if err != nil {
+		c.logf(LogError, "error creating request: %v", err)
+		return
+	}
+
+	//Send request, recording the outcome for the circuit breaker once parsed
+	ctx = context.WithValue(ctx, breakerKey{}, true)
+	body, err := c.send(ctx, http.MethodPost, protocol.ReadPath, requestData)
+	if err != nil {
+		err = fmt.Errorf("error requesting data from server: %w", err)
+	} else {
+		resp, err = protocol.ParseResponse(body)
+	}
+	c.recordOutcome(ctx, err)
+	if err != nil {
+		return response{}, err
+	}
+	return resp, nil
+}
+
+func (c *Client) writeValue(ctx context.Context, sensorID int, valueName string, value string) error {
+	return c.writeValues(ctx, []datapointWrite{{sensorID, valueName, value}})
+}
+
+//writeValues sends the given values to the controller in a single request
+func (c *Client) writeValues(ctx context.Context, writes []datapointWrite) error {
+	writes, err := c.expandVirtualWrites(writes)
+	if err != nil {
+		return err
+	}
+	writes = c.guardFrost(writes)
+	if c.DryRun {
+		for _, w := range writes {
+			c.dryRun(ctx, fmt.Sprintf("G%v.%v", w.sensorID, w.datapoint), w.value)
+			c.audit(ctx, fmt.Sprintf("G%v.%v", w.sensorID, w.datapoint), w.sensorID, w.value, nil)
+		}
+		return nil
+	}
+
+	//lock sensors in ascending order, so concurrent batches can not deadlock
+	ids := make([]int, 0, len(writes))
+	seen := make(map[int]bool, len(writes))
+	for _, w := range writes {
+		if !seen[w.sensorID] {
+			seen[w.sensorID] = true
+			ids = append(ids, w.sensorID)
+		}
+	}
+	sort.Ints(ids)
+
+	//events are published after unlocking, as subscribers may write to the same sensors
+	events, err := c.writeLocked(ctx, ids, writes)
+	for _, e := range events {
+		c.Events().Publish(e)
+	}
+	return err
+}
+
+//writeLocked sends the writes with the given sensors locked, and returns the events to publish
+//about them
+func (c *Client) writeLocked(ctx context.Context, ids []int, writes []datapointWrite) (events []Event, err error) {
+	for _, id := range ids {
+		unlock := c.writeLocks.lock(id)
+		defer unlock()
+	}
+
+	c.ensureCapabilities(ctx)
+	err = c.sendWrites(ctx, writes)
+	if err == nil && c.VerifyWrites {
+		err = c.verifyWrites(ctx, writes)
+	}
+	origin, now := OriginFromContext(ctx), time.Now()
+	events = make([]Event, 0, len(writes))
+	for _, w := range writes {
+		item := fmt.Sprintf("G%v.%v", w.sensorID, w.datapoint)
+		c.audit(ctx, item, w.sensorID, w.value, err)
+		if err != nil {
+			events = append(events, WriteFailed{Time: now, SensorID: w.sensorID, Datapoint: w.datapoint, Value: w.value, Err: err, Origin: origin})
+			continue
+		}
+		c.origins.record(w.sensorID, origin, now)
+		events = append(events, ValueWritten{Time: now, Item: item, SensorID: w.sensorID, Value: w.value, Origin: origin})
+	}
+	return events, err
+}
+
+//sendWrites performs a single writeVal request, with the sensors already locked
+func (c *Client) sendWrites(ctx context.Context, writes []datapointWrite) error {
+	if err := c.writeLimiter.wait(ctx, c.WriteInterval); err != nil {
+		return err
+	}
+
+	//the value on the controller is unknown after any write attempt, successful or not
+	params := make([]string, len(writes))
+	for i, w := range writes {
+		c.cache.invalidate(w.sensorID, datapointField(w.datapoint))
+		name := c.controllerName(fmt.Sprintf("G%v.%v", w.sensorID, w.datapoint))
+		params[i] = protocol.WriteParam(name, w.value)
+	}
+
+	return c.sendWriteRequest(ctx, params)
+}
+
+//sendWriteRequest sends escaped name=value parameters to writeVal.cgi
+func (c *Client) sendWriteRequest(ctx context.Context, params []string) (err error) {
+	c.stats.update(func(s *Stats) { s.Writes++ })
+	ctx, done := c.instrument(ctx, "write", "writeVal.cgi", len(params))
+	defer func() { done(err) }()
+
+	//Send request
+	path := protocol.WriteURI(params)
+	_, err = c.send(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return fmt.Errorf("error sending data to server: %w", err)
+	}
+
+	return nil
+}
+
+//writeControllerValue writes an item of the controller itself, e.g. R0.PairingCmd, rather than
+//of a sensor
+func (c *Client) writeControllerValue(ctx context.Context, name string, value string) error {
+	if c.DryRun {
+		c.dryRun(ctx, name, value)
+		c.audit(ctx, name, -1, value, nil)
+		return nil
+	}
+	err := c.writeLimiter.wait(ctx, c.WriteInterval)
+	if err == nil {
+		err = c.sendWriteRequest(ctx, []string{protocol.WriteParam(name, value)})
+	}
+	c.audit(ctx, name, -1, value, err)
+	if err == nil {
+		c.Events().Publish(ValueWritten{Time: time.Now(), Item: name, SensorID: -1, Value: value, Origin: OriginFromContext(ctx)})
+	}
+	return err
+}
+
+//dryRun reports a write skipped in dry run mode
+func (c *Client) dryRun(ctx context.Context, item string, value string) {
+	c.logf(LogInfo, "dry run: %v=%v not written", item, value)
+	c.Events().Publish(DryRunWrite{Time: time.Now(), Item: item, Value: value, Origin: OriginFromContext(ctx)})
+}
+
+//GetSensorCount returns the total number of sensors on the server
+func (c *Client) GetSensorCount(ctx context.Context) (sensorCount int, err error) {
+	if c.CacheTTL > 0 {
+		sensorCount, ok := c.cache.getCount(c.CacheTTL)
+		c.countCache(ok)
+		if ok {
+			return sensorCount, nil
+		}
+	}
+
+	req := readRequest{Items: []readRequestItem{readRequestItem{Name: "totalNumberOfDevices"}}}
+
+	resp, err := c.readValues(ctx, req)
+	if err != nil {
+		if sensorCount, ok := c.lastGood.getCount(c.LastKnownGood); c.LastKnownGood > 0 && ok {
+			return sensorCount, nil
+		}
+		return 0, err
+	}
+
+	value, ok := resp.Value("totalNumberOfDevices")
+	if !ok {
+		return 0, errors.New("no values returned")
+	}
+
+	intValue, err := strconv.ParseInt(value, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("Unexpected value %v", value)
+	}
+
+	if c.CacheTTL > 0 {
+		c.cache.putCount(int(intValue))
+	}
+	if c.LastKnownGood > 0 {
+		c.lastGood.putCount(int(intValue))
+	}
+	return int(intValue), nil
+}
+
+//SetTargetTemperature changes the target temperature of a given sensor, given in the client
+//unit. A ramp of the sensor started by SetTargetTemperatureRamped is cancelled.
+func (c *Client) SetTargetTemperature(ctx context.Context, sensorID int, targetTemperature float32) error {
+	c.ramps.cancel(sensorID)
+	return c.setTargetTemperature(ctx, sensorID, targetTemperature)
+}
+
+func (c *Client) setTargetTemperature(ctx context.Context, sensorID int, targetTemperature float32) error {
+	value, err := c.formatTarget(sensorID, targetTemperature)
+	if err != nil {
+		return err
+	}
+	return c.writeValue(ctx, sensorID, "SollTemp", value)
+}
+
+//formatTemperature converts a temperature to the centidegrees used by the controller
+func formatTemperature(t float32) string {
+	return strconv.FormatFloat(float64(t*100), 'f', 0, 32)
+}
+
+//SetProgram changes the active week program of the thermostat
+func (c *Client) SetProgram(ctx context.Context, sensorID int, program Program) error {
+	if err := program.check(); err != nil {
+		return err
+	}
+	value := strconv.Itoa(int(program))
+	return c.writeValue(ctx, sensorID, "WeekProg", value)
+}
+
+//SetMode changes the active operating mode
+func (c *Client) SetMode(ctx context.Context, sensorID int, mode Mode) error {
+	if err := mode.check(); err != nil {
+		return err
+	}
+	value := strconv.Itoa(int(mode))
+	return c.writeValue(ctx, sensorID, "OPMode", value)
+}
+
+//GetSensors returns current sensor data for the sensors on the server. Problems parsing
+//individual values are logged; use GetSensorsWithWarnings to receive them.
+func (c *Client) GetSensors(ctx context.Context, sensorCount int) (sensors []Sensor, err error) {
+	sensors, _, err = c.GetSensorsWithWarnings(ctx, sensorCount)
+	return sensors, err
+}
+
+//GetSensorsWithWarnings returns current sensor data for the sensors on the server, along with
+//a warning for each value in the response which could not be parsed and was skipped, and for
+//each requested value missing from the response.
+//In strict mode, any such warning makes it fail with a *ParseError instead.
+func (c *Client) GetSensorsWithWarnings(ctx context.Context, sensorCount int) (sensors []Sensor, warnings []ParseWarning, err error) {
+	if c.CacheTTL > 0 {
+		sensors, warnings, ok := c.cache.get(sensorCount, c.CacheTTL)
+		c.countCache(ok)
+		if ok {
+			sensors = c.withVirtualSensors(sensors)
+			c.annotate(sensors)
+			return sensors, warnings, nil
+		}
+	}
+	sensors, warnings, err = c.fetchSensors(ctx, sensorCount)
+	if err != nil {
+		if stale, staleWarnings, ok := c.serveStale(sensorCount, err); ok {
+			stale = c.withVirtualSensors(stale)
+			c.annotate(stale)
+			return stale, staleWarnings, nil
+		}
+	}
+	if err == nil {
+		sensors = c.withVirtualSensors(sensors)
+	}
+	c.annotate(sensors)
+	return sensors, warnings, err
+}
+
+type sensorResult struct {
+	sensors  []Sensor
+	warnings []ParseWarning
+}
+
+//fetchSensors reads sensor data from the controller, and updates the cache. Concurrent calls
+//for the same sensors share one request.
+func (c *Client) fetchSensors(ctx context.Context, sensorCount int) (sensors []Sensor, warnings []ParseWarning, err error) {
+	key := strconv.Itoa(sensorCount)
+	val, err := c.sensorReads.do(ctx, key, func(ctx context.Context) (interface{}, error) {
+		sensors, warnings, err := c.readSensors(ctx, sensorCount)
+		return sensorResult{sensors, warnings}, err
+	})
+	if val == nil {
+		return []Sensor{}, nil, err
+	}
+
+	//every caller gets its own copy, as the result is shared
+	result := val.(sensorResult)
+	sensors = make([]Sensor, len(result.sensors))
+	copy(sensors, result.sensors)
+	warnings = make([]ParseWarning, len(result.warnings))
+	copy(warnings, result.warnings)
+	return sensors, warnings, err
+}
+
+//readSensors performs the controller request for fetchSensors
+func (c *Client) readSensors(ctx context.Context, sensorCount int) (sensors []Sensor, warnings []ParseWarning, err error) {
+	sensors, warnings, err = c.readAllSensors(ctx, sensorCount)
+	if err != nil {
+		return sensors, warnings, err
+	}
+
+	if c.CacheTTL > 0 {
+		c.cache.put(sensors, warnings)
+	}
+	if c.LastKnownGood > 0 {
+		c.lastGood.put(sensors, warnings)
+	}
+	return sensors, warnings, nil
+}
+
+//readSensorDatapoints reads the given datapoints of the given sensors in a single request
+func (c *Client) readSensorDatapoints(ctx context.Context, ids []int, datapoints []Datapoint) (sensors []Sensor, warnings []ParseWarning, err error) {
+	//Create request for all values
+	req := readRequest{Items: make([]readRequestItem, 0, len(ids)*len(datapoints))}
+	for _, id := range ids {
+		for _, d := range datapoints {
+			req.Items = append(req.Items, readRequestItem{Name: "G" + strconv.Itoa(id) + "." + d.Name})
+		}
+	}
+
+	resp, err := c.readValues(ctx, req)
+	return c.parseSensorResponse(resp, err, ids, datapoints)
+}
+
+//parseSensorResponse parses the sensors of a read for readSensorDatapoints, given the error of
+//the read
+func (c *Client) parseSensorResponse(resp response, err error, ids []int, datapoints []Datapoint) (sensors []Sensor, warnings []ParseWarning, _ error) {
+	var respErr *ResponseError
+	if errors.As(err, &respErr) && !c.Strict {
+		//missing items are reported by parseSensors
+		for _, name := range respErr.Duplicate {
+			warnings = append(warnings, ParseWarning{Item: name, Message: "duplicate item"})
+		}
+	} else if err != nil {
+		return []Sensor{}, nil, err
+	}
+
+	sensors, sensorWarnings := parseSensors(resp, ids, datapoints, c.KeepRawValues, c.codecFor)
+	readAt := time.Now()
+	for i := range sensors {
+		sensors[i].ReadAt = readAt
+	}
+	c.normalizeUnits(sensors)
+	c.applySoftwareOffsets(sensors)
+	warnings = append(warnings, sensorWarnings...)
+	c.stats.update(func(s *Stats) { s.ParseWarnings += int64(len(warnings)) })
+	if c.Strict && len(warnings) > 0 {
+		return []Sensor{}, warnings, &ParseError{Warnings: warnings}
+	}
+	for _, warning := range warnings {
+		c.logf(LogWarning, "%v", warning)
+	}
+	return sensors, warnings, nil
+}
@@ -0,0 +1,270 @@
+package roth_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/rothtest"
+)
+
+//testSensors are the thermostats the fake controller starts with
+func testSensors() []roth.Sensor {
+	return []roth.Sensor{
+		{Id: 0, Name: "Living room", RoomTemperature: 20.86, TargetTemperature: 21, Program: roth.Program1, Mode: roth.ModeDay},
+		{Id: 1, Name: "Bedroom", RoomTemperature: 18.5, TargetTemperature: 17.5, Program: roth.ProgramConstant, Mode: roth.ModeNight},
+	}
+}
+
+func newTestClient(srv *rothtest.Server, options ...roth.Option) *roth.Client {
+	return roth.NewClient(srv.URL, append([]roth.Option{roth.WithLogger(roth.DiscardLogger)}, options...)...)
+}
+
+func TestGetSensorsParsing(t *testing.T) {
+	tests := []struct {
+		name string
+		//raw datapoint values overriding those of testSensors
+		values map[string]string
+		//want is sensor 0 as parsed, with the fields in want.Valid compared
+		want         roth.Sensor
+		wantWarnings []string
+	}{
+		{
+			name: "valid",
+			want: roth.Sensor{Id: 0, Name: "Living room", RoomTemperature: 20.86, TargetTemperature: 21, Program: roth.Program1, Mode: roth.ModeDay, Valid: roth.AllFields},
+		},
+		{
+			name:         "room temperature not a number",
+			values:       map[string]string{"G0.RaumTemp": "abc"},
+			want:         roth.Sensor{Id: 0, Name: "Living room", TargetTemperature: 21, Program: roth.Program1, Mode: roth.ModeDay, Valid: roth.AllFields &^ roth.FieldRoomTemperature},
+			wantWarnings: []string{"G0.RaumTemp"},
+		},
+		{
+			name:         "temperature out of 16 bit range",
+			values:       map[string]string{"G0.SollTemp": "70000"},
+			want:         roth.Sensor{Id: 0, Name: "Living room", RoomTemperature: 20.86, Program: roth.Program1, Mode: roth.ModeDay, Valid: roth.AllFields &^ roth.FieldTargetTemperature},
+			wantWarnings: []string{"G0.SollTemp"},
+		},
+		{
+			name:         "negative room temperature",
+			values:       map[string]string{"G0.RaumTemp": "-250"},
+			want:         roth.Sensor{Id: 0, Name: "Living room", RoomTemperature: -2.5, TargetTemperature: 21, Program: roth.Program1, Mode: roth.ModeDay, Valid: roth.AllFields},
+			wantWarnings: nil,
+		},
+		{
+			name:         "unknown week program",
+			values:       map[string]string{"G0.WeekProg": "9"},
+			want:         roth.Sensor{Id: 0, Name: "Living room", RoomTemperature: 20.86, TargetTemperature: 21, Mode: roth.ModeDay, Valid: roth.AllFields &^ roth.FieldProgram},
+			wantWarnings: []string{"G0.WeekProg"},
+		},
+		{
+			name:         "unknown mode",
+			values:       map[string]string{"G0.OPMode": "7"},
+			want:         roth.Sensor{Id: 0, Name: "Living room", RoomTemperature: 20.86, TargetTemperature: 21, Program: roth.Program1, Valid: roth.AllFields &^ roth.FieldMode},
+			wantWarnings: []string{"G0.OPMode"},
+		},
+		{
+			name:   "several invalid values",
+			values: map[string]string{"G0.RaumTemp": "", "G0.OPMode": "x"},
+			want:   roth.Sensor{Id: 0, Name: "Living room", TargetTemperature: 21, Program: roth.Program1, Valid: roth.AllFields &^ (roth.FieldRoomTemperature | roth.FieldMode)},
+			//warnings are in the order of the items in the response
+			wantWarnings: []string{"G0.RaumTemp", "G0.OPMode"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := rothtest.NewServer(testSensors()...)
+			defer srv.Close()
+			for name, value := range test.values {
+				srv.SetValue(name, value)
+			}
+
+			sensors, warnings, err := newTestClient(srv).GetSensorsWithWarnings(context.Background(), 2)
+			if err != nil {
+				t.Fatalf("GetSensorsWithWarnings: %v", err)
+			}
+			if len(sensors) != 2 {
+				t.Fatalf("got %v sensors, want 2", len(sensors))
+			}
+			compareSensor(t, sensors[0], test.want)
+
+			var items []string
+			for _, w := range warnings {
+				items = append(items, w.Item)
+			}
+			if !equalStrings(items, test.wantWarnings) {
+				t.Errorf("got warnings for %q, want %q", items, test.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestGetSensorsStrictAndGaps(t *testing.T) {
+	tests := []struct {
+		name    string
+		strict  bool
+		sensors []roth.Sensor
+		setup   func(srv *rothtest.Server)
+		wantIDs []int
+		//wantWarnings is the number of warnings expected
+		wantWarnings int
+		//wantErr checks the error, nil if none is expected
+		wantErr func(err error) bool
+	}{
+		{
+			name:    "complete",
+			sensors: testSensors(),
+			wantIDs: []int{0, 1},
+		},
+		{
+			name:    "complete strict",
+			strict:  true,
+			sensors: testSensors(),
+			wantIDs: []int{0, 1},
+		},
+		{
+			name: "gap of a removed thermostat",
+			sensors: []roth.Sensor{
+				{Id: 0, Name: "Living room", TargetTemperature: 21},
+				{Id: 2, Name: "Kitchen", TargetTemperature: 20},
+			},
+			wantIDs: []int{0, 2},
+		},
+		{
+			name: "gap of a removed thermostat strict",
+			sensors: []roth.Sensor{
+				{Id: 0, Name: "Living room", TargetTemperature: 21},
+				{Id: 2, Name: "Kitchen", TargetTemperature: 20},
+			},
+			strict:  true,
+			wantIDs: []int{0, 2},
+		},
+		{
+			name:         "missing item",
+			sensors:      testSensors(),
+			setup:        func(srv *rothtest.Server) { srv.SetFault(rothtest.FaultMissingItems) },
+			wantIDs:      []int{0, 1},
+			wantWarnings: 1,
+		},
+		{
+			name:    "missing item strict",
+			strict:  true,
+			sensors: testSensors(),
+			setup:   func(srv *rothtest.Server) { srv.SetFault(rothtest.FaultMissingItems) },
+			wantErr: func(err error) bool {
+				var respErr *roth.ResponseError
+				return errors.As(err, &respErr) && len(respErr.Missing) == 1
+			},
+		},
+		{
+			name:         "invalid value",
+			sensors:      testSensors(),
+			setup:        func(srv *rothtest.Server) { srv.SetValue("G1.SollTemp", "warm") },
+			wantIDs:      []int{0, 1},
+			wantWarnings: 1,
+		},
+		{
+			name:    "invalid value strict",
+			strict:  true,
+			sensors: testSensors(),
+			setup:   func(srv *rothtest.Server) { srv.SetValue("G1.SollTemp", "warm") },
+			wantErr: func(err error) bool {
+				var parseErr *roth.ParseError
+				return errors.As(err, &parseErr) && len(parseErr.Warnings) == 1 && parseErr.Warnings[0].Item == "G1.SollTemp"
+			},
+		},
+		{
+			name:    "malformed response",
+			sensors: testSensors(),
+			setup:   func(srv *rothtest.Server) { srv.SetFault(rothtest.FaultMalformedXML) },
+			wantErr: func(err error) bool { return err != nil },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := rothtest.NewServer(test.sensors...)
+			defer srv.Close()
+			if test.setup != nil {
+				test.setup(srv)
+			}
+			var options []roth.Option
+			if test.strict {
+				options = append(options, roth.WithStrict())
+			}
+
+			sensors, warnings, err := newTestClient(srv, options...).GetSensorsWithWarnings(context.Background(), len(test.sensors))
+			if test.wantErr != nil {
+				if err == nil || !test.wantErr(err) {
+					t.Fatalf("got error %v, want a different error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetSensorsWithWarnings: %v", err)
+			}
+			var ids []int
+			for _, s := range sensors {
+				ids = append(ids, s.Id)
+			}
+			if !equalInts(ids, test.wantIDs) {
+				t.Errorf("got sensors %v, want %v", ids, test.wantIDs)
+			}
+			if len(warnings) != test.wantWarnings {
+				t.Errorf("got %v warnings, want %v: %v", len(warnings), test.wantWarnings, warnings)
+			}
+		})
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//compareSensor reports the differences in the fields of want.Valid, and in the set itself
+func compareSensor(t *testing.T, got, want roth.Sensor) {
+	t.Helper()
+	if got.Id != want.Id {
+		t.Errorf("got sensor %v, want %v", got.Id, want.Id)
+	}
+	if got.Valid != want.Valid {
+		t.Errorf("got valid fields %v, want %v", got.Valid, want.Valid)
+	}
+	if want.Valid&roth.FieldName != 0 && got.Name != want.Name {
+		t.Errorf("got name %q, want %q", got.Name, want.Name)
+	}
+	if want.Valid&roth.FieldRoomTemperature != 0 && got.RoomTemperature != want.RoomTemperature {
+		t.Errorf("got room temperature %v, want %v", got.RoomTemperature, want.RoomTemperature)
+	}
+	if want.Valid&roth.FieldTargetTemperature != 0 && got.TargetTemperature != want.TargetTemperature {
+		t.Errorf("got target temperature %v, want %v", got.TargetTemperature, want.TargetTemperature)
+	}
+	if want.Valid&roth.FieldProgram != 0 && got.Program != want.Program {
+		t.Errorf("got program %v, want %v", got.Program, want.Program)
+	}
+	if want.Valid&roth.FieldMode != 0 && got.Mode != want.Mode {
+		t.Errorf("got mode %v, want %v", got.Mode, want.Mode)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
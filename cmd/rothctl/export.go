@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/history"
+)
+
+//export writes samples recorded by a history file store as csv or parquet, either to a single
+//file or to one file per sensor
+func export(ctx context.Context, client *roth.Client, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	historyFile := flags.String("history", "", "history file written by the recorder")
+	format := flags.String("format", "csv", "output format, csv or parquet")
+	sensors := flags.String("sensor", "", "comma separated sensor ids, all sensors if empty")
+	fromDate := flags.String("from", "", "first date to export, as 2006-01-02")
+	toDate := flags.String("to", "", "last date to export, as 2006-01-02")
+	out := flags.String("out", "", "output file, stdout if empty")
+	dir := flags.String("dir", "", "write one file per sensor to the given directory")
+	flags.Parse(args)
+
+	if *historyFile == "" {
+		return fmt.Errorf("missing -history")
+	}
+	var write func(io.Writer, []history.Sample) error
+	switch *format {
+	case "csv":
+		write = history.WriteCSV
+	case "parquet":
+		write = history.WriteParquet
+	default:
+		return fmt.Errorf("invalid format %q: must be csv or parquet", *format)
+	}
+
+	from, err := parseDate(*fromDate, time.Time{})
+	if err != nil {
+		return err
+	}
+	//the to date is inclusive
+	to, err := parseDate(*toDate, time.Now())
+	if err != nil {
+		return err
+	}
+	if *toDate != "" {
+		to = to.AddDate(0, 0, 1)
+	}
+
+	var ids []int
+	if *sensors != "" {
+		for _, s := range strings.Split(*sensors, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				return fmt.Errorf("invalid sensor id %q", s)
+			}
+			ids = append(ids, id)
+		}
+	}
+
+	store, err := history.OpenFile(*historyFile)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if len(ids) == 0 {
+		if ids, err = store.Sensors(); err != nil {
+			return err
+		}
+	}
+
+	if *dir == "" {
+		samples, err := history.Select(store, from, to, ids...)
+		if err != nil {
+			return err
+		}
+		if *out == "" {
+			return write(os.Stdout, samples)
+		}
+		return writeFile(*out, samples, write)
+	}
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		samples, err := history.Select(store, from, to, id)
+		if err != nil {
+			return err
+		}
+		if err := writeFile(filepath.Join(*dir, fmt.Sprintf("sensor%d.%v", id, *format)), samples, write); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//parseDate parses a date in the local time zone, or returns def if empty
+func parseDate(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q: must be formatted as 2006-01-02", s)
+	}
+	return t, nil
+}
+
+func writeFile(path string, samples []history.Sample, write func(io.Writer, []history.Sample) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f, samples); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/history"
+)
+
+//importHistory adds the samples of a csv log or a Home Assistant history export to a history
+//file, before the samples recorded so far
+func importHistory(ctx context.Context, client *roth.Client, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	historyFile := flags.String("history", "", "history file written by the recorder")
+	format := flags.String("format", "csv", "input format, csv or homeassistant")
+	entityList := flags.String("entity", "", "comma separated home assistant entities and sensor ids, e.g. climate.bathroom=3")
+	flags.Parse(args)
+	if *historyFile == "" || flags.NArg() != 1 {
+		return fmt.Errorf("usage: import -history file [-format csv|homeassistant] [-entity entity=id,...] <file>")
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var samples []history.Sample
+	switch *format {
+	case "csv":
+		samples, err = history.ReadCSV(f)
+	case "homeassistant":
+		entities := make(map[string]int)
+		for _, entry := range strings.Split(*entityList, ",") {
+			if strings.TrimSpace(entry) == "" {
+				continue
+			}
+			parts := strings.SplitN(entry, "=", 2)
+			id, idErr := strconv.Atoi(strings.TrimSpace(parts[len(parts)-1]))
+			if len(parts) != 2 || idErr != nil {
+				return fmt.Errorf("invalid entity %q: must be entity=sensor id", entry)
+			}
+			entities[strings.TrimSpace(parts[0])] = id
+		}
+		if len(entities) == 0 {
+			return fmt.Errorf("missing -entity for the homeassistant format")
+		}
+		samples, err = history.ReadHomeAssistant(f, entities)
+	default:
+		return fmt.Errorf("invalid format %q: must be csv or homeassistant", *format)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading %v: %v", flags.Arg(0), err)
+	}
+
+	store, err := history.OpenFile(*historyFile)
+	if err != nil {
+		return err
+	}
+	imported, err := history.Import(store, samples)
+	if err != nil {
+		store.Close()
+		return err
+	}
+	if err := store.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("imported %v of %v samples\n", imported, len(samples))
+	return nil
+}
@@ -0,0 +1,145 @@
+//Command rothctl inspects and controls a Roth Touchline controller from the command line.
+//
+//Usage:
+//
+//	rothctl [-url http://ROTH-10A6D5] [-dry-run] <command> [arguments]
+//
+//The controller url may also be given in the ROTH_URL environment variable.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+//command is a rothctl subcommand
+type command struct {
+	usage string
+	run   func(ctx context.Context, client *roth.Client, args []string) error
+	//local commands do not talk to the controller, and need no url
+	local bool
+}
+
+var commands = map[string]command{
+	"diag":     {"diag [-zip file]  write a diagnostics bundle, as json to stdout or as a zip file", diag, false},
+	"report":   {"report -history file [-period day|week] [-from date] [-to date] [-format text|html|json]  print comfort reports", report, true},
+	"set":      {"set <sensor> target|mode|program <value>  change a value of a sensor", set, false},
+	"schedule": {"schedule copy|apply [-program p] [-template file] <sensors>  copy a week program from the first sensor to the others, or apply a template", schedule, false},
+	"verify":   {"verify [-apply] <config.yaml>  compare the thermostats against a declared config, and optionally converge them", verify, false},
+	"export":   {"export -history file [-format csv|parquet] [-sensor ids] [-from date] [-to date] [-out file | -dir dir]  export recorded history", export, true},
+	"import":   {"import -history file [-format csv|homeassistant] [-entity entity=id,...] <file>  import temperature logs into a history file", importHistory, true},
+	"test":     {"test [-confirm] status|start|stop|exercise|valve <sensor> open|closed|pump on|off|loop [-duration d] <sensor>  test valves and the pump when commissioning", test, false},
+}
+
+func main() {
+	flags := flag.NewFlagSet("rothctl", flag.ExitOnError)
+	managementURL := flags.String("url", os.Getenv("ROTH_URL"), "base url of the controller")
+	timeout := flags.Duration("timeout", 10*time.Second, "timeout of each request")
+	dryRun := flags.Bool("dry-run", false, "print writes instead of sending them to the controller")
+	origin := flags.String("origin", "rothctl", "origin of writes in the audit log and events, e.g. user:alice")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: rothctl [-url url] [-dry-run] <command> [arguments]")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, "\ncommands:")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(os.Stderr, "  %v\n", commands[name].usage)
+		}
+	}
+	flags.Parse(os.Args[1:])
+
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok || (*managementURL == "" && !cmd.local) {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx = roth.WithOrigin(ctx, *origin)
+
+	options := []roth.Option{roth.WithTimeout(*timeout), roth.WithLogger(roth.NewWriterLogger(os.Stderr, roth.LogWarning))}
+	if *dryRun {
+		options = append(options, roth.WithDryRun())
+	}
+	client := roth.NewClient(*managementURL, options...)
+	if *dryRun {
+		client.Events().Subscribe(func(e roth.Event) {
+			if w, ok := e.(roth.DryRunWrite); ok {
+				fmt.Printf("would write %v=%v\n", w.Item, w.Value)
+			}
+		})
+	}
+	if err := cmd.run(ctx, client, flags.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "rothctl %v: %v\n", flags.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+func diag(ctx context.Context, client *roth.Client, args []string) error {
+	flags := flag.NewFlagSet("diag", flag.ExitOnError)
+	zipFile := flags.String("zip", "", "write a zip bundle to the given file")
+	flags.Parse(args)
+
+	d := client.Diagnostics(ctx)
+	for _, failure := range d.Failures {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", failure)
+	}
+	if *zipFile == "" {
+		return d.WriteJSON(os.Stdout)
+	}
+
+	f, err := os.Create(*zipFile)
+	if err != nil {
+		return err
+	}
+	if err := d.WriteZip(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func set(ctx context.Context, client *roth.Client, args []string) error {
+	if len(args) != 3 {
+		return fmt.Errorf("usage: set <sensor> target|mode|program <value>")
+	}
+	sensorID, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid sensor id %q", args[0])
+	}
+
+	switch args[1] {
+	case "target":
+		target, err := strconv.ParseFloat(args[2], 32)
+		if err != nil {
+			return fmt.Errorf("invalid temperature %q", args[2])
+		}
+		return client.SetTargetTemperature(ctx, sensorID, float32(target))
+	case "mode":
+		mode, err := roth.ParseMode(args[2])
+		if err != nil {
+			return err
+		}
+		return client.SetMode(ctx, sensorID, mode)
+	case "program":
+		program, err := roth.ParseProgram(args[2])
+		if err != nil {
+			return err
+		}
+		return client.SetProgram(ctx, sensorID, program)
+	}
+	return fmt.Errorf("unknown value %q: must be target, mode or program", args[1])
+}
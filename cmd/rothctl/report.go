@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/history"
+)
+
+//report prints daily or weekly comfort reports from a history file store
+func report(ctx context.Context, client *roth.Client, args []string) error {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	historyFile := flags.String("history", "", "history file written by the recorder")
+	period := flags.String("period", "day", "report period, day or week")
+	format := flags.String("format", "text", "output format, text, html or json")
+	fromDate := flags.String("from", "", "first date to report, as 2006-01-02, a week ago if empty")
+	toDate := flags.String("to", "", "last date to report, as 2006-01-02, today if empty")
+	band := flags.Float64("band", history.DefaultComfortBand, "distance from the target temperature considered comfortable")
+	flags.Parse(args)
+
+	if *historyFile == "" {
+		return fmt.Errorf("missing -history")
+	}
+	today := time.Now()
+	to, err := parseDate(*toDate, time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.Local))
+	if err != nil {
+		return err
+	}
+	//the to date is inclusive
+	to = to.AddDate(0, 0, 1)
+	from, err := parseDate(*fromDate, to.AddDate(0, 0, -7))
+	if err != nil {
+		return err
+	}
+
+	store, err := history.OpenFile(*historyFile)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	reporter := history.NewReporter(store)
+	reporter.ComfortBand = float32(*band)
+
+	var reports []history.Report
+	switch *period {
+	case "day":
+		reports, err = reporter.Daily(from, to)
+	case "week":
+		reports, err = reporter.Weekly(from, to)
+	default:
+		return fmt.Errorf("invalid period %q: must be day or week", *period)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "text":
+		for _, r := range reports {
+			if err := r.WriteText(os.Stdout); err != nil {
+				return err
+			}
+			fmt.Println()
+		}
+	case "html":
+		for _, r := range reports {
+			if err := r.WriteHTML(os.Stdout); err != nil {
+				return err
+			}
+		}
+	case "json":
+		return json.NewEncoder(os.Stdout).Encode(reports)
+	default:
+		return fmt.Errorf("invalid format %q: must be text, html or json", *format)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+//schedule copies week programs between sensors, or applies a template file to them
+func schedule(ctx context.Context, client *roth.Client, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: schedule copy|apply [arguments]")
+	}
+	flags := flag.NewFlagSet("schedule "+args[0], flag.ExitOnError)
+	programName := flags.String("program", "program1", "week program to copy or write")
+	templateFile := flags.String("template", "", "template file, for apply")
+	flags.Parse(args[1:])
+
+	program, err := roth.ParseProgram(*programName)
+	if err != nil {
+		return err
+	}
+	ids := make([]int, flags.NArg())
+	for i, arg := range flags.Args() {
+		if ids[i], err = strconv.Atoi(arg); err != nil {
+			return fmt.Errorf("invalid sensor id %q", arg)
+		}
+	}
+
+	var result roth.BulkResult
+	switch args[0] {
+	case "copy":
+		if len(ids) < 2 {
+			return fmt.Errorf("usage: schedule copy [-program p] <from> <to>...")
+		}
+		result, err = client.CopyWeekSchedule(ctx, ids[0], program, ids[1:]...)
+	case "apply":
+		if *templateFile == "" || len(ids) == 0 {
+			return fmt.Errorf("usage: schedule apply [-program p] -template file <sensor>...")
+		}
+		f, err := os.Open(*templateFile)
+		if err != nil {
+			return err
+		}
+		t, err := roth.LoadTemplate(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		result, err = client.ApplyTemplate(ctx, t, program, ids...)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown schedule command %q: must be copy or apply", args[0])
+	}
+	if err != nil {
+		return err
+	}
+	return result.Err()
+}
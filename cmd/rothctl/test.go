@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+const testUsage = "usage: test [-confirm] status|start|stop|exercise|valve <sensor> open|closed|pump on|off|loop [-duration d] <sensor>"
+
+//test operates the actuator test of the controller, for commissioning heating loops. Commands
+//taking over or exercising the outputs need -confirm.
+func test(ctx context.Context, client *roth.Client, args []string) error {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	confirm := flags.Bool("confirm", false, "confirm commands taking over the outputs of the controller")
+	duration := flags.Duration("duration", 5*time.Minute, "how long loop keeps the valve open and the pump running")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return fmt.Errorf(testUsage)
+	}
+	admin := client.Admin()
+	args = flags.Args()[1:]
+
+	switch flags.Arg(0) {
+	case "status":
+		status, err := admin.TestStatus(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("test mode: %v\npump: %v\n", onOff(status.Active), onOff(status.Pump))
+		return nil
+	case "start":
+		return admin.StartTestMode(ctx, *confirm)
+	case "stop":
+		return admin.StopTestMode(ctx)
+	case "exercise":
+		return admin.ExerciseValves(ctx, *confirm)
+	case "valve":
+		if len(args) != 2 {
+			return fmt.Errorf(testUsage)
+		}
+		sensorID, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid sensor id %q", args[0])
+		}
+		open, err := parseOnOff(args[1])
+		if err != nil {
+			return err
+		}
+		return admin.ForceValve(ctx, sensorID, open)
+	case "pump":
+		if len(args) != 1 {
+			return fmt.Errorf(testUsage)
+		}
+		on, err := parseOnOff(args[0])
+		if err != nil {
+			return err
+		}
+		return admin.ForcePump(ctx, on)
+	case "loop":
+		//flags may also follow the subcommand, e.g. loop -duration 2m 3
+		loopFlags := flag.NewFlagSet("test loop", flag.ExitOnError)
+		loopFlags.BoolVar(confirm, "confirm", *confirm, "confirm taking over the outputs of the controller")
+		loopFlags.DurationVar(duration, "duration", *duration, "how long to keep the valve open and the pump running")
+		loopFlags.Parse(args)
+		if loopFlags.NArg() != 1 {
+			return fmt.Errorf(testUsage)
+		}
+		sensorID, err := strconv.Atoi(loopFlags.Arg(0))
+		if err != nil {
+			return fmt.Errorf("invalid sensor id %q", loopFlags.Arg(0))
+		}
+		fmt.Printf("testing loop of sensor %v for %v\n", sensorID, *duration)
+		return admin.TestLoop(ctx, sensorID, *duration, *confirm)
+	}
+	return fmt.Errorf(testUsage)
+}
+
+//parseOnOff parses the state of an output, as on/off, open/closed or a boolean
+func parseOnOff(s string) (bool, error) {
+	switch s {
+	case "on", "open":
+		return true, nil
+	case "off", "closed", "close":
+		return false, nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("invalid state %q: must be on or off", s)
+	}
+	return b, nil
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+//verify compares the thermostats against a declared config in yaml or json, and optionally
+//writes the declared values. Without -apply, drift fails the command, for use in scripts.
+func verify(ctx context.Context, client *roth.Client, args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	apply := flags.Bool("apply", false, "write the declared values of all settings which differ")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: verify [-apply] <config.yaml>")
+	}
+
+	data, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	data, err = yamlToJSON(data)
+	if err != nil {
+		return fmt.Errorf("error reading %v: %v", flags.Arg(0), err)
+	}
+	config, err := roth.ReadDeclaredConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	drift, err := client.CompareConfig(ctx, config)
+	if err != nil {
+		return err
+	}
+	for _, d := range drift {
+		fmt.Println(d)
+	}
+	if len(drift) == 0 {
+		fmt.Println("controller matches the declared config")
+		return nil
+	}
+	if !*apply {
+		return fmt.Errorf("%v settings differ from the declared config", len(drift))
+	}
+	if err := client.ApplyDrift(ctx, drift).Err(); err != nil {
+		return err
+	}
+	fmt.Println("applied the declared config")
+	return nil
+}
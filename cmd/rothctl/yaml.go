@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//yamlLine is a non-empty line of a yaml document, without comments
+type yamlLine struct {
+	number  int
+	indent  int
+	content string
+}
+
+//yamlToJSON converts a yaml document to json, so it can be decoded by the json decoders of the
+//library. Only the block style used by configuration files is supported: mappings, sequences,
+//plain and quoted scalars, and flow sequences of scalars. Json documents are returned as is.
+func yamlToJSON(data []byte) ([]byte, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return data, nil
+	}
+
+	var lines []yamlLine
+	for i, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(stripComment(raw), " \t\r")
+		content := strings.TrimLeft(raw, " ")
+		if content == "" || content == "---" {
+			continue
+		}
+		if strings.HasPrefix(content, "\t") {
+			return nil, fmt.Errorf("line %v: tabs are not allowed for indentation", i+1)
+		}
+		lines = append(lines, yamlLine{number: i + 1, indent: len(raw) - len(content), content: content})
+	}
+	if len(lines) == 0 {
+		return []byte("null"), nil
+	}
+
+	p := &yamlParser{lines: lines}
+	value, err := p.block(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %v: unexpected indentation", p.lines[p.pos].number)
+	}
+	return json.Marshal(value)
+}
+
+//stripComment removes a comment from a line, outside of quoted strings
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+//block parses the mapping or sequence starting at the current line, with the given indentation
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	line := p.lines[p.pos]
+	if line.content == "-" || strings.HasPrefix(line.content, "- ") {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	items := []interface{}{}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent != indent || !(line.content == "-" || strings.HasPrefix(line.content, "- ")) {
+			break
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(line.content, "-"), " ")
+		if rest == "" {
+			p.pos++
+			value, err := p.nested(indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+			continue
+		}
+		if _, _, ok := splitKey(rest); ok || strings.HasPrefix(rest, "- ") {
+			//an item starting on the line of the dash, e.g. "- id: 3", continues at the column of
+			//its first key
+			p.lines[p.pos] = yamlLine{number: line.number, indent: indent + len(line.content) - len(rest), content: rest}
+			value, err := p.block(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+			continue
+		}
+		value, err := scalar(rest, line.number)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, value)
+		p.pos++
+	}
+	return items, nil
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	values := make(map[string]interface{})
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent != indent || line.content == "-" || strings.HasPrefix(line.content, "- ") {
+			break
+		}
+		key, rest, ok := splitKey(line.content)
+		if !ok {
+			return nil, fmt.Errorf("line %v: expected key: value", line.number)
+		}
+		if _, ok := values[key]; ok {
+			return nil, fmt.Errorf("line %v: duplicate key %v", line.number, key)
+		}
+		p.pos++
+		if rest != "" {
+			value, err := scalar(rest, line.number)
+			if err != nil {
+				return nil, err
+			}
+			values[key] = value
+			continue
+		}
+		//sequences may be indented like their key
+		if p.pos < len(p.lines) && p.lines[p.pos].indent == indent && strings.HasPrefix(p.lines[p.pos].content, "-") {
+			value, err := p.sequence(indent)
+			if err != nil {
+				return nil, err
+			}
+			values[key] = value
+			continue
+		}
+		value, err := p.nested(indent)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+//nested parses the block indented deeper than indent at the current line, or returns null if
+//there is none
+func (p *yamlParser) nested(indent int) (interface{}, error) {
+	if p.pos >= len(p.lines) || p.lines[p.pos].indent <= indent {
+		return nil, nil
+	}
+	return p.block(p.lines[p.pos].indent)
+}
+
+//splitKey splits "key: value" or "key:" into key and value
+func splitKey(content string) (key string, rest string, ok bool) {
+	if strings.HasPrefix(content, "\"") || strings.HasPrefix(content, "'") {
+		return "", "", false
+	}
+	i := strings.Index(content, ": ")
+	if i < 0 {
+		if !strings.HasSuffix(content, ":") {
+			return "", "", false
+		}
+		i = len(content) - 1
+	}
+	key = strings.TrimSpace(content[:i])
+	if key == "" || strings.ContainsAny(key, "[]{}") {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(content[i+1:]), true
+}
+
+//scalar parses a plain or quoted scalar, or a flow sequence of scalars
+func scalar(s string, number int) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(s, "\""):
+		value, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: invalid string %v", number, s)
+		}
+		return value, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("line %v: invalid string %v", number, s)
+		}
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("line %v: invalid sequence %v", number, s)
+		}
+		items := []interface{}{}
+		inner := strings.TrimSpace(s[1 : len(s)-1])
+		if inner == "" {
+			return items, nil
+		}
+		for _, item := range strings.Split(inner, ",") {
+			value, err := scalar(strings.TrimSpace(item), number)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+		}
+		return items, nil
+	case strings.HasPrefix(s, "{"):
+		return nil, fmt.Errorf("line %v: flow mappings are not supported", number)
+	}
+
+	switch s {
+	case "null", "Null", "NULL", "~":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	return s, nil
+}
@@ -0,0 +1,234 @@
+package roth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Codec converts between the raw values of the controller and Go values, for datapoints the
+//library does not know, e.g. relay states, timestamps or error bits
+type Codec struct {
+	Decode func(raw string) (interface{}, error)
+	//Encode converts a value to a raw value. If nil, items using the codec are read-only.
+	Encode func(value interface{}) (string, error)
+}
+
+//BoolCodec converts 0 and 1 to false and true
+var BoolCodec = Codec{
+	Decode: func(raw string) (interface{}, error) {
+		switch raw {
+		case "0":
+			return false, nil
+		case "1":
+			return true, nil
+		}
+		return nil, fmt.Errorf("invalid boolean value %q", raw)
+	},
+	Encode: func(value interface{}) (string, error) {
+		b, ok := value.(bool)
+		if !ok {
+			return "", fmt.Errorf("expected a bool, got %T", value)
+		}
+		if b {
+			return "1", nil
+		}
+		return "0", nil
+	},
+}
+
+//IntCodec converts integers to int64
+var IntCodec = Codec{
+	Decode: func(raw string) (interface{}, error) {
+		return strconv.ParseInt(raw, 10, 64)
+	},
+	Encode: func(value interface{}) (string, error) {
+		i, err := toInt(value)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(i, 10), nil
+	},
+}
+
+//ScaledCodec converts integers to float64 divided by scale, e.g. ScaledCodec(100) for values
+//in hundredths like the temperatures. Encoded values are rounded to the nearest integer.
+func ScaledCodec(scale float64) Codec {
+	return Codec{
+		Decode: func(raw string) (interface{}, error) {
+			i, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			return float64(i) / scale, nil
+		},
+		Encode: func(value interface{}) (string, error) {
+			var f float64
+			switch v := value.(type) {
+			case float64:
+				f = v
+			case float32:
+				f = float64(v)
+			default:
+				i, err := toInt(value)
+				if err != nil {
+					return "", fmt.Errorf("expected a number, got %T", value)
+				}
+				f = float64(i)
+			}
+			return strconv.FormatInt(int64(math.Round(f*scale)), 10), nil
+		},
+	}
+}
+
+//TimestampCodec converts unix timestamps in seconds to time.Time
+var TimestampCodec = Codec{
+	Decode: func(raw string) (interface{}, error) {
+		seconds, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return time.Unix(seconds, 0), nil
+	},
+	Encode: func(value interface{}) (string, error) {
+		t, ok := value.(time.Time)
+		if !ok {
+			return "", fmt.Errorf("expected a time.Time, got %T", value)
+		}
+		return strconv.FormatInt(t.Unix(), 10), nil
+	},
+}
+
+//BitfieldCodec converts an integer to the names of the bits set, bit 0 being the first name.
+//Bits without a name are named bit<n>. Values are encoded from a []string of names.
+func BitfieldCodec(names ...string) Codec {
+	name := func(bit int) string {
+		if bit < len(names) && names[bit] != "" {
+			return names[bit]
+		}
+		return "bit" + strconv.Itoa(bit)
+	}
+	return Codec{
+		Decode: func(raw string) (interface{}, error) {
+			bits, err := strconv.ParseUint(raw, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			set := []string{}
+			for bit := 0; bits != 0; bit++ {
+				if bits&1 != 0 {
+					set = append(set, name(bit))
+				}
+				bits >>= 1
+			}
+			return set, nil
+		},
+		Encode: func(value interface{}) (string, error) {
+			set, ok := value.([]string)
+			if !ok {
+				return "", fmt.Errorf("expected a []string, got %T", value)
+			}
+			var bits uint64
+		next:
+			for _, s := range set {
+				for bit := 0; bit < 64; bit++ {
+					if name(bit) == s {
+						bits |= 1 << uint(bit)
+						continue next
+					}
+				}
+				return "", fmt.Errorf("unknown bit %v", s)
+			}
+			return strconv.FormatUint(bits, 10), nil
+		},
+	}
+}
+
+func toInt(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	}
+	return 0, fmt.Errorf("expected an integer, got %T", value)
+}
+
+type codecEntry struct {
+	pattern string
+	codec   Codec
+}
+
+//RegisterCodec sets the codec of the items matching the pattern, e.g. "G*.RelaisStatus" or
+//"R0.ErrorCode". Patterns use the syntax of path.Match, and match case insensitively; codecs
+//registered later take precedence. Codecs decode the custom datapoints of GetSensors into
+//Sensor.Values, encode values for WriteDatapoint, and decode the values of ReadDecoded. Like the
+//other client settings, codecs must be registered before first use of the client.
+func (c *Client) RegisterCodec(pattern string, codec Codec) error {
+	pattern = strings.ToLower(pattern)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid codec pattern %v: %v", pattern, err)
+	}
+	if codec.Decode == nil {
+		return errors.New("codec has no decode function")
+	}
+	c.codecs = append(c.codecs, codecEntry{pattern, codec})
+	return nil
+}
+
+//codecFor returns the codec registered for an item
+func (c *Client) codecFor(item string) (Codec, bool) {
+	if len(c.codecs) == 0 {
+		return Codec{}, false
+	}
+	item = strings.ToLower(item)
+	for i := len(c.codecs) - 1; i >= 0; i-- {
+		if ok, _ := path.Match(c.codecs[i].pattern, item); ok {
+			return c.codecs[i].codec, true
+		}
+	}
+	return Codec{}, false
+}
+
+//ReadDecoded reads arbitrary items like ReadRaw, and decodes their values with the registered
+//codecs. Values of items without a codec are returned as strings. Values which can not be
+//decoded are left out of the result, and reported in the error.
+func (c *Client) ReadDecoded(ctx context.Context, names ...string) (map[string]interface{}, error) {
+	raw, err := c.ReadRaw(ctx, names...)
+	if raw == nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{}, len(raw))
+	for _, name := range names {
+		value, ok := raw[name]
+		if !ok {
+			continue
+		}
+		codec, ok := c.codecFor(c.resolveItem(name))
+		if !ok {
+			values[name] = value
+			continue
+		}
+		decoded, decodeErr := codec.Decode(value)
+		if decodeErr != nil {
+			if err == nil {
+				err = fmt.Errorf("error decoding %v value %q: %v", name, value, decodeErr)
+			}
+			continue
+		}
+		values[name] = decoded
+	}
+	return values, err
+}
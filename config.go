@@ -0,0 +1,137 @@
+package roth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+//Config is a backup of the settings of a controller and its thermostats, as written by
+//DumpConfig. It is meant to be stored as json.
+type Config struct {
+	Time time.Time `json:"time"`
+	//Unit is the unit of all temperatures in the document
+	Unit    Unit           `json:"unit"`
+	Sensors []SensorConfig `json:"sensors"`
+}
+
+//SensorConfig holds the settings of a single thermostat. Settings the controller did not report
+//are nil, and left unchanged by RestoreConfig.
+type SensorConfig struct {
+	Id                int      `json:"id"`
+	Name              *string  `json:"name,omitempty"`
+	TargetTemperature *float32 `json:"targetTemperature,omitempty"`
+	Program           *Program `json:"program,omitempty"`
+	Mode              *Mode    `json:"mode,omitempty"`
+	//Offset is the measurement offset, see SetTemperatureOffset
+	Offset *float32 `json:"offset,omitempty"`
+	//Unit is the unit the thermostat displays
+	Unit *Unit `json:"displayUnit,omitempty"`
+	//UniqueID identifies the thermostat independent of its index, see RemapConfig
+	UniqueID string `json:"uniqueId,omitempty"`
+}
+
+//ConfigOffset is the field selecting the measurement offset in RestoreConfig, in addition to the
+//sensor fields
+const ConfigOffset Field = 1 << 16
+
+//DumpConfig reads the settings of all thermostats
+func (c *Client) DumpConfig(ctx context.Context) (*Config, error) {
+	sensorCount, err := c.GetSensorCount(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sensors, _, err := c.fetchSensors(ctx, sensorCount)
+	if err != nil {
+		return nil, err
+	}
+
+	uniqueIDs, err := c.uniqueIDs(ctx, sensorCount)
+	if err != nil {
+		c.logf(LogWarning, "error reading unique device ids: %v", err)
+	}
+
+	config := &Config{Time: time.Now(), Unit: c.Unit}
+	for _, s := range sensors {
+		s := s
+		sc := SensorConfig{Id: s.Id, UniqueID: uniqueIDs[s.Id]}
+		if s.Valid.Has(FieldName) {
+			sc.Name = &s.Name
+		}
+		if s.Valid.Has(FieldTargetTemperature) {
+			sc.TargetTemperature = &s.TargetTemperature
+		}
+		if s.Valid.Has(FieldProgram) {
+			sc.Program = &s.Program
+		}
+		if s.Valid.Has(FieldMode) {
+			sc.Mode = &s.Mode
+		}
+		if s.Valid.Has(FieldUnit) {
+			unit := c.units.get(s.Id)
+			sc.Unit = &unit
+		}
+		if offset, err := c.GetTemperatureOffset(ctx, s.Id); err == nil {
+			sc.Offset = &offset
+		}
+		config.Sensors = append(config.Sensors, sc)
+	}
+	return config, nil
+}
+
+//RestoreConfig writes the selected settings of a backup back to the thermostats, e.g.
+//FieldTargetTemperature|FieldMode, or AllFields|ConfigOffset for everything. Sensors are
+//matched by id.
+func (c *Client) RestoreConfig(ctx context.Context, config *Config, fields Field) (BulkResult, error) {
+	if config == nil {
+		return nil, errors.New("no config to restore")
+	}
+
+	batch := c.NewBatch()
+	for _, sc := range config.Sensors {
+		if sc.Name != nil && fields.Has(FieldName) {
+			batch.add(sc.Id, "name", *sc.Name)
+		}
+		if sc.TargetTemperature != nil && fields.Has(FieldTargetTemperature) {
+			batch.SetTargetTemperature(sc.Id, ConvertTemperature(*sc.TargetTemperature, config.Unit, c.Unit))
+		}
+		if sc.Program != nil && fields.Has(FieldProgram) {
+			batch.SetProgram(sc.Id, *sc.Program)
+		}
+		if sc.Mode != nil && fields.Has(FieldMode) {
+			batch.SetMode(sc.Id, *sc.Mode)
+		}
+		if sc.Unit != nil && fields.Has(FieldUnit) {
+			batch.add(sc.Id, "TempSIUnit", strconv.Itoa(int(*sc.Unit)))
+		}
+		if sc.Offset != nil && fields.Has(ConfigOffset) {
+			offset := convertDelta(*sc.Offset, config.Unit, c.units.get(sc.Id))
+			batch.add(sc.Id, offsetDatapoint, formatTemperature(offset))
+		}
+	}
+	//sent even if empty, so rejected values are reported
+	return batch.Send(ctx), nil
+}
+
+//WriteTo writes the config as indented json
+func (config *Config) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(append(data, '\n'))
+	return int64(n), err
+}
+
+//ReadConfig reads a config written by Config.WriteTo
+func ReadConfig(r io.Reader) (*Config, error) {
+	var config Config
+	if err := json.NewDecoder(r).Decode(&config); err != nil {
+		return nil, fmt.Errorf("error reading config: %v", err)
+	}
+	return &config, nil
+}
@@ -0,0 +1,44 @@
+package roth_test
+
+import (
+	"context"
+	"testing"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/rothtest"
+)
+
+func TestRestoreConfigRejected(t *testing.T) {
+	srv := rothtest.NewServer(batchSensors()...)
+	defer srv.Close()
+
+	target, tooHot := float32(22), float32(35)
+	program, mode := roth.Program(8), roth.ModeNight
+	config := &roth.Config{
+		Unit: roth.Celsius,
+		Sensors: []roth.SensorConfig{
+			{Id: 0, TargetTemperature: &target},
+			{Id: 1, TargetTemperature: &tooHot, Mode: &mode},
+			{Id: 2, Program: &program},
+		},
+	}
+
+	result, err := newTestClient(srv).RestoreConfig(context.Background(), config, roth.AllFields)
+	if err != nil {
+		t.Fatalf("RestoreConfig: %v", err)
+	}
+	if want := []int{1, 2}; !equalInts(result.Failed(), want) {
+		t.Errorf("got failed sensors %v, want %v", result.Failed(), want)
+	}
+	compareValues(t, srv, map[string]string{"G0.SollTemp": "2200", "G1.SollTemp": "2000", "G1.OPMode": "0", "G2.WeekProg": "1"})
+
+	//a config with nothing but rejected values still reports them
+	config.Sensors = config.Sensors[2:]
+	result, err = newTestClient(srv).RestoreConfig(context.Background(), config, roth.AllFields)
+	if err != nil {
+		t.Fatalf("RestoreConfig: %v", err)
+	}
+	if want := []int{2}; !equalInts(result.Failed(), want) {
+		t.Errorf("got failed sensors %v, want %v", result.Failed(), want)
+	}
+}
@@ -0,0 +1,120 @@
+package roth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//controller items read by GetControllerState
+const (
+	deviceCountItem  = "totalNumberOfDevices"
+	isMasterItem     = "isMaster"
+	systemStatusItem = "R0.SystemStatus"
+	errorFlagsItem   = "R0.ErrorCode"
+)
+
+//ErrorFlags are the error bits reported by the controller
+type ErrorFlags uint32
+
+//Has returns whether the given bit is set
+func (f ErrorFlags) Has(bit uint) bool {
+	return f&(1<<bit) != 0
+}
+
+//String returns the set bits, e.g. "bit0|bit3", or "none"
+func (f ErrorFlags) String() string {
+	var bits []string
+	for bit := uint(0); bit < 32; bit++ {
+		if f.Has(bit) {
+			bits = append(bits, "bit"+strconv.Itoa(int(bit)))
+		}
+	}
+	if len(bits) == 0 {
+		return "none"
+	}
+	return strings.Join(bits, "|")
+}
+
+//ControllerState holds the datapoints of the controller itself
+type ControllerState struct {
+	//DeviceCount is the number of sensors, see GetSensorCount
+	DeviceCount int `json:"deviceCount"`
+	//PairedDevices is the number of thermostats paired with the controller
+	PairedDevices int `json:"pairedDevices"`
+	//IsMaster is set for the master of several coupled controllers, and for a single controller
+	IsMaster     bool       `json:"isMaster"`
+	SystemStatus int        `json:"systemStatus"`
+	ErrorFlags   ErrorFlags `json:"errorFlags"`
+	//Alarms interprets ErrorFlags
+	Alarms Alarms `json:"alarms"`
+	//Time is the clock of the controller, zero if not reported
+	Time time.Time `json:"time,omitempty"`
+	//Missing lists the items the controller did not report or left empty, whose fields have their zero value
+	Missing []string `json:"missing,omitempty"`
+}
+
+//Equal returns whether two states are the same, ignoring the clock of the controller
+func (s ControllerState) Equal(other ControllerState) bool {
+	return s.DeviceCount == other.DeviceCount &&
+		s.PairedDevices == other.PairedDevices &&
+		s.IsMaster == other.IsMaster &&
+		s.SystemStatus == other.SystemStatus &&
+		s.ErrorFlags == other.ErrorFlags &&
+		strings.Join(s.Missing, ",") == strings.Join(other.Missing, ",")
+}
+
+//controllerStateItems are the items read by GetControllerState
+var controllerStateItems = []string{deviceCountItem, pairedDevicesItem, isMasterItem, systemStatusItem, errorFlagsItem, controllerTimeItem}
+
+//GetControllerState reads the datapoints of the controller in a single request. Items the
+//controller does not report, e.g. on older firmware, are listed in Missing.
+func (c *Client) GetControllerState(ctx context.Context) (ControllerState, error) {
+	req := readRequest{Items: make([]readRequestItem, len(controllerStateItems))}
+	for i, item := range controllerStateItems {
+		req.Items[i] = readRequestItem{Name: item}
+	}
+	resp, err := c.readValues(ctx, req)
+	var respErr *ResponseError
+	if err != nil && !errors.As(err, &respErr) {
+		return ControllerState{}, err
+	}
+	return parseControllerState(resp)
+}
+
+//parseControllerState reads the controller items of a response
+func parseControllerState(resp response) (ControllerState, error) {
+	var state ControllerState
+	var parseErr error
+	number := func(item string, bitSize int) int64 {
+		value, ok := resp.Value(item)
+		if !ok || value == "" {
+			state.Missing = append(state.Missing, item)
+			return 0
+		}
+		n, err := strconv.ParseInt(value, 10, bitSize)
+		if err != nil && parseErr == nil {
+			parseErr = fmt.Errorf("unexpected value %v for %v", value, item)
+		}
+		return n
+	}
+	state.DeviceCount = int(number(deviceCountItem, 16))
+	state.PairedDevices = int(number(pairedDevicesItem, 16))
+	state.IsMaster = number(isMasterItem, 16) == 1
+	state.SystemStatus = int(number(systemStatusItem, 32))
+	state.ErrorFlags = ErrorFlags(number(errorFlagsItem, 64))
+	state.Alarms = AlarmsOf(state.ErrorFlags)
+	if seconds := number(controllerTimeItem, 64); seconds > 0 {
+		state.Time = time.Unix(seconds, 0)
+	}
+	if parseErr != nil {
+		return ControllerState{}, parseErr
+	}
+	if len(state.Missing) == len(controllerStateItems) {
+		return ControllerState{}, errors.New("no values returned")
+	}
+	return state, nil
+}
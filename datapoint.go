@@ -0,0 +1,251 @@
+package roth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//Datapoint maps a datapoint of every thermostat, e.g. RaumTemp, to a sensor value. The built-in
+//datapoints are registered for every client; use Client.RegisterDatapoint to read additional
+//ones with GetSensors.
+type Datapoint struct {
+	//Name is the datapoint name without the sensor prefix. Names in controller responses are
+	//matched case insensitively.
+	Name string
+
+	//Field is the sensor field set by Parse, or 0 for custom datapoints
+	Field Field
+
+	//Parse stores a raw value in the sensor, or returns an error describing why it is invalid.
+	//If nil, the raw value is stored in Sensor.Extra under Name, and decoded into Sensor.Values
+	//if a codec is registered for the datapoint.
+	Parse func(s *Sensor, value string) error
+
+	//Format converts a value to the raw value written by WriteDatapoint. If nil, the codec
+	//registered for the datapoint is used, if any; otherwise the datapoint is read-only.
+	Format func(value interface{}) (string, error)
+}
+
+//parse applies the datapoint value to the sensor
+func (d Datapoint) parse(s *Sensor, value string) error {
+	if d.Parse == nil {
+		if s.Extra == nil {
+			s.Extra = make(map[string]string)
+		}
+		s.Extra[d.Name] = value
+		return nil
+	}
+	if err := d.Parse(s, value); err != nil {
+		return err
+	}
+	s.Valid |= d.Field
+	return nil
+}
+
+//RegisterDatapoint adds a datapoint read for every sensor by GetSensors, replacing a registered
+//datapoint of the same name. Like the other client settings, datapoints must be registered
+//before first use of the client.
+func (c *Client) RegisterDatapoint(d Datapoint) {
+	for i, existing := range c.customDatapoints {
+		if strings.EqualFold(existing.Name, d.Name) {
+			c.customDatapoints[i] = d
+			return
+		}
+	}
+	c.customDatapoints = append(c.customDatapoints, d)
+}
+
+//datapoints returns the built-in and registered datapoints, in the order they are requested
+func (c *Client) datapoints() []Datapoint {
+	if len(c.customDatapoints) == 0 && !c.ReadAlarms {
+		return sensorFields
+	}
+	datapoints := make([]Datapoint, 0, len(sensorFields)+len(c.customDatapoints)+1)
+	datapoints = append(datapoints, sensorFields...)
+	if c.ReadAlarms {
+		datapoints = append(datapoints, Datapoint{Name: alarmDatapoint, Parse: parseAlarms})
+	}
+	for _, d := range c.customDatapoints {
+		if field := datapointField(d.Name); field != 0 {
+			//a registered built-in datapoint replaces the default
+			for i := range datapoints {
+				if datapoints[i].Field == field {
+					datapoints[i] = d
+				}
+			}
+			continue
+		}
+		datapoints = append(datapoints, d)
+	}
+	return datapoints
+}
+
+//WriteDatapoint writes a value to a datapoint of a sensor, converted using the Format function
+//of the datapoint. Target temperatures are checked, converted and rounded like by
+//SetTargetTemperature; other temperatures are written as is, in the unit of the thermostat. The
+//datapoint may be given by an alias, e.g. targetTemperature.
+func (c *Client) WriteDatapoint(ctx context.Context, sensorID int, datapoint string, value interface{}) error {
+	datapoint = c.resolveAlias(datapoint)
+	for _, d := range c.datapoints() {
+		if !strings.EqualFold(d.Name, datapoint) {
+			continue
+		}
+		if strings.EqualFold(d.Name, "SollTemp") {
+			t, err := targetValue(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for %v: %v", d.Name, err)
+			}
+			return c.SetTargetTemperature(ctx, sensorID, t)
+		}
+		format := d.Format
+		if format == nil {
+			if codec, ok := c.codecFor(fmt.Sprintf("G%v.%v", sensorID, d.Name)); ok {
+				format = codec.Encode
+			}
+		}
+		if format == nil {
+			return fmt.Errorf("datapoint %v is read-only", d.Name)
+		}
+		raw, err := format(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for %v: %v", d.Name, err)
+		}
+		return c.writeValue(ctx, sensorID, d.Name, raw)
+	}
+	return fmt.Errorf("unknown datapoint %v", datapoint)
+}
+
+//ReadRaw reads arbitrary items, e.g. G0.RaumTemp or totalNumberOfDevices, and returns their
+//values as reported by the controller, without any parsing or scaling. Items may be given by
+//their aliases, e.g. G0.roomTemperature or deviceCount, and are returned under the names given.
+//Items missing from the response are left out of the result, along with a *ResponseError.
+func (c *Client) ReadRaw(ctx context.Context, names ...string) (map[string]string, error) {
+	req := readRequest{Items: make([]readRequestItem, len(names))}
+	for i, name := range names {
+		req.Items[i] = readRequestItem{Name: c.resolveItem(name)}
+	}
+
+	resp, err := c.readValues(ctx, req)
+	var respErr *ResponseError
+	if err != nil && !errors.As(err, &respErr) {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(names))
+	for i, name := range names {
+		if value, ok := resp.Value(req.Items[i].Name); ok {
+			values[name] = value
+		}
+	}
+	return values, err
+}
+
+//parseNumber parses the 16 bit integers used by the controller for numeric datapoints
+func parseNumber(value string) (int64, error) {
+	intValue, err := strconv.ParseInt(value, 10, 16)
+	if err != nil {
+		return 0, errors.New("invalid numeric value")
+	}
+	return intValue, nil
+}
+
+func parseRoomTemperature(s *Sensor, value string) error {
+	intValue, err := parseNumber(value)
+	s.RoomTemperature = float32(intValue) / 100
+	return err
+}
+
+func parseTargetTemperature(s *Sensor, value string) error {
+	intValue, err := parseNumber(value)
+	s.TargetTemperature = float32(intValue) / 100
+	return err
+}
+
+func parseName(s *Sensor, value string) error {
+	s.Name = value
+	return nil
+}
+
+//parseProgram, parseMode and parseUnit reject values unknown to this library, so they are
+//reported as warnings, and the field left invalid, rather than failing the encoding of the sensor
+func parseProgram(s *Sensor, value string) error {
+	intValue, err := parseNumber(value)
+	if err != nil {
+		return err
+	}
+	if program := Program(intValue); !program.Valid() {
+		return fmt.Errorf("unknown program %d", intValue)
+	}
+	s.Program = Program(intValue)
+	return nil
+}
+
+func parseMode(s *Sensor, value string) error {
+	intValue, err := parseNumber(value)
+	if err != nil {
+		return err
+	}
+	if mode := Mode(intValue); !mode.Valid() {
+		return fmt.Errorf("unknown mode %d", intValue)
+	}
+	s.Mode = Mode(intValue)
+	return nil
+}
+
+func parseUnit(s *Sensor, value string) error {
+	intValue, err := parseNumber(value)
+	if err != nil {
+		return err
+	}
+	if unit := Unit(intValue); unit != Celsius && unit != Fahrenheit {
+		return fmt.Errorf("unknown unit %d", intValue)
+	}
+	s.Unit = Unit(intValue)
+	return nil
+}
+
+func formatTargetTemperature(value interface{}) (string, error) {
+	t, err := targetValue(value)
+	if err != nil {
+		return "", err
+	}
+	return formatTemperature(t), nil
+}
+
+//targetValue converts a target temperature given as any number
+func targetValue(value interface{}) (float32, error) {
+	switch t := value.(type) {
+	case float32:
+		return t, nil
+	case float64:
+		return float32(t), nil
+	case int:
+		return float32(t), nil
+	}
+	return 0, fmt.Errorf("expected a temperature, got %T", value)
+}
+
+func formatProgram(value interface{}) (string, error) {
+	program, ok := value.(Program)
+	if !ok {
+		return "", fmt.Errorf("expected a Program, got %T", value)
+	}
+	if err := program.check(); err != nil {
+		return "", err
+	}
+	return strconv.Itoa(int(program)), nil
+}
+
+func formatMode(value interface{}) (string, error) {
+	mode, ok := value.(Mode)
+	if !ok {
+		return "", fmt.Errorf("expected a Mode, got %T", value)
+	}
+	if err := mode.check(); err != nil {
+		return "", err
+	}
+	return strconv.Itoa(int(mode)), nil
+}
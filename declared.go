@@ -0,0 +1,178 @@
+package roth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+//DeclaredConfig is the intended configuration of the thermostats, e.g. kept in version control
+//by an installer and compared against the controller with CompareConfig. Settings left out are
+//not checked.
+type DeclaredConfig struct {
+	//Unit is the unit of all temperatures in the document
+	Unit    Unit             `json:"unit" yaml:"unit"`
+	Sensors []DeclaredSensor `json:"sensors" yaml:"sensors"`
+}
+
+//DeclaredSensor holds the intended settings of a single thermostat
+type DeclaredSensor struct {
+	Id                int      `json:"id" yaml:"id"`
+	Name              *string  `json:"name,omitempty" yaml:"name,omitempty"`
+	TargetTemperature *float32 `json:"targetTemperature,omitempty" yaml:"targetTemperature,omitempty"`
+	Program           *Program `json:"program,omitempty" yaml:"program,omitempty"`
+	Mode              *Mode    `json:"mode,omitempty" yaml:"mode,omitempty"`
+	//MinTarget and MaxTarget limit the target temperature, for rooms where it may be changed on
+	//the thermostat, but only within bounds. A target outside the limits is moved to the limit.
+	MinTarget *float32 `json:"minTarget,omitempty" yaml:"minTarget,omitempty"`
+	MaxTarget *float32 `json:"maxTarget,omitempty" yaml:"maxTarget,omitempty"`
+}
+
+//ReadDeclaredConfig reads a declared config in json. Unknown settings are rejected, so typos do
+//not silently go unchecked.
+func ReadDeclaredConfig(r io.Reader) (*DeclaredConfig, error) {
+	var config DeclaredConfig
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("error reading declared config: %v", err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (config *DeclaredConfig) validate() error {
+	seen := make(map[int]bool, len(config.Sensors))
+	for _, ds := range config.Sensors {
+		if seen[ds.Id] {
+			return fmt.Errorf("sensor %v is declared twice", ds.Id)
+		}
+		seen[ds.Id] = true
+		if ds.MinTarget != nil && ds.MaxTarget != nil && *ds.MinTarget > *ds.MaxTarget {
+			return fmt.Errorf("sensor %v has minTarget above maxTarget", ds.Id)
+		}
+		for _, t := range []*float32{ds.TargetTemperature, ds.MinTarget, ds.MaxTarget} {
+			if t == nil {
+				continue
+			}
+			if err := checkTargetIn(*t, config.Unit); err != nil {
+				return fmt.Errorf("sensor %v: %v", ds.Id, err)
+			}
+		}
+	}
+	return nil
+}
+
+//Drift is a setting of a thermostat differing from the declared config
+type Drift struct {
+	SensorID int `json:"sensorId"`
+	//Field is the differing setting, or 0 if the declared sensor is not paired
+	Field    Field  `json:"field"`
+	Actual   string `json:"actual"`
+	Declared string `json:"declared"`
+
+	//apply adds the write converging the setting to a batch, nil if it can not be converged
+	apply func(b *Batch)
+}
+
+func (d Drift) String() string {
+	if d.Field == 0 {
+		return fmt.Sprintf("sensor %v is %v", d.SensorID, d.Actual)
+	}
+	return fmt.Sprintf("sensor %v %v is %v, declared %v", d.SensorID, d.Field, d.Actual, d.Declared)
+}
+
+//CompareConfig reads the thermostats, and returns the settings differing from the declared
+//config, ordered as declared. Settings the controller did not report are not compared.
+func (c *Client) CompareConfig(ctx context.Context, config *DeclaredConfig) ([]Drift, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	sensorCount, err := c.GetSensorCount(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sensors, err := c.ForceRefresh(ctx, sensorCount)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int]Sensor, len(sensors))
+	for _, s := range sensors {
+		byID[s.Id] = s
+	}
+	var drift []Drift
+	for _, ds := range config.Sensors {
+		s, ok := byID[ds.Id]
+		if !ok {
+			drift = append(drift, Drift{SensorID: ds.Id, Actual: "not paired", Declared: "paired"})
+			continue
+		}
+		id := s.Id
+		add := func(field Field, actual, declared interface{}, apply func(b *Batch)) {
+			drift = append(drift, Drift{SensorID: id, Field: field, Actual: fmt.Sprint(actual), Declared: fmt.Sprint(declared), apply: apply})
+		}
+		temperature := func(t float32) string {
+			return strconv.FormatFloat(float64(t), 'f', -1, 32) + " " + c.Unit.String()
+		}
+		//declared targets are compared as written, so a target between two steps of the
+		//resolution is not reported again after every ApplyDrift
+		declared := func(t *float32) *float32 {
+			if t == nil {
+				return nil
+			}
+			rounded := c.RoundTarget(id, ConvertTemperature(*t, config.Unit, c.Unit))
+			return &rounded
+		}
+
+		if ds.Name != nil && s.Valid.Has(FieldName) && s.Name != *ds.Name {
+			name := *ds.Name
+			add(FieldName, strconv.Quote(s.Name), strconv.Quote(name), func(b *Batch) { b.add(id, "name", name) })
+		}
+		if ds.Program != nil && s.Valid.Has(FieldProgram) && s.Program != *ds.Program {
+			program := *ds.Program
+			add(FieldProgram, s.Program, program, func(b *Batch) { b.SetProgram(id, program) })
+		}
+		if ds.Mode != nil && s.Valid.Has(FieldMode) && s.Mode != *ds.Mode {
+			mode := *ds.Mode
+			add(FieldMode, s.Mode, mode, func(b *Batch) { b.SetMode(id, mode) })
+		}
+		if !s.Valid.Has(FieldTargetTemperature) {
+			continue
+		}
+		if target := declared(ds.TargetTemperature); target != nil {
+			if temperatureDiffers(s.TargetTemperature, *target) {
+				t := *target
+				add(FieldTargetTemperature, temperature(s.TargetTemperature), temperature(t), func(b *Batch) { b.SetTargetTemperature(id, t) })
+			}
+			continue
+		}
+		min, max := declared(ds.MinTarget), declared(ds.MaxTarget)
+		if min != nil && s.TargetTemperature < *min && temperatureDiffers(s.TargetTemperature, *min) {
+			t := *min
+			add(FieldTargetTemperature, temperature(s.TargetTemperature), "at least "+temperature(t), func(b *Batch) { b.SetTargetTemperature(id, t) })
+		}
+		if max != nil && s.TargetTemperature > *max && temperatureDiffers(s.TargetTemperature, *max) {
+			t := *max
+			add(FieldTargetTemperature, temperature(s.TargetTemperature), "at most "+temperature(t), func(b *Batch) { b.SetTargetTemperature(id, t) })
+		}
+	}
+	return drift, nil
+}
+
+//ApplyDrift writes the declared values of the settings found by CompareConfig. Sensors which are
+//not paired can not be converged, and are left out of the result.
+func (c *Client) ApplyDrift(ctx context.Context, drift []Drift) BulkResult {
+	batch := c.NewBatch()
+	for _, d := range drift {
+		if d.apply != nil {
+			d.apply(batch)
+		}
+	}
+	//sent even if empty, so rejected values are reported
+	return batch.Send(ctx)
+}
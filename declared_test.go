@@ -0,0 +1,106 @@
+package roth_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/rothtest"
+)
+
+func TestReadDeclaredConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		//wantErr is part of the error expected, or empty if the config is valid
+		wantErr string
+	}{
+		{
+			name: "valid",
+			json: `{"unit": "celsius", "sensors": [{"id": 0, "targetTemperature": 21, "mode": "day"}, {"id": 1, "minTarget": 18, "maxTarget": 22}]}`,
+		},
+		{
+			name: "fahrenheit",
+			json: `{"unit": "fahrenheit", "sensors": [{"id": 0, "targetTemperature": 70}]}`,
+		},
+		{
+			name:    "target above upper limit",
+			json:    `{"unit": "celsius", "sensors": [{"id": 0, "targetTemperature": 40}]}`,
+			wantErr: "invalid target temperature",
+		},
+		{
+			name:    "fahrenheit target below lower limit",
+			json:    `{"unit": "fahrenheit", "sensors": [{"id": 0, "minTarget": 35}]}`,
+			wantErr: "invalid target temperature",
+		},
+		{
+			name:    "limits reversed",
+			json:    `{"sensors": [{"id": 0, "minTarget": 22, "maxTarget": 18}]}`,
+			wantErr: "minTarget above maxTarget",
+		},
+		{
+			name:    "sensor declared twice",
+			json:    `{"sensors": [{"id": 0}, {"id": 0}]}`,
+			wantErr: "declared twice",
+		},
+		{
+			name:    "unknown mode",
+			json:    `{"sensors": [{"id": 0, "mode": "vacation"}]}`,
+			wantErr: "error reading declared config",
+		},
+		{
+			name:    "unknown setting",
+			json:    `{"sensors": [{"id": 0, "target": 21}]}`,
+			wantErr: "unknown field",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := roth.ReadDeclaredConfig(strings.NewReader(test.json))
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("ReadDeclaredConfig: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("got error %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyDrift(t *testing.T) {
+	srv := rothtest.NewServer(batchSensors()...)
+	defer srv.Close()
+	c := newTestClient(srv)
+
+	config, err := roth.ReadDeclaredConfig(strings.NewReader(`{"unit": "celsius", "sensors": [
+		{"id": 0, "targetTemperature": 21.5},
+		{"id": 1, "mode": "night", "program": "program1"},
+		{"id": 2, "minTarget": 21, "maxTarget": 23},
+		{"id": 3, "targetTemperature": 20}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	drift, err := c.CompareConfig(context.Background(), config)
+	if err != nil {
+		t.Fatalf("CompareConfig: %v", err)
+	}
+	if len(drift) != 4 {
+		t.Fatalf("got %v drifted settings, want 4: %v", len(drift), drift)
+	}
+
+	//the sensor which is not paired can not be converged, and is left out
+	result := c.ApplyDrift(context.Background(), drift)
+	if err := result.Err(); err != nil {
+		t.Errorf("ApplyDrift: %v", err)
+	}
+	if len(result) != 3 {
+		t.Errorf("got results for %v sensors, want 3: %v", len(result), result)
+	}
+	compareValues(t, srv, map[string]string{"G0.SollTemp": "2150", "G1.OPMode": "1", "G1.WeekProg": "1", "G2.SollTemp": "2100"})
+}
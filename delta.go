@@ -0,0 +1,148 @@
+package roth
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+)
+
+//changeCounterItem is thought, from user reports, to be increased by newer firmware whenever any
+//value of the controller or a thermostat changes. This is unconfirmed, so watchers only rely on
+//it once they saw it change along with the sensors, see deltaProbing. Older firmware does not
+//know it, and reports it empty.
+const changeCounterItem = "R0.ChangeCounter"
+
+//deltaFullInterval bounds how long a watcher relies on the change counter, in case a change
+//was not counted
+const deltaFullInterval = 15 * time.Minute
+
+//deltaSupport is whether the controller has a change counter
+type deltaSupport int
+
+const (
+	deltaUnknown deltaSupport = iota
+	//deltaProbing is a controller reporting a counter which was not yet seen to count a change
+	//of the sensors. Polls read in full until it was.
+	deltaProbing
+	deltaSupported
+	deltaUnsupported
+)
+
+//deltaState holds the change counter as of the last full poll of a Watcher
+type deltaState struct {
+	disabled bool
+	support  deltaSupport
+	token    string
+	fullAt   time.Time
+}
+
+//SetDeltaReads enables or disables delta reads, which are enabled by default. On firmware with
+//a change counter, every poll reads only the counter, and reads the sensors only if it changed
+//since the previous poll, or at least every 15 minutes. Polls without changes repeat the
+//previous readings with the time of the poll. The counter is only relied on once a change of
+//the sensors was seen to change it too; until then, and on firmware without one or whose
+//counter missed a change, every poll reads all sensors.
+func (w *Watcher) SetDeltaReads(enabled bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.delta.disabled = !enabled
+}
+
+//DeltaReads returns whether the watcher uses delta reads, i.e. they are enabled and the change
+//counter of the controller was confirmed
+func (w *Watcher) DeltaReads() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return !w.delta.disabled && w.delta.support == deltaSupported
+}
+
+//changeToken reads the change counter of the controller, if delta reads are enabled and it may
+//have one. ok is false if the sensors must be read in full; token is still set while the counter
+//is probed.
+func (w *Watcher) changeToken(ctx context.Context) (token string, ok bool) {
+	w.mu.Lock()
+	use := !w.delta.disabled && w.delta.support != deltaUnsupported
+	w.mu.Unlock()
+	if !use {
+		return "", false
+	}
+
+	resp, err := w.client.readValues(ctx, readRequest{Items: []readRequestItem{{Name: changeCounterItem}}})
+	var respErr *ResponseError
+	if err != nil && !errors.As(err, &respErr) {
+		//the full read reports the error
+		return "", false
+	}
+	token, _ = resp.Value(changeCounterItem)
+	_, parseErr := strconv.ParseUint(token, 10, 64)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if parseErr != nil {
+		if w.delta.support != deltaUnsupported {
+			w.client.logf(LogDebug, "controller has no change counter, polling with full reads")
+		}
+		w.delta.support = deltaUnsupported
+		return "", false
+	}
+	if w.delta.support == deltaUnknown {
+		w.delta.support = deltaProbing
+	}
+	return token, w.delta.support == deltaSupported
+}
+
+//repeatLast fills a poll with the readings of the previous full poll, if the change counter did
+//not change since. It returns false if the sensors must be read.
+func (w *Watcher) repeatLast(poll *Poll, token string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.delta.token != token || w.lastRaw == nil || poll.Time.Sub(w.delta.fullAt) >= deltaFullInterval {
+		return false
+	}
+	poll.Raw = make([]Sensor, len(w.lastRaw))
+	poll.Sensors = make([]Sensor, len(w.lastRaw))
+	for i, s := range w.lastRaw {
+		poll.Raw[i] = s
+		poll.Sensors[i] = w.last[s.Id]
+		poll.Raw[i].ReadAt, poll.Raw[i].Stale = poll.Time, false
+		poll.Sensors[i].ReadAt, poll.Sensors[i].Stale = poll.Time, false
+	}
+	return true
+}
+
+//setToken records the change counter read before a successful full poll of the raw sensors.
+//While probing, a change of the sensors since the previous full poll confirms the counter if it
+//changed as well, and rules it out if not.
+func (w *Watcher) setToken(token string, at time.Time, sensors []Sensor) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.delta.support == deltaProbing && w.delta.token != "" && sensorsChanged(w.lastRaw, sensors) {
+		if token != w.delta.token {
+			w.client.logf(LogDebug, "change counter confirmed, polling with delta reads")
+			w.delta.support = deltaSupported
+		} else {
+			w.client.logf(LogDebug, "change counter missed a change, polling with full reads")
+			w.delta.support = deltaUnsupported
+		}
+	}
+	w.delta.token, w.delta.fullAt = token, at
+}
+
+//sensorsChanged returns whether any reading differs between two full polls
+func sensorsChanged(previous, current []Sensor) bool {
+	if len(previous) != len(current) {
+		return true
+	}
+	byID := make(map[int]Sensor, len(previous))
+	for _, s := range previous {
+		byID[s.Id] = s
+	}
+	for _, s := range current {
+		p, ok := byID[s.Id]
+		if !ok || changedFields(p, s) != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package roth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/rothtest"
+)
+
+func TestDeltaReads(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(srv *rothtest.Server)
+		//wantDelta is whether delta reads are used once a change was seen
+		wantDelta bool
+	}{
+		{
+			name:      "counting change counter",
+			setup:     func(srv *rothtest.Server) { srv.EnableChangeCounter() },
+			wantDelta: true,
+		},
+		{
+			name:  "change counter missing a change",
+			setup: func(srv *rothtest.Server) { srv.SetValue("R0.ChangeCounter", "7") },
+		},
+		{
+			name:  "no change counter",
+			setup: func(srv *rothtest.Server) {},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := rothtest.NewServer(testSensors()...)
+			defer srv.Close()
+			test.setup(srv)
+			client := newTestClient(srv)
+			w := roth.NewWatcher(client, time.Minute)
+			ctx := context.Background()
+
+			//the counter is not relied on before it was seen to count a change
+			w.Poll(ctx)
+			w.Poll(ctx)
+			if w.DeltaReads() || client.Stats().DeltaPolls != 0 {
+				t.Fatalf("delta reads before a change was seen")
+			}
+
+			srv.SetValue("G0.RaumTemp", "2150")
+			if poll := w.Poll(ctx); len(poll.Changes) != 1 {
+				t.Fatalf("got %v changes, want 1", len(poll.Changes))
+			}
+			if w.DeltaReads() != test.wantDelta {
+				t.Errorf("got delta reads %v, want %v", w.DeltaReads(), test.wantDelta)
+			}
+
+			poll := w.Poll(ctx)
+			wantPolls := int64(0)
+			if test.wantDelta {
+				wantPolls = 1
+			}
+			if got := client.Stats().DeltaPolls; got != wantPolls {
+				t.Errorf("got %v delta polls, want %v", got, wantPolls)
+			}
+			if len(poll.Sensors) != 2 || poll.Sensors[0].RoomTemperature != 21.5 {
+				t.Errorf("got sensors %+v, want the changed readings", poll.Sensors)
+			}
+
+			//changes are still seen with delta reads
+			srv.SetValue("G1.RaumTemp", "1900")
+			if poll := w.Poll(ctx); len(poll.Changes) != 1 {
+				t.Errorf("got %v changes, want 1", len(poll.Changes))
+			}
+		})
+	}
+}
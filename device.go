@@ -0,0 +1,56 @@
+package roth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+//DeviceInfo identifies the hardware and firmware of a paired thermostat
+type DeviceInfo struct {
+	Id              int    `json:"id"`
+	SoftwareVersion string `json:"softwareVersion"`
+	HardwareVersion string `json:"hardwareVersion"`
+}
+
+//GetDeviceInfo returns the versions reported by a thermostat
+func (c *Client) GetDeviceInfo(ctx context.Context, sensorID int) (DeviceInfo, error) {
+	infos, err := c.readDeviceInfos(ctx, []int{sensorID})
+	if err != nil {
+		return DeviceInfo{}, err
+	}
+	return infos[0], nil
+}
+
+//GetDeviceInfos returns the versions reported by all thermostats, read in a single request
+func (c *Client) GetDeviceInfos(ctx context.Context, sensorCount int) ([]DeviceInfo, error) {
+	return c.readDeviceInfos(ctx, c.sensorIDs(sensorCount))
+}
+
+func (c *Client) readDeviceInfos(ctx context.Context, ids []int) ([]DeviceInfo, error) {
+	req := readRequest{}
+	for _, id := range ids {
+		req.Items = append(req.Items,
+			readRequestItem{Name: fmt.Sprintf("G%v.SWVersion", id)},
+			readRequestItem{Name: fmt.Sprintf("G%v.HWVersion", id)})
+	}
+
+	//thermostats not reporting a version are returned with an empty version, unless none do
+	resp, err := c.readValues(ctx, req)
+	var respErr *ResponseError
+	if errors.As(err, &respErr) {
+		if len(respErr.Missing) == len(req.Items) {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	infos := make([]DeviceInfo, len(ids))
+	for i, id := range ids {
+		infos[i].Id = id
+		infos[i].SoftwareVersion, _ = resp.Value(fmt.Sprintf("G%v.SWVersion", id))
+		infos[i].HardwareVersion, _ = resp.Value(fmt.Sprintf("G%v.HWVersion", id))
+	}
+	return infos, nil
+}
@@ -0,0 +1,151 @@
+package roth
+
+import (
+	"context"
+	"html"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//DefaultDeviceListPath is the page of the web interface of the controller listing the
+//thermostats, read by the name fallback unless Client.DeviceListPath is set
+const DefaultDeviceListPath = "/devices.html"
+
+//deviceListTTL is how long the device list is kept before it is read again
+const deviceListTTL = 10 * time.Minute
+
+var (
+	rowPattern    = regexp.MustCompile(`(?is)<tr[^>]*>(.*?)</tr>`)
+	cellPattern   = regexp.MustCompile(`(?is)<t[dh][^>]*>(.*?)</t[dh]>`)
+	optionPattern = regexp.MustCompile(`(?is)<option[^>]*value=["']?G?(\d+)["']?[^>]*>(.*?)</option>`)
+	tagPattern    = regexp.MustCompile(`<[^>]*>`)
+	indexPattern  = regexp.MustCompile(`(?i)^G?(\d+)$`)
+	numberPattern = regexp.MustCompile(`^[-+]?[\d.,]+\s*(°\s*[CF])?$`)
+)
+
+//pageKey marks requests for pages of the web interface in their context
+type pageKey struct{}
+
+func isPageRequest(ctx context.Context) bool {
+	page, _ := ctx.Value(pageKey{}).(bool)
+	return page
+}
+
+//deviceList holds the device list last read by the name fallback
+type deviceList struct {
+	mu     sync.Mutex
+	names  map[int]string
+	readAt time.Time
+}
+
+//GetDeviceList reads the thermostats from the device list page of the web interface of the
+//controller, as ids and names. It is independent of ILRReadValues.cgi, which some firmware
+//answers with empty names. Pages listing the thermostats in table rows, with the index in one
+//cell and the name in a later one, or as options of a selection, are understood.
+func (c *Client) GetDeviceList(ctx context.Context) (map[int]string, error) {
+	path := c.DeviceListPath
+	if path == "" {
+		path = DefaultDeviceListPath
+	}
+	page, err := c.send(context.WithValue(ctx, pageKey{}, true), http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return parseDeviceList(page), nil
+}
+
+//parseDeviceList extracts ids and names from a device list page
+func parseDeviceList(page []byte) map[int]string {
+	text := func(s string) string {
+		return strings.TrimSpace(html.UnescapeString(tagPattern.ReplaceAllString(s, "")))
+	}
+	names := make(map[int]string)
+	for _, row := range rowPattern.FindAllStringSubmatch(string(page), -1) {
+		id := -1
+		for _, cell := range cellPattern.FindAllStringSubmatch(row[1], -1) {
+			value := text(cell[1])
+			if id < 0 {
+				if m := indexPattern.FindStringSubmatch(value); m != nil {
+					id, _ = strconv.Atoi(m[1])
+				}
+				continue
+			}
+			if value != "" && !numberPattern.MatchString(value) {
+				names[id] = value
+				break
+			}
+		}
+	}
+	for _, option := range optionPattern.FindAllStringSubmatch(string(page), -1) {
+		id, _ := strconv.Atoi(option[1])
+		if name := text(option[2]); name != "" {
+			if _, ok := names[id]; !ok {
+				names[id] = name
+			}
+		}
+	}
+	return names
+}
+
+//deviceNames returns the device list, read again if older than deviceListTTL
+func (c *Client) deviceNames(ctx context.Context) (map[int]string, error) {
+	c.deviceList.mu.Lock()
+	defer c.deviceList.mu.Unlock()
+	if c.deviceList.names != nil && time.Since(c.deviceList.readAt) < deviceListTTL {
+		return c.deviceList.names, nil
+	}
+	names, err := c.GetDeviceList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.deviceList.names, c.deviceList.readAt = names, time.Now()
+	return names, nil
+}
+
+//applyNameFallback takes the names of sensors read without one from the device list, and adds
+//sensors found there but not read, e.g. as the controller returned no values at all for them.
+//Added sensors only have their name.
+func (c *Client) applyNameFallback(ctx context.Context, sensors []Sensor, sensorCount int) []Sensor {
+	incomplete := len(sensors) < sensorCount
+	for _, s := range sensors {
+		incomplete = incomplete || !s.Valid.Has(FieldName) || s.Name == ""
+	}
+	if !incomplete {
+		return sensors
+	}
+	names, err := c.deviceNames(ctx)
+	if err != nil {
+		c.logf(LogWarning, "error reading device list: %v", err)
+		return sensors
+	}
+
+	read := make(map[int]bool, len(sensors))
+	for i := range sensors {
+		s := &sensors[i]
+		read[s.Id] = true
+		if name, ok := names[s.Id]; ok && (!s.Valid.Has(FieldName) || s.Name == "") {
+			s.Name = name
+			s.Valid |= FieldName
+		}
+	}
+	ids := make([]int, 0, len(names))
+	for id := range names {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		if len(sensors) >= sensorCount {
+			break
+		}
+		if !read[id] {
+			sensors = append(sensors, Sensor{Id: id, Name: names[id], Unit: c.Unit, Valid: FieldName, ReadAt: time.Now()})
+		}
+	}
+	sort.SliceStable(sensors, func(i, j int) bool { return sensors[i].Id < sensors[j].Id })
+	return sensors
+}
@@ -0,0 +1,228 @@
+package roth
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//LoggedError is a problem logged by the client, kept for diagnostics
+type LoggedError struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+}
+
+//errorLogSize is the number of recent problems kept by a client
+const errorLogSize = 50
+
+//errorLog keeps the most recent warnings and errors of a client
+type errorLog struct {
+	mu      sync.Mutex
+	entries []LoggedError
+	next    int
+}
+
+func (l *errorLog) add(level LogLevel, message string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry := LoggedError{Time: time.Now(), Level: level.String(), Message: message}
+	if len(l.entries) < errorLogSize {
+		l.entries = append(l.entries, entry)
+		return
+	}
+	l.entries[l.next] = entry
+	l.next = (l.next + 1) % errorLogSize
+}
+
+//RecentErrors returns the most recent warnings and errors of the client, oldest first
+func (c *Client) RecentErrors() []LoggedError {
+	l := &c.errors
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append(append([]LoggedError{}, l.entries[l.next:]...), l.entries[:l.next]...)
+}
+
+//Diagnostics is a snapshot of the client configuration and controller state, for
+//troubleshooting. Credentials and network addresses of the controller are redacted.
+type Diagnostics struct {
+	Time   time.Time         `json:"time"`
+	System map[string]string `json:"system"`
+	Client map[string]string `json:"client"`
+
+	Capabilities Capabilities `json:"capabilities"`
+	//Aliases maps the English names of datapoints to the names used by the controller
+	Aliases map[string]string `json:"aliases"`
+	//Timing holds the round trip time of a few pings and a full read
+	Timing map[string]string `json:"timing"`
+
+	SensorCount int               `json:"sensorCount"`
+	Datapoints  map[string]string `json:"datapoints"`
+	Sensors     []Sensor          `json:"sensors"`
+	Warnings    []ParseWarning    `json:"warnings"`
+
+	RecentErrors []LoggedError `json:"recentErrors"`
+	//Exchanges holds the requests and responses kept with Client.DebugCapture before the
+	//diagnostics were collected
+	Exchanges []Exchange `json:"exchanges,omitempty"`
+	//Failures lists the steps which failed while collecting the diagnostics
+	Failures []string `json:"failures,omitempty"`
+}
+
+//diagnosticPings is the number of pings timed by Diagnostics
+const diagnosticPings = 3
+
+//Diagnostics collects information for troubleshooting. Steps which fail are listed in
+//Diagnostics.Failures, so a bundle is produced even for an unreachable controller.
+func (c *Client) Diagnostics(ctx context.Context) *Diagnostics {
+	d := &Diagnostics{
+		Time: time.Now(),
+		System: map[string]string{
+			"go":   runtime.Version(),
+			"os":   runtime.GOOS,
+			"arch": runtime.GOARCH,
+		},
+		Client: map[string]string{
+			"urls":          strings.Join(redactURLs(c.addresses()), " "),
+			"activeURL":     strings.Join(redactURLs([]string{c.ActiveURL()}), ""),
+			"unit":          c.Unit.String(),
+			"strict":        fmt.Sprint(c.Strict),
+			"chunkSize":     fmt.Sprint(c.chunkSize()),
+			"cacheTTL":      c.CacheTTL.String(),
+			"timeout":       c.Timeout.String(),
+			"retries":       fmt.Sprint(c.Retries),
+			"authenticated": fmt.Sprint(c.Username != "" || c.Password != ""),
+			"verifyWrites":  fmt.Sprint(c.VerifyWrites),
+			"debugCapture":  fmt.Sprint(c.DebugCapture),
+		},
+		Capabilities: c.Capabilities(),
+		Aliases:      c.Aliases(),
+		Timing:       make(map[string]string),
+		//taken first, so the requests of the diagnostics do not push out earlier ones
+		Exchanges: c.CapturedExchanges(),
+	}
+	fail := func(step string, err error) {
+		d.Failures = append(d.Failures, fmt.Sprintf("%v: %v", step, err))
+	}
+
+	for i := 0; i < diagnosticPings; i++ {
+		latency, err := c.Ping(ctx)
+		if err != nil {
+			fail("ping", err)
+			break
+		}
+		d.Timing[fmt.Sprintf("ping%v", i+1)] = latency.String()
+	}
+
+	sensorCount, err := c.GetSensorCount(ctx)
+	if err != nil {
+		fail("sensor count", err)
+	}
+	d.SensorCount = sensorCount
+
+	start := time.Now()
+	d.Sensors, d.Warnings, err = c.readAllSensors(ctx, sensorCount)
+	d.Timing["fullRead"] = time.Since(start).String()
+	if err != nil {
+		fail("sensors", err)
+	}
+
+	d.Datapoints, err = c.Admin().DumpDatapoints(ctx, sensorCount)
+	if err != nil {
+		fail("datapoints", err)
+	}
+	for name := range d.Datapoints {
+		if strings.HasPrefix(name, "hw.") {
+			d.Datapoints[name] = "redacted"
+		}
+	}
+
+	d.RecentErrors = c.RecentErrors()
+	return d
+}
+
+//redactURLs removes credentials from urls
+func redactURLs(urls []string) []string {
+	redacted := make([]string, len(urls))
+	for i, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil {
+			redacted[i] = "invalid url"
+			continue
+		}
+		if u.User != nil {
+			u.User = url.User("redacted")
+		}
+		redacted[i] = u.String()
+	}
+	return redacted
+}
+
+//WriteJSON writes the diagnostics as indented json
+func (d *Diagnostics) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+//WriteZip writes a zip bundle with the diagnostics as json, and the raw datapoints as a sorted
+//text file for quick reading, as well as the captured exchanges, if any
+func (d *Diagnostics) WriteZip(w io.Writer) error {
+	archive := zip.NewWriter(w)
+
+	f, err := archive.Create("diagnostics.json")
+	if err != nil {
+		return err
+	}
+	if err := d.WriteJSON(f); err != nil {
+		return err
+	}
+
+	f, err = archive.Create("datapoints.txt")
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(d.Datapoints))
+	for name := range d.Datapoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		line := fmt.Sprintf("%v=%v", name, d.Datapoints[name])
+		if english := EnglishName(name); english != "" {
+			line += "  # " + english
+		}
+		if _, err := fmt.Fprintln(f, line); err != nil {
+			return err
+		}
+	}
+
+	if len(d.Exchanges) > 0 {
+		f, err = archive.Create("exchanges.txt")
+		if err != nil {
+			return err
+		}
+		for _, e := range d.Exchanges {
+			status := fmt.Sprint(e.StatusCode)
+			if e.Err != "" {
+				status += " " + e.Err
+			}
+			if _, err := fmt.Fprintf(f, "%v %v %v: %v in %v\n> %v\n< %v\n\n", e.Time.Format(time.RFC3339Nano), e.Method, e.Path, status, e.Duration, e.Request, e.Response); err != nil {
+				return err
+			}
+		}
+	}
+
+	return archive.Close()
+}
@@ -0,0 +1,186 @@
+//Package domoticz connects sensors to Domoticz through its JSON API. It creates virtual
+//temperature and setpoint devices for the sensors, and pushes updates to them as the sensors
+//change.
+package domoticz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+//Domoticz device types of the created devices
+const (
+	//temperatureType is the "Temp" device type, with subtype LaCrosse TX3
+	temperatureType, temperatureSubtype = 80, 5
+	//setpointType is the "Thermostat" device type, with subtype SetPoint
+	setpointType, setpointSubtype = 242, 1
+)
+
+//Device holds the indices of the Domoticz devices of a sensor. A zero index is not updated.
+type Device struct {
+	Temperature int `json:"temperature"`
+	Setpoint    int `json:"setpoint"`
+}
+
+//Client updates devices on a Domoticz server
+type Client struct {
+	//URL of Domoticz, e.g. http://domoticz:8080
+	URL string
+	//Username and Password for basic authentication, if set
+	Username, Password string
+	//Devices maps sensor ids to their devices
+	Devices map[int]Device
+	//HTTPClient is used for the requests. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+	//OnError is called when a device could not be updated
+	OnError func(sensorID int, err error)
+}
+
+//NewClient creates a client for the Domoticz server at the given url
+func NewClient(url string) *Client {
+	return &Client{URL: url, Devices: make(map[int]Device)}
+}
+
+//CreateDevices creates a temperature and a setpoint device for each sensor without devices, on
+//the virtual hardware with the given index (a "Dummy" hardware in Domoticz), and adds them to
+//Devices. The returned map holds the devices of all sensors, and can be saved for later runs.
+func (d *Client) CreateDevices(ctx context.Context, hardware int, sensors []roth.Sensor) (map[int]Device, error) {
+	for _, s := range sensors {
+		device := d.Devices[s.Id]
+		var err error
+		if device.Temperature == 0 {
+			if device.Temperature, err = d.createDevice(ctx, hardware, s.Name, temperatureType, temperatureSubtype); err != nil {
+				return d.Devices, err
+			}
+		}
+		d.Devices[s.Id] = device
+		if device.Setpoint == 0 {
+			if device.Setpoint, err = d.createDevice(ctx, hardware, s.Name+" setpoint", setpointType, setpointSubtype); err != nil {
+				return d.Devices, err
+			}
+		}
+		d.Devices[s.Id] = device
+	}
+	return d.Devices, nil
+}
+
+func (d *Client) createDevice(ctx context.Context, hardware int, name string, deviceType, subtype int) (int, error) {
+	var result struct {
+		Idx string `json:"idx"`
+	}
+	err := d.command(ctx, url.Values{
+		"param":         {"createdevice"},
+		"idx":           {strconv.Itoa(hardware)},
+		"sensorname":    {name},
+		"devicetype":    {strconv.Itoa(deviceType)},
+		"devicesubtype": {strconv.Itoa(subtype)},
+	}, &result)
+	if err != nil {
+		return 0, err
+	}
+	idx, err := strconv.Atoi(result.Idx)
+	if err != nil {
+		return 0, fmt.Errorf("invalid device index %q", result.Idx)
+	}
+	return idx, nil
+}
+
+//Attach pushes the sensors changed by every poll of the watcher
+func (d *Client) Attach(w *roth.Watcher) {
+	w.Subscribe(func(p roth.Poll) {
+		var changed []roth.Sensor
+		for _, c := range p.Changes {
+			changed = append(changed, c.Current)
+		}
+		d.Update(context.Background(), changed...)
+	})
+}
+
+//Update sets the devices of the sensors to their current values
+func (d *Client) Update(ctx context.Context, sensors ...roth.Sensor) {
+	for _, s := range sensors {
+		device, ok := d.Devices[s.Id]
+		if !ok {
+			continue
+		}
+		if err := d.update(ctx, device, s); err != nil && d.OnError != nil {
+			d.OnError(s.Id, err)
+		}
+	}
+}
+
+func (d *Client) update(ctx context.Context, device Device, s roth.Sensor) error {
+	if device.Temperature != 0 && s.Valid.Has(roth.FieldRoomTemperature) {
+		if err := d.updateDevice(ctx, device.Temperature, s.RoomTemperature); err != nil {
+			return err
+		}
+	}
+	if device.Setpoint != 0 && s.Valid.Has(roth.FieldTargetTemperature) {
+		if err := d.updateDevice(ctx, device.Setpoint, s.TargetTemperature); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Client) updateDevice(ctx context.Context, idx int, value float32) error {
+	return d.command(ctx, url.Values{
+		"param":  {"udevice"},
+		"idx":    {strconv.Itoa(idx)},
+		"nvalue": {"0"},
+		"svalue": {strconv.FormatFloat(float64(value), 'f', -1, 32)},
+	}, nil)
+}
+
+//command sends a type=command request to the JSON API, decoding the response into result if
+//not nil
+func (d *Client) command(ctx context.Context, params url.Values, result interface{}) error {
+	params.Set("type", "command")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(d.URL, "/")+"/json.htm?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if d.Username != "" {
+		req.SetBasicAuth(d.Username, d.Password)
+	}
+
+	client := d.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("domoticz returned %v", resp.Status)
+	}
+
+	var status struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return fmt.Errorf("invalid domoticz response: %v", err)
+	}
+	if status.Status != "OK" {
+		return fmt.Errorf("domoticz %v failed: %v %v", params.Get("param"), status.Status, status.Message)
+	}
+	if result != nil {
+		return json.Unmarshal(body, result)
+	}
+	return nil
+}
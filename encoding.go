@@ -0,0 +1,197 @@
+package roth
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+//sensorDocument is the serialized form of a Sensor. The yaml tags are honoured by yaml
+//encoders through Sensor.MarshalYAML.
+type sensorDocument struct {
+	Id                int                    `json:"id" yaml:"id"`
+	Name              *string                `json:"name" yaml:"name"`
+	RoomTemperature   *float32               `json:"roomTemperature" yaml:"roomTemperature"`
+	TargetTemperature *float32               `json:"targetTemperature" yaml:"targetTemperature"`
+	Unit              Unit                   `json:"unit" yaml:"unit"`
+	Program           *Program               `json:"program" yaml:"program"`
+	Mode              *Mode                  `json:"mode" yaml:"mode"`
+	Valve             ValveState             `json:"valve,omitempty" yaml:"valve,omitempty"`
+	Valid             *Field                 `json:"valid,omitempty" yaml:"valid,omitempty"`
+	Extra             map[string]string      `json:"extra,omitempty" yaml:"extra,omitempty"`
+	Values            map[string]interface{} `json:"values,omitempty" yaml:"values,omitempty"`
+	Raw               map[string]string      `json:"raw,omitempty" yaml:"raw,omitempty"`
+	Step              *ProgramStep           `json:"step,omitempty" yaml:"step,omitempty"`
+	Metadata          *Metadata              `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	Members           []int                  `json:"members,omitempty" yaml:"members,omitempty"`
+	Alarms            *Alarms                `json:"alarms,omitempty" yaml:"alarms,omitempty"`
+	//Stale and ReadAt are only set for stale sensors, so dashboards can show their age
+	Stale  bool       `json:"stale,omitempty" yaml:"stale,omitempty"`
+	ReadAt *time.Time `json:"readAt,omitempty" yaml:"readAt,omitempty"`
+}
+
+func (s Sensor) document() sensorDocument {
+	doc := sensorDocument{Id: s.Id, Unit: s.Unit, Extra: s.Extra, Values: s.Values, Raw: s.Raw, Step: s.Step, Metadata: s.Metadata, Members: s.Members, Alarms: s.Alarms}
+	if s.Valid.Has(FieldName) {
+		doc.Name = &s.Name
+	}
+	if s.Valid.Has(FieldRoomTemperature) {
+		doc.RoomTemperature = &s.RoomTemperature
+	}
+	if s.Valid.Has(FieldTargetTemperature) {
+		doc.TargetTemperature = &s.TargetTemperature
+	}
+	if s.Valid.Has(FieldProgram) {
+		doc.Program = &s.Program
+	}
+	if s.Valid.Has(FieldMode) {
+		doc.Mode = &s.Mode
+	}
+	if s.Valid.Has(FieldRoomTemperature | FieldTargetTemperature) {
+		doc.Valve = s.GetValveState()
+	}
+	valid := s.Valid
+	doc.Valid = &valid
+	if s.Stale {
+		doc.Stale = true
+		doc.ReadAt = &s.ReadAt
+	}
+	return doc
+}
+
+//MarshalJSON encodes the sensor with stable field names, e.g.
+//
+//	{"id":0,"name":"Bathroom","roomTemperature":20.86,"targetTemperature":22,"unit":"celsius",
+//	 "program":"program1","mode":"day","valve":"open",
+//	 "valid":["roomTemperature","targetTemperature","name","program","mode","unit"]}
+//
+//Values not read from the controller (see Valid) are null and left out of "valid". The valve
+//state is derived from the temperatures, and ignored when decoding.
+func (s Sensor) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.document())
+}
+
+//MarshalYAML encodes the sensor like MarshalJSON, for yaml encoders supporting the
+//Marshaler interface of gopkg.in/yaml
+func (s Sensor) MarshalYAML() (interface{}, error) {
+	doc := s.document()
+	//yaml encoders do not use Field.MarshalJSON
+	valid := doc.Valid.names()
+	doc.Valid = nil
+	return struct {
+		sensorDocument `yaml:",inline"`
+		Valid          []string `yaml:"valid"`
+	}{doc, valid}, nil
+}
+
+//UnmarshalJSON decodes a sensor encoded by MarshalJSON. When "valid" is absent, every non-null
+//value is considered valid.
+func (s *Sensor) UnmarshalJSON(data []byte) error {
+	var doc sensorDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	sensor := Sensor{Id: doc.Id, Unit: doc.Unit, Extra: doc.Extra, Values: doc.Values, Raw: doc.Raw, Stale: doc.Stale, Step: doc.Step, Metadata: doc.Metadata, Members: doc.Members, Alarms: doc.Alarms}
+	if doc.ReadAt != nil {
+		sensor.ReadAt = *doc.ReadAt
+	}
+	var present Field
+	if doc.Name != nil {
+		sensor.Name = *doc.Name
+		present |= FieldName
+	}
+	if doc.RoomTemperature != nil {
+		sensor.RoomTemperature = *doc.RoomTemperature
+		present |= FieldRoomTemperature
+	}
+	if doc.TargetTemperature != nil {
+		sensor.TargetTemperature = *doc.TargetTemperature
+		present |= FieldTargetTemperature
+	}
+	if doc.Program != nil {
+		sensor.Program = *doc.Program
+		present |= FieldProgram
+	}
+	if doc.Mode != nil {
+		sensor.Mode = *doc.Mode
+		present |= FieldMode
+	}
+	sensor.Valid = present | FieldUnit
+	if doc.Valid != nil {
+		sensor.Valid = *doc.Valid
+	}
+	*s = sensor
+	return nil
+}
+
+//MarshalText encodes the unit as "celsius" or "fahrenheit"
+func (u Unit) MarshalText() ([]byte, error) {
+	switch u {
+	case Celsius:
+		return []byte("celsius"), nil
+	case Fahrenheit:
+		return []byte("fahrenheit"), nil
+	}
+	return nil, fmt.Errorf("invalid unit %d", int(u))
+}
+
+//UnmarshalText decodes a unit encoded by MarshalText
+func (u *Unit) UnmarshalText(text []byte) error {
+	switch strings.ToLower(string(text)) {
+	case "celsius", "c", "°c":
+		*u = Celsius
+	case "fahrenheit", "f", "°f":
+		*u = Fahrenheit
+	default:
+		return fmt.Errorf("invalid unit %q: must be celsius or fahrenheit", text)
+	}
+	return nil
+}
+
+//names returns the json names of the fields in the set, in request order
+func (f Field) names() []string {
+	names := []string{}
+	for _, sf := range sensorFields {
+		if f.Has(sf.Field) {
+			names = append(names, jsonFieldName(sf.Field))
+		}
+	}
+	return names
+}
+
+//jsonFieldName is the name of a field as used in the serialized sensor
+func jsonFieldName(field Field) string {
+	name := fieldNames[field]
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+//MarshalJSON encodes the set as a list of field names, e.g. ["name","roomTemperature"]
+func (f Field) MarshalJSON() ([]byte, error) {
+	return json.Marshal(f.names())
+}
+
+//UnmarshalJSON decodes a list of field names
+func (f *Field) UnmarshalJSON(data []byte) error {
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+
+	var set Field
+	for _, name := range names {
+		field := Field(0)
+		for _, sf := range sensorFields {
+			if strings.EqualFold(name, fieldNames[sf.Field]) {
+				field = sf.Field
+			}
+		}
+		if field == 0 {
+			return fmt.Errorf("unknown sensor field %q", name)
+		}
+		set |= field
+	}
+	*f = set
+	return nil
+}
@@ -0,0 +1,233 @@
+//Package energy estimates the heating demand of each room from polled valve states. The Touchline
+//system has no energy metering, but the time each valve is open, and how far the room is below
+//its setpoint meanwhile, is a usable proxy.
+package energy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+//maxGap is the longest interval between two polls attributed to the earlier reading. Longer
+//gaps, e.g. while the controller was unreachable, are not counted.
+const maxGap = 15 * time.Minute
+
+//DayStats is the heating summary of a room for one day
+type DayStats struct {
+	SensorID int
+	//Date is the local date, formatted as 2006-01-02
+	Date string
+	//Observed is the time covered by polls
+	Observed time.Duration
+	//Heating is the time the valve was open
+	Heating time.Duration
+	//DegreeMinutes is the integral over time of how far the room was below its target
+	DegreeMinutes float64
+}
+
+//DutyCycle returns the fraction of the observed time the valve was open
+func (d DayStats) DutyCycle() float64 {
+	if d.Observed <= 0 {
+		return 0
+	}
+	return float64(d.Heating) / float64(d.Observed)
+}
+
+type observation struct {
+	time   time.Time
+	sensor roth.Sensor
+}
+
+//Estimator accumulates heating statistics from polls
+type Estimator struct {
+	mu    sync.Mutex
+	last  map[int]observation
+	days  map[int]map[string]*DayStats
+	names map[int]string
+	//labels holds the metadata of the sensors as metric labels, see roth.Metadata.MetricLabels
+	labels map[int]string
+}
+
+//NewEstimator creates an empty estimator
+func NewEstimator() *Estimator {
+	return &Estimator{
+		last:   make(map[int]observation),
+		days:   make(map[int]map[string]*DayStats),
+		names:  make(map[int]string),
+		labels: make(map[int]string),
+	}
+}
+
+//Attach accumulates every poll of the watcher
+func (e *Estimator) Attach(w *roth.Watcher) {
+	w.Subscribe(e.Observe)
+}
+
+//Observe accumulates the interval since the previous poll, using the state seen at the start
+//of the interval
+func (e *Estimator) Observe(p roth.Poll) {
+	if p.Err != nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, s := range p.Sensors {
+		if !s.Valid.Has(roth.FieldRoomTemperature | roth.FieldTargetTemperature) {
+			continue
+		}
+		if s.Valid.Has(roth.FieldName) {
+			e.names[s.Id] = s.Name
+		}
+		if s.Metadata != nil {
+			e.labels[s.Id] = formatLabels(s.Metadata.MetricLabels())
+		} else {
+			delete(e.labels, s.Id)
+		}
+
+		previous, ok := e.last[s.Id]
+		e.last[s.Id] = observation{p.Time, s}
+		if !ok {
+			continue
+		}
+		dt := p.Time.Sub(previous.time)
+		if dt <= 0 || dt > maxGap {
+			continue
+		}
+
+		date := previous.time.Format("2006-01-02")
+		day := e.day(s.Id, date)
+		day.Observed += dt
+		if previous.sensor.GetValveValue() == 1 {
+			day.Heating += dt
+			day.DegreeMinutes += float64(previous.sensor.TargetTemperature-previous.sensor.RoomTemperature) * dt.Minutes()
+		}
+	}
+}
+
+func (e *Estimator) day(sensorID int, date string) *DayStats {
+	days, ok := e.days[sensorID]
+	if !ok {
+		days = make(map[string]*DayStats)
+		e.days[sensorID] = days
+	}
+	day, ok := days[date]
+	if !ok {
+		day = &DayStats{SensorID: sensorID, Date: date}
+		days[date] = day
+	}
+	return day
+}
+
+//Day returns the statistics of a sensor for the local date of t
+func (e *Estimator) Day(sensorID int, t time.Time) DayStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	date := t.Format("2006-01-02")
+	if day, ok := e.days[sensorID][date]; ok {
+		return *day
+	}
+	return DayStats{SensorID: sensorID, Date: date}
+}
+
+//Days returns the daily statistics of a sensor, oldest first
+func (e *Estimator) Days(sensorID int) []DayStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var days []DayStats
+	for _, day := range e.days[sensorID] {
+		days = append(days, *day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Date < days[j].Date })
+	return days
+}
+
+//Sensors returns the ids of all sensors with statistics, sorted
+func (e *Estimator) Sensors() []int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ids := make([]int, 0, len(e.days))
+	for id := range e.days {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+//WriteMetrics writes the totals of all sensors in the Prometheus text exposition format
+func (e *Estimator) WriteMetrics(w io.Writer) error {
+	type totals struct {
+		name, labels            string
+		observed, heating, dmin float64
+	}
+
+	e.mu.Lock()
+	ids := make([]int, 0, len(e.days))
+	all := make(map[int]totals)
+	for id, days := range e.days {
+		t := totals{name: e.names[id], labels: e.labels[id]}
+		for _, d := range days {
+			t.observed += d.Observed.Seconds()
+			t.heating += d.Heating.Seconds()
+			t.dmin += d.DegreeMinutes
+		}
+		all[id] = t
+		ids = append(ids, id)
+	}
+	e.mu.Unlock()
+	sort.Ints(ids)
+
+	metrics := []struct {
+		name, help string
+		value      func(t totals) float64
+	}{
+		{"roth_heating_observed_seconds_total", "Time covered by polls.", func(t totals) float64 { return t.observed }},
+		{"roth_heating_seconds_total", "Time the valve was open.", func(t totals) float64 { return t.heating }},
+		{"roth_heating_degree_minutes_total", "Integral of the temperature below target while heating.", func(t totals) float64 { return t.dmin }},
+	}
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v counter\n", m.name, m.help, m.name); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if _, err := fmt.Fprintf(w, "%v{sensor=\"%v\",name=%q%v} %g\n", m.name, id, all[id].name, all[id].labels, m.value(all[id])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//formatLabels formats metric labels as ,name="value" pairs, sorted by name. Labels named like
+//the labels set by WriteMetrics are left out.
+func formatLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		if name != "sensor" && name != "name" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, ",%v=%q", name, labels[name])
+	}
+	return b.String()
+}
+
+//ServeHTTP serves the metrics for scraping by Prometheus
+func (e *Estimator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	e.WriteMetrics(w)
+}
@@ -0,0 +1,184 @@
+package roth
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+//Program is the week program of a thermostat
+type Program int
+
+const (
+	//ProgramConstant is no program, i.e. the same temperature setting throughout the day and week
+	ProgramConstant Program = 0
+	//Program1 is one of the three programmable programs on the thermostat
+	Program1 Program = 1
+	//Program2 is one of the three programmable programs on the thermostat
+	Program2 Program = 2
+	//Program3 is one of the three programmable programs on the thermostat
+	Program3 Program = 3
+)
+
+var programNames = []string{"constant", "program1", "program2", "program3"}
+
+//Valid returns whether the program is known to the controller
+func (p Program) Valid() bool {
+	return p >= ProgramConstant && p <= Program3
+}
+
+func (p Program) String() string {
+	if !p.Valid() {
+		return fmt.Sprintf("Program(%d)", int(p))
+	}
+	return programNames[p]
+}
+
+//check returns an error describing the accepted values if the program is not valid
+func (p Program) check() error {
+	if !p.Valid() {
+		return fmt.Errorf("invalid program %d: must be one of %v", int(p), strings.Join(programNames, ", "))
+	}
+	return nil
+}
+
+//ParseProgram parses a program name as returned by String, or its number
+func ParseProgram(s string) (Program, error) {
+	i, err := parseEnum(s, programNames)
+	if err != nil {
+		return 0, fmt.Errorf("invalid program %q: must be one of %v", s, strings.Join(programNames, ", "))
+	}
+	return Program(i), nil
+}
+
+//MarshalText encodes the program as its name, e.g. in json
+func (p Program) MarshalText() ([]byte, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	return []byte(p.String()), nil
+}
+
+//UnmarshalText decodes a program name or number
+func (p *Program) UnmarshalText(text []byte) error {
+	program, err := ParseProgram(string(text))
+	if err != nil {
+		return err
+	}
+	*p = program
+	return nil
+}
+
+//UnmarshalJSON decodes a program name, or the number used by earlier versions
+func (p *Program) UnmarshalJSON(data []byte) error {
+	return unmarshalEnum(data, p)
+}
+
+//Mode is the operating mode of a thermostat
+type Mode int
+
+const (
+	//ModeDay is the normal operating mode
+	ModeDay Mode = 0
+	//ModeNight is night operating mode
+	ModeNight Mode = 1
+	//ModeHoliday is holiday mode (no frost)
+	ModeHoliday Mode = 2
+)
+
+var modeNames = []string{"day", "night", "holiday"}
+
+//Valid returns whether the mode is known to the controller
+func (m Mode) Valid() bool {
+	return m >= ModeDay && m <= ModeHoliday
+}
+
+func (m Mode) String() string {
+	if !m.Valid() {
+		return fmt.Sprintf("Mode(%d)", int(m))
+	}
+	return modeNames[m]
+}
+
+//check returns an error describing the accepted values if the mode is not valid
+func (m Mode) check() error {
+	if !m.Valid() {
+		return fmt.Errorf("invalid mode %d: must be one of %v", int(m), strings.Join(modeNames, ", "))
+	}
+	return nil
+}
+
+//ParseMode parses a mode name as returned by String, or its number
+func ParseMode(s string) (Mode, error) {
+	i, err := parseEnum(s, modeNames)
+	if err != nil {
+		return 0, fmt.Errorf("invalid mode %q: must be one of %v", s, strings.Join(modeNames, ", "))
+	}
+	return Mode(i), nil
+}
+
+//MarshalText encodes the mode as its name, e.g. in json
+func (m Mode) MarshalText() ([]byte, error) {
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+	return []byte(m.String()), nil
+}
+
+//UnmarshalText decodes a mode name or number
+func (m *Mode) UnmarshalText(text []byte) error {
+	mode, err := ParseMode(string(text))
+	if err != nil {
+		return err
+	}
+	*m = mode
+	return nil
+}
+
+//UnmarshalJSON decodes a mode name, or the number used by earlier versions
+func (m *Mode) UnmarshalJSON(data []byte) error {
+	return unmarshalEnum(data, m)
+}
+
+//unmarshalEnum decodes a json string or number using the UnmarshalText method of v
+func unmarshalEnum(data []byte, v interface{ UnmarshalText([]byte) error }) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		var n json.Number
+		if err := json.Unmarshal(data, &n); err != nil {
+			return err
+		}
+		text = n.String()
+	}
+	return v.UnmarshalText([]byte(text))
+}
+
+//parseEnum returns the index of s in names, case insensitively, or accepts the index itself
+func parseEnum(s string, names []string) (int, error) {
+	s = strings.TrimSpace(s)
+	for i, name := range names {
+		if strings.EqualFold(s, name) {
+			return i, nil
+		}
+	}
+	var i int
+	if _, err := fmt.Sscanf(s, "%d", &i); err == nil && fmt.Sprint(i) == s && i >= 0 && i < len(names) {
+		return i, nil
+	}
+	return 0, fmt.Errorf("unknown value %q", s)
+}
+
+//ValveState is the state of the valve connected to a sensor
+type ValveState string
+
+const (
+	//ValveOpen represents a valve in its open state
+	ValveOpen ValveState = "open"
+
+	//ValveClosed represents a valve in its closed state
+	ValveClosed ValveState = "closed"
+)
+
+func (v ValveState) String() string {
+	return string(v)
+}
@@ -0,0 +1,43 @@
+package roth_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/rothtest"
+)
+
+func TestSetInvalidValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(c *roth.Client) error
+		item  string
+	}{
+		{"mode", func(c *roth.Client) error { return c.SetMode(context.Background(), 0, roth.Mode(3)) }, "G0.OPMode"},
+		{"negative mode", func(c *roth.Client) error { return c.SetMode(context.Background(), 0, roth.Mode(-1)) }, "G0.OPMode"},
+		{"program", func(c *roth.Client) error { return c.SetProgram(context.Background(), 0, roth.Program(4)) }, "G0.WeekProg"},
+		{"program datapoint", func(c *roth.Client) error {
+			return c.WriteDatapoint(context.Background(), 0, "WeekProg", roth.Program(7))
+		}, "G0.WeekProg"},
+		{"mode datapoint", func(c *roth.Client) error {
+			return c.WriteDatapoint(context.Background(), 0, "OPMode", roth.Mode(5))
+		}, "G0.OPMode"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := rothtest.NewServer(batchSensors()...)
+			defer srv.Close()
+			before, _ := srv.Value(test.item)
+
+			if err := test.write(newTestClient(srv)); err == nil || !strings.Contains(err.Error(), "invalid") {
+				t.Errorf("got error %v, want an invalid value", err)
+			}
+			if got, _ := srv.Value(test.item); got != before {
+				t.Errorf("invalid value written as %v", got)
+			}
+		})
+	}
+}
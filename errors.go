@@ -0,0 +1,101 @@
+package roth
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	//ErrUnauthorized is returned when the controller or a proxy in front of it requires
+	//credentials, or rejected the credentials given
+	ErrUnauthorized = errors.New("controller requires authentication")
+
+	//ErrBusy is returned when the controller is temporarily unable to handle requests
+	ErrBusy = errors.New("controller busy")
+
+	//ErrCircuitOpen is returned, wrapped, for requests rejected by the circuit breaker without
+	//contacting the controller, see Client.BreakerThreshold
+	ErrCircuitOpen = errors.New("circuit open, controller degraded")
+
+	//ErrUnsupported is returned, wrapped, for commands whose item the controller does not know
+	ErrUnsupported = errors.New("not supported by the controller")
+)
+
+//StatusError is returned when the controller responds with an unexpected http status, or with a
+//page in place of the expected data. It wraps ErrUnauthorized or ErrBusy where applicable, so
+//these can be checked with errors.Is.
+type StatusError struct {
+	StatusCode int
+	//Body is the start of the response body
+	Body string
+	kind error
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("unexpected response from controller: %v %v", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.kind != nil {
+		msg = fmt.Sprintf("%v (%v %v)", e.kind, e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	if e.Body != "" {
+		msg += fmt.Sprintf(": %q", e.Body)
+	}
+	return msg
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.kind
+}
+
+//checkResponse converts error statuses and known error pages of the controller to a
+//*StatusError. The controller answers both cgi endpoints with xml or plain text, so an html
+//page in a successful response is the login page of the controller or a proxy, or an error page.
+func checkResponse(statusCode int, body []byte) error {
+	err := &StatusError{StatusCode: statusCode, Body: bodySnippet(body)}
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		err.kind = ErrUnauthorized
+	case statusCode == http.StatusServiceUnavailable || statusCode == http.StatusTooManyRequests:
+		err.kind = ErrBusy
+	case statusCode < 200 || statusCode > 299:
+	default:
+		lower := bytes.ToLower(bytes.TrimSpace(body))
+		if !bytes.HasPrefix(lower, []byte("<!doctype html")) && !bytes.HasPrefix(lower, []byte("<html")) {
+			if bytes.Contains(lower, []byte("busy")) && !bytes.HasPrefix(lower, []byte("<")) {
+				err.kind = ErrBusy
+				return err
+			}
+			return nil
+		}
+		switch {
+		case bytes.Contains(lower, []byte("password")) || bytes.Contains(lower, []byte("login")):
+			err.kind = ErrUnauthorized
+		case bytes.Contains(lower, []byte("busy")):
+			err.kind = ErrBusy
+		}
+	}
+	return err
+}
+
+//checkPage is checkResponse for pages of the web interface of the controller, which are html.
+//A page with a password field is the login page.
+func checkPage(statusCode int, body []byte) error {
+	if statusCode < 200 || statusCode > 299 {
+		return checkResponse(statusCode, body)
+	}
+	if bytes.Contains(bytes.ToLower(body), []byte(`type="password"`)) {
+		return &StatusError{StatusCode: statusCode, Body: bodySnippet(body), kind: ErrUnauthorized}
+	}
+	return nil
+}
+
+//bodySnippet returns the start of a response body for error messages
+func bodySnippet(body []byte) string {
+	const max = 80
+	body = bytes.TrimSpace(body)
+	if len(body) > max {
+		return string(body[:max]) + "..."
+	}
+	return string(body)
+}
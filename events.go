@@ -0,0 +1,184 @@
+package roth
+
+import (
+	"sync"
+	"time"
+)
+
+//Event is published on the event bus of a client. Subscribers tell events apart with a type
+//switch on the concrete types, e.g. SensorChanged or ControllerDown. Modules outside this
+//package define events of their own, like alert.AlertTriggered.
+type Event interface {
+	//EventTime returns when the event occurred
+	EventTime() time.Time
+}
+
+//SensorChanged is published by a Watcher for every sensor which changed between two polls
+type SensorChanged struct {
+	Time time.Time
+	SensorChange
+}
+
+//ControllerStateChanged is published by a Watcher watching the controller state when it changes
+type ControllerStateChanged struct {
+	Time time.Time
+	ControllerStateChange
+}
+
+//ControllerDown is published by a HealthMonitor when the controller stops answering
+type ControllerDown struct {
+	Time time.Time
+	Err  error
+}
+
+//ControllerUp is published by a HealthMonitor when the controller answers again, or for the
+//first time
+type ControllerUp struct {
+	Time    time.Time
+	Latency time.Duration
+}
+
+//ValueWritten is published by the client for every value written successfully. Item is the
+//canonical item name, e.g. G3.SollTemp, and Value the raw value written. SensorID is -1 for
+//items of the controller itself.
+type ValueWritten struct {
+	Time     time.Time
+	Item     string
+	SensorID int
+	Value    string
+	//Origin is the origin of the write, see WithOrigin
+	Origin string
+}
+
+//ControllerDegraded is published by a client when its circuit breaker opens, as the controller
+//failed BreakerThreshold consecutive requests. Requests are rejected for Cooldown.
+type ControllerDegraded struct {
+	Time     time.Time
+	Err      error
+	Failures int
+	Cooldown time.Duration
+}
+
+//ControllerRecovered is published by a client when its circuit breaker closes again, as a probe
+//succeeded. Degraded is how long the circuit was open or half-open.
+type ControllerRecovered struct {
+	Time     time.Time
+	Degraded time.Duration
+}
+
+//WriteFailed is published by the client for every write which failed
+type WriteFailed struct {
+	Time      time.Time
+	SensorID  int
+	Datapoint string
+	Value     string
+	Err       error
+	//Origin is the origin of the write, see WithOrigin
+	Origin string
+}
+
+//DryRunWrite is published by a client in dry run mode for every write it skipped. Item is the
+//canonical item name, e.g. G3.SollTemp, and Value the raw value which would have been written.
+type DryRunWrite struct {
+	Time  time.Time
+	Item  string
+	Value string
+	//Origin is the origin of the write, see WithOrigin
+	Origin string
+}
+
+//Corrected is published by a Reconciler for every value it corrected
+type Corrected struct {
+	Time time.Time
+	Correction
+}
+
+//EventTime returns when the event occurred
+func (e SensorChanged) EventTime() time.Time { return e.Time }
+
+//EventTime returns when the event occurred
+func (e ControllerStateChanged) EventTime() time.Time { return e.Time }
+
+//EventTime returns when the event occurred
+func (e ControllerDown) EventTime() time.Time { return e.Time }
+
+//EventTime returns when the event occurred
+func (e ControllerUp) EventTime() time.Time { return e.Time }
+
+//EventTime returns when the event occurred
+func (e ControllerDegraded) EventTime() time.Time { return e.Time }
+
+//EventTime returns when the event occurred
+func (e ControllerRecovered) EventTime() time.Time { return e.Time }
+
+//EventTime returns when the event occurred
+func (e ValueWritten) EventTime() time.Time { return e.Time }
+
+//EventTime returns when the event occurred
+func (e WriteFailed) EventTime() time.Time { return e.Time }
+
+//EventTime returns when the event occurred
+func (e DryRunWrite) EventTime() time.Time { return e.Time }
+
+//EventTime returns when the event occurred
+func (e Corrected) EventTime() time.Time { return e.Time }
+
+//Bus delivers events to subscribers. Events are delivered synchronously, in the order they are
+//published, so subscribers should return quickly.
+type Bus struct {
+	mu          sync.Mutex
+	subscribers map[int]func(Event)
+	order       []int
+	next        int
+}
+
+//NewBus creates an event bus without subscribers
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[int]func(Event))}
+}
+
+//Subscribe registers fn to receive every event published from now on. Call the returned
+//function to unsubscribe.
+func (b *Bus) Subscribe(fn func(Event)) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	b.subscribers[id] = fn
+	b.order = append(b.order, id)
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, id)
+		for i, other := range b.order {
+			if other == id {
+				b.order = append(b.order[:i:i], b.order[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+//Publish delivers an event to all subscribers
+func (b *Bus) Publish(e Event) {
+	b.mu.Lock()
+	subscribers := make([]func(Event), 0, len(b.order))
+	for _, id := range b.order {
+		subscribers = append(subscribers, b.subscribers[id])
+	}
+	b.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(e)
+	}
+}
+
+//Events returns the event bus of the client, on which the client and the modules using it
+//publish their events
+func (c *Client) Events() *Bus {
+	c.busOnce.Do(func() {
+		c.bus = NewBus()
+	})
+	return c.bus
+}
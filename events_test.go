@@ -0,0 +1,41 @@
+package roth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/rothtest"
+)
+
+func TestWriteFromSubscriber(t *testing.T) {
+	srv := rothtest.NewServer(testSensors()...)
+	defer srv.Close()
+	client := newTestClient(srv)
+
+	//a subscriber correcting the mode after every target write, which locks the same sensor
+	corrected := make(chan error, 1)
+	client.Events().Subscribe(func(e roth.Event) {
+		if w, ok := e.(roth.ValueWritten); ok && w.Item == "G0.SollTemp" {
+			corrected <- client.SetMode(context.Background(), 0, roth.ModeNight)
+		}
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.SetTargetTemperature(context.Background(), 0, 22)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("SetTargetTemperature: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write deadlocked on its own subscriber")
+	}
+	if err := <-corrected; err != nil {
+		t.Fatalf("SetMode from subscriber: %v", err)
+	}
+	compareValues(t, srv, map[string]string{"G0.SollTemp": "2200", "G0.OPMode": "1"})
+}
@@ -0,0 +1,330 @@
+//Package expr evaluates small user-defined expressions on sensor readings, e.g.
+//
+//	sensor('Bath').Room < sensor('Bath').Target - 2 && hour() >= 22
+//
+//so alert conditions and rule triggers can be given in configuration files instead of Go code.
+//
+//Expressions consist of numbers, strings in single or double quotes, true and false, the
+//operators || && == != < <= > >= + - * / and !, parentheses, and these functions:
+//
+//	sensor(name or id)  the sensor with the given name, compared case insensitively, or id
+//	hour(), minute()    the time of day of the evaluation
+//	weekday()           the day of the week, 1 for Monday to 7 for Sunday
+//	abs(x), min(x, y), max(x, y)
+//
+//Within rules, self is the sensor the rule is on. Sensors have the fields Room and Target,
+//the temperatures, Mode and Program, their names like 'night' or 'program1', Name, Id, Stale
+//and ValveOpen. Field names are case insensitive, and strings are compared case insensitively.
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+//Expression is a compiled expression
+type Expression struct {
+	src  string
+	root node
+}
+
+//Compile parses an expression, and checks its function and field names
+func Compile(src string) (*Expression, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", src, err)
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseBinary(1)
+	if err == nil && p.peek().kind != tokenEOF {
+		err = unexpected(p.peek(), "an operator")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", src, err)
+	}
+	return &Expression{src: src, root: root}, nil
+}
+
+//String returns the source of the expression
+func (e *Expression) String() string {
+	return e.src
+}
+
+//Env is what an expression is evaluated against
+type Env struct {
+	//Sensors are the readings available to sensor()
+	Sensors []roth.Sensor
+	//Self is the sensor available as self, if any
+	Self *roth.Sensor
+	//Time is the time of the evaluation, for hour(), minute() and weekday()
+	Time time.Time
+}
+
+//Eval evaluates the expression. The result is a float64, string, bool or roth.Sensor. Fields
+//not read from the controller, or sensors not found, are an error.
+func (e *Expression) Eval(env Env) (interface{}, error) {
+	return eval(e.root, env)
+}
+
+//Bool evaluates an expression which must result in true or false, like a condition
+func (e *Expression) Bool(env Env) (bool, error) {
+	v, err := e.Eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%q is not a condition: it results in %v", e.src, typeName(v))
+	}
+	return b, nil
+}
+
+//function is a built-in function
+type function struct {
+	minArgs, maxArgs int
+	call             func(env Env, args []interface{}) (interface{}, error)
+}
+
+var functions = map[string]function{
+	"sensor":  {1, 1, findSensor},
+	"hour":    {0, 0, func(env Env, args []interface{}) (interface{}, error) { return float64(env.Time.Hour()), nil }},
+	"minute":  {0, 0, func(env Env, args []interface{}) (interface{}, error) { return float64(env.Time.Minute()), nil }},
+	"weekday": {0, 0, weekday},
+	"abs": {1, 1, func(env Env, args []interface{}) (interface{}, error) {
+		x, err := number(args[0])
+		return math.Abs(x), err
+	}},
+	"min": {2, 2, func(env Env, args []interface{}) (interface{}, error) { return numbers(args, math.Min) }},
+	"max": {2, 2, func(env Env, args []interface{}) (interface{}, error) { return numbers(args, math.Max) }},
+}
+
+func findSensor(env Env, args []interface{}) (interface{}, error) {
+	switch key := args[0].(type) {
+	case string:
+		for _, s := range env.Sensors {
+			if strings.EqualFold(s.Name, key) {
+				return s, nil
+			}
+		}
+		return nil, fmt.Errorf("no sensor named %q", key)
+	case float64:
+		for _, s := range env.Sensors {
+			if float64(s.Id) == key {
+				return s, nil
+			}
+		}
+		return nil, fmt.Errorf("no sensor %v", key)
+	}
+	return nil, fmt.Errorf("sensor() takes a name or id, not %v", typeName(args[0]))
+}
+
+func weekday(env Env, args []interface{}) (interface{}, error) {
+	day := env.Time.Weekday()
+	if day == time.Sunday {
+		return float64(7), nil
+	}
+	return float64(day), nil
+}
+
+func numbers(args []interface{}, fn func(x, y float64) float64) (interface{}, error) {
+	x, err := number(args[0])
+	if err != nil {
+		return nil, err
+	}
+	y, err := number(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return fn(x, y), nil
+}
+
+//sensorField is a field of sensors, valid if the sensor has the given fields
+type sensorField struct {
+	valid roth.Field
+	value func(s roth.Sensor) interface{}
+}
+
+func roomTemperature(s roth.Sensor) interface{}   { return float64(s.RoomTemperature) }
+func targetTemperature(s roth.Sensor) interface{} { return float64(s.TargetTemperature) }
+
+//sensorFields are the fields of sensors, by lower case name
+var sensorFields = map[string]sensorField{
+	"room":              {roth.FieldRoomTemperature, roomTemperature},
+	"roomtemperature":   {roth.FieldRoomTemperature, roomTemperature},
+	"target":            {roth.FieldTargetTemperature, targetTemperature},
+	"targettemperature": {roth.FieldTargetTemperature, targetTemperature},
+	"mode":              {roth.FieldMode, func(s roth.Sensor) interface{} { return s.Mode.String() }},
+	"program":           {roth.FieldProgram, func(s roth.Sensor) interface{} { return s.Program.String() }},
+	"name":              {roth.FieldName, func(s roth.Sensor) interface{} { return s.Name }},
+	"id":                {0, func(s roth.Sensor) interface{} { return float64(s.Id) }},
+	"stale":             {0, func(s roth.Sensor) interface{} { return s.Stale }},
+	"valveopen": {roth.FieldRoomTemperature | roth.FieldTargetTemperature, func(s roth.Sensor) interface{} {
+		return s.GetValveState() == roth.ValveOpen
+	}},
+}
+
+func eval(n node, env Env) (interface{}, error) {
+	switch n := n.(type) {
+	case literal:
+		return n.value, nil
+	case ident:
+		if env.Self == nil {
+			return nil, errors.New("self is only available in rules on a sensor")
+		}
+		return *env.Self, nil
+	case member:
+		v, err := eval(n.object, env)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := v.(roth.Sensor)
+		if !ok {
+			return nil, fmt.Errorf("%v has no field %v", typeName(v), n.name)
+		}
+		field := sensorFields[n.name]
+		if !s.Valid.Has(field.valid) {
+			return nil, fmt.Errorf("sensor %v has no %v reading", s.Id, n.name)
+		}
+		return field.value(s), nil
+	case call:
+		args := make([]interface{}, len(n.args))
+		for i, arg := range n.args {
+			v, err := eval(arg, env)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = v
+		}
+		return functions[n.name].call(env, args)
+	case unary:
+		v, err := eval(n.operand, env)
+		if err != nil {
+			return nil, err
+		}
+		if n.op == "!" {
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("! needs a condition, not %v", typeName(v))
+			}
+			return !b, nil
+		}
+		x, err := number(v)
+		return -x, err
+	case binary:
+		return evalBinary(n, env)
+	}
+	return nil, fmt.Errorf("unknown node %T", n)
+}
+
+func evalBinary(n binary, env Env) (interface{}, error) {
+	left, err := eval(n.left, env)
+	if err != nil {
+		return nil, err
+	}
+	//&& and || only evaluate the right side if needed, e.g. for a sensor only read sometimes
+	if n.op == "&&" || n.op == "||" {
+		l, ok := left.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%v needs conditions, not %v", n.op, typeName(left))
+		}
+		if l == (n.op == "||") {
+			return l, nil
+		}
+		right, err := eval(n.right, env)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := right.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%v needs conditions, not %v", n.op, typeName(right))
+		}
+		return r, nil
+	}
+
+	right, err := eval(n.right, env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "==" || n.op == "!=" {
+		equal, err := equals(left, right)
+		return equal == (n.op == "=="), err
+	}
+	x, err := number(left)
+	if err != nil {
+		return nil, err
+	}
+	y, err := number(right)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "<":
+		return x < y, nil
+	case "<=":
+		return x <= y, nil
+	case ">":
+		return x > y, nil
+	case ">=":
+		return x >= y, nil
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/":
+		if y == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return x / y, nil
+	}
+	return nil, fmt.Errorf("unknown operator %v", n.op)
+}
+
+func equals(a, b interface{}) (bool, error) {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			return a == b, nil
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.EqualFold(a, b), nil
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			return a == b, nil
+		}
+	case roth.Sensor:
+		if b, ok := b.(roth.Sensor); ok {
+			return a.Id == b.Id, nil
+		}
+	}
+	return false, fmt.Errorf("can not compare %v with %v", typeName(a), typeName(b))
+}
+
+func number(v interface{}) (float64, error) {
+	if f, ok := v.(float64); ok {
+		return f, nil
+	}
+	return 0, fmt.Errorf("expected a number, not %v", typeName(v))
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case float64:
+		return "a number"
+	case string:
+		return "a string"
+	case bool:
+		return "a condition"
+	case roth.Sensor:
+		return "a sensor"
+	}
+	return fmt.Sprintf("%T", v)
+}
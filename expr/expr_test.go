@@ -0,0 +1,149 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+//testEnv has a fully read bathroom, a kitchen of which only the name was read, and a time on a
+//Sunday evening
+func testEnv() Env {
+	bath := roth.Sensor{Id: 3, Name: "Bath", RoomTemperature: 18.5, TargetTemperature: 21, Mode: roth.ModeNight, Program: roth.Program1, Valid: roth.AllFields}
+	return Env{
+		Sensors: []roth.Sensor{
+			bath,
+			{Id: 4, Name: "Kitchen", Valid: roth.FieldName, Stale: true},
+		},
+		Self: &bath,
+		Time: time.Date(2026, 10, 18, 22, 30, 0, 0, time.UTC),
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{"1 + 2 * 3", 7.0},
+		{"(1 + 2) * 3", 9.0},
+		{"10 - 4 - 3", 3.0},
+		{"8 / 4 / 2", 1.0},
+		{"-2 * -3", 6.0},
+		{"- (1 + 1)", -2.0},
+		{"1.5 < 2 && 2 <= 2 && 3 > 2 && 2 >= 3 == false", true},
+		{"!true || 1 != 1", false},
+		{"true || false && false", true},
+		{"'Night' == \"night\"", true},
+		{"sensor('bath').Room", 18.5},
+		{"sensor('Bath').Room < sensor('Bath').Target - 2", true},
+		{"sensor(3).TARGETTEMPERATURE", 21.0},
+		{"sensor('Bath').mode == 'night' && sensor('Bath').Program == 'program1'", true},
+		{"sensor('Bath').ValveOpen", true},
+		{"sensor('Kitchen').Stale && sensor('Kitchen').Id == 4", true},
+		{"sensor('Kitchen').Name", "Kitchen"},
+		{"self == sensor('Bath')", true},
+		{"self.Room", 18.5},
+		{"hour() >= 22 && minute() == 30", true},
+		{"weekday()", 7.0},
+		{"abs(-2.5)", 2.5},
+		{"min(3, 1) + max(3, 1)", 4.0},
+		//the right side is not evaluated when the left decides
+		{"false && sensor('Kitchen').Room > 20", false},
+		{"true || sensor('Garage').Room > 20", true},
+	}
+	for _, test := range tests {
+		e, err := Compile(test.src)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", test.src, err)
+			continue
+		}
+		got, err := e.Eval(testEnv())
+		if err != nil || got != test.want {
+			t.Errorf("%q = %v (%v), want %v", test.src, got, err, test.want)
+		}
+		if e.String() != test.src {
+			t.Errorf("String() = %q, want %q", e.String(), test.src)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		//wantErr is a part of the expected error
+		wantErr string
+	}{
+		{"", "unexpected end"},
+		{"1 +", "unexpected end"},
+		{"(1 + 2", "unexpected end of expression, expected )"},
+		{"1 2", `unexpected "2" at 2`},
+		{"'bath", "unterminated string at 0"},
+		{"1 # 2", `unexpected '#' at 2`},
+		{"1.2.3", "invalid number 1.2.3"},
+		{"foo", "unknown name foo"},
+		{"foo()", "unknown function foo"},
+		{"abs(1, 2)", "wrong number of arguments to abs"},
+		{"hour(1)", "wrong number of arguments to hour"},
+		{"min(1,)", "expected a value"},
+		{"self.Humidity", "unknown sensor field Humidity at 5"},
+		{"self.", "expected field name"},
+		{"sensor('Bath')..Room", "expected field name"},
+	}
+	for _, test := range tests {
+		_, err := Compile(test.src)
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("Compile(%q) returned %v, want %q", test.src, err, test.wantErr)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		src     string
+		env     func(env *Env)
+		wantErr string
+	}{
+		{src: "sensor('Garage').Room", wantErr: `no sensor named "Garage"`},
+		{src: "sensor(9)", wantErr: "no sensor 9"},
+		{src: "sensor(true)", wantErr: "sensor() takes a name or id, not a condition"},
+		{src: "sensor('Kitchen').Room > 20", wantErr: "sensor 4 has no room reading"},
+		{src: "sensor('Kitchen').ValveOpen", wantErr: "sensor 4 has no valveopen reading"},
+		{src: "self.Room", env: func(env *Env) { env.Self = nil }, wantErr: "self is only available in rules on a sensor"},
+		{src: "1 / 0", wantErr: "division by zero"},
+		{src: "'a' + 1", wantErr: "expected a number, not a string"},
+		{src: "1 == 'a'", wantErr: "can not compare a number with a string"},
+		{src: "!1", wantErr: "! needs a condition, not a number"},
+		{src: "1 && true", wantErr: "&& needs conditions, not a number"},
+		{src: "false || 2", wantErr: "|| needs conditions, not a number"},
+		{src: "(1 + 1).Room", wantErr: "a number has no field room"},
+		{src: "abs('x')", wantErr: "expected a number"},
+	}
+	for _, test := range tests {
+		e, err := Compile(test.src)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", test.src, err)
+			continue
+		}
+		env := testEnv()
+		if test.env != nil {
+			test.env(&env)
+		}
+		if got, err := e.Eval(env); err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%q = %v, %v, want error %q", test.src, got, err, test.wantErr)
+		}
+	}
+}
+
+func TestBool(t *testing.T) {
+	e, _ := Compile("self.Room < 19")
+	if b, err := e.Bool(testEnv()); err != nil || !b {
+		t.Errorf("got %v, %v, want true", b, err)
+	}
+	e, _ = Compile("self.Room")
+	if _, err := e.Bool(testEnv()); err == nil || !strings.Contains(err.Error(), "is not a condition: it results in a number") {
+		t.Errorf("got error %v, want not a condition", err)
+	}
+}
@@ -0,0 +1,258 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//tokenKind is the kind of a token of an expression
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	//pos is the byte offset in the source, for error messages
+	pos int
+}
+
+//operators are the operators and punctuation, longest first so && is not read as &
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "(", ")", ",", "."}
+
+//tokenize splits an expression into tokens
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokenNumber, src[start:i], start})
+		case c == '\'' || c == '"':
+			start := i
+			end := strings.IndexByte(src[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at %v", start)
+			}
+			tokens = append(tokens, token{tokenString, src[i+1 : i+1+end], start})
+			i += end + 2
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			start := i
+			for i < len(src) && (src[i] == '_' || src[i] >= 'a' && src[i] <= 'z' || src[i] >= 'A' && src[i] <= 'Z' || src[i] >= '0' && src[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdent, src[start:i], start})
+		default:
+			op := ""
+			for _, candidate := range operators {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at %v", c, i)
+			}
+			tokens = append(tokens, token{tokenOp, op, i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(src)}), nil
+}
+
+//node is a node of the syntax tree of an expression
+type node interface{}
+
+type (
+	literal struct{ value interface{} }
+	ident   struct{ name string }
+	unary   struct {
+		op      string
+		operand node
+	}
+	binary struct {
+		op          string
+		left, right node
+	}
+	call struct {
+		name string
+		args []node
+	}
+	member struct {
+		object node
+		name   string
+	}
+)
+
+//precedence of the binary operators, higher binds tighter
+var precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6,
+}
+
+//parser is a precedence climbing parser of expressions
+type parser struct {
+	tokens []token
+	next   int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) advance() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEOF {
+		p.next++
+	}
+	return t
+}
+
+func (p *parser) expect(op string) error {
+	if t := p.advance(); t.kind != tokenOp || t.text != op {
+		return unexpected(t, op)
+	}
+	return nil
+}
+
+func unexpected(t token, expected string) error {
+	if t.kind == tokenEOF {
+		return fmt.Errorf("unexpected end of expression, expected %v", expected)
+	}
+	return fmt.Errorf("unexpected %q at %v, expected %v", t.text, t.pos, expected)
+}
+
+//parseBinary parses operators of at least the given precedence
+func (p *parser) parseBinary(minPrecedence int) (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		prec, ok := precedence[t.text]
+		if t.kind != tokenOp || !ok || prec < minPrecedence {
+			return left, nil
+		}
+		p.advance()
+		right, err := p.parseBinary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binary{t.text, left, right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if t := p.peek(); t.kind == tokenOp && (t.text == "!" || t.text == "-") {
+		p.advance()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unary{t.text, operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if t := p.peek(); t.kind != tokenOp || t.text != "." {
+			return n, nil
+		}
+		p.advance()
+		t := p.advance()
+		if t.kind != tokenIdent {
+			return nil, unexpected(t, "field name")
+		}
+		if _, ok := sensorFields[strings.ToLower(t.text)]; !ok {
+			return nil, fmt.Errorf("unknown sensor field %v at %v", t.text, t.pos)
+		}
+		n = member{n, strings.ToLower(t.text)}
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.advance()
+	switch t.kind {
+	case tokenNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %v at %v", t.text, t.pos)
+		}
+		return literal{f}, nil
+	case tokenString:
+		return literal{t.text}, nil
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		}
+		if next := p.peek(); next.kind != tokenOp || next.text != "(" {
+			if t.text != "self" {
+				return nil, fmt.Errorf("unknown name %v at %v", t.text, t.pos)
+			}
+			return ident{t.text}, nil
+		}
+		p.advance()
+		var args []node
+		if next := p.peek(); next.kind != tokenOp || next.text != ")" {
+			for {
+				arg, err := p.parseBinary(1)
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, arg)
+				if next := p.peek(); next.kind == tokenOp && next.text == "," {
+					p.advance()
+					continue
+				}
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		f, ok := functions[t.text]
+		if !ok {
+			return nil, fmt.Errorf("unknown function %v at %v", t.text, t.pos)
+		}
+		if len(args) < f.minArgs || len(args) > f.maxArgs {
+			return nil, fmt.Errorf("wrong number of arguments to %v at %v", t.text, t.pos)
+		}
+		return call{t.text, args}, nil
+	case tokenOp:
+		if t.text == "(" {
+			n, err := p.parseBinary(1)
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		}
+	}
+	return nil, unexpected(t, "a value")
+}
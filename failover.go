@@ -0,0 +1,204 @@
+package roth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//failoverCooldown is how long an address which failed is tried after the others
+const failoverCooldown = 30 * time.Second
+
+//failover tracks the health of the addresses of a controller
+type failover struct {
+	mu        sync.Mutex
+	preferred string
+	failedAt  map[string]time.Time
+}
+
+//order returns the addresses in the order they should be tried: the preferred address first,
+//then the others in configured order, with recently failed addresses last
+func (f *failover) order(addresses []string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ordered := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		if address == f.preferred {
+			ordered = append([]string{address}, ordered...)
+		} else {
+			ordered = append(ordered, address)
+		}
+	}
+
+	var healthy, failed []string
+	now := time.Now()
+	for _, address := range ordered {
+		if t, ok := f.failedAt[address]; ok && now.Sub(t) < failoverCooldown {
+			failed = append(failed, address)
+		} else {
+			healthy = append(healthy, address)
+		}
+	}
+	return append(healthy, failed...)
+}
+
+func (f *failover) succeeded(address string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.preferred = address
+	delete(f.failedAt, address)
+}
+
+func (f *failover) failed(address string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failedAt == nil {
+		f.failedAt = make(map[string]time.Time)
+	}
+	f.failedAt[address] = time.Now()
+}
+
+//addresses returns all configured base urls of the controller
+func (c *Client) addresses() []string {
+	return append([]string{c.ManagementURL}, c.FallbackURLs...)
+}
+
+//ActiveURL returns the base url currently preferred for requests
+func (c *Client) ActiveURL() string {
+	return c.failover.order(c.addresses())[0]
+}
+
+//send performs a request against the controller and returns the response body, failing over
+//to the fallback addresses if the request can not be completed, and retrying failed requests
+//as configured by Client.Retries
+func (c *Client) send(ctx context.Context, method string, path string, body []byte) (data []byte, err error) {
+	if err := c.allowRequest(); err != nil {
+		return nil, err
+	}
+	if _, recorded := ctx.Value(breakerKey{}).(bool); !recorded {
+		defer func() { c.recordOutcome(ctx, err) }()
+	}
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && c.CircuitState() == CircuitOpen {
+			//other requests opened the circuit meanwhile
+			return nil, fmt.Errorf("%w, not retrying: %v", ErrCircuitOpen, err)
+		}
+		data, err = c.sendOnce(ctx, method, path, body)
+		if err != nil {
+			c.errors.add(LogError, fmt.Sprintf("request %v %v failed: %v", method, strings.SplitN(path, "?", 2)[0], err))
+		}
+		if err == nil || attempt >= c.Retries || ctx.Err() != nil || errors.Is(err, ErrUnauthorized) {
+			return data, err
+		}
+
+		c.stats.update(func(s *Stats) { s.Retries++ })
+		backoff := c.retryBackoff() << uint(attempt)
+		c.logf(LogWarning, "request failed, retrying in %v: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (c *Client) retryBackoff() time.Duration {
+	if c.RetryBackoff > 0 {
+		return c.RetryBackoff
+	}
+	return DefaultRetryBackoff
+}
+
+//sendOnce tries each address of the controller in turn
+func (c *Client) sendOnce(ctx context.Context, method string, path string, body []byte) ([]byte, error) {
+	addresses := c.failover.order(c.addresses())
+
+	var lastErr error
+	for i, address := range addresses {
+		url, err := endpoint(address, path)
+		if err != nil {
+			return nil, err
+		}
+		data, err := c.sendTo(ctx, method, url, body)
+		if err == nil {
+			c.failover.succeeded(address)
+			return data, nil
+		}
+		lastErr = err
+		c.failover.failed(address)
+
+		if ctx.Err() != nil {
+			break
+		}
+		if i < len(addresses)-1 {
+			c.logf(LogWarning, "request to %v failed, trying next address: %v", address, err)
+		}
+	}
+	return nil, lastErr
+}
+
+func (c *Client) sendTo(ctx context.Context, method string, url string, body []byte) (data []byte, err error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, errors.New("error creating request")
+	}
+	if body != nil {
+		httpRequest.Header.Set("Content-Type", "text/xml")
+	}
+	c.authenticate(httpRequest)
+
+	//the exchange is captured as received, even if it is rejected below
+	start := time.Now()
+	statusCode := 0
+	var received []byte
+	defer func() {
+		c.capture(method, httpRequest.URL.RequestURI(), body, statusCode, received, start, err)
+	}()
+	httpResponse, err := c.roundTrip(httpRequest)
+	if err != nil {
+		c.countRequest(time.Since(start), err)
+		return nil, err
+	}
+	defer httpResponse.Body.Close()
+	statusCode = httpResponse.StatusCode
+
+	//read one byte more than allowed, to tell a response of exactly the maximum size from a
+	//larger one
+	limit := c.maxResponseSize()
+	data, err = ioutil.ReadAll(io.LimitReader(httpResponse.Body, limit+1))
+	received = data
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("response exceeds %v bytes", limit)
+	}
+	if isPageRequest(ctx) {
+		err = checkPage(httpResponse.StatusCode, data)
+	} else {
+		err = checkResponse(httpResponse.StatusCode, data)
+	}
+	c.countRequest(time.Since(start), err)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
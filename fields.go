@@ -0,0 +1,73 @@
+package roth
+
+import "strings"
+
+//Field identifies a sensor value read from the controller. Fields can be combined into a set
+//using bitwise or.
+type Field uint
+
+const (
+	//FieldName is the sensor name (datapoint name)
+	FieldName Field = 1 << iota
+	//FieldRoomTemperature is the measured room temperature (datapoint RaumTemp)
+	FieldRoomTemperature
+	//FieldTargetTemperature is the target temperature (datapoint SollTemp)
+	FieldTargetTemperature
+	//FieldProgram is the active week program (datapoint WeekProg)
+	FieldProgram
+	//FieldMode is the operating mode (datapoint OPMode)
+	FieldMode
+	//FieldUnit is the temperature unit used by the thermostat (datapoint TempSIUnit)
+	FieldUnit
+
+	//AllFields is the set of all sensor fields
+	AllFields = FieldName | FieldRoomTemperature | FieldTargetTemperature | FieldProgram | FieldMode | FieldUnit
+)
+
+//sensorFields lists the datapoints of the sensor fields, in the order they are requested
+var sensorFields = []Datapoint{
+	{Name: "RaumTemp", Field: FieldRoomTemperature, Parse: parseRoomTemperature},
+	{Name: "SollTemp", Field: FieldTargetTemperature, Parse: parseTargetTemperature, Format: formatTargetTemperature},
+	{Name: "name", Field: FieldName, Parse: parseName},
+	{Name: "WeekProg", Field: FieldProgram, Parse: parseProgram, Format: formatProgram},
+	{Name: "OPMode", Field: FieldMode, Parse: parseMode, Format: formatMode},
+	{Name: "TempSIUnit", Field: FieldUnit, Parse: parseUnit},
+}
+
+var fieldNames = map[Field]string{
+	FieldName:              "Name",
+	FieldRoomTemperature:   "RoomTemperature",
+	FieldTargetTemperature: "TargetTemperature",
+	FieldProgram:           "Program",
+	FieldMode:              "Mode",
+	FieldUnit:              "Unit",
+}
+
+//Has returns whether all fields in other are contained in f
+func (f Field) Has(other Field) bool {
+	return f&other == other
+}
+
+//String returns the names of the fields in the set, separated by |
+func (f Field) String() string {
+	var names []string
+	for _, sf := range sensorFields {
+		if f.Has(sf.Field) {
+			names = append(names, fieldNames[sf.Field])
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+//datapointField returns the field read from the given sensor datapoint, or 0 if unknown
+func datapointField(datapoint string) Field {
+	for _, sf := range sensorFields {
+		if strings.EqualFold(sf.Name, datapoint) {
+			return sf.Field
+		}
+	}
+	return 0
+}
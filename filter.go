@@ -0,0 +1,133 @@
+package roth
+
+import (
+	"sort"
+	"sync"
+)
+
+//Filter smooths the room temperature readings of a single sensor. Filters keep state between
+//readings, so every sensor needs its own instance.
+type Filter interface {
+	//Filter takes a raw reading and returns the filtered value. It returns false if the
+	//reading was rejected as implausible.
+	Filter(value float32) (filtered float32, ok bool)
+}
+
+type medianFilter struct {
+	size   int
+	window []float32
+}
+
+//NewMedianFilter returns a filter reporting the median of the last n readings, which removes
+//single spikes entirely
+func NewMedianFilter(n int) Filter {
+	if n < 1 {
+		n = 1
+	}
+	return &medianFilter{size: n}
+}
+
+func (f *medianFilter) Filter(value float32) (float32, bool) {
+	f.window = append(f.window, value)
+	if len(f.window) > f.size {
+		f.window = f.window[1:]
+	}
+
+	sorted := make([]float32, len(f.window))
+	copy(sorted, f.window)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2], true
+}
+
+type ewmaFilter struct {
+	alpha   float32
+	value   float32
+	started bool
+}
+
+//NewEWMAFilter returns an exponentially weighted moving average. Alpha between 0 and 1 is the
+//weight of the newest reading; lower values smooth more.
+func NewEWMAFilter(alpha float32) Filter {
+	return &ewmaFilter{alpha: alpha}
+}
+
+func (f *ewmaFilter) Filter(value float32) (float32, bool) {
+	if !f.started {
+		f.value, f.started = value, true
+	} else {
+		f.value += f.alpha * (value - f.value)
+	}
+	return f.value, true
+}
+
+type boundedFilter struct {
+	min, max float32
+	next     Filter
+}
+
+//NewBoundedFilter rejects readings outside [min, max], like the 0.00 values reported after
+//radio glitches, and passes plausible readings on to next. Next may be nil.
+func NewBoundedFilter(min, max float32, next Filter) Filter {
+	return &boundedFilter{min: min, max: max, next: next}
+}
+
+func (f *boundedFilter) Filter(value float32) (float32, bool) {
+	if value < f.min || value > f.max {
+		return 0, false
+	}
+	if f.next == nil {
+		return value, true
+	}
+	return f.next.Filter(value)
+}
+
+//filterSet holds the filter of every sensor of a watcher
+type filterSet struct {
+	mu         sync.Mutex
+	newFilter  func() Filter
+	filters    map[int]Filter
+	lastOutput map[int]float32
+}
+
+//apply filters the room temperature of the sensors in place. Rejected readings are replaced
+//by the last filtered value, or marked invalid if there is none.
+func (fs *filterSet) apply(sensors []Sensor) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for i := range sensors {
+		s := &sensors[i]
+		if !s.Valid.Has(FieldRoomTemperature) {
+			continue
+		}
+
+		f, ok := fs.filters[s.Id]
+		if !ok {
+			if fs.newFilter == nil {
+				continue
+			}
+			f = fs.newFilter()
+			fs.set(s.Id, f)
+		}
+
+		if value, ok := f.Filter(s.RoomTemperature); ok {
+			s.RoomTemperature = value
+			fs.lastOutput[s.Id] = value
+		} else if last, ok := fs.lastOutput[s.Id]; ok {
+			s.RoomTemperature = last
+		} else {
+			s.RoomTemperature = 0
+			s.Valid &^= FieldRoomTemperature
+		}
+	}
+}
+
+//set installs a filter for a sensor. The caller must hold fs.mu.
+func (fs *filterSet) set(sensorID int, f Filter) {
+	if fs.filters == nil {
+		fs.filters = make(map[int]Filter)
+		fs.lastOutput = make(map[int]float32)
+	}
+	fs.filters[sensorID] = f
+	delete(fs.lastOutput, sensorID)
+}
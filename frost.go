@@ -0,0 +1,149 @@
+package roth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+//FrostProtection is published when the client prevented a target temperature below the frost
+//minimum of a sensor
+type FrostProtection struct {
+	Time     time.Time
+	SensorID int
+	//Target is the target temperature below the minimum, in the client unit
+	Target  float32
+	Minimum float32
+	//Corrected is set if the target was found on the controller, e.g. set on the thermostat or
+	//by another program, and corrected by a Watcher. Otherwise a write was raised to the minimum.
+	Corrected bool
+	//Err is set if the corrective write failed
+	Err error
+}
+
+//EventTime returns when the target was prevented
+func (e FrostProtection) EventTime() time.Time { return e.Time }
+
+func (e FrostProtection) String() string {
+	action := "write raised to the minimum"
+	switch {
+	case e.Corrected && e.Err != nil:
+		action = fmt.Sprintf("correction failed: %v", e.Err)
+	case e.Corrected:
+		action = "corrected"
+	}
+	return fmt.Sprintf("sensor %v target %.1f is below the frost minimum %.1f: %v", e.SensorID, e.Target, e.Minimum, action)
+}
+
+//frostGuard holds the frost minimums of a client
+type frostGuard struct {
+	mu sync.Mutex
+	//minimum applies to sensors without a minimum of their own, if set
+	minimum   *float32
+	perSensor map[int]float32
+}
+
+//SetFrostMinimum protects all sensors against target temperatures below t, in the client unit,
+//e.g. 8 °C. Writes below the minimum, whether by schedules, scenes, rules or scripts, are raised
+//to the minimum, and a Watcher corrects targets below it set on the thermostats themselves.
+//Both publish a FrostProtection event.
+func (c *Client) SetFrostMinimum(t float32) {
+	c.frost.mu.Lock()
+	defer c.frost.mu.Unlock()
+	c.frost.minimum = &t
+}
+
+//SetSensorFrostMinimum sets the frost minimum of a single sensor, overriding SetFrostMinimum,
+//e.g. a higher minimum for a room with water pipes in an outer wall
+func (c *Client) SetSensorFrostMinimum(sensorID int, t float32) {
+	c.frost.mu.Lock()
+	defer c.frost.mu.Unlock()
+	if c.frost.perSensor == nil {
+		c.frost.perSensor = make(map[int]float32)
+	}
+	c.frost.perSensor[sensorID] = t
+}
+
+//FrostMinimum returns the frost minimum of a sensor, if it has one
+func (c *Client) FrostMinimum(sensorID int) (float32, bool) {
+	c.frost.mu.Lock()
+	defer c.frost.mu.Unlock()
+	return c.frost.get(sensorID)
+}
+
+func (g *frostGuard) get(sensorID int) (float32, bool) {
+	if t, ok := g.perSensor[sensorID]; ok {
+		return t, true
+	}
+	if g.minimum != nil {
+		return *g.minimum, true
+	}
+	return 0, false
+}
+
+//guardFrost raises target temperature writes below the frost minimum of their sensor
+func (c *Client) guardFrost(writes []datapointWrite) []datapointWrite {
+	var events []FrostProtection
+	c.frost.mu.Lock()
+	for i, w := range writes {
+		if !strings.EqualFold(w.datapoint, "SollTemp") {
+			continue
+		}
+		minimum, ok := c.frost.get(w.sensorID)
+		if !ok {
+			continue
+		}
+		raw, err := parseNumber(w.value)
+		if err != nil {
+			continue
+		}
+		target := ConvertTemperature(float32(raw)/100, c.units.get(w.sensorID), c.Unit)
+		if target >= minimum || !temperatureDiffers(target, minimum) {
+			continue
+		}
+		value, err := c.formatTarget(w.sensorID, minimum)
+		if err != nil {
+			c.logf(LogWarning, "invalid frost minimum of sensor %v: %v", w.sensorID, err)
+			continue
+		}
+		writes[i].value = value
+		events = append(events, FrostProtection{Time: time.Now(), SensorID: w.sensorID, Target: target, Minimum: minimum})
+	}
+	c.frost.mu.Unlock()
+
+	for _, e := range events {
+		c.logf(LogWarning, "%v", e)
+		c.Events().Publish(e)
+	}
+	return writes
+}
+
+//correctFrost writes the frost minimum of the physical sensors read with a target below it
+func (c *Client) correctFrost(ctx context.Context, sensors []Sensor) {
+	type correction struct {
+		id              int
+		target, minimum float32
+	}
+	var corrections []correction
+	c.frost.mu.Lock()
+	for _, s := range sensors {
+		if s.Members != nil || s.Stale || !s.Valid.Has(FieldTargetTemperature) {
+			continue
+		}
+		minimum, ok := c.frost.get(s.Id)
+		if !ok || s.TargetTemperature >= minimum || !temperatureDiffers(s.TargetTemperature, minimum) {
+			continue
+		}
+		corrections = append(corrections, correction{s.Id, s.TargetTemperature, minimum})
+	}
+	c.frost.mu.Unlock()
+
+	for _, corr := range corrections {
+		err := c.setTargetTemperature(ctx, corr.id, corr.minimum)
+		e := FrostProtection{Time: time.Now(), SensorID: corr.id, Target: corr.target, Minimum: corr.minimum, Corrected: true, Err: err}
+		c.logf(LogWarning, "%v", e)
+		c.Events().Publish(e)
+	}
+}
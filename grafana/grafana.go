@@ -0,0 +1,274 @@
+//Package grafana serves recorded history and live values over the query contract of the Grafana
+//JSON datasource plugins (grafana-simple-json-datasource and simpod-json-datasource), so rooms
+//can be graphed in Grafana without a time series database.
+package grafana
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/history"
+)
+
+//metrics are the series available for each sensor, with their value in a sample
+var metrics = []struct {
+	name  string
+	value func(s history.Sample) float64
+}{
+	{"roomTemperature", func(s history.Sample) float64 { return float64(s.RoomTemperature) }},
+	{"targetTemperature", func(s history.Sample) float64 { return float64(s.TargetTemperature) }},
+	{"valveOpen", func(s history.Sample) float64 {
+		if s.ValveOpen {
+			return 1
+		}
+		return 0
+	}},
+}
+
+//liveTarget is the table target listing the current values of all sensors
+const liveTarget = "live"
+
+/*
+Datasource is an http.Handler implementing the JSON datasource endpoints. Time series targets
+are named sensor<id>.<metric>, e.g. sensor3.roomTemperature, and are read from the history
+store. When a watcher is attached, the latest poll is appended to each series, and the "live"
+target returns the current values as a table.
+
+	http.Handle("/grafana/", http.StripPrefix("/grafana", grafana.New(store)))
+*/
+type Datasource struct {
+	store history.Store
+
+	mu   sync.Mutex
+	live []history.Sample
+}
+
+//New creates a datasource reading history from the store
+func New(store history.Store) *Datasource {
+	return &Datasource{store: store}
+}
+
+//Attach keeps the latest successful poll of the watcher as live values
+func (d *Datasource) Attach(w *roth.Watcher) {
+	w.Subscribe(func(p roth.Poll) {
+		if p.Err != nil {
+			return
+		}
+		var live []history.Sample
+		for _, s := range p.Sensors {
+			if s.Valid.Has(roth.FieldRoomTemperature | roth.FieldTargetTemperature) {
+				live = append(live, history.SampleOf(p.Time, s))
+			}
+		}
+		d.mu.Lock()
+		d.live = live
+		d.mu.Unlock()
+	})
+}
+
+//liveSample returns the latest live sample of a sensor
+func (d *Datasource) liveSample(sensorID int) (history.Sample, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, s := range d.live {
+		if s.SensorID == sensorID {
+			return s, true
+		}
+	}
+	return history.Sample{}, false
+}
+
+//ServeHTTP serves the connection test at /, and /search, /query and /annotations
+func (d *Datasource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var response interface{}
+	var err error
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case "":
+		w.WriteHeader(http.StatusOK)
+		return
+	case "/search":
+		response, err = d.search()
+	case "/query":
+		var q query
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			http.Error(w, fmt.Sprintf("invalid query: %v", err), http.StatusBadRequest)
+			return
+		}
+		for _, t := range q.Targets {
+			if t.Target == liveTarget {
+				continue
+			}
+			if _, _, err := parseTarget(t.Target); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		response, err = d.query(q)
+	case "/annotations":
+		response = []struct{}{}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+//search lists all targets
+func (d *Datasource) search() ([]string, error) {
+	ids, err := d.store.Sensors()
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	for _, s := range d.live {
+		ids = append(ids, s.SensorID)
+	}
+	d.mu.Unlock()
+	sort.Ints(ids)
+
+	targets := []string{liveTarget}
+	for i, id := range ids {
+		if i > 0 && ids[i-1] == id {
+			continue
+		}
+		for _, m := range metrics {
+			targets = append(targets, fmt.Sprintf("sensor%d.%v", id, m.name))
+		}
+	}
+	return targets, nil
+}
+
+type query struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	MaxDataPoints int `json:"maxDataPoints"`
+	Targets       []struct {
+		Target string `json:"target"`
+		Type   string `json:"type"`
+	} `json:"targets"`
+}
+
+type series struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+type table struct {
+	Type    string          `json:"type"`
+	Columns []column        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+type column struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+func (d *Datasource) query(q query) ([]interface{}, error) {
+	response := []interface{}{}
+	for _, t := range q.Targets {
+		if t.Target == liveTarget {
+			response = append(response, d.liveTable())
+			continue
+		}
+		s, err := d.series(t.Target, q.Range.From, q.Range.To, q.MaxDataPoints)
+		if err != nil {
+			return nil, err
+		}
+		response = append(response, s)
+	}
+	return response, nil
+}
+
+//series reads a time series target, averaging consecutive samples when there are more than
+//maxPoints
+func (d *Datasource) series(target string, from, to time.Time, maxPoints int) (series, error) {
+	sensorID, metric, err := parseTarget(target)
+	if err != nil {
+		return series{}, err
+	}
+	samples, err := d.store.Query(sensorID, from, to)
+	if err != nil {
+		return series{}, err
+	}
+	if live, ok := d.liveSample(sensorID); ok && !live.Time.Before(from) && live.Time.Before(to) &&
+		(len(samples) == 0 || live.Time.After(samples[len(samples)-1].Time)) {
+		samples = append(samples, live)
+	}
+
+	result := series{Target: target, Datapoints: [][2]float64{}}
+	if len(samples) > 0 && samples[len(samples)-1].Name != "" {
+		result.Target = fmt.Sprintf("%v %v", samples[len(samples)-1].Name, metrics[metric].name)
+	}
+	group := 1
+	if maxPoints > 0 && len(samples) > maxPoints {
+		group = (len(samples) + maxPoints - 1) / maxPoints
+	}
+	for start := 0; start < len(samples); start += group {
+		end := start + group
+		if end > len(samples) {
+			end = len(samples)
+		}
+		var sum float64
+		for _, s := range samples[start:end] {
+			sum += metrics[metric].value(s)
+		}
+		ms := samples[end-1].Time.UnixNano() / int64(time.Millisecond)
+		result.Datapoints = append(result.Datapoints, [2]float64{sum / float64(end-start), float64(ms)})
+	}
+	return result, nil
+}
+
+//parseTarget parses a target of the form sensor<id>.<metric>, returning the index of the metric
+func parseTarget(target string) (int, int, error) {
+	dot := strings.IndexByte(target, '.')
+	if !strings.HasPrefix(target, "sensor") || dot < 0 {
+		return 0, 0, fmt.Errorf("invalid target %q: must be sensor<id>.<metric>", target)
+	}
+	id, err := strconv.Atoi(target[len("sensor"):dot])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid target %q: must be sensor<id>.<metric>", target)
+	}
+	for i, m := range metrics {
+		if m.name == target[dot+1:] {
+			return id, i, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("unknown metric %q", target[dot+1:])
+}
+
+//liveTable returns the current values of all sensors as a table
+func (d *Datasource) liveTable() table {
+	t := table{
+		Type: "table",
+		Columns: []column{
+			{"Time", "time"}, {"Sensor", "number"}, {"Name", "string"},
+			{"Room temperature", "number"}, {"Target temperature", "number"},
+			{"Mode", "string"}, {"Program", "string"}, {"Valve open", "string"},
+		},
+		Rows: [][]interface{}{},
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, s := range d.live {
+		t.Rows = append(t.Rows, []interface{}{
+			s.Time.UnixNano() / int64(time.Millisecond), s.SensorID, s.Name,
+			s.RoomTemperature, s.TargetTemperature, s.Mode.String(), s.Program.String(), strconv.FormatBool(s.ValveOpen),
+		})
+	}
+	return t
+}
@@ -0,0 +1,174 @@
+package roth
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//Ping performs a lightweight read of the controller, bypassing any cache, and returns the
+//round trip time. The controller is reachable if the returned error is nil.
+func (c *Client) Ping(ctx context.Context) (latency time.Duration, err error) {
+	req := readRequest{Items: []readRequestItem{{Name: "totalNumberOfDevices"}}}
+
+	start := time.Now()
+	resp, err := c.readChunks(ctx, req)
+	latency = time.Since(start)
+	if err != nil {
+		return latency, err
+	}
+
+	value, ok := resp.Value("totalNumberOfDevices")
+	if !ok {
+		return latency, fmt.Errorf("unexpected ping response")
+	}
+	if _, err := strconv.Atoi(value); err != nil {
+		return latency, fmt.Errorf("unexpected ping response %q", value)
+	}
+	return latency, nil
+}
+
+//HealthState is the reachability of a controller as seen by a HealthMonitor
+type HealthState int
+
+const (
+	//HealthUnknown is the state before the first probe has completed
+	HealthUnknown HealthState = iota
+	//HealthUp means the controller answers probes
+	HealthUp
+	//HealthDown means the controller failed the configured number of consecutive probes
+	HealthDown
+)
+
+func (s HealthState) String() string {
+	switch s {
+	case HealthUnknown:
+		return "unknown"
+	case HealthUp:
+		return "up"
+	case HealthDown:
+		return "down"
+	}
+	return fmt.Sprintf("HealthState(%d)", int(s))
+}
+
+//HealthEvent describes a transition between health states
+type HealthEvent struct {
+	State    HealthState
+	Previous HealthState
+	Time     time.Time
+	//Latency of the probe causing the transition
+	Latency time.Duration
+	//Err is the error of the probe causing a transition to HealthDown
+	Err error
+}
+
+//HealthMonitor periodically pings a controller and reports Up/Down transitions
+type HealthMonitor struct {
+	client   *Client
+	interval time.Duration
+
+	//FailureThreshold is the number of consecutive failed probes before the controller is
+	//considered down. Values below 1 are treated as 1.
+	FailureThreshold int
+	//Timeout limits the duration of each probe. If zero, the probe interval is used.
+	Timeout time.Duration
+	//OnChange is called on every state transition, including the first from HealthUnknown: on
+	//the first successful probe, or when FailureThreshold probes failed before any succeeded
+	OnChange func(HealthEvent)
+
+	mu          sync.Mutex
+	state       HealthState
+	failures    int
+	lastLatency time.Duration
+	lastErr     error
+
+	runner Runner
+}
+
+//NewHealthMonitor creates a monitor probing the controller at the given interval
+func NewHealthMonitor(client *Client, interval time.Duration) *HealthMonitor {
+	return &HealthMonitor{
+		client:           client,
+		interval:         interval,
+		FailureThreshold: 3,
+	}
+}
+
+//State returns the current health state, along with the latency and error of the last probe
+func (m *HealthMonitor) State() (state HealthState, latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state, m.lastLatency, m.lastErr
+}
+
+//Run probes the controller until the context is cancelled
+func (m *HealthMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		m.probe(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *HealthMonitor) probe(ctx context.Context) {
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = m.interval
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, timeout)
+	latency, err := m.client.Ping(probeCtx)
+	cancel()
+	if ctx.Err() != nil {
+		//shutting down, not a controller failure
+		return
+	}
+
+	threshold := m.FailureThreshold
+	if threshold < 1 {
+		threshold = 1
+	}
+
+	m.mu.Lock()
+	m.lastLatency, m.lastErr = latency, err
+	previous := m.state
+	if err == nil {
+		m.failures = 0
+		m.state = HealthUp
+	} else {
+		m.failures++
+		//failures count alike from HealthUnknown and HealthUp, so a single failed first probe
+		//does not report the controller down
+		if m.failures >= threshold {
+			m.state = HealthDown
+		}
+	}
+	state := m.state
+	m.mu.Unlock()
+
+	if state != previous {
+		if state == HealthDown {
+			m.client.logf(LogWarning, "controller down: %v", err)
+		} else {
+			m.client.logf(LogInfo, "controller %v", state)
+		}
+		now := time.Now()
+		if state == HealthDown {
+			m.client.Events().Publish(ControllerDown{Time: now, Err: err})
+		} else {
+			m.client.Events().Publish(ControllerUp{Time: now, Latency: latency})
+		}
+		if m.OnChange != nil {
+			m.OnChange(HealthEvent{State: state, Previous: previous, Time: now, Latency: latency, Err: err})
+		}
+	}
+}
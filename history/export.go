@@ -0,0 +1,58 @@
+package history
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+//Select returns the samples of the given sensors in the time range [from, to), ordered by
+//sensor and time. If no sensor ids are given, all sensors are selected.
+func Select(store Store, from, to time.Time, sensorIDs ...int) ([]Sample, error) {
+	if len(sensorIDs) == 0 {
+		var err error
+		if sensorIDs, err = store.Sensors(); err != nil {
+			return nil, err
+		}
+	}
+
+	var samples []Sample
+	for _, id := range sensorIDs {
+		s, err := store.Query(id, from, to)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, s...)
+	}
+	return samples, nil
+}
+
+//csvHeader names the columns written by WriteCSV
+var csvHeader = []string{"time", "sensor", "name", "room_temperature", "target_temperature", "mode", "program", "valve_open"}
+
+//WriteCSV writes samples as csv with a header row, with times in RFC 3339 format, for
+//spreadsheets and pandas
+func WriteCSV(w io.Writer, samples []Sample) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, s := range samples {
+		record := []string{
+			s.Time.Format(time.RFC3339),
+			strconv.Itoa(s.SensorID),
+			s.Name,
+			strconv.FormatFloat(float64(s.RoomTemperature), 'f', -1, 32),
+			strconv.FormatFloat(float64(s.TargetTemperature), 'f', -1, 32),
+			s.Mode.String(),
+			s.Program.String(),
+			strconv.FormatBool(s.ValveOpen),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
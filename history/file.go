@@ -0,0 +1,93 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+//FileStore is a Store appending samples to a file, one json object per line. All samples are
+//also kept in memory for queries, so the file is only read when the store is opened.
+type FileStore struct {
+	memory *MemoryStore
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+//OpenFile opens or creates a file store
+func OpenFile(path string) (*FileStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	memory := NewMemoryStore()
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var s Sample
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error parsing %v line %v: %v", path, line, err)
+		}
+		memory.Append(s)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &FileStore{memory: memory, file: file}, nil
+}
+
+//Append writes samples to the file
+func (f *FileStore) Append(samples ...Sample) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w := bufio.NewWriter(f.file)
+	for _, s := range samples {
+		data, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		w.Write(data)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.memory.Append(samples...)
+}
+
+//Query returns the samples of a sensor in the time range [from, to)
+func (f *FileStore) Query(sensorID int, from, to time.Time) ([]Sample, error) {
+	return f.memory.Query(sensorID, from, to)
+}
+
+//Sensors returns the ids of all sensors with samples
+func (f *FileStore) Sensors() ([]int, error) {
+	return f.memory.Sensors()
+}
+
+//Sync commits the file to disk
+func (f *FileStore) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Sync()
+}
+
+//Close closes the file
+func (f *FileStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
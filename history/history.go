@@ -0,0 +1,200 @@
+//Package history records sensor readings over time, for modules learning from past behavior and
+//for exporting to analysis tools.
+package history
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+//Sample is a single reading of a sensor
+type Sample struct {
+	Time              time.Time    `json:"time"`
+	SensorID          int          `json:"sensor"`
+	Name              string       `json:"name,omitempty"`
+	RoomTemperature   float32      `json:"roomTemperature"`
+	TargetTemperature float32      `json:"targetTemperature"`
+	Mode              roth.Mode    `json:"mode"`
+	Program           roth.Program `json:"program"`
+	ValveOpen         bool         `json:"valveOpen"`
+}
+
+//SampleOf converts a sensor reading to a sample
+func SampleOf(t time.Time, s roth.Sensor) Sample {
+	return Sample{
+		Time:              t,
+		SensorID:          s.Id,
+		Name:              s.Name,
+		RoomTemperature:   s.RoomTemperature,
+		TargetTemperature: s.TargetTemperature,
+		Mode:              s.Mode,
+		Program:           s.Program,
+		ValveOpen:         s.GetValveValue() == 1,
+	}
+}
+
+//Sensor converts a sample back to a sensor reading, e.g. for replaying history
+func (s Sample) Sensor() roth.Sensor {
+	return roth.Sensor{
+		Id:                s.SensorID,
+		Name:              s.Name,
+		RoomTemperature:   s.RoomTemperature,
+		TargetTemperature: s.TargetTemperature,
+		Mode:              s.Mode,
+		Program:           s.Program,
+		Valid:             roth.FieldName | roth.FieldRoomTemperature | roth.FieldTargetTemperature | roth.FieldMode | roth.FieldProgram,
+		ReadAt:            s.Time,
+	}
+}
+
+//Store persists samples
+type Store interface {
+	//Append adds samples to the store
+	Append(samples ...Sample) error
+	//Query returns the samples of a sensor in the time range [from, to), ordered by time
+	Query(sensorID int, from, to time.Time) ([]Sample, error)
+	//Sensors returns the ids of all sensors with samples, sorted
+	Sensors() ([]int, error)
+}
+
+//MemoryStore is a Store keeping samples in memory
+type MemoryStore struct {
+	mu      sync.Mutex
+	samples map[int][]Sample
+}
+
+//NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{samples: make(map[int][]Sample)}
+}
+
+//Append adds samples to the store
+func (m *MemoryStore) Append(samples ...Sample) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range samples {
+		list := m.samples[s.SensorID]
+		list = append(list, s)
+		//samples normally arrive in order; keep the list sorted when they do not
+		if n := len(list); n > 1 && list[n-1].Time.Before(list[n-2].Time) {
+			sort.SliceStable(list, func(i, j int) bool { return list[i].Time.Before(list[j].Time) })
+		}
+		m.samples[s.SensorID] = list
+	}
+	return nil
+}
+
+//Query returns the samples of a sensor in the time range [from, to)
+func (m *MemoryStore) Query(sensorID int, from, to time.Time) ([]Sample, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := m.samples[sensorID]
+	start := sort.Search(len(list), func(i int) bool { return !list[i].Time.Before(from) })
+	end := sort.Search(len(list), func(i int) bool { return !list[i].Time.Before(to) })
+	result := make([]Sample, end-start)
+	copy(result, list[start:end])
+	return result, nil
+}
+
+//Sensors returns the ids of all sensors with samples
+func (m *MemoryStore) Sensors() ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := make([]int, 0, len(m.samples))
+	for id := range m.samples {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+//Recorder appends every successful poll of a watcher to a store
+type Recorder struct {
+	store Store
+
+	//OnError is called when samples could not be stored
+	OnError func(err error)
+
+	mu      sync.Mutex
+	stopped bool
+	runner  roth.Runner
+}
+
+//syncer is implemented by stores buffering samples, like FileStore
+type syncer interface {
+	Sync() error
+}
+
+//Start records polls again after Stop, and stops the recorder when ctx is cancelled. Polls are
+//recorded without starting the recorder, so Start is only needed to tie it to a context.
+func (r *Recorder) Start(ctx context.Context) error {
+	r.mu.Lock()
+	r.stopped = false
+	r.mu.Unlock()
+	return r.runner.Start(ctx, func(ctx context.Context) {
+		<-ctx.Done()
+		r.stop()
+	})
+}
+
+//Stop ignores further polls, waits until a poll being recorded is stored, and syncs the store
+//to disk if it supports it
+func (r *Recorder) Stop(ctx context.Context) error {
+	if err := r.runner.Stop(ctx); err != nil {
+		return err
+	}
+	return r.stop()
+}
+
+func (r *Recorder) stop() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return nil
+	}
+	r.stopped = true
+	if s, ok := r.store.(syncer); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+//NewRecorder creates a recorder writing to the given store
+func NewRecorder(store Store) *Recorder {
+	return &Recorder{store: store}
+}
+
+//Attach records every poll of the watcher
+func (r *Recorder) Attach(w *roth.Watcher) {
+	w.Subscribe(r.Record)
+}
+
+//Record stores the sensors of a poll. Failed polls, stale sensors and fields the controller did
+//not report are skipped.
+func (r *Recorder) Record(p roth.Poll) {
+	if p.Err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+
+	var samples []Sample
+	for _, s := range p.Sensors {
+		if s.Valid.Has(roth.FieldRoomTemperature|roth.FieldTargetTemperature) && !s.Stale {
+			samples = append(samples, SampleOf(p.Time, s))
+		}
+	}
+	if err := r.store.Append(samples...); err != nil && r.OnError != nil {
+		r.OnError(err)
+	}
+}
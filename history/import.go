@@ -0,0 +1,341 @@
+package history
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+//csvColumns maps the accepted column names of ReadCSV to the columns written by WriteCSV
+var csvColumns = map[string]string{
+	"time":               "time",
+	"timestamp":          "time",
+	"sensor":             "sensor",
+	"sensor_id":          "sensor",
+	"name":               "name",
+	"room_temperature":   "room_temperature",
+	"temperature":        "room_temperature",
+	"target_temperature": "target_temperature",
+	"setpoint":           "target_temperature",
+	"mode":               "mode",
+	"program":            "program",
+	"valve_open":         "valve_open",
+}
+
+//ReadCSV reads samples from csv with a header row, as written by WriteCSV or by spreadsheets
+//and other loggers. The time, sensor and room_temperature columns are required; other columns
+//are optional, and unknown columns are ignored. Times may be given in RFC 3339 format, as
+//2006-01-02 15:04:05 in local time, or as unix seconds.
+func ReadCSV(r io.Reader) ([]Sample, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading csv header: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		if column, ok := csvColumns[strings.ToLower(strings.TrimSpace(name))]; ok {
+			columns[column] = i
+		}
+	}
+	for _, required := range []string{"time", "sensor", "room_temperature"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("csv has no %v column", required)
+		}
+	}
+
+	var samples []Sample
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		value := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		var s Sample
+		if s.Time, err = parseTime(value("time")); err != nil {
+			return nil, fmt.Errorf("line %v: %v", line, err)
+		}
+		if s.SensorID, err = strconv.Atoi(value("sensor")); err != nil {
+			return nil, fmt.Errorf("line %v: invalid sensor %q", line, value("sensor"))
+		}
+		s.Name = value("name")
+		if s.RoomTemperature, err = parseFloat(value("room_temperature")); err != nil {
+			return nil, fmt.Errorf("line %v: invalid room temperature %q", line, value("room_temperature"))
+		}
+		if v := value("target_temperature"); v != "" {
+			if s.TargetTemperature, err = parseFloat(v); err != nil {
+				return nil, fmt.Errorf("line %v: invalid target temperature %q", line, v)
+			}
+		}
+		if v := value("mode"); v != "" {
+			if s.Mode, err = roth.ParseMode(v); err != nil {
+				return nil, fmt.Errorf("line %v: %v", line, err)
+			}
+		}
+		if v := value("program"); v != "" {
+			if s.Program, err = roth.ParseProgram(v); err != nil {
+				return nil, fmt.Errorf("line %v: %v", line, err)
+			}
+		}
+		if v := value("valve_open"); v != "" {
+			if s.ValveOpen, err = strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("line %v: invalid valve_open %q", line, v)
+			}
+		} else {
+			s.ValveOpen = s.RoomTemperature < s.TargetTemperature
+		}
+		samples = append(samples, s)
+	}
+	return samples, nil
+}
+
+//timeFormats are the time formats accepted by the importers besides unix seconds
+var timeFormats = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04"}
+
+func parseTime(s string) (time.Time, error) {
+	for _, format := range timeFormats {
+		if t, err := time.ParseInLocation(format, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(seconds*1e9)), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+func parseFloat(s string) (float32, error) {
+	f, err := strconv.ParseFloat(s, 32)
+	return float32(f), err
+}
+
+//haState is a state change recorded by Home Assistant
+type haState struct {
+	EntityID    string                 `json:"entity_id"`
+	State       string                 `json:"state"`
+	Attributes  map[string]interface{} `json:"attributes"`
+	LastChanged string                 `json:"last_changed"`
+}
+
+//ReadHomeAssistant reads the history of Home Assistant entities, either as json returned by
+//the /api/history/period endpoint of the recorder, or as csv with entity_id, state and
+//last_changed columns as downloaded from the history panel. entities maps the entity ids to
+//sensor ids. Climate entities provide the room and target temperature from their attributes,
+//other entities, e.g. temperature sensors, the room temperature as their state. Entities
+//report changes independently, so every sample carries the last known values of the other
+//fields; samples before the first room temperature of a sensor are skipped.
+func ReadHomeAssistant(r io.Reader, entities map[string]int) ([]Sample, error) {
+	buffered := bufio.NewReader(r)
+	first, err := firstByte(buffered)
+	if err != nil {
+		return nil, err
+	}
+
+	var states []haState
+	if first == '[' {
+		var lists [][]haState
+		if err := json.NewDecoder(buffered).Decode(&lists); err != nil {
+			return nil, fmt.Errorf("error reading home assistant history: %v", err)
+		}
+		for _, list := range lists {
+			//minimal responses only name the entity in the first state
+			entity := ""
+			for _, s := range list {
+				if s.EntityID == "" {
+					s.EntityID = entity
+				}
+				entity = s.EntityID
+				states = append(states, s)
+			}
+		}
+	} else {
+		if states, err = readHomeAssistantCSV(buffered); err != nil {
+			return nil, err
+		}
+	}
+
+	type change struct {
+		time  time.Time
+		state haState
+	}
+	var changes []change
+	for _, s := range states {
+		if _, ok := entities[s.EntityID]; !ok {
+			continue
+		}
+		t, err := parseTime(s.LastChanged)
+		if err != nil {
+			return nil, fmt.Errorf("entity %v: %v", s.EntityID, err)
+		}
+		changes = append(changes, change{t, s})
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].time.Before(changes[j].time) })
+
+	type current struct {
+		sample          Sample
+		room, hasTarget bool
+	}
+	sensors := make(map[int]*current)
+	var samples []Sample
+	for _, c := range changes {
+		id := entities[c.state.EntityID]
+		cur := sensors[id]
+		if cur == nil {
+			cur = &current{sample: Sample{SensorID: id}}
+			sensors[id] = cur
+		}
+
+		attributes := c.state.Attributes
+		if name, ok := attributes["friendly_name"].(string); ok {
+			cur.sample.Name = name
+		}
+		updated := false
+		if strings.HasPrefix(c.state.EntityID, "climate.") {
+			if t, ok := attributes["current_temperature"].(float64); ok {
+				cur.sample.RoomTemperature, cur.room, updated = float32(t), true, true
+			}
+			if t, ok := attributes["temperature"].(float64); ok {
+				cur.sample.TargetTemperature, cur.hasTarget, updated = float32(t), true, true
+			}
+		} else if t, err := parseFloat(c.state.State); err == nil {
+			cur.sample.RoomTemperature, cur.room, updated = t, true, true
+		}
+		if !updated || !cur.room {
+			continue
+		}
+		s := cur.sample
+		s.Time = c.time
+		s.ValveOpen = cur.hasTarget && s.RoomTemperature < s.TargetTemperature
+		samples = append(samples, s)
+	}
+	return samples, nil
+}
+
+//firstByte returns the first byte of the input other than whitespace and a byte order mark,
+//leaving it unread
+func firstByte(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("error reading home assistant history: %v", err)
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n', 0xef, 0xbb, 0xbf:
+			continue
+		}
+		return b, r.UnreadByte()
+	}
+}
+
+func readHomeAssistantCSV(r io.Reader) ([]haState, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading csv header: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"entity_id", "state", "last_changed"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("csv has no %v column", required)
+		}
+	}
+
+	var states []haState
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return states, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < len(header) {
+			continue
+		}
+		states = append(states, haState{
+			EntityID:    strings.TrimSpace(record[columns["entity_id"]]),
+			State:       strings.TrimSpace(record[columns["state"]]),
+			LastChanged: strings.TrimSpace(record[columns["last_changed"]]),
+		})
+	}
+}
+
+//Import appends samples to a store, e.g. read with ReadCSV or ReadHomeAssistant, so modules
+//learning from history have data before the first recorded poll. Samples of a sensor at or
+//after its first sample already in the store are skipped, so an import does not overlap the
+//recorded history and may be repeated. It returns the number of samples imported.
+func Import(store Store, samples []Sample) (int, error) {
+	bySensor := make(map[int][]Sample)
+	for _, s := range samples {
+		bySensor[s.SensorID] = append(bySensor[s.SensorID], s)
+	}
+	ids := make([]int, 0, len(bySensor))
+	for id := range bySensor {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	imported := 0
+	for _, id := range ids {
+		list := bySensor[id]
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Time.Before(list[j].Time) })
+		existing, err := store.Query(id, time.Time{}, list[len(list)-1].Time.Add(time.Nanosecond))
+		if err != nil {
+			return imported, err
+		}
+		if len(existing) > 0 {
+			cutoff := existing[0].Time
+			n := sort.Search(len(list), func(i int) bool { return !list[i].Time.Before(cutoff) })
+			list = list[:n]
+		}
+		if len(list) == 0 {
+			continue
+		}
+		if err := store.Append(list...); err != nil {
+			return imported, err
+		}
+		imported += len(list)
+	}
+	return imported, nil
+}
+
+//Polls groups samples taken at the same time into polls, ordered by time, for replaying
+//history into modules learning from polls, like anomaly.Detector.Learn
+func Polls(samples []Sample) []roth.Poll {
+	sorted := make([]Sample, len(samples))
+	copy(sorted, samples)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+
+	var polls []roth.Poll
+	for _, s := range sorted {
+		if n := len(polls); n == 0 || !polls[n-1].Time.Equal(s.Time) {
+			polls = append(polls, roth.Poll{Time: s.Time})
+		}
+		p := &polls[len(polls)-1]
+		p.Sensors = append(p.Sensors, s.Sensor())
+		p.Raw = p.Sensors
+	}
+	return polls
+}
@@ -0,0 +1,221 @@
+package history
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+//compareSamples reports the differences between samples, comparing times with Equal
+func compareSamples(t *testing.T, got, want []Sample) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %v samples, want %v: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if !g.Time.Equal(w.Time) {
+			t.Errorf("sample %v: got time %v, want %v", i, g.Time, w.Time)
+		}
+		g.Time, w.Time = time.Time{}, time.Time{}
+		if g != w {
+			t.Errorf("sample %v: got %+v, want %+v", i, g, w)
+		}
+	}
+}
+
+func TestReadCSVRoundTrip(t *testing.T) {
+	start := time.Date(2026, 1, 10, 6, 0, 0, 0, time.UTC)
+	samples := []Sample{
+		{Time: start, SensorID: 0, Name: "Living room, south", RoomTemperature: 20.86, TargetTemperature: 21, Mode: roth.ModeDay, Program: roth.Program1, ValveOpen: true},
+		{Time: start.Add(5 * time.Minute), SensorID: 1, Name: `Bedroom "loft"`, RoomTemperature: -2.5, TargetTemperature: 17.5, Mode: roth.ModeNight, Program: roth.ProgramConstant},
+	}
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, samples); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	got, err := ReadCSV(&buf)
+	if err != nil {
+		t.Fatalf("ReadCSV: %v", err)
+	}
+	compareSamples(t, got, samples)
+}
+
+func TestReadCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+		want []Sample
+		//wantErr is a part of the expected error, empty if none is expected
+		wantErr string
+	}{
+		{
+			name: "aliases and unix seconds",
+			csv:  "Timestamp,Sensor_ID,Temperature,Setpoint,Humidity\n1768024800,2,19.5,21,40\n",
+			want: []Sample{{Time: time.Unix(1768024800, 0), SensorID: 2, RoomTemperature: 19.5, TargetTemperature: 21, ValveOpen: true}},
+		},
+		{
+			name: "local time and explicit valve",
+			csv:  "time,sensor,room_temperature,target_temperature,valve_open\n2026-01-10 06:00,0,22,21,true\n",
+			want: []Sample{{Time: time.Date(2026, 1, 10, 6, 0, 0, 0, time.Local), RoomTemperature: 22, TargetTemperature: 21, ValveOpen: true}},
+		},
+		{
+			name: "short rows",
+			csv:  "time,sensor,room_temperature,mode\n2026-01-10T06:00:00Z,0,20\n",
+			want: []Sample{{Time: time.Date(2026, 1, 10, 6, 0, 0, 0, time.UTC), RoomTemperature: 20}},
+		},
+		{
+			name:    "missing column",
+			csv:     "time,room_temperature\n2026-01-10T06:00:00Z,20\n",
+			wantErr: "no sensor column",
+		},
+		{
+			name:    "invalid time",
+			csv:     "time,sensor,room_temperature\n2026-01-10T06:00:00Z,0,20\nyesterday,0,20\n",
+			wantErr: "line 3: invalid time",
+		},
+		{
+			name:    "invalid temperature",
+			csv:     "time,sensor,room_temperature\n2026-01-10T06:00:00Z,0,warm\n",
+			wantErr: "line 2: invalid room temperature",
+		},
+		{
+			name:    "invalid mode",
+			csv:     "time,sensor,room_temperature,mode\n2026-01-10T06:00:00Z,0,20,party\n",
+			wantErr: "line 2",
+		},
+		{
+			name:    "empty",
+			csv:     "",
+			wantErr: "header",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ReadCSV(strings.NewReader(test.csv))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadCSV: %v", err)
+			}
+			compareSamples(t, got, test.want)
+		})
+	}
+}
+
+func TestReadHomeAssistant(t *testing.T) {
+	entities := map[string]int{"climate.living_room": 0, "sensor.bedroom_temperature": 1}
+	at := func(minute int) time.Time {
+		return time.Date(2026, 1, 10, 6, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  []Sample
+	}{
+		{
+			name: "json with minimal states",
+			input: `[
+				[
+					{"entity_id": "climate.living_room", "state": "heat", "last_changed": "2026-01-10T06:10:00+00:00",
+						"attributes": {"friendly_name": "Living room", "current_temperature": 20.5, "temperature": 21}},
+					{"state": "heat", "last_changed": "2026-01-10T06:00:00.000000+00:00",
+						"attributes": {"temperature": 22}},
+					{"state": "heat", "last_changed": "2026-01-10T06:20:00+00:00",
+						"attributes": {"current_temperature": 21.5, "temperature": 21}}
+				],
+				[
+					{"entity_id": "sensor.bedroom_temperature", "state": "18.5", "last_changed": "2026-01-10T06:05:00Z", "attributes": {}},
+					{"state": "unavailable", "last_changed": "2026-01-10T06:15:00Z"}
+				],
+				[
+					{"entity_id": "sensor.outdoor", "state": "-3", "last_changed": "2026-01-10T06:05:00Z"}
+				]
+			]`,
+			want: []Sample{
+				//the target set at 06:00 is skipped, as there is no room temperature yet
+				{Time: at(5), SensorID: 1, RoomTemperature: 18.5},
+				{Time: at(10), SensorID: 0, Name: "Living room", RoomTemperature: 20.5, TargetTemperature: 21, ValveOpen: true},
+				{Time: at(20), SensorID: 0, Name: "Living room", RoomTemperature: 21.5, TargetTemperature: 21},
+			},
+		},
+		{
+			name:  "csv with byte order mark",
+			input: "\xef\xbb\xbfentity_id,state,last_changed\nsensor.bedroom_temperature,18.5,2026-01-10T06:05:00.000Z\nsensor.bedroom_temperature,unknown,2026-01-10T06:06:00.000Z\nsensor.bedroom_temperature,19,2026-01-10T06:07:00.000Z\n",
+			want: []Sample{
+				{Time: at(5), SensorID: 1, RoomTemperature: 18.5},
+				{Time: at(7), SensorID: 1, RoomTemperature: 19},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ReadHomeAssistant(strings.NewReader(test.input), entities)
+			if err != nil {
+				t.Fatalf("ReadHomeAssistant: %v", err)
+			}
+			compareSamples(t, got, test.want)
+		})
+	}
+
+	for name, input := range map[string]string{
+		"empty":        "  \n",
+		"invalid json": `[[{"entity_id": 3}]]`,
+		"csv columns":  "entity_id,value\nsensor.bedroom_temperature,18\n",
+		"invalid time": `[[{"entity_id": "sensor.bedroom_temperature", "state": "18", "last_changed": "now"}]]`,
+	} {
+		if _, err := ReadHomeAssistant(strings.NewReader(input), entities); err == nil {
+			t.Errorf("%v: no error", name)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	start := time.Date(2026, 1, 10, 6, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	//recorded history of sensor 0 starts at 06:20
+	store.Append(Sample{Time: start.Add(20 * time.Minute), SensorID: 0, RoomTemperature: 21})
+
+	var samples []Sample
+	for minute := 30; minute >= 0; minute -= 10 {
+		for id := 0; id < 2; id++ {
+			samples = append(samples, Sample{Time: start.Add(time.Duration(minute) * time.Minute), SensorID: id, RoomTemperature: 20})
+		}
+	}
+	n, err := Import(store, samples)
+	if err != nil || n != 6 {
+		t.Fatalf("imported %v samples (%v), want the 2 of sensor 0 before 06:20 and the 4 of sensor 1", n, err)
+	}
+	recorded, _ := store.Query(0, time.Time{}, start.Add(time.Hour))
+	if len(recorded) != 3 || !recorded[0].Time.Equal(start) || recorded[2].RoomTemperature != 21 {
+		t.Errorf("got sensor 0 history %+v", recorded)
+	}
+
+	//importing again adds nothing
+	if n, err := Import(store, samples); err != nil || n != 0 {
+		t.Errorf("imported %v samples (%v) again, want 0", n, err)
+	}
+}
+
+func TestPolls(t *testing.T) {
+	start := time.Date(2026, 1, 10, 6, 0, 0, 0, time.UTC)
+	polls := Polls([]Sample{
+		{Time: start.Add(time.Minute), SensorID: 0, Name: "a"},
+		{Time: start, SensorID: 1, Name: "b"},
+		{Time: start, SensorID: 0, Name: "a"},
+	})
+	if len(polls) != 2 || !polls[0].Time.Equal(start) || len(polls[0].Sensors) != 2 || len(polls[1].Sensors) != 1 {
+		t.Fatalf("got polls %+v, want 2 sensors at the start and 1 a minute later", polls)
+	}
+	if polls[0].Sensors[0].Id != 1 || polls[0].Sensors[1].Id != 0 {
+		t.Errorf("got sensors %v and %v, want the order of the samples kept", polls[0].Sensors[0].Id, polls[0].Sensors[1].Id)
+	}
+}
@@ -0,0 +1,232 @@
+package history
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+//parquet physical types
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetFloat     = 4
+	parquetByteArray = 6
+)
+
+//parquet converted types
+const (
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+)
+
+//parquetColumn is a column of the file, with its values PLAIN encoded
+type parquetColumn struct {
+	name          string
+	physicalType  int32
+	convertedType int32
+	data          bytes.Buffer
+}
+
+//WriteParquet writes samples as a parquet file, for analysis in pandas or other data tools.
+//The file is kept minimal: one row group, required columns, PLAIN encoding and no
+//compression, with the metadata in the thrift compact protocol as described by
+//https://github.com/apache/parquet-format.
+func WriteParquet(w io.Writer, samples []Sample) error {
+	columns := []*parquetColumn{
+		{name: "time", physicalType: parquetInt64, convertedType: parquetTimestampMillis},
+		{name: "sensor", physicalType: parquetInt32, convertedType: -1},
+		{name: "name", physicalType: parquetByteArray, convertedType: parquetUTF8},
+		{name: "room_temperature", physicalType: parquetFloat, convertedType: -1},
+		{name: "target_temperature", physicalType: parquetFloat, convertedType: -1},
+		{name: "mode", physicalType: parquetByteArray, convertedType: parquetUTF8},
+		{name: "program", physicalType: parquetByteArray, convertedType: parquetUTF8},
+		{name: "valve_open", physicalType: parquetBoolean, convertedType: -1},
+	}
+
+	var valves []bool
+	for _, s := range samples {
+		binary.Write(&columns[0].data, binary.LittleEndian, s.Time.UnixNano()/1e6)
+		binary.Write(&columns[1].data, binary.LittleEndian, int32(s.SensorID))
+		writeByteArray(&columns[2].data, s.Name)
+		binary.Write(&columns[3].data, binary.LittleEndian, math.Float32bits(s.RoomTemperature))
+		binary.Write(&columns[4].data, binary.LittleEndian, math.Float32bits(s.TargetTemperature))
+		writeByteArray(&columns[5].data, s.Mode.String())
+		writeByteArray(&columns[6].data, s.Program.String())
+		valves = append(valves, s.ValveOpen)
+	}
+	//booleans are bit packed, least significant bit first
+	packed := make([]byte, (len(valves)+7)/8)
+	for i, v := range valves {
+		if v {
+			packed[i/8] |= 1 << uint(i%8)
+		}
+	}
+	columns[7].data.Write(packed)
+
+	out := &countingWriter{w: w}
+	if _, err := out.Write([]byte("PAR1")); err != nil {
+		return err
+	}
+
+	//one data page per column
+	var chunks []thrift
+	var totalSize int64
+	for _, col := range columns {
+		offset := out.n
+		header := thriftStruct(
+			thriftI32(1, 0), //DATA_PAGE
+			thriftI32(2, int32(col.data.Len())),
+			thriftI32(3, int32(col.data.Len())),
+			thriftStructField(5, thriftStruct(
+				thriftI32(1, int32(len(samples))),
+				thriftI32(2, 0), //PLAIN
+				thriftI32(3, 3), //RLE
+				thriftI32(4, 3), //RLE
+			)),
+		)
+		var page bytes.Buffer
+		header.encode(&page)
+		page.Write(col.data.Bytes())
+		size := int64(page.Len())
+		if _, err := out.Write(page.Bytes()); err != nil {
+			return err
+		}
+		totalSize += size
+
+		chunks = append(chunks, thriftStruct(
+			thriftI64(2, offset),
+			thriftStructField(3, thriftStruct(
+				thriftI32(1, col.physicalType),
+				thriftList(2, 5, thriftI32(0, 0)),
+				thriftList(3, 8, thriftString(0, col.name)),
+				thriftI32(4, 0), //UNCOMPRESSED
+				thriftI64(5, int64(len(samples))),
+				thriftI64(6, size),
+				thriftI64(7, size),
+				thriftI64(9, offset),
+			)),
+		))
+	}
+
+	schema := []thrift{thriftStruct(
+		thriftString(4, "schema"),
+		thriftI32(5, int32(len(columns))),
+	)}
+	for _, col := range columns {
+		fields := []thrift{
+			thriftI32(1, col.physicalType),
+			thriftI32(3, 0), //REQUIRED
+			thriftString(4, col.name),
+		}
+		if col.convertedType >= 0 {
+			fields = append(fields, thriftI32(6, col.convertedType))
+		}
+		schema = append(schema, thriftStruct(fields...))
+	}
+
+	metadata := thriftStruct(
+		thriftI32(1, 1),
+		thriftList(2, 12, schema...),
+		thriftI64(3, int64(len(samples))),
+		thriftList(4, 12, thriftStruct(
+			thriftList(1, 12, chunks...),
+			thriftI64(2, totalSize),
+			thriftI64(3, int64(len(samples))),
+		)),
+		thriftString(6, "rothTouchline history"),
+	)
+	var footer bytes.Buffer
+	metadata.encode(&footer)
+	binary.Write(&footer, binary.LittleEndian, int32(footer.Len()))
+	footer.WriteString("PAR1")
+	_, err := out.Write(footer.Bytes())
+	return err
+}
+
+func writeByteArray(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.LittleEndian, int32(len(s)))
+	buf.WriteString(s)
+}
+
+//countingWriter tracks the offset in the file, for the column chunk metadata
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+//thrift is a field of a thrift struct, or an element of a list, in the compact protocol
+type thrift struct {
+	id       int16
+	typ      byte
+	value    interface{}
+	elemType byte
+}
+
+func thriftI32(id int16, v int32) thrift     { return thrift{id: id, typ: 5, value: int64(v)} }
+func thriftI64(id int16, v int64) thrift     { return thrift{id: id, typ: 6, value: v} }
+func thriftString(id int16, v string) thrift { return thrift{id: id, typ: 8, value: v} }
+
+func thriftList(id int16, elemType byte, elems ...thrift) thrift {
+	return thrift{id: id, typ: 9, value: elems, elemType: elemType}
+}
+
+//thriftStruct is a struct value, used as a list element or with thriftStructField
+func thriftStruct(fields ...thrift) thrift {
+	return thrift{typ: 12, value: fields}
+}
+
+func thriftStructField(id int16, s thrift) thrift {
+	s.id = id
+	return s
+}
+
+//encode writes the value, without a field header
+func (t thrift) encode(buf *bytes.Buffer) {
+	switch v := t.value.(type) {
+	case int64:
+		writeVarint(buf, uint64((v<<1)^(v>>63)))
+	case string:
+		writeVarint(buf, uint64(len(v)))
+		buf.WriteString(v)
+	case []thrift:
+		if t.typ == 9 {
+			if len(v) < 15 {
+				buf.WriteByte(byte(len(v))<<4 | t.elemType)
+			} else {
+				buf.WriteByte(0xf0 | t.elemType)
+				writeVarint(buf, uint64(len(v)))
+			}
+			for _, elem := range v {
+				elem.encode(buf)
+			}
+			return
+		}
+		var last int16
+		for _, field := range v {
+			if delta := field.id - last; delta > 0 && delta <= 15 {
+				buf.WriteByte(byte(delta)<<4 | field.typ)
+			} else {
+				buf.WriteByte(field.typ)
+				writeVarint(buf, uint64((int64(field.id)<<1)^(int64(field.id)>>63)))
+			}
+			last = field.id
+			field.encode(buf)
+		}
+		buf.WriteByte(0)
+	}
+}
+
+func writeVarint(buf *bytes.Buffer, v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	buf.Write(tmp[:n])
+}
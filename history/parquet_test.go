@@ -0,0 +1,206 @@
+package history
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+func TestWriteParquet(t *testing.T) {
+	start := time.Date(2026, 1, 10, 6, 0, 0, 0, time.UTC)
+	var samples []Sample
+	//more than 8 samples, so the valve bits span two bytes
+	for i := 0; i < 10; i++ {
+		samples = append(samples, Sample{
+			Time:              start.Add(time.Duration(i) * 5 * time.Minute),
+			SensorID:          i % 3,
+			Name:              fmt.Sprintf("Stue %v°", i),
+			RoomTemperature:   19.5 + float32(i)/10,
+			TargetTemperature: 21,
+			Mode:              roth.ModeDay,
+			Program:           roth.Program2,
+			ValveOpen:         i%3 == 0,
+		})
+	}
+
+	for _, test := range []struct {
+		name    string
+		samples []Sample
+	}{
+		{"samples", samples},
+		{"empty", nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteParquet(&buf, test.samples); err != nil {
+				t.Fatalf("WriteParquet: %v", err)
+			}
+			got := readParquet(t, buf.Bytes())
+			if len(got) != len(test.samples) {
+				t.Fatalf("got %v rows, want %v", len(got), len(test.samples))
+			}
+			for i, want := range test.samples {
+				//the time is stored in milliseconds
+				want.Time = want.Time.Truncate(time.Millisecond)
+				if got[i] != want {
+					t.Errorf("row %v: got %+v, want %+v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+//readParquet decodes a file as written by WriteParquet, following the offsets in the footer
+func readParquet(t *testing.T, file []byte) []Sample {
+	t.Helper()
+	if len(file) < 12 || string(file[:4]) != "PAR1" || string(file[len(file)-4:]) != "PAR1" {
+		t.Fatalf("missing magic in %q", file)
+	}
+	footerLen := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	footer := bytes.NewReader(file[len(file)-8-footerLen : len(file)-8])
+	metadata := readThriftStruct(t, footer)
+	if footer.Len() != 0 {
+		t.Fatalf("%v bytes after the file metadata", footer.Len())
+	}
+
+	rows := int(metadata[3].(int64))
+	schema := metadata[2].([]interface{})
+	if len(schema) != 9 || schema[0].(map[int16]interface{})[5].(int64) != 8 {
+		t.Fatalf("got schema %v, want a root with 8 columns", schema)
+	}
+	rowGroups := metadata[4].([]interface{})
+	if len(rowGroups) != 1 {
+		t.Fatalf("got %v row groups, want 1", len(rowGroups))
+	}
+	chunks := rowGroups[0].(map[int16]interface{})[1].([]interface{})
+
+	samples := make([]Sample, rows)
+	for i, chunk := range chunks {
+		meta := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+		name := string(schema[i+1].(map[int16]interface{})[4].([]byte))
+		if path := string(meta[3].([]interface{})[0].([]byte)); path != name {
+			t.Errorf("column %v has path %q", name, path)
+		}
+		if n := meta[5].(int64); n != int64(rows) {
+			t.Errorf("column %v has %v values, want %v", name, n, rows)
+		}
+
+		page := bytes.NewReader(file[meta[9].(int64):])
+		header := readThriftStruct(t, page)
+		data := make([]byte, header[2].(int64))
+		page.Read(data)
+		values := bytes.NewReader(data)
+		for row := range samples {
+			s := &samples[row]
+			switch name {
+			case "time":
+				var ms int64
+				binary.Read(values, binary.LittleEndian, &ms)
+				s.Time = time.Unix(0, ms*1e6).UTC()
+			case "sensor":
+				var id int32
+				binary.Read(values, binary.LittleEndian, &id)
+				s.SensorID = int(id)
+			case "room_temperature", "target_temperature":
+				var bits uint32
+				binary.Read(values, binary.LittleEndian, &bits)
+				if name == "room_temperature" {
+					s.RoomTemperature = math.Float32frombits(bits)
+				} else {
+					s.TargetTemperature = math.Float32frombits(bits)
+				}
+			case "name", "mode", "program":
+				var n int32
+				binary.Read(values, binary.LittleEndian, &n)
+				str := make([]byte, n)
+				values.Read(str)
+				switch name {
+				case "name":
+					s.Name = string(str)
+				case "mode":
+					s.Mode, _ = roth.ParseMode(string(str))
+				case "program":
+					s.Program, _ = roth.ParseProgram(string(str))
+				}
+			case "valve_open":
+				s.ValveOpen = data[row/8]&(1<<uint(row%8)) != 0
+			default:
+				t.Fatalf("unexpected column %q", name)
+			}
+		}
+	}
+	return samples
+}
+
+//readThriftStruct decodes a struct in the thrift compact protocol, with lists as []interface{},
+//integers as int64 and strings as []byte
+func readThriftStruct(t *testing.T, r *bytes.Reader) map[int16]interface{} {
+	t.Helper()
+	fields := map[int16]interface{}{}
+	var last int16
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			t.Fatalf("truncated struct: %v", err)
+		}
+		if b == 0 {
+			return fields
+		}
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			id = int16(readZigzag(t, r))
+		}
+		last = id
+		fields[id] = readThriftValue(t, r, b&0x0f)
+	}
+}
+
+func readThriftValue(t *testing.T, r *bytes.Reader, typ byte) interface{} {
+	t.Helper()
+	switch typ {
+	case 5, 6:
+		return readZigzag(t, r)
+	case 8:
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			t.Fatalf("truncated binary: %v", err)
+		}
+		b := make([]byte, n)
+		r.Read(b)
+		return b
+	case 9:
+		header, err := r.ReadByte()
+		if err != nil {
+			t.Fatalf("truncated list: %v", err)
+		}
+		n := uint64(header >> 4)
+		if n == 15 {
+			if n, err = binary.ReadUvarint(r); err != nil {
+				t.Fatalf("truncated list: %v", err)
+			}
+		}
+		elems := make([]interface{}, n)
+		for i := range elems {
+			elems[i] = readThriftValue(t, r, header&0x0f)
+		}
+		return elems
+	case 12:
+		return readThriftStruct(t, r)
+	}
+	t.Fatalf("unexpected thrift type %v", typ)
+	return nil
+}
+
+func readZigzag(t *testing.T, r *bytes.Reader) int64 {
+	t.Helper()
+	v, err := binary.ReadUvarint(r)
+	if err != nil {
+		t.Fatalf("truncated varint: %v", err)
+	}
+	return int64(v>>1) ^ -int64(v&1)
+}
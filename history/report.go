@@ -0,0 +1,257 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"text/tabwriter"
+	"time"
+)
+
+//DefaultComfortBand is the distance from the target temperature considered comfortable, unless
+//Reporter.ComfortBand is set
+const DefaultComfortBand = 0.5
+
+//DefaultMaxGap is the longest interval between two samples attributed to the earlier sample,
+//unless Reporter.MaxGap is set. Longer gaps, e.g. while the recorder was stopped, are not
+//counted.
+const DefaultMaxGap = 15 * time.Minute
+
+//RoomReport summarizes the behavior of a room over a period
+type RoomReport struct {
+	SensorID int    `json:"sensor"`
+	Name     string `json:"name"`
+	//Observed is the time covered by samples
+	Observed time.Duration `json:"observed"`
+	//Comfortable is the time the room temperature was within the comfort band of the target
+	Comfortable time.Duration `json:"comfortable"`
+	//Heating is the time the valve was open
+	Heating            time.Duration `json:"heating"`
+	MinTemperature     float32       `json:"minTemperature"`
+	MaxTemperature     float32       `json:"maxTemperature"`
+	AverageTemperature float32       `json:"averageTemperature"`
+	AverageTarget      float32       `json:"averageTarget"`
+}
+
+//ComfortRatio returns the fraction of the observed time the room was comfortable
+func (r RoomReport) ComfortRatio() float64 {
+	if r.Observed <= 0 {
+		return 0
+	}
+	return float64(r.Comfortable) / float64(r.Observed)
+}
+
+//DutyCycle returns the fraction of the observed time the valve was open
+func (r RoomReport) DutyCycle() float64 {
+	if r.Observed <= 0 {
+		return 0
+	}
+	return float64(r.Heating) / float64(r.Observed)
+}
+
+//Report summarizes all rooms over a period
+type Report struct {
+	From  time.Time    `json:"from"`
+	To    time.Time    `json:"to"`
+	Rooms []RoomReport `json:"rooms"`
+}
+
+//Reporter summarizes recorded history into comfort reports, for finding rooms the manifold
+//supplies too much or too little
+type Reporter struct {
+	Store Store
+	//ComfortBand is the distance from the target temperature considered comfortable. If zero,
+	//DefaultComfortBand is used.
+	ComfortBand float32
+	//MaxGap is the longest interval between samples counted. If zero, DefaultMaxGap is used.
+	MaxGap time.Duration
+}
+
+//NewReporter creates a reporter over the store, with the default comfort band
+func NewReporter(store Store) *Reporter {
+	return &Reporter{Store: store}
+}
+
+//Summarize reports on the time range [from, to). If no sensor ids are given, all sensors are
+//included. Rooms without samples in the range are left out.
+func (r *Reporter) Summarize(from, to time.Time, sensorIDs ...int) (Report, error) {
+	band := r.ComfortBand
+	if band == 0 {
+		band = DefaultComfortBand
+	}
+	maxGap := r.MaxGap
+	if maxGap == 0 {
+		maxGap = DefaultMaxGap
+	}
+	if len(sensorIDs) == 0 {
+		var err error
+		if sensorIDs, err = r.Store.Sensors(); err != nil {
+			return Report{}, err
+		}
+	}
+
+	report := Report{From: from, To: to, Rooms: []RoomReport{}}
+	for _, id := range sensorIDs {
+		samples, err := r.Store.Query(id, from, to)
+		if err != nil {
+			return Report{}, err
+		}
+		if len(samples) == 0 {
+			continue
+		}
+
+		room := RoomReport{SensorID: id, MinTemperature: samples[0].RoomTemperature, MaxTemperature: samples[0].RoomTemperature}
+		var temperatureSum, targetSum float64
+		for i, s := range samples {
+			if s.Name != "" {
+				room.Name = s.Name
+			}
+			if s.RoomTemperature < room.MinTemperature {
+				room.MinTemperature = s.RoomTemperature
+			}
+			if s.RoomTemperature > room.MaxTemperature {
+				room.MaxTemperature = s.RoomTemperature
+			}
+
+			//each sample holds until the next one, or the end of the range
+			end := to
+			if i+1 < len(samples) {
+				end = samples[i+1].Time
+			}
+			dt := end.Sub(s.Time)
+			if dt > maxGap {
+				dt = maxGap
+			}
+			if dt <= 0 {
+				continue
+			}
+			room.Observed += dt
+			temperatureSum += float64(s.RoomTemperature) * dt.Seconds()
+			targetSum += float64(s.TargetTemperature) * dt.Seconds()
+			if d := s.RoomTemperature - s.TargetTemperature; d >= -band && d <= band {
+				room.Comfortable += dt
+			}
+			if s.ValveOpen {
+				room.Heating += dt
+			}
+		}
+		if room.Observed > 0 {
+			room.AverageTemperature = float32(temperatureSum / room.Observed.Seconds())
+			room.AverageTarget = float32(targetSum / room.Observed.Seconds())
+		}
+		report.Rooms = append(report.Rooms, room)
+	}
+	return report, nil
+}
+
+//Daily reports on each local day from the day of from up to the day before to
+func (r *Reporter) Daily(from, to time.Time, sensorIDs ...int) ([]Report, error) {
+	return r.periods(from, to, 1, sensorIDs)
+}
+
+//Weekly reports on each week starting on a monday, from the week of from up to to
+func (r *Reporter) Weekly(from, to time.Time, sensorIDs ...int) ([]Report, error) {
+	start := startOfDay(from)
+	start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+	return r.periods(start, to, 7, sensorIDs)
+}
+
+func (r *Reporter) periods(from, to time.Time, days int, sensorIDs []int) ([]Report, error) {
+	var reports []Report
+	for start := startOfDay(from); start.Before(to); start = start.AddDate(0, 0, days) {
+		report, err := r.Summarize(start, start.AddDate(0, 0, days), sensorIDs...)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+//WriteText writes the report as an aligned table
+func (report Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "%v - %v\n", report.From.Format("2006-01-02 15:04"), report.To.Format("2006-01-02 15:04"))
+	fmt.Fprintln(tw, "sensor\tname\tcomfort\tduty cycle\tmin\tavg\tmax\ttarget\t")
+	for _, room := range report.Rooms {
+		fmt.Fprintf(tw, "%d\t%v\t%.0f%%\t%.0f%%\t%.1f\t%.1f\t%.1f\t%.1f\t\n", room.SensorID, room.Name,
+			100*room.ComfortRatio(), 100*room.DutyCycle(),
+			room.MinTemperature, room.AverageTemperature, room.MaxTemperature, room.AverageTarget)
+	}
+	return tw.Flush()
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"percent": func(f float64) string { return fmt.Sprintf("%.0f%%", 100*f) },
+	"degrees": func(f float32) string { return fmt.Sprintf("%.1f °C", f) },
+}).Parse(`<table class="roth-report">
+<caption>{{.From.Format "2006-01-02 15:04"}} – {{.To.Format "2006-01-02 15:04"}}</caption>
+<tr><th>Sensor</th><th>Name</th><th>Comfort</th><th>Duty cycle</th><th>Min</th><th>Average</th><th>Max</th><th>Target</th></tr>
+{{range .Rooms}}<tr><td>{{.SensorID}}</td><td>{{.Name}}</td><td>{{percent .ComfortRatio}}</td><td>{{percent .DutyCycle}}</td><td>{{degrees .MinTemperature}}</td><td>{{degrees .AverageTemperature}}</td><td>{{degrees .MaxTemperature}}</td><td>{{degrees .AverageTarget}}</td></tr>
+{{end}}</table>
+`))
+
+//WriteHTML writes the report as an html table, for embedding in a page or mail
+func (report Report) WriteHTML(w io.Writer) error {
+	return reportTemplate.Execute(w, report)
+}
+
+//ServeHTTP serves daily or weekly reports, selected with the query parameters period=day|week,
+//from and to (as 2006-01-02, the last week by default), and format=html|text|json
+func (r *Reporter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	to := startOfDay(time.Now()).AddDate(0, 0, 1)
+	from := to.AddDate(0, 0, -7)
+	for name, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.ParseInLocation("2006-01-02", value, time.Local)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid %v date %q", name, value), http.StatusBadRequest)
+				return
+			}
+			*t = parsed
+		}
+	}
+
+	var reports []Report
+	var err error
+	switch query.Get("period") {
+	case "", "day":
+		reports, err = r.Daily(from, to)
+	case "week":
+		reports, err = r.Weekly(from, to)
+	default:
+		http.Error(w, "invalid period: must be day or week", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch query.Get("format") {
+	case "", "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		for _, report := range reports {
+			report.WriteHTML(w)
+		}
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, report := range reports {
+			report.WriteText(w)
+			fmt.Fprintln(w)
+		}
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reports)
+	default:
+		http.Error(w, "invalid format: must be html, text or json", http.StatusBadRequest)
+	}
+}
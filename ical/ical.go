@@ -0,0 +1,422 @@
+//Package ical reads the events of iCalendar (RFC 5545) files and feeds, e.g. a holiday calendar
+//or the bookings of a holiday home, for schedules depending on them. Only what schedules need is
+//supported: the start, end and summary of events, time zones given by TZID, simple recurrences
+//by FREQ, INTERVAL, COUNT and UNTIL, occurrences excluded by EXDATE or moved by RECURRENCE-ID, and
+//cancelled events.
+package ical
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Event is an event of a calendar, or an occurrence of a recurring event
+type Event struct {
+	UID     string
+	Summary string
+	Start   time.Time
+	//End is exclusive. For all-day events, Start and End are midnight in local time.
+	End    time.Time
+	AllDay bool
+
+	rule *recurrence
+	//exdates are the starts of occurrences excluded from the recurrence
+	exdates []time.Time
+	//recurrenceID is the start of the occurrence of the recurring event of the same UID which
+	//this event replaces
+	recurrenceID time.Time
+	cancelled    bool
+}
+
+//Calendar holds the events of a calendar
+type Calendar struct {
+	Events []Event
+	//Warnings describes recurrence rules which are not supported, in which case the events only
+	//have their first occurrence, and unknown time zones, in which case local time is used
+	Warnings []string
+}
+
+//recurrence is a simple recurrence rule
+type recurrence struct {
+	freq     string
+	interval int
+	count    int
+	until    time.Time
+}
+
+//maxOccurrences bounds the expansion of recurring events without COUNT or UNTIL
+const maxOccurrences = 10000
+
+//Parse reads a calendar. Components other than events, and properties other than the ones
+//needed, are skipped.
+func Parse(r io.Reader) (*Calendar, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+	cal := &Calendar{}
+	var event *Event
+	unknownZones := make(map[string]bool)
+	parseEventTime := func(value string, params map[string]string) (time.Time, bool, error) {
+		t, allDay, err := parseTime(value, params)
+		if tzid := params["TZID"]; err == nil && tzid != "" && !knownZone(tzid) && !unknownZones[tzid] {
+			unknownZones[tzid] = true
+			cal.Warnings = append(cal.Warnings, fmt.Sprintf("unknown time zone %q, using local time", tzid))
+		}
+		return t, allDay, err
+	}
+	for n, line := range lines {
+		name, params, value := splitLine(line)
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			event = &Event{}
+		case name == "END" && strings.EqualFold(value, "VEVENT"):
+			if event == nil {
+				return nil, fmt.Errorf("line %v: END:VEVENT without BEGIN", n+1)
+			}
+			if event.Start.IsZero() {
+				return nil, fmt.Errorf("line %v: event %q has no DTSTART", n+1, event.Summary)
+			}
+			if event.End.IsZero() {
+				event.End = event.Start
+				if event.AllDay {
+					event.End = event.Start.AddDate(0, 0, 1)
+				}
+			}
+			cal.Events = append(cal.Events, *event)
+			event = nil
+		case event == nil:
+		case name == "UID":
+			event.UID = value
+		case name == "SUMMARY":
+			event.Summary = unescape(value)
+		case name == "DTSTART", name == "DTEND":
+			t, allDay, err := parseEventTime(value, params)
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %v", n+1, err)
+			}
+			if name == "DTSTART" {
+				event.Start, event.AllDay = t, allDay
+			} else {
+				event.End = t
+			}
+		case name == "EXDATE":
+			for _, v := range strings.Split(value, ",") {
+				t, _, err := parseEventTime(v, params)
+				if err != nil {
+					return nil, fmt.Errorf("line %v: %v", n+1, err)
+				}
+				event.exdates = append(event.exdates, t)
+			}
+		case name == "RECURRENCE-ID":
+			t, _, err := parseEventTime(value, params)
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %v", n+1, err)
+			}
+			event.recurrenceID = t
+		case name == "STATUS":
+			event.cancelled = strings.EqualFold(value, "CANCELLED")
+		case name == "DURATION":
+			d, err := parseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %v", n+1, err)
+			}
+			event.End = event.Start.Add(d)
+		case name == "RRULE":
+			rule, err := parseRule(value)
+			if err != nil {
+				cal.Warnings = append(cal.Warnings, fmt.Sprintf("line %v: %v", n+1, err))
+				break
+			}
+			event.rule = rule
+		}
+	}
+	return cal, nil
+}
+
+//ParseFile reads a calendar from a file
+func ParseFile(path string) (*Calendar, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+//Load reads a calendar from an http, https or webcal url, or from a file. If client is nil,
+//http.DefaultClient is used.
+func Load(ctx context.Context, client *http.Client, source string) (*Calendar, error) {
+	url := source
+	if strings.HasPrefix(url, "webcal://") {
+		url = "https://" + strings.TrimPrefix(url, "webcal://")
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return ParseFile(source)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error loading calendar: status %v", resp.Status)
+	}
+	return Parse(resp.Body)
+}
+
+//Occurrences returns the events, and occurrences of recurring events, overlapping the period
+//from from to to, ordered by start. Cancelled events and occurrences are left out, and moved
+//occurrences are returned at their new time only.
+func (c *Calendar) Occurrences(from, to time.Time) []Event {
+	//overridden holds the replaced occurrences of recurring events, by UID
+	overridden := make(map[string][]time.Time)
+	for _, e := range c.Events {
+		if !e.recurrenceID.IsZero() {
+			overridden[e.UID] = append(overridden[e.UID], e.recurrenceID)
+		}
+	}
+
+	var events []Event
+	for _, e := range c.Events {
+		if e.cancelled {
+			continue
+		}
+		if !e.recurrenceID.IsZero() {
+			//a replaced occurrence does not recur itself
+			e.rule = nil
+		}
+		excluded := e.exdates
+		if e.rule != nil {
+			excluded = append(excluded[:len(excluded):len(excluded)], overridden[e.UID]...)
+		}
+		duration := e.End.Sub(e.Start)
+		for i, start := 0, e.Start; i < maxOccurrences && start.Before(to); i++ {
+			if e.rule != nil && (e.rule.count > 0 && i >= e.rule.count || !e.rule.until.IsZero() && start.After(e.rule.until)) {
+				break
+			}
+			if contains(excluded, start) {
+				if e.rule == nil {
+					break
+				}
+				start = e.rule.next(start)
+				continue
+			}
+			end := start.Add(duration)
+			if e.AllDay {
+				//whole days, regardless of daylight saving time changes in between
+				end = start.AddDate(0, 0, int(duration.Hours()+12)/24)
+			}
+			if end.After(from) || start.Equal(from) {
+				occurrence := e
+				occurrence.Start, occurrence.End, occurrence.rule = start, end, nil
+				events = append(events, occurrence)
+			}
+			if e.rule == nil {
+				break
+			}
+			start = e.rule.next(start)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Start.Before(events[j].Start) })
+	return events
+}
+
+func contains(times []time.Time, t time.Time) bool {
+	for _, other := range times {
+		if other.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+//On returns the events overlapping the day of t, in the location of t
+func (c *Calendar) On(t time.Time) []Event {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return c.Occurrences(day, day.AddDate(0, 0, 1))
+}
+
+func (r *recurrence) next(t time.Time) time.Time {
+	switch r.freq {
+	case "DAILY":
+		return t.AddDate(0, 0, r.interval)
+	case "WEEKLY":
+		return t.AddDate(0, 0, 7*r.interval)
+	case "MONTHLY":
+		return t.AddDate(0, r.interval, 0)
+	}
+	return t.AddDate(r.interval, 0, 0)
+}
+
+//unfold reads the lines of a calendar, joining folded lines
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+//splitLine splits a content line into its upper case name, parameters and value
+func splitLine(line string) (name string, params map[string]string, value string) {
+	colon := strings.IndexByte(line, ':')
+	if colon < 0 {
+		return strings.ToUpper(line), nil, ""
+	}
+	parts := strings.Split(line[:colon], ";")
+	params = make(map[string]string)
+	for _, p := range parts[1:] {
+		if eq := strings.IndexByte(p, '='); eq >= 0 {
+			params[strings.ToUpper(p[:eq])] = strings.Trim(p[eq+1:], `"`)
+		}
+	}
+	return strings.ToUpper(parts[0]), params, line[colon+1:]
+}
+
+func unescape(s string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
+
+//knownZone returns whether the time zone of a TZID parameter is known
+func knownZone(tzid string) bool {
+	_, err := time.LoadLocation(tzid)
+	return err == nil
+}
+
+//parseTime parses a DATE or DATE-TIME value, in UTC if it ends in Z, in the location of the
+//TZID parameter, or in local time
+func parseTime(value string, params map[string]string) (t time.Time, allDay bool, err error) {
+	loc := time.Local
+	if tzid, ok := params["TZID"]; ok {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	switch {
+	case params["VALUE"] == "DATE" || len(value) == 8:
+		t, err = time.ParseInLocation("20060102", value, time.Local)
+		allDay = true
+	case strings.HasSuffix(value, "Z"):
+		t, err = time.Parse("20060102T150405Z", value)
+	default:
+		t, err = time.ParseInLocation("20060102T150405", value, loc)
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid time %q", value)
+	}
+	return t, allDay, nil
+}
+
+//parseDuration parses a duration like PT1H30M, P2D or P1W
+func parseDuration(value string) (time.Duration, error) {
+	s := strings.TrimPrefix(value, "+")
+	if !strings.HasPrefix(s, "P") {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	var d time.Duration
+	inTime := false
+	number := ""
+	for _, c := range s[1:] {
+		switch {
+		case c >= '0' && c <= '9':
+			number += string(c)
+			continue
+		case c == 'T':
+			inTime = true
+			continue
+		}
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		number = ""
+		switch {
+		case c == 'W' && !inTime:
+			d += time.Duration(n) * 7 * 24 * time.Hour
+		case c == 'D' && !inTime:
+			d += time.Duration(n) * 24 * time.Hour
+		case c == 'H' && inTime:
+			d += time.Duration(n) * time.Hour
+		case c == 'M' && inTime:
+			d += time.Duration(n) * time.Minute
+		case c == 'S' && inTime:
+			d += time.Duration(n) * time.Second
+		default:
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+	}
+	if number != "" {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return d, nil
+}
+
+//parseRule parses the parts of a recurrence rule which are supported. Rules with BYDAY and
+//similar parts are rejected, rather than producing occurrences on the wrong days.
+func parseRule(value string) (*recurrence, error) {
+	r := &recurrence{interval: 1}
+	for _, part := range strings.Split(value, ";") {
+		eq := strings.IndexByte(part, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("invalid recurrence rule %q", value)
+		}
+		key, v := strings.ToUpper(part[:eq]), part[eq+1:]
+		var err error
+		switch key {
+		case "FREQ":
+			r.freq = strings.ToUpper(v)
+		case "INTERVAL":
+			r.interval, err = strconv.Atoi(v)
+			if err == nil && r.interval < 1 {
+				err = errors.New("interval must be positive")
+			}
+		case "COUNT":
+			r.count, err = strconv.Atoi(v)
+		case "UNTIL":
+			var date bool
+			r.until, date, err = parseTime(v, nil)
+			if date {
+				//a date includes the whole day
+				r.until = r.until.AddDate(0, 0, 1).Add(-time.Nanosecond)
+			}
+		case "WKST":
+		default:
+			return nil, fmt.Errorf("unsupported recurrence rule %q: %v is not supported", value, key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid recurrence rule %q: %v", value, err)
+		}
+	}
+	switch r.freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+	default:
+		return nil, fmt.Errorf("unsupported recurrence rule %q", value)
+	}
+	return r, nil
+}
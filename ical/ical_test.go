@@ -0,0 +1,244 @@
+package ical_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kvantetore/rothTouchline/ical"
+)
+
+//calendar wraps events in a calendar, with CRLF line endings like real feeds
+func calendar(events ...string) string {
+	lines := []string{"BEGIN:VCALENDAR", "VERSION:2.0"}
+	for _, e := range events {
+		lines = append(lines, "BEGIN:VEVENT", strings.TrimSpace(e), "END:VEVENT")
+	}
+	lines = append(lines, "END:VCALENDAR", "")
+	return strings.Replace(strings.Join(lines, "\n"), "\n", "\r\n", -1)
+}
+
+func mustParse(t *testing.T, data string) *ical.Calendar {
+	t.Helper()
+	cal, err := ical.Parse(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return cal
+}
+
+func TestParse(t *testing.T) {
+	oslo, err := time.LoadLocation("Europe/Oslo")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	cal := mustParse(t, calendar(
+		"UID:folded\nSUMMARY:Family\n  visit\\, north wing\nDTSTART:20240301T150000Z\nDTEND:20240303T110000Z",
+		"UID:zone\nSUMMARY:Zone\nDTSTART;TZID=Europe/Oslo:20240301T150000\nDURATION:PT1H30M",
+		"UID:day\nSUMMARY:Day\nDTSTART;VALUE=DATE:20240301",
+		"UID:unknown\nSUMMARY:Unknown zone\nDTSTART;TZID=Mars/Olympus:20240301T150000",
+		"UID:byday\nSUMMARY:By day\nDTSTART:20240301T150000Z\nRRULE:FREQ=WEEKLY;BYDAY=MO",
+	))
+
+	want := []ical.Event{
+		{UID: "folded", Summary: "Family visit, north wing", Start: time.Date(2024, 3, 1, 15, 0, 0, 0, time.UTC), End: time.Date(2024, 3, 3, 11, 0, 0, 0, time.UTC)},
+		{UID: "zone", Summary: "Zone", Start: time.Date(2024, 3, 1, 15, 0, 0, 0, oslo), End: time.Date(2024, 3, 1, 16, 30, 0, 0, oslo)},
+		{UID: "day", Summary: "Day", Start: time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local), End: time.Date(2024, 3, 2, 0, 0, 0, 0, time.Local), AllDay: true},
+		{UID: "unknown", Summary: "Unknown zone", Start: time.Date(2024, 3, 1, 15, 0, 0, 0, time.Local), End: time.Date(2024, 3, 1, 15, 0, 0, 0, time.Local)},
+		{UID: "byday", Summary: "By day", Start: time.Date(2024, 3, 1, 15, 0, 0, 0, time.UTC), End: time.Date(2024, 3, 1, 15, 0, 0, 0, time.UTC)},
+	}
+	if len(cal.Events) != len(want) {
+		t.Fatalf("got %v events, want %v", len(cal.Events), len(want))
+	}
+	for i, e := range cal.Events {
+		compareEvent(t, e, want[i])
+	}
+
+	//the unsupported rule leaves the event with its first occurrence
+	if got := cal.Occurrences(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)); len(got) != len(want) {
+		t.Errorf("got %v occurrences, want %v", len(got), len(want))
+	}
+	if len(cal.Warnings) != 2 || !strings.Contains(cal.Warnings[0], "Mars/Olympus") || !strings.Contains(cal.Warnings[1], "BYDAY") {
+		t.Errorf("got warnings %q, want the unknown zone and the unsupported rule", cal.Warnings)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "end without begin", data: "BEGIN:VCALENDAR\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+		{name: "no start", data: calendar("UID:a\nSUMMARY:No start")},
+		{name: "invalid start", data: calendar("UID:a\nDTSTART:2024-03-01")},
+		{name: "invalid duration", data: calendar("UID:a\nDTSTART:20240301T150000Z\nDURATION:PT1X")},
+		{name: "invalid exception", data: calendar("UID:a\nDTSTART:20240301T150000Z\nEXDATE:20240302T150000Z,tomorrow")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ical.Parse(strings.NewReader(test.data)); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+func TestOccurrences(t *testing.T) {
+	day := func(d, hour int) time.Time { return time.Date(2024, 3, d, hour, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name     string
+		events   []string
+		from, to time.Time
+		//want are the starts of the occurrences
+		want []time.Time
+	}{
+		{
+			name:   "single event overlapping the start",
+			events: []string{"UID:a\nDTSTART:20240301T150000Z\nDTEND:20240303T110000Z"},
+			from:   day(2, 0), to: day(10, 0),
+			want: []time.Time{day(1, 15)},
+		},
+		{
+			name:   "single event before",
+			events: []string{"UID:a\nDTSTART:20240301T150000Z\nDTEND:20240301T160000Z"},
+			from:   day(2, 0), to: day(10, 0),
+		},
+		{
+			name:   "daily with count",
+			events: []string{"UID:a\nDTSTART:20240301T080000Z\nDTEND:20240301T090000Z\nRRULE:FREQ=DAILY;COUNT=3"},
+			from:   day(1, 0), to: day(10, 0),
+			want: []time.Time{day(1, 8), day(2, 8), day(3, 8)},
+		},
+		{
+			name:   "weekly with interval until a date",
+			events: []string{"UID:a\nDTSTART:20240301T080000Z\nRRULE:FREQ=WEEKLY;INTERVAL=2;UNTIL=20240329"},
+			from:   day(1, 0), to: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+			want: []time.Time{day(1, 8), day(15, 8), day(29, 8)},
+		},
+		{
+			name:   "window within a recurrence",
+			events: []string{"UID:a\nDTSTART:20240301T080000Z\nRRULE:FREQ=DAILY"},
+			from:   day(5, 0), to: day(7, 0),
+			want: []time.Time{day(5, 8), day(6, 8)},
+		},
+		{
+			name:   "excluded occurrences",
+			events: []string{"UID:a\nDTSTART:20240301T080000Z\nRRULE:FREQ=DAILY;COUNT=4\nEXDATE:20240302T080000Z,20240304T080000Z"},
+			from:   day(1, 0), to: day(10, 0),
+			want: []time.Time{day(1, 8), day(3, 8)},
+		},
+		{
+			name: "moved occurrence",
+			events: []string{
+				"UID:a\nDTSTART:20240301T080000Z\nRRULE:FREQ=DAILY;COUNT=3",
+				"UID:a\nRECURRENCE-ID:20240302T080000Z\nDTSTART:20240302T120000Z",
+			},
+			from: day(1, 0), to: day(10, 0),
+			want: []time.Time{day(1, 8), day(2, 12), day(3, 8)},
+		},
+		{
+			name: "cancelled occurrence",
+			events: []string{
+				"UID:a\nDTSTART:20240301T080000Z\nRRULE:FREQ=DAILY;COUNT=3",
+				"UID:a\nRECURRENCE-ID:20240302T080000Z\nDTSTART:20240302T080000Z\nSTATUS:CANCELLED",
+			},
+			from: day(1, 0), to: day(10, 0),
+			want: []time.Time{day(1, 8), day(3, 8)},
+		},
+		{
+			name:   "cancelled event",
+			events: []string{"UID:a\nDTSTART:20240301T080000Z\nRRULE:FREQ=DAILY;COUNT=3\nSTATUS:CANCELLED"},
+			from:   day(1, 0), to: day(10, 0),
+		},
+		{
+			name: "ordered by start",
+			events: []string{
+				"UID:a\nDTSTART:20240303T080000Z",
+				"UID:b\nDTSTART:20240301T080000Z\nRRULE:FREQ=DAILY;COUNT=3",
+			},
+			from: day(1, 0), to: day(10, 0),
+			want: []time.Time{day(1, 8), day(2, 8), day(3, 8), day(3, 8)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cal := mustParse(t, calendar(test.events...))
+			var got []time.Time
+			for _, e := range cal.Occurrences(test.from, test.to) {
+				got = append(got, e.Start)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got occurrences %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if !got[i].Equal(test.want[i]) {
+					t.Errorf("got occurrences %v, want %v", got, test.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestOn(t *testing.T) {
+	cal := mustParse(t, calendar(
+		"UID:a\nSUMMARY:Holiday\nDTSTART;VALUE=DATE:20240301\nDTEND;VALUE=DATE:20240303",
+		"UID:b\nSUMMARY:Yearly\nDTSTART;VALUE=DATE:20231225\nRRULE:FREQ=YEARLY",
+	))
+	tests := []struct {
+		day  time.Time
+		want []string
+	}{
+		{day: time.Date(2024, 2, 29, 12, 0, 0, 0, time.Local)},
+		{day: time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local), want: []string{"Holiday"}},
+		{day: time.Date(2024, 3, 2, 23, 59, 0, 0, time.Local), want: []string{"Holiday"}},
+		{day: time.Date(2024, 3, 3, 0, 0, 0, 0, time.Local)},
+		{day: time.Date(2026, 12, 25, 8, 0, 0, 0, time.Local), want: []string{"Yearly"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, e := range cal.On(test.day) {
+			got = append(got, e.Summary)
+		}
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("got %q on %v, want %q", got, test.day, test.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bookings.ics" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(calendar("UID:a\nSUMMARY:Booking\nDTSTART;VALUE=DATE:20240301")))
+	}))
+	defer srv.Close()
+
+	cal, err := ical.Load(context.Background(), nil, srv.URL+"/bookings.ics")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(cal.Events) != 1 || cal.Events[0].Summary != "Booking" {
+		t.Errorf("got events %+v, want the booking", cal.Events)
+	}
+	if _, err := ical.Load(context.Background(), nil, srv.URL+"/missing.ics"); err == nil {
+		t.Error("got no error for a missing feed")
+	}
+	if _, err := ical.Load(context.Background(), nil, "/nonexistent/bookings.ics"); err == nil {
+		t.Error("got no error for a missing file")
+	}
+}
+
+func compareEvent(t *testing.T, got, want ical.Event) {
+	t.Helper()
+	if got.UID != want.UID || got.Summary != want.Summary || got.AllDay != want.AllDay || !got.Start.Equal(want.Start) || !got.End.Equal(want.End) {
+		t.Errorf("got event %v %q %v to %v (all day %v), want %v %q %v to %v (all day %v)",
+			got.UID, got.Summary, got.Start, got.End, got.AllDay, want.UID, want.Summary, want.Start, want.End, want.AllDay)
+	}
+}
@@ -0,0 +1,121 @@
+package roth
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/kvantetore/rothTouchline/protocol"
+)
+
+//maxSensorIndex bounds the indices probed for sensors beyond a gap. Controllers number far
+//fewer thermostats, so it is only reached if the sensor count is wrong.
+const maxSensorIndex = 64
+
+//sensorIndices holds the indices of the sensors found by the last full read, and the sensor
+//count they were read for. After thermostats are removed, the controller keeps the indices of
+//the others, so they are not always 0 to the sensor count. If fewer sensors were found than
+//counted, the indices up to maxSensorIndex have been probed without finding the others, and
+//are not probed again until the count changes or a known sensor disappears.
+type sensorIndices struct {
+	mu    sync.Mutex
+	count int
+	ids   []int
+}
+
+//sensorIDs returns the indices of the given number of sensors, as found by the last full read,
+//or 0 to sensorCount-1 if there was none or the count changed since
+func (c *Client) sensorIDs(sensorCount int) []int {
+	ids, _ := c.lookupSensorIDs(sensorCount)
+	return ids
+}
+
+//lookupSensorIDs is sensorIDs, also returning whether the indices are those found by the last
+//full read
+func (c *Client) lookupSensorIDs(sensorCount int) (ids []int, known bool) {
+	c.indices.mu.Lock()
+	defer c.indices.mu.Unlock()
+	if c.indices.ids != nil && c.indices.count == sensorCount {
+		ids = make([]int, len(c.indices.ids))
+		copy(ids, c.indices.ids)
+		return ids, true
+	}
+	ids = make([]int, sensorCount)
+	for i := range ids {
+		ids[i] = i
+	}
+	return ids, false
+}
+
+//readAllSensors reads the given number of sensors. If indices without a thermostat leave it
+//short of sensorCount, the indices above them up to maxSensorIndex are probed in one read, and
+//the sensors found there used instead.
+func (c *Client) readAllSensors(ctx context.Context, sensorCount int) (sensors []Sensor, warnings []ParseWarning, err error) {
+	ids, known := c.lookupSensorIDs(sensorCount)
+	sensors, warnings, err = c.readSensorDatapoints(ctx, ids, c.datapoints())
+	if err != nil {
+		return []Sensor{}, warnings, err
+	}
+
+	//probe unless the last read already did, and found the same sensors
+	if len(sensors) < sensorCount && (!known || len(sensors) < len(ids)) {
+		next := 0
+		if len(ids) > 0 {
+			next = ids[len(ids)-1] + 1
+		}
+		var probe []int
+		for ; next < maxSensorIndex; next++ {
+			probe = append(probe, next)
+		}
+		found, probeWarnings, err := c.probeSensors(ctx, probe)
+		if err != nil {
+			return []Sensor{}, warnings, err
+		}
+		if missing := sensorCount - len(sensors); len(found) > missing {
+			found = found[:missing]
+		}
+		sensors = append(sensors, found...)
+		warnings = append(warnings, probeWarnings...)
+	}
+
+	if c.NameFallback {
+		sensors = c.applyNameFallback(ctx, sensors, sensorCount)
+	}
+	if len(sensors) < sensorCount {
+		c.logf(LogWarning, "found %v of %v sensors", len(sensors), sensorCount)
+	}
+	found := make([]int, len(sensors))
+	for i, s := range sensors {
+		found[i] = s.Id
+	}
+	c.indices.mu.Lock()
+	c.indices.count, c.indices.ids = sensorCount, found
+	c.indices.mu.Unlock()
+	return sensors, warnings, nil
+}
+
+//probeSensors reads the sensors at the given indices, and returns those with any value. Indices
+//the controller left out of the response are no thermostats, rather than incomplete ones, so
+//their warnings are dropped.
+func (c *Client) probeSensors(ctx context.Context, ids []int) (sensors []Sensor, warnings []ParseWarning, err error) {
+	if len(ids) == 0 {
+		return nil, nil, nil
+	}
+	read, readWarnings, err := c.readSensorDatapoints(ctx, ids, c.datapoints())
+	if err != nil {
+		return nil, nil, err
+	}
+	kept := make(map[string]bool)
+	for _, s := range read {
+		if s.Valid != 0 {
+			sensors = append(sensors, s)
+			kept[strconv.Itoa(s.Id)] = true
+		}
+	}
+	for _, w := range readWarnings {
+		if id, _, ok := protocol.SplitItemName(w.Item); ok && kept[id] {
+			warnings = append(warnings, w)
+		}
+	}
+	return sensors, warnings, nil
+}
@@ -0,0 +1,127 @@
+package roth_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/rothtest"
+)
+
+//countingServer serves the controller, counting the requests reaching it
+func countingServer(c *rothtest.Controller, requests *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(requests, 1)
+		c.ServeHTTP(w, r)
+	}))
+}
+
+//removeSensor empties the datapoints of a sensor, like the controller does for a removed
+//thermostat
+func removeSensor(c *rothtest.Controller, id int) {
+	for _, d := range []string{"name", "RaumTemp", "SollTemp", "WeekProg", "OPMode", "TempSIUnit"} {
+		c.SetValue(fmt.Sprintf("G%v.%v", id, d), "")
+	}
+}
+
+func TestSensorIndices(t *testing.T) {
+	tests := []struct {
+		name    string
+		sensors []int
+		//count is the sensor count passed to GetSensors
+		count   int
+		wantIDs []int
+		//change is applied between the first and the second read
+		change func(c *rothtest.Controller)
+		//wantProbe is set if the second read probes the indices above the known sensors, in
+		//more than the one request of a read of the known sensors
+		wantProbe    bool
+		wantIDsAfter []int
+	}{
+		{
+			name:         "no gaps",
+			sensors:      []int{0, 1, 2},
+			count:        3,
+			wantIDs:      []int{0, 1, 2},
+			wantIDsAfter: []int{0, 1, 2},
+		},
+		{
+			name:         "sensor beyond a gap",
+			sensors:      []int{0, 2, 5},
+			count:        3,
+			wantIDs:      []int{0, 2, 5},
+			wantIDsAfter: []int{0, 2, 5},
+		},
+		{
+			//the probe finding nothing is remembered, rather than repeated on every read
+			name:         "count above the thermostats",
+			sensors:      []int{0, 1},
+			count:        3,
+			wantIDs:      []int{0, 1},
+			wantIDsAfter: []int{0, 1},
+		},
+		{
+			name:         "known sensor removed",
+			sensors:      []int{0, 1, 2},
+			count:        3,
+			wantIDs:      []int{0, 1, 2},
+			change:       func(c *rothtest.Controller) { removeSensor(c, 1) },
+			wantProbe:    true,
+			wantIDsAfter: []int{0, 2},
+		},
+		{
+			name:    "sensor paired beyond the known ones",
+			sensors: []int{0, 1},
+			count:   3,
+			wantIDs: []int{0, 1},
+			change: func(c *rothtest.Controller) {
+				c.SetSensor(roth.Sensor{Id: 4, Name: "Hall", TargetTemperature: 20})
+			},
+			//the count is unchanged, so the earlier probe is trusted until a sensor goes missing
+			wantIDsAfter: []int{0, 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var sensors []roth.Sensor
+			for _, id := range test.sensors {
+				sensors = append(sensors, roth.Sensor{Id: id, Name: fmt.Sprint("Room ", id), TargetTemperature: 21})
+			}
+			controller := rothtest.NewController(sensors...)
+			var requests int64
+			srv := countingServer(controller, &requests)
+			defer srv.Close()
+			c := roth.NewClient(srv.URL, roth.WithLogger(roth.DiscardLogger))
+
+			read := func() []int {
+				sensors, err := c.GetSensors(context.Background(), test.count)
+				if err != nil {
+					t.Fatalf("GetSensors: %v", err)
+				}
+				var ids []int
+				for _, s := range sensors {
+					ids = append(ids, s.Id)
+				}
+				return ids
+			}
+			if ids := read(); !equalInts(ids, test.wantIDs) {
+				t.Errorf("got sensors %v, want %v", ids, test.wantIDs)
+			}
+			if test.change != nil {
+				test.change(controller)
+			}
+			before := atomic.LoadInt64(&requests)
+			if ids := read(); !equalInts(ids, test.wantIDsAfter) {
+				t.Errorf("got sensors %v after the change, want %v", ids, test.wantIDsAfter)
+			}
+			if probed := atomic.LoadInt64(&requests)-before > 1; probed != test.wantProbe {
+				t.Errorf("got probe %v, want %v", probed, test.wantProbe)
+			}
+		})
+	}
+}
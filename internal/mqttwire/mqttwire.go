@@ -0,0 +1,181 @@
+//Package mqttwire encodes and decodes the packets of the subset of MQTT 3.1.1 used by the mqtt
+//bridge and the alert notifier, which publish and subscribe with QoS 0
+package mqttwire
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+//Packet types, as the high nibble of the fixed header
+const (
+	PacketConnect    = 0x10
+	PacketConnAck    = 0x20
+	PacketPublish    = 0x30
+	PacketSubscribe  = 0x82 //with the reserved flags required for SUBSCRIBE
+	PacketSubAck     = 0x90
+	PacketPingReq    = 0xc0
+	PacketPingResp   = 0xd0
+	PacketDisconnect = 0xe0
+)
+
+//MaxPacketLength is the largest remaining length encodable in a fixed header
+const MaxPacketLength = 268435455
+
+//ConnectOptions are the fields of a CONNECT packet
+type ConnectOptions struct {
+	ClientID           string
+	Username, Password string
+	KeepAlive          int //in seconds
+	WillTopic          string
+	WillMessage        string
+}
+
+//ConnectPacket returns the body of a CONNECT packet with a clean session, and a retained will
+//if WillTopic is set
+func ConnectPacket(o ConnectOptions) []byte {
+	var b bytes.Buffer
+	WriteString(&b, "MQTT")
+	b.WriteByte(4) //protocol level 3.1.1
+	flags := byte(0x02)
+	if o.WillTopic != "" {
+		flags |= 0x04 | 0x20 //will, retained with QoS 0
+	}
+	if o.Username != "" {
+		flags |= 0x80
+	}
+	if o.Password != "" {
+		flags |= 0x40
+	}
+	b.WriteByte(flags)
+	b.WriteByte(byte(o.KeepAlive >> 8))
+	b.WriteByte(byte(o.KeepAlive))
+	WriteString(&b, o.ClientID)
+	if o.WillTopic != "" {
+		WriteString(&b, o.WillTopic)
+		WriteString(&b, o.WillMessage)
+	}
+	if o.Username != "" {
+		WriteString(&b, o.Username)
+	}
+	if o.Password != "" {
+		WriteString(&b, o.Password)
+	}
+	return b.Bytes()
+}
+
+//CheckConnAck returns an error if the broker refused the connection
+func CheckConnAck(header byte, body []byte) error {
+	if header&0xf0 != PacketConnAck || len(body) != 2 {
+		return errors.New("mqtt broker did not acknowledge the connection")
+	}
+	if body[1] != 0 {
+		return fmt.Errorf("mqtt connection refused (code %v)", body[1])
+	}
+	return nil
+}
+
+//PublishPacket returns the header and body of a PUBLISH packet with QoS 0
+func PublishPacket(topic string, payload []byte, retain bool) (byte, []byte) {
+	var b bytes.Buffer
+	WriteString(&b, topic)
+	b.Write(payload)
+	header := byte(PacketPublish)
+	if retain {
+		header |= 0x01
+	}
+	return header, b.Bytes()
+}
+
+//ParsePublish returns the topic and payload of a PUBLISH packet
+func ParsePublish(header byte, body []byte) (string, []byte, error) {
+	if len(body) < 2 {
+		return "", nil, errors.New("mqtt publish packet too short")
+	}
+	length := int(body[0])<<8 | int(body[1])
+	rest := body[2:]
+	if len(rest) < length {
+		return "", nil, errors.New("mqtt publish packet too short")
+	}
+	topic, rest := string(rest[:length]), rest[length:]
+	//QoS 1 and 2 carry a packet identifier
+	if header&0x06 != 0 {
+		if len(rest) < 2 {
+			return "", nil, errors.New("mqtt publish packet too short")
+		}
+		rest = rest[2:]
+	}
+	return topic, rest, nil
+}
+
+//SubscribePacket returns the body of a SUBSCRIBE packet for topic filters with QoS 0
+func SubscribePacket(id uint16, filters ...string) []byte {
+	var b bytes.Buffer
+	b.WriteByte(byte(id >> 8))
+	b.WriteByte(byte(id))
+	for _, f := range filters {
+		WriteString(&b, f)
+		b.WriteByte(0)
+	}
+	return b.Bytes()
+}
+
+//WriteString writes a string prefixed by its length
+func WriteString(b *bytes.Buffer, s string) {
+	b.WriteByte(byte(len(s) >> 8))
+	b.WriteByte(byte(len(s)))
+	b.WriteString(s)
+}
+
+//WritePacket writes a packet with the given fixed header byte
+func WritePacket(w io.Writer, header byte, body []byte) error {
+	if len(body) > MaxPacketLength {
+		return errors.New("mqtt packet too large")
+	}
+	packet := []byte{header}
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if length == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(packet, body...))
+	return err
+}
+
+//ReadPacket reads a packet, returning its fixed header byte and its body
+func ReadPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, nil, errors.New("malformed mqtt packet length")
+		}
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
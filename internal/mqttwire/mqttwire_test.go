@@ -0,0 +1,119 @@
+package mqttwire
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPacketLength(t *testing.T) {
+	tests := []struct {
+		length int
+		//want is the encoded remaining length
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x01}},
+		{16383, []byte{0xff, 0x7f}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+		{2097152, []byte{0x80, 0x80, 0x80, 0x01}},
+	}
+	for _, test := range tests {
+		body := bytes.Repeat([]byte{'x'}, test.length)
+		var buf bytes.Buffer
+		if err := WritePacket(&buf, PacketPublish, body); err != nil {
+			t.Fatalf("WritePacket of %v bytes: %v", test.length, err)
+		}
+		if got := buf.Bytes()[1 : 1+len(test.want)]; !bytes.Equal(got, test.want) {
+			t.Errorf("length %v encoded as % x, want % x", test.length, got, test.want)
+		}
+
+		header, got, err := ReadPacket(bufio.NewReader(&buf))
+		if err != nil || header != PacketPublish || !bytes.Equal(got, body) {
+			t.Errorf("length %v read back as %#x with %v bytes, %v", test.length, header, len(got), err)
+		}
+	}
+}
+
+func TestReadPacketMalformed(t *testing.T) {
+	for name, packet := range map[string][]byte{
+		"length of 5 bytes": {PacketPublish, 0xff, 0xff, 0xff, 0xff, 0x01},
+		"truncated length":  {PacketPublish, 0x80},
+		"truncated body":    {PacketPublish, 0x03, 0x00, 0x01},
+		"empty":             {},
+	} {
+		if header, body, err := ReadPacket(bufio.NewReader(bytes.NewReader(packet))); err == nil {
+			t.Errorf("%v: read %#x % x", name, header, body)
+		}
+	}
+}
+
+func TestConnectPacket(t *testing.T) {
+	got := ConnectPacket(ConnectOptions{ClientID: "c", Username: "u", Password: "p", KeepAlive: 30, WillTopic: "s", WillMessage: "off"})
+	want := []byte{
+		0x00, 0x04, 'M', 'Q', 'T', 'T', 0x04,
+		0x02 | 0x04 | 0x20 | 0x80 | 0x40, //clean session, retained will, username and password
+		0x00, 30,
+		0x00, 0x01, 'c',
+		0x00, 0x01, 's', 0x00, 0x03, 'o', 'f', 'f',
+		0x00, 0x01, 'u',
+		0x00, 0x01, 'p',
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got % x, want % x", got, want)
+	}
+
+	got = ConnectPacket(ConnectOptions{ClientID: "c"})
+	want = []byte{0x00, 0x04, 'M', 'Q', 'T', 'T', 0x04, 0x02, 0x00, 0x00, 0x00, 0x01, 'c'}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got % x, want % x", got, want)
+	}
+}
+
+func TestCheckConnAck(t *testing.T) {
+	if err := CheckConnAck(PacketConnAck, []byte{0, 0}); err != nil {
+		t.Errorf("accepted connection: %v", err)
+	}
+	if err := CheckConnAck(PacketConnAck, []byte{0, 5}); err == nil || !strings.Contains(err.Error(), "5") {
+		t.Errorf("refused connection returned %v, want the code", err)
+	}
+	if err := CheckConnAck(PacketPublish, []byte{0, 0}); err == nil {
+		t.Error("publish accepted as acknowledgement")
+	}
+}
+
+func TestPublish(t *testing.T) {
+	header, body := PublishPacket("a/b", []byte("21.5"), true)
+	if header != PacketPublish|0x01 {
+		t.Errorf("got header %#x, want retained publish", header)
+	}
+	topic, payload, err := ParsePublish(header, body)
+	if err != nil || topic != "a/b" || string(payload) != "21.5" {
+		t.Errorf("parsed %q %q, %v", topic, payload, err)
+	}
+
+	//QoS 1 carries a packet identifier before the payload
+	topic, payload, err = ParsePublish(PacketPublish|0x02, []byte{0x00, 0x01, 'x', 0x12, 0x34, '2', '2'})
+	if err != nil || topic != "x" || string(payload) != "22" {
+		t.Errorf("parsed %q %q, %v", topic, payload, err)
+	}
+
+	for _, body := range [][]byte{{0x00}, {0x00, 0x05, 'x'}} {
+		if _, _, err := ParsePublish(PacketPublish, body); err == nil {
+			t.Errorf("parsed short packet % x", body)
+		}
+	}
+	if _, _, err := ParsePublish(PacketPublish|0x02, []byte{0x00, 0x01, 'x', 0x12}); err == nil {
+		t.Error("parsed QoS 1 packet without identifier")
+	}
+}
+
+func TestSubscribePacket(t *testing.T) {
+	got := SubscribePacket(0x0102, "a", "b/c")
+	want := []byte{0x01, 0x02, 0x00, 0x01, 'a', 0x00, 0x00, 0x03, 'b', '/', 'c', 0x00}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got % x, want % x", got, want)
+	}
+}
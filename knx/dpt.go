@@ -0,0 +1,120 @@
+package knx
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+//GroupAddress is a KNX group address, formatted in three levels as main/middle/sub
+type GroupAddress uint16
+
+//ParseGroupAddress parses a three level (1/2/3) or two level (1/515) group address
+func ParseGroupAddress(s string) (GroupAddress, error) {
+	parts := strings.Split(s, "/")
+	limits := map[int][]int{3: {31, 7, 255}, 2: {31, 2047}}[len(parts)]
+	if limits == nil {
+		return 0, fmt.Errorf("invalid group address %q", s)
+	}
+	var address int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || n > limits[i] {
+			return 0, fmt.Errorf("invalid group address %q", s)
+		}
+		address = address*(limits[i]+1) + n
+	}
+	return GroupAddress(address), nil
+}
+
+//String formats the address in three levels
+func (a GroupAddress) String() string {
+	return fmt.Sprintf("%d/%d/%d", a>>11, a>>8&0x07, a&0xff)
+}
+
+//MarshalText formats the address in three levels
+func (a GroupAddress) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+//UnmarshalText parses an address formatted by ParseGroupAddress
+func (a *GroupAddress) UnmarshalText(text []byte) error {
+	address, err := ParseGroupAddress(string(text))
+	if err != nil {
+		return err
+	}
+	*a = address
+	return nil
+}
+
+//EncodeTemperature encodes a temperature in °C as DPT 9.001, a 2 byte float
+func EncodeTemperature(t float32) []byte {
+	exponent := 0
+	for v := float64(t) * 100; v < -2048 || v > 2047; v /= 2 {
+		exponent++
+	}
+	mantissa := int(math.Round(float64(t) * 100 / float64(int(1)<<uint(exponent))))
+	//rounding may still overflow the mantissa
+	if mantissa > 2047 || mantissa < -2048 {
+		mantissa, exponent = mantissa/2, exponent+1
+	}
+	raw := uint16(mantissa) & 0x07ff
+	if mantissa < 0 {
+		raw |= 0x8000
+	}
+	raw |= uint16(exponent) << 11
+	return []byte{byte(raw >> 8), byte(raw)}
+}
+
+//DecodeTemperature decodes a DPT 9.001 temperature
+func DecodeTemperature(data []byte) (float32, error) {
+	if len(data) != 2 {
+		return 0, fmt.Errorf("invalid 2 byte float of %d bytes", len(data))
+	}
+	mantissa := int(data[0]&0x07)<<8 | int(data[1])
+	if data[0]&0x80 != 0 {
+		mantissa -= 2048
+	}
+	exponent := uint(data[0] >> 3 & 0x0f)
+	return float32(0.01 * float64(mantissa<<exponent)), nil
+}
+
+//HVAC modes of DPT 20.102
+const (
+	hvacAuto       = 0
+	hvacComfort    = 1
+	hvacStandby    = 2
+	hvacEconomy    = 3
+	hvacProtection = 4
+)
+
+//EncodeMode encodes a mode as a DPT 20.102 HVAC mode: day as comfort, night as economy and
+//holiday as building protection
+func EncodeMode(m roth.Mode) []byte {
+	switch m {
+	case roth.ModeNight:
+		return []byte{hvacEconomy}
+	case roth.ModeHoliday:
+		return []byte{hvacProtection}
+	}
+	return []byte{hvacComfort}
+}
+
+//DecodeMode decodes a DPT 20.102 HVAC mode. Auto selects day, and standby selects night.
+func DecodeMode(data []byte) (roth.Mode, error) {
+	if len(data) != 1 {
+		return 0, fmt.Errorf("invalid hvac mode of %d bytes", len(data))
+	}
+	switch data[0] {
+	case hvacAuto, hvacComfort:
+		return roth.ModeDay, nil
+	case hvacStandby, hvacEconomy:
+		return roth.ModeNight, nil
+	case hvacProtection:
+		return roth.ModeHoliday, nil
+	}
+	return 0, fmt.Errorf("invalid hvac mode %d", data[0])
+}
@@ -0,0 +1,118 @@
+package knx
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+func TestParseGroupAddress(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    GroupAddress
+		wantErr bool
+	}{
+		{in: "1/2/3", want: 1<<11 | 2<<8 | 3},
+		{in: "31/7/255", want: 0xffff},
+		{in: "1/515", want: 1<<11 | 515},
+		{in: "0/0/1", want: 1},
+		{in: "32/0/0", wantErr: true},
+		{in: "1/8/0", wantErr: true},
+		{in: "1/2048", wantErr: true},
+		{in: "1/2/3/4", wantErr: true},
+		{in: "1", wantErr: true},
+		{in: "a/b/c", wantErr: true},
+		{in: "1/-1/3", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ParseGroupAddress(test.in)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseGroupAddress(%q) = %v, want an error", test.in, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("ParseGroupAddress(%q) = %v, %v, want %v", test.in, got, err, test.want)
+		}
+	}
+
+	//addresses round trip through their three level form
+	for _, in := range []string{"1/2/3", "31/7/255", "0/0/1"} {
+		address, _ := ParseGroupAddress(in)
+		text, _ := address.MarshalText()
+		var parsed GroupAddress
+		if err := parsed.UnmarshalText(text); err != nil || parsed != address || string(text) != in {
+			t.Errorf("%v formatted as %q parsed to %v, %v", in, text, parsed, err)
+		}
+	}
+}
+
+func TestTemperatureCodec(t *testing.T) {
+	tests := []struct {
+		t    float32
+		want []byte
+	}{
+		{0, []byte{0x00, 0x00}},
+		{20.47, []byte{0x07, 0xff}},
+		{20.48, []byte{0x0c, 0x00}},
+		{21, []byte{0x0c, 0x1a}},
+		{-2.5, []byte{0x87, 0x06}},
+		{-20.48, []byte{0x80, 0x00}},
+		{-30, []byte{0x8a, 0x24}},
+		{655.36, []byte{0x34, 0x00}},
+	}
+	for _, test := range tests {
+		got := EncodeTemperature(test.t)
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("EncodeTemperature(%v) = % x, want % x", test.t, got, test.want)
+		}
+		decoded, err := DecodeTemperature(test.want)
+		if err != nil || decoded != test.t {
+			t.Errorf("DecodeTemperature(% x) = %v, %v, want %v", test.want, decoded, err, test.t)
+		}
+	}
+
+	//the range of room temperatures round trips within half a step of the largest exponent used,
+	//0.08 degrees from ±81.92
+	for c := -5000; c <= 10000; c++ {
+		temp := float32(c) / 100
+		decoded, err := DecodeTemperature(EncodeTemperature(temp))
+		if err != nil || math.Abs(float64(decoded-temp)) > 0.0401 {
+			t.Fatalf("%v decoded as %v, %v", temp, decoded, err)
+		}
+	}
+
+	if _, err := DecodeTemperature([]byte{0x0c}); err == nil {
+		t.Error("DecodeTemperature of 1 byte succeeded")
+	}
+}
+
+func TestModeCodec(t *testing.T) {
+	for _, mode := range []roth.Mode{roth.ModeDay, roth.ModeNight, roth.ModeHoliday} {
+		decoded, err := DecodeMode(EncodeMode(mode))
+		if err != nil || decoded != mode {
+			t.Errorf("mode %v decoded as %v, %v", mode, decoded, err)
+		}
+	}
+
+	tests := []struct {
+		data    []byte
+		want    roth.Mode
+		wantErr bool
+	}{
+		{data: []byte{hvacAuto}, want: roth.ModeDay},
+		{data: []byte{hvacStandby}, want: roth.ModeNight},
+		{data: []byte{5}, wantErr: true},
+		{data: []byte{}, wantErr: true},
+		{data: []byte{1, 1}, wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := DecodeMode(test.data)
+		if (err != nil) != test.wantErr || (!test.wantErr && got != test.want) {
+			t.Errorf("DecodeMode(% x) = %v, %v, want %v", test.data, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+//Package knx bridges sensors to a KNX installation through a KNXnet/IP tunnelling gateway, such
+//as a KNX IP interface or knxd. Room temperatures, setpoints and modes are sent to configurable
+//group addresses as they change, and setpoints and modes written on the bus are applied to the
+//controller.
+package knx
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+//Mapping assigns group addresses to the values of a sensor. A zero address is not used; note
+//that 0/0/0 is not a valid group address. Temperatures are DPT 9.001, which is always in °C
+//whatever the unit of the client, modes DPT 20.102.
+type Mapping struct {
+	SensorID int `json:"sensor"`
+	//RoomTemperature receives the room temperature
+	RoomTemperature GroupAddress `json:"roomTemperature,omitempty"`
+	//TargetTemperature receives the target temperature. Writes to it change the target
+	//temperature, unless SetTargetTemperature is set.
+	TargetTemperature GroupAddress `json:"targetTemperature,omitempty"`
+	//SetTargetTemperature is a separate address for changing the target temperature
+	SetTargetTemperature GroupAddress `json:"setTargetTemperature,omitempty"`
+	//Mode receives the mode. Writes to it change the mode, unless SetMode is set.
+	Mode GroupAddress `json:"mode,omitempty"`
+	//SetMode is a separate address for changing the mode
+	SetMode GroupAddress `json:"setMode,omitempty"`
+}
+
+//command is a value written on the bus, to be applied to the controller
+type command struct {
+	sensorID int
+	apply    func(ctx context.Context, c *roth.Client) error
+}
+
+//Bridge connects sensors and group addresses
+type Bridge struct {
+	client   *roth.Client
+	mappings []Mapping
+
+	//ReconnectDelay is the delay before reconnecting a lost tunnel, 10 seconds if zero
+	ReconnectDelay time.Duration
+	//OnError is called when the tunnel fails, or a value could not be sent or applied
+	OnError func(err error)
+
+	mu      sync.Mutex
+	tunnel  *Tunnel
+	sensors map[int]roth.Sensor
+
+	telegrams chan Telegram
+}
+
+//NewBridge creates a bridge for the sensors of the mappings
+func NewBridge(client *roth.Client, mappings ...Mapping) *Bridge {
+	return &Bridge{
+		client:    client,
+		mappings:  mappings,
+		sensors:   make(map[int]roth.Sensor),
+		telegrams: make(chan Telegram, 100),
+	}
+}
+
+//Attach sends the values of every successful poll of the watcher which changed, and keeps them
+//to answer reads from the bus
+func (b *Bridge) Attach(w *roth.Watcher) {
+	w.Subscribe(func(p roth.Poll) {
+		if p.Err != nil {
+			return
+		}
+		b.mu.Lock()
+		var changed []roth.Sensor
+		for _, s := range p.Sensors {
+			previous, seen := b.sensors[s.Id]
+			if !seen || changedValues(previous, s) {
+				changed = append(changed, s)
+			}
+			b.sensors[s.Id] = s
+		}
+		b.mu.Unlock()
+
+		for _, s := range changed {
+			b.Publish(s)
+		}
+	})
+}
+
+func changedValues(a, b roth.Sensor) bool {
+	return a.RoomTemperature != b.RoomTemperature || a.TargetTemperature != b.TargetTemperature || a.Mode != b.Mode || a.Valid != b.Valid
+}
+
+//Publish sends the values of a sensor to its group addresses. Nothing is sent while the
+//tunnel is not connected.
+func (b *Bridge) Publish(s roth.Sensor) {
+	b.mu.Lock()
+	tunnel := b.tunnel
+	b.mu.Unlock()
+	if tunnel == nil {
+		return
+	}
+	for _, m := range b.mappings {
+		if m.SensorID != s.Id {
+			continue
+		}
+		for address, data := range values(m, s) {
+			if err := tunnel.Write(address, data); err != nil {
+				b.report(fmt.Errorf("sending %v failed: %v", address, err))
+			}
+		}
+	}
+}
+
+//values returns the encoded values of a sensor, by group address
+func values(m Mapping, s roth.Sensor) map[GroupAddress][]byte {
+	v := make(map[GroupAddress][]byte)
+	if m.RoomTemperature != 0 && s.Valid.Has(roth.FieldRoomTemperature) {
+		v[m.RoomTemperature] = EncodeTemperature(s.Room().Celsius())
+	}
+	if m.TargetTemperature != 0 && s.Valid.Has(roth.FieldTargetTemperature) {
+		v[m.TargetTemperature] = EncodeTemperature(s.Target().Celsius())
+	}
+	if m.Mode != 0 && s.Valid.Has(roth.FieldMode) {
+		v[m.Mode] = EncodeMode(s.Mode)
+	}
+	return v
+}
+
+func (b *Bridge) report(err error) {
+	if b.OnError != nil {
+		b.OnError(err)
+	}
+}
+
+//Run connects to the gateway, and handles telegrams from the bus until the context is
+//cancelled. Lost connections are reestablished.
+func (b *Bridge) Run(ctx context.Context, gateway string) {
+	delay := b.ReconnectDelay
+	if delay == 0 {
+		delay = 10 * time.Second
+	}
+
+	go b.handleTelegrams(ctx)
+	for {
+		tunnel, err := DialTunnel(ctx, gateway, func(t Telegram) {
+			select {
+			case b.telegrams <- t:
+			default:
+				b.report(fmt.Errorf("telegram queue full, telegram to %v dropped", t.Destination))
+			}
+		})
+		if err == nil {
+			b.mu.Lock()
+			b.tunnel = tunnel
+			sensors := make([]roth.Sensor, 0, len(b.sensors))
+			for _, s := range b.sensors {
+				sensors = append(sensors, s)
+			}
+			b.mu.Unlock()
+			//bring the bus up to date after connecting
+			for _, s := range sensors {
+				b.Publish(s)
+			}
+
+			select {
+			case <-ctx.Done():
+				tunnel.Close()
+			case <-tunnel.Done():
+			}
+			b.mu.Lock()
+			b.tunnel = nil
+			b.mu.Unlock()
+			err = tunnel.Err()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		b.report(err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+//handleTelegrams answers reads and applies writes, separately from the receiving goroutine
+//of the tunnel
+func (b *Bridge) handleTelegrams(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-b.telegrams:
+			if t.IsRead() {
+				b.respond(t.Destination)
+			} else if t.IsWrite() {
+				b.write(ctx, t)
+			}
+		}
+	}
+}
+
+//respond answers a read of a group address with the latest value
+func (b *Bridge) respond(address GroupAddress) {
+	b.mu.Lock()
+	tunnel := b.tunnel
+	var data []byte
+	for _, m := range b.mappings {
+		if s, ok := b.sensors[m.SensorID]; ok && data == nil {
+			data = values(m, s)[address]
+		}
+	}
+	b.mu.Unlock()
+
+	if tunnel != nil && data != nil {
+		if err := tunnel.Respond(address, data); err != nil {
+			b.report(fmt.Errorf("responding to %v failed: %v", address, err))
+		}
+	}
+}
+
+//write applies a write from the bus to the controller
+func (b *Bridge) write(ctx context.Context, t Telegram) {
+	for _, m := range b.mappings {
+		var err error
+		switch t.Destination {
+		case m.SetTargetTemperature, commandAddress(m.TargetTemperature, m.SetTargetTemperature):
+			if t.Destination == 0 {
+				continue
+			}
+			var target float32
+			if target, err = DecodeTemperature(t.Data); err == nil {
+				err = b.client.SetTargetTemperature(ctx, m.SensorID, roth.ConvertTemperature(target, roth.Celsius, b.client.Unit))
+			}
+		case m.SetMode, commandAddress(m.Mode, m.SetMode):
+			if t.Destination == 0 {
+				continue
+			}
+			var mode roth.Mode
+			if mode, err = DecodeMode(t.Data); err == nil {
+				err = b.client.SetMode(ctx, m.SensorID, mode)
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			b.report(fmt.Errorf("applying write to %v failed: %v", t.Destination, err))
+		}
+	}
+}
+
+//commandAddress returns the status address if it also accepts writes, i.e. if there is no
+//separate command address
+func commandAddress(status, command GroupAddress) GroupAddress {
+	if command != 0 {
+		return 0
+	}
+	return status
+}
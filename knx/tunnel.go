@@ -0,0 +1,353 @@
+package knx
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+//KNXnet/IP service types
+const (
+	connectRequest          = 0x0205
+	connectResponse         = 0x0206
+	connectionStateRequest  = 0x0207
+	connectionStateResponse = 0x0208
+	disconnectRequest       = 0x0209
+	disconnectResponse      = 0x020a
+	tunnellingRequest       = 0x0420
+	tunnellingAck           = 0x0421
+)
+
+//cEMI message codes
+const (
+	cemiDataRequest    = 0x11
+	cemiDataIndication = 0x29
+)
+
+//application layer services of group telegrams
+const (
+	groupValueRead     uint16 = 0x000
+	groupValueResponse uint16 = 0x040
+	groupValueWrite    uint16 = 0x080
+)
+
+const (
+	defaultPort = "3671"
+	ackTimeout  = time.Second
+	//heartbeatInterval is the interval of connection state requests; gateways drop
+	//connections idle for two minutes
+	heartbeatInterval = 60 * time.Second
+)
+
+//ErrClosed is returned when sending through a closed tunnel
+var ErrClosed = errors.New("knx tunnel closed")
+
+//Telegram is a group telegram received from the bus
+type Telegram struct {
+	Source      uint16
+	Destination GroupAddress
+	//APCI is the application layer service: read, response or write
+	APCI uint16
+	Data []byte
+}
+
+//IsRead reports whether the telegram is a GroupValueRead
+func (t Telegram) IsRead() bool { return t.APCI == groupValueRead }
+
+//IsWrite reports whether the telegram is a GroupValueWrite
+func (t Telegram) IsWrite() bool { return t.APCI == groupValueWrite }
+
+//Tunnel is a KNXnet/IP tunnelling connection to a KNX IP interface or knxd. The connection uses
+//NAT mode, so it works across routers.
+type Tunnel struct {
+	conn    *net.UDPConn
+	channel byte
+
+	//Address is the individual address assigned to the tunnel by the gateway
+	Address uint16
+
+	sendMu  sync.Mutex
+	seq     byte
+	acks    chan byte
+	done    chan struct{}
+	closeMu sync.Mutex
+	err     error
+
+	handler func(Telegram)
+}
+
+//DialTunnel connects to the gateway at address ("host" or "host:port"). Received group
+//telegrams are passed to handler from the goroutine receiving acknowledgements, so handler must
+//not block or send telegrams itself.
+func DialTunnel(ctx context.Context, address string, handler func(Telegram)) (*Tunnel, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, defaultPort)
+	}
+	raddr, err := net.ResolveUDPAddr("udp4", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp4", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+
+	//control and data endpoints are 0.0.0.0:0, answer to the sender (NAT mode)
+	body := append(nat(), nat()...)
+	body = append(body, 0x04, 0x04, 0x02, 0x00)
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+	}
+	if _, err := conn.Write(frame(connectRequest, body)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	buf := make([]byte, 512)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("knx connect failed: %v", err)
+		}
+		service, payload, ok := parseFrame(buf[:n])
+		if !ok || service != connectResponse {
+			continue
+		}
+		if len(payload) < 2 {
+			continue
+		}
+		if payload[1] != 0 {
+			conn.Close()
+			return nil, fmt.Errorf("knx connect rejected with status %#x", payload[1])
+		}
+		t := &Tunnel{
+			conn:    conn,
+			channel: payload[0],
+			acks:    make(chan byte, 1),
+			done:    make(chan struct{}),
+			handler: handler,
+		}
+		if len(payload) >= 14 {
+			t.Address = binary.BigEndian.Uint16(payload[12:14])
+		}
+		conn.SetDeadline(time.Time{})
+		go t.receive()
+		go t.heartbeat()
+		return t, nil
+	}
+}
+
+//nat returns a HPAI for 0.0.0.0:0 over UDP
+func nat() []byte {
+	return []byte{0x08, 0x01, 0, 0, 0, 0, 0, 0}
+}
+
+func frame(service uint16, body []byte) []byte {
+	f := []byte{0x06, 0x10, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(f[2:4], service)
+	binary.BigEndian.PutUint16(f[4:6], uint16(6+len(body)))
+	return append(f, body...)
+}
+
+func parseFrame(f []byte) (uint16, []byte, bool) {
+	if len(f) < 6 || f[0] != 0x06 || f[1] != 0x10 {
+		return 0, nil, false
+	}
+	length := int(binary.BigEndian.Uint16(f[4:6]))
+	if length > len(f) || length < 6 {
+		return 0, nil, false
+	}
+	return binary.BigEndian.Uint16(f[2:4]), f[6:length], true
+}
+
+//Done is closed when the tunnel is closed, by Close or by the gateway
+func (t *Tunnel) Done() <-chan struct{} {
+	return t.done
+}
+
+//Err returns why the tunnel was closed
+func (t *Tunnel) Err() error {
+	t.closeMu.Lock()
+	defer t.closeMu.Unlock()
+	return t.err
+}
+
+//Close disconnects from the gateway
+func (t *Tunnel) Close() error {
+	select {
+	case <-t.done:
+		return nil
+	default:
+	}
+	t.conn.Write(frame(disconnectRequest, append([]byte{t.channel, 0}, nat()...)))
+	t.shutdown(ErrClosed)
+	return nil
+}
+
+func (t *Tunnel) shutdown(err error) {
+	t.closeMu.Lock()
+	defer t.closeMu.Unlock()
+	select {
+	case <-t.done:
+		return
+	default:
+	}
+	t.err = err
+	close(t.done)
+	t.conn.Close()
+}
+
+//receive handles frames from the gateway until the tunnel is closed
+func (t *Tunnel) receive() {
+	buf := make([]byte, 512)
+	lastSeq := -1
+	for {
+		n, err := t.conn.Read(buf)
+		if err != nil {
+			t.shutdown(err)
+			return
+		}
+		service, payload, ok := parseFrame(buf[:n])
+		if !ok || len(payload) < 2 {
+			continue
+		}
+
+		switch service {
+		case tunnellingAck:
+			if len(payload) >= 4 && payload[1] == t.channel && payload[3] == 0 {
+				select {
+				case t.acks <- payload[2]:
+				default:
+				}
+			}
+		case tunnellingRequest:
+			if len(payload) < 4 || payload[1] != t.channel {
+				continue
+			}
+			seq := payload[2]
+			t.conn.Write(frame(tunnellingAck, []byte{0x04, t.channel, seq, 0}))
+			//repeated requests are acknowledged again, but handled once
+			if int(seq) == lastSeq {
+				continue
+			}
+			lastSeq = int(seq)
+			if telegram, ok := parseCEMI(payload[4:]); ok && t.handler != nil {
+				t.handler(telegram)
+			}
+		case connectionStateResponse:
+			if payload[1] != 0 {
+				t.shutdown(fmt.Errorf("knx connection lost, status %#x", payload[1]))
+				return
+			}
+		case disconnectRequest:
+			t.conn.Write(frame(disconnectResponse, []byte{t.channel, 0}))
+			t.shutdown(errors.New("knx gateway disconnected"))
+			return
+		}
+	}
+}
+
+//heartbeat keeps the connection alive
+func (t *Tunnel) heartbeat() {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+			t.conn.Write(frame(connectionStateRequest, append([]byte{t.channel, 0}, nat()...)))
+		}
+	}
+}
+
+//send sends a group telegram and waits for the gateway to acknowledge it, repeating it once
+func (t *Tunnel) send(destination GroupAddress, apci uint16, data []byte) error {
+	t.sendMu.Lock()
+	defer t.sendMu.Unlock()
+	select {
+	case <-t.done:
+		return ErrClosed
+	default:
+	}
+
+	seq := t.seq
+	request := frame(tunnellingRequest, append([]byte{0x04, t.channel, seq, 0}, cemi(destination, apci, data)...))
+	for attempt := 0; attempt < 2; attempt++ {
+		if _, err := t.conn.Write(request); err != nil {
+			return err
+		}
+		timeout := time.NewTimer(ackTimeout)
+	wait:
+		for {
+			select {
+			case ack := <-t.acks:
+				if ack != seq {
+					continue
+				}
+				timeout.Stop()
+				t.seq++
+				return nil
+			case <-timeout.C:
+				break wait
+			case <-t.done:
+				timeout.Stop()
+				return ErrClosed
+			}
+		}
+	}
+	err := errors.New("knx gateway did not acknowledge telegram")
+	t.shutdown(err)
+	return err
+}
+
+//Write sends a GroupValueWrite
+func (t *Tunnel) Write(destination GroupAddress, data []byte) error {
+	return t.send(destination, groupValueWrite, data)
+}
+
+//Respond sends a GroupValueResponse, answering a GroupValueRead
+func (t *Tunnel) Respond(destination GroupAddress, data []byte) error {
+	return t.send(destination, groupValueResponse, data)
+}
+
+//cemi builds an L_Data.req frame for a group telegram. The data follows the APCI, as used by
+//datapoint types of one byte or more.
+func cemi(destination GroupAddress, apci uint16, data []byte) []byte {
+	f := []byte{cemiDataRequest, 0x00, 0xbc, 0xe0, 0, 0, 0, 0, byte(1 + len(data)), byte(apci >> 8 & 0x03), byte(apci & 0xc0)}
+	binary.BigEndian.PutUint16(f[6:8], uint16(destination))
+	return append(f, data...)
+}
+
+//parseCEMI parses an L_Data.ind frame carrying a group telegram
+func parseCEMI(f []byte) (Telegram, bool) {
+	if len(f) < 2 || f[0] != cemiDataIndication || len(f) < 2+int(f[1]) {
+		return Telegram{}, false
+	}
+	f = f[2+int(f[1]):]
+	if len(f) < 9 || f[1]&0x80 == 0 {
+		return Telegram{}, false
+	}
+	length := int(f[6])
+	if len(f) < 8+length || length < 1 {
+		return Telegram{}, false
+	}
+	t := Telegram{
+		Source:      binary.BigEndian.Uint16(f[2:4]),
+		Destination: GroupAddress(binary.BigEndian.Uint16(f[4:6])),
+		APCI:        uint16(f[7]&0x03)<<8 | uint16(f[8]&0xc0),
+	}
+	if length == 1 {
+		//data of up to 6 bits is packed into the APCI
+		t.Data = []byte{f[8] & 0x3f}
+	} else {
+		t.Data = append([]byte{}, f[9:8+length]...)
+	}
+	return t, true
+}
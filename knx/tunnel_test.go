@@ -0,0 +1,280 @@
+package knx
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/rothtest"
+)
+
+//fakeGateway is the gateway side of a tunnel, answering the connect request with channel 7
+type fakeGateway struct {
+	t    *testing.T
+	conn *net.UDPConn
+	peer *net.UDPAddr
+}
+
+func newFakeGateway(t *testing.T) *fakeGateway {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &fakeGateway{t: t, conn: conn}
+}
+
+func (g *fakeGateway) address() string {
+	return g.conn.LocalAddr().String()
+}
+
+//read returns the next frame from the tunnel
+func (g *fakeGateway) read() (uint16, []byte) {
+	g.t.Helper()
+	buf := make([]byte, 512)
+	g.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, peer, err := g.conn.ReadFromUDP(buf)
+	if err != nil {
+		g.t.Fatalf("gateway read: %v", err)
+	}
+	g.peer = peer
+	service, payload, ok := parseFrame(buf[:n])
+	if !ok {
+		g.t.Fatalf("gateway received invalid frame % x", buf[:n])
+	}
+	return service, payload
+}
+
+//expect reads the next frame, skipping heartbeats, and checks its service
+func (g *fakeGateway) expect(service uint16) []byte {
+	g.t.Helper()
+	for {
+		got, payload := g.read()
+		if got == connectionStateRequest {
+			continue
+		}
+		if got != service {
+			g.t.Fatalf("gateway received service %#x, want %#x", got, service)
+		}
+		return payload
+	}
+}
+
+func (g *fakeGateway) send(service uint16, body []byte) {
+	g.t.Helper()
+	if _, err := g.conn.WriteToUDP(frame(service, body), g.peer); err != nil {
+		g.t.Fatalf("gateway write: %v", err)
+	}
+}
+
+//accept answers the connect request, assigning the individual address 1.1.5
+func (g *fakeGateway) accept(status byte) {
+	g.t.Helper()
+	g.expect(connectRequest)
+	g.send(connectResponse, append(append([]byte{7, status}, nat()...), 0x04, 0x04, 0x11, 0x05))
+}
+
+//indication is a tunnelling request carrying a group telegram from the bus
+func indication(seq byte, destination GroupAddress, apci uint16, data []byte) []byte {
+	f := cemi(destination, apci, data)
+	f[0] = cemiDataIndication
+	//source 1.1.20
+	f[4], f[5] = 0x11, 0x14
+	return append([]byte{0x04, 7, seq, 0}, f...)
+}
+
+func dialFake(t *testing.T, g *fakeGateway, handler func(Telegram)) *Tunnel {
+	t.Helper()
+	result := make(chan *Tunnel, 1)
+	go func() {
+		tunnel, err := DialTunnel(context.Background(), g.address(), handler)
+		if err != nil {
+			t.Errorf("DialTunnel: %v", err)
+		}
+		result <- tunnel
+	}()
+	g.accept(0)
+	tunnel := <-result
+	if tunnel == nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() { tunnel.Close() })
+	return tunnel
+}
+
+func TestTunnel(t *testing.T) {
+	g := newFakeGateway(t)
+	telegrams := make(chan Telegram, 10)
+	tunnel := dialFake(t, g, func(tg Telegram) { telegrams <- tg })
+	if tunnel.Address != 0x1105 {
+		t.Errorf("got individual address %#x, want 0x1105", tunnel.Address)
+	}
+
+	//a write is sent as L_Data.req and completes on the acknowledgement
+	address, _ := ParseGroupAddress("1/2/3")
+	done := make(chan error, 1)
+	go func() { done <- tunnel.Write(address, EncodeTemperature(21)) }()
+	request := g.expect(tunnellingRequest)
+	want := append([]byte{0x04, 7, 0, 0}, cemi(address, groupValueWrite, []byte{0x0c, 0x1a})...)
+	if !bytes.Equal(request, want) {
+		t.Errorf("got tunnelling request % x, want % x", request, want)
+	}
+	g.send(tunnellingAck, []byte{0x04, 7, 0, 0})
+	if err := <-done; err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	//the next telegram uses the next sequence number, and is repeated once without an ack
+	go func() { done <- tunnel.Respond(address, []byte{0x01}) }()
+	first := g.expect(tunnellingRequest)
+	repeated := g.expect(tunnellingRequest)
+	if first[2] != 1 || !bytes.Equal(first, repeated) {
+		t.Errorf("got requests % x and % x, want sequence 1 repeated", first, repeated)
+	}
+	g.send(tunnellingAck, []byte{0x04, 7, 1, 0})
+	if err := <-done; err != nil {
+		t.Fatalf("Respond: %v", err)
+	}
+
+	//telegrams from the bus are acknowledged, and repeated ones handled once
+	modeAddress, _ := ParseGroupAddress("1/2/4")
+	for i := 0; i < 2; i++ {
+		g.send(tunnellingRequest, indication(5, modeAddress, groupValueWrite, []byte{hvacEconomy}))
+		if ack := g.expect(tunnellingAck); !bytes.Equal(ack, []byte{0x04, 7, 5, 0}) {
+			t.Errorf("got ack % x, want sequence 5", ack)
+		}
+	}
+	g.send(tunnellingRequest, indication(6, modeAddress, groupValueRead, nil))
+	g.expect(tunnellingAck)
+
+	write, read := <-telegrams, <-telegrams
+	if !write.IsWrite() || write.Destination != modeAddress || write.Source != 0x1114 || !bytes.Equal(write.Data, []byte{hvacEconomy}) {
+		t.Errorf("got telegram %+v, want a write of economy to 1/2/4 from 1.1.20", write)
+	}
+	if !read.IsRead() || read.Destination != modeAddress {
+		t.Errorf("got telegram %+v, want a read of 1/2/4", read)
+	}
+	select {
+	case tg := <-telegrams:
+		t.Errorf("got repeated telegram %+v", tg)
+	default:
+	}
+
+	//the gateway closing the connection ends the tunnel
+	g.send(disconnectRequest, append([]byte{7, 0}, nat()...))
+	g.expect(disconnectResponse)
+	select {
+	case <-tunnel.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("tunnel not closed after disconnect request")
+	}
+	if tunnel.Err() == nil {
+		t.Error("got no error after the gateway disconnected")
+	}
+	if err := tunnel.Write(address, []byte{0}); err != ErrClosed {
+		t.Errorf("Write after close returned %v, want ErrClosed", err)
+	}
+}
+
+func TestTunnelRejected(t *testing.T) {
+	g := newFakeGateway(t)
+	result := make(chan error, 1)
+	go func() {
+		_, err := DialTunnel(context.Background(), g.address(), nil)
+		result <- err
+	}()
+	//no more connections
+	g.accept(0x24)
+	if err := <-result; err == nil {
+		t.Error("DialTunnel succeeded after the connect request was rejected")
+	}
+}
+
+func TestParseCEMI(t *testing.T) {
+	address, _ := ParseGroupAddress("1/2/4")
+	valid := indication(0, address, groupValueWrite, []byte{0x0c, 0x1a})[4:]
+	if tg, ok := parseCEMI(valid); !ok || !bytes.Equal(tg.Data, []byte{0x0c, 0x1a}) {
+		t.Errorf("got %+v, %v, want the 2 byte value", tg, ok)
+	}
+
+	//data of up to 6 bits is packed into the APCI
+	packed := append([]byte{}, valid[:11]...)
+	packed[8], packed[10] = 1, byte(groupValueWrite)|0x01
+	if tg, ok := parseCEMI(packed); !ok || !tg.IsWrite() || !bytes.Equal(tg.Data, []byte{0x01}) {
+		t.Errorf("got %+v, %v, want a write of 1", tg, ok)
+	}
+
+	for name, f := range map[string][]byte{
+		"empty":                  {},
+		"request":                append([]byte{cemiDataRequest}, valid[1:]...),
+		"additional info beyond": {cemiDataIndication, 20, 0, 0},
+		"individual address":     append(append([]byte{}, valid[:3]...), append([]byte{valid[3] &^ 0x80}, valid[4:]...)...),
+		"truncated data":         valid[:len(valid)-1],
+	} {
+		if tg, ok := parseCEMI(f); ok {
+			t.Errorf("%v: parsed % x as %+v", name, f, tg)
+		}
+	}
+}
+
+func TestBridgeConvertsUnits(t *testing.T) {
+	srv := rothtest.NewServer(roth.Sensor{Id: 0, Name: "Living room", RoomTemperature: 20, TargetTemperature: 21, Program: roth.Program1, Mode: roth.ModeDay})
+	defer srv.Close()
+	client := roth.NewClient(srv.URL, roth.WithLogger(roth.DiscardLogger), roth.WithUnit(roth.Fahrenheit))
+
+	room, _ := ParseGroupAddress("1/1/1")
+	target, _ := ParseGroupAddress("1/1/2")
+	b := NewBridge(client, Mapping{SensorID: 0, RoomTemperature: room, TargetTemperature: target})
+	errs := make(chan error, 10)
+	b.OnError = func(err error) { errs <- err }
+	w := roth.NewWatcher(client, time.Minute)
+	b.Attach(w)
+	if p := w.Poll(context.Background()); p.Err != nil {
+		t.Fatalf("Poll: %v", p.Err)
+	}
+
+	g := newFakeGateway(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx, g.address())
+	g.accept(0)
+
+	//the sensors are sent in °C after connecting
+	sent := make(map[GroupAddress][]byte)
+	for i := 0; i < 2; i++ {
+		request := g.expect(tunnellingRequest)
+		tg, _ := parseCEMI(append([]byte{cemiDataIndication}, request[5:]...))
+		sent[tg.Destination] = tg.Data
+		g.send(tunnellingAck, []byte{0x04, 7, request[2], 0})
+	}
+	if got, want := sent[room], EncodeTemperature(20); !bytes.Equal(got, want) {
+		t.Errorf("got room temperature % x, want % x", got, want)
+	}
+	if got, want := sent[target], EncodeTemperature(21); !bytes.Equal(got, want) {
+		t.Errorf("got target temperature % x, want % x", got, want)
+	}
+
+	//a setpoint written on the bus in °C is applied as such
+	g.send(tunnellingRequest, indication(0, target, groupValueWrite, EncodeTemperature(22.5)))
+	g.expect(tunnellingAck)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if value, _ := srv.Value("G0.SollTemp"); value == "2250" {
+			break
+		}
+		select {
+		case err := <-errs:
+			t.Fatalf("bridge error: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			value, _ := srv.Value("G0.SollTemp")
+			t.Fatalf("got target %q after the write from the bus, want 2250", value)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,164 @@
+package roth
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+//ErrRunning is returned by Start if the service is already running
+var ErrRunning = errors.New("already running")
+
+//ErrStopped is reported for work handed to a service after Stop, e.g. a value queued on a
+//stopped WriteQueue
+var ErrStopped = errors.New("service stopped")
+
+//Service is the lifecycle shared by the long-running subsystems, e.g. Watcher, HealthMonitor,
+//Reconciler, WriteQueue, history.Recorder and webhook.Dispatcher, so they behave alike when
+//embedded in a larger service.
+//
+//Start begins the work in the background and returns immediately. The service runs until Stop
+//is called or the context given to Start is cancelled.
+//
+//Stop ends the work and drains what is in flight: queued writes are sent, recorders flush their
+//stores, queued webhooks are delivered. It returns once the service has stopped, or with the
+//error of ctx if ctx expires first. Stopping a service which is not running returns nil. A
+//stopped service may be started again.
+type Service interface {
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
+//Runner implements Start and Stop for a service running a loop until its context is cancelled,
+//like the Run methods of this module. The zero value is ready to use.
+type Runner struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+//Start runs the loop in a new goroutine, with a context cancelled by Stop. It returns ErrRunning
+//if the loop is still running.
+func (r *Runner) Start(ctx context.Context, run func(ctx context.Context)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running() {
+		return ErrRunning
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	r.cancel, r.done = cancel, done
+	go func() {
+		defer close(done)
+		defer cancel()
+		run(ctx)
+	}()
+	return nil
+}
+
+//Stop cancels the loop, and waits until it returned or ctx expires
+func (r *Runner) Stop(ctx context.Context) error {
+	r.mu.Lock()
+	cancel, done := r.cancel, r.done
+	r.mu.Unlock()
+	if done == nil {
+		return nil
+	}
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//Running returns whether the loop is running
+func (r *Runner) Running() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.running()
+}
+
+func (r *Runner) running() bool {
+	if r.done == nil {
+		return false
+	}
+	select {
+	case <-r.done:
+		return false
+	default:
+		return true
+	}
+}
+
+//Start polls in the background, see Run
+func (w *Watcher) Start(ctx context.Context) error {
+	return w.runner.Start(ctx, w.Run)
+}
+
+//Stop ends polling. A poll in progress is cancelled.
+func (w *Watcher) Stop(ctx context.Context) error {
+	return w.runner.Stop(ctx)
+}
+
+//Start probes the controller in the background, see Run
+func (m *HealthMonitor) Start(ctx context.Context) error {
+	return m.runner.Start(ctx, m.Run)
+}
+
+//Stop ends probing
+func (m *HealthMonitor) Stop(ctx context.Context) error {
+	return m.runner.Stop(ctx)
+}
+
+//Start reconciles in the background, see Run
+func (r *Reconciler) Start(ctx context.Context) error {
+	return r.runner.Start(ctx, r.Run)
+}
+
+//Stop ends reconciling. A correction in progress is cancelled.
+func (r *Reconciler) Stop(ctx context.Context) error {
+	return r.runner.Stop(ctx)
+}
+
+//Start accepts values again after Stop. Values are sent without starting the queue, so Start is
+//only needed to tie the queue to a context: when ctx is cancelled, the queue is stopped and
+//drained as by Stop.
+func (q *WriteQueue) Start(ctx context.Context) error {
+	q.mu.Lock()
+	q.stopped = false
+	q.mu.Unlock()
+	return q.runner.Start(ctx, func(ctx context.Context) {
+		<-ctx.Done()
+		q.drain(context.Background())
+	})
+}
+
+//Stop rejects further values with ErrStopped, sends all pending values immediately, and waits
+//until they are written
+func (q *WriteQueue) Stop(ctx context.Context) error {
+	if err := q.runner.Stop(ctx); err != nil {
+		return err
+	}
+	return q.drain(ctx)
+}
+
+//drain stops accepting values, and flushes the pending ones
+func (q *WriteQueue) drain(ctx context.Context) error {
+	q.mu.Lock()
+	q.stopped = true
+	q.mu.Unlock()
+
+	flushed := make(chan struct{})
+	go func() {
+		q.Flush()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,75 @@
+package roth
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+//LogLevel is the severity of a log message
+type LogLevel int
+
+const (
+	//LogDebug is used for detailed protocol traces
+	LogDebug LogLevel = iota
+	//LogInfo is used for noteworthy events in normal operation
+	LogInfo
+	//LogWarning is used for recoverable problems, e.g. values which could not be parsed
+	LogWarning
+	//LogError is used for failed operations
+	LogError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "debug"
+	case LogInfo:
+		return "info"
+	case LogWarning:
+		return "warning"
+	case LogError:
+		return "error"
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(l))
+}
+
+//Logger receives diagnostic messages from the client
+type Logger interface {
+	Log(level LogLevel, message string)
+}
+
+//LoggerFunc adapts an ordinary function to the Logger interface
+type LoggerFunc func(level LogLevel, message string)
+
+//Log calls f(level, message)
+func (f LoggerFunc) Log(level LogLevel, message string) {
+	f(level, message)
+}
+
+//DiscardLogger drops all messages
+var DiscardLogger Logger = LoggerFunc(func(LogLevel, string) {})
+
+type writerLogger struct {
+	mu       sync.Mutex
+	w        io.Writer
+	minLevel LogLevel
+}
+
+//NewWriterLogger creates a logger writing messages at or above minLevel to w, one per line
+func NewWriterLogger(w io.Writer, minLevel LogLevel) Logger {
+	return &writerLogger{w: w, minLevel: minLevel}
+}
+
+func (l *writerLogger) Log(level LogLevel, message string) {
+	if level < l.minLevel {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.w, "%v: %v\n", level, message)
+}
+
+//defaultLogger keeps the behaviour of earlier versions, which printed problems to stdout
+var defaultLogger = NewWriterLogger(os.Stdout, LogWarning)
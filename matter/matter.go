@@ -0,0 +1,197 @@
+/*
+Package matter exposes each sensor as a thermostat device of a Matter bridge, so Google Home,
+Alexa and Apple Home can control the floor heating. The Matter protocol itself (commissioning,
+fabrics, Thread and the interaction model) is left to an external Matter stack, adapted to the
+Backend interface; this package maps sensors onto the attributes of the Thermostat cluster and
+applies writes from controllers.
+
+The mapping follows the Thermostat cluster (0x0201) of a heating only thermostat:
+
+	LocalTemperature         room temperature, in 0.01 °C
+	OccupiedHeatingSetpoint  target temperature, in 0.01 °C, writable
+	SystemMode               Heat for day and night mode, Off for holiday mode, writable
+
+Temperatures are in °C as the cluster requires, whatever the unit of the client. Writing
+SystemMode Heat to a sensor in holiday mode selects day mode.
+*/
+package matter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+//SystemMode values of the Thermostat cluster
+const (
+	SystemModeOff  uint8 = 0
+	SystemModeHeat uint8 = 4
+)
+
+//ThermostatState holds the Thermostat cluster attributes of a device
+type ThermostatState struct {
+	//LocalTemperature in 0.01 °C, nil if unknown
+	LocalTemperature *int16
+	//OccupiedHeatingSetpoint in 0.01 °C
+	OccupiedHeatingSetpoint int16
+	SystemMode              uint8
+	//Reachable is false while the controller cannot be read, for the Bridged Device Basic
+	//Information cluster
+	Reachable bool
+}
+
+//Write is an attribute write from a Matter controller. Nil fields are not written.
+type Write struct {
+	OccupiedHeatingSetpoint *int16
+	SystemMode              *uint8
+}
+
+//Device is a bridged thermostat endpoint of the backend
+type Device interface {
+	//Update reports changed attributes to subscribed controllers
+	Update(state ThermostatState) error
+}
+
+//Backend is a Matter bridge stack, adding bridged device endpoints to its aggregator
+type Backend interface {
+	//AddThermostat adds a thermostat endpoint. UniqueID is stable across restarts, and write is
+	//called for attribute writes from controllers; an error rejects the write.
+	AddThermostat(uniqueID string, name string, write func(Write) error) (Device, error)
+}
+
+//Bridge keeps thermostat devices in sync with the sensors
+type Bridge struct {
+	client  *roth.Client
+	backend Backend
+
+	//OnError is called when a device could not be added or updated
+	OnError func(sensorID int, err error)
+
+	mu      sync.Mutex
+	devices map[int]Device
+	sensors map[int]roth.Sensor
+}
+
+//NewBridge creates a bridge adding devices to the backend
+func NewBridge(client *roth.Client, backend Backend) *Bridge {
+	return &Bridge{
+		client:  client,
+		backend: backend,
+		devices: make(map[int]Device),
+		sensors: make(map[int]roth.Sensor),
+	}
+}
+
+//Attach adds a device for each sensor of the first successful poll of the watcher, and updates
+//the devices on every poll. Devices are marked unreachable while polls fail.
+func (b *Bridge) Attach(w *roth.Watcher) {
+	w.Subscribe(func(p roth.Poll) {
+		if p.Err != nil {
+			b.mu.Lock()
+			devices := make(map[int]Device, len(b.devices))
+			for id, device := range b.devices {
+				devices[id] = device
+			}
+			b.mu.Unlock()
+			for id, device := range devices {
+				b.update(id, device, roth.Sensor{Id: id}, false)
+			}
+			return
+		}
+		for _, s := range p.Sensors {
+			b.Update(s)
+		}
+	})
+}
+
+//Update adds or updates the device of a sensor
+func (b *Bridge) Update(s roth.Sensor) {
+	b.mu.Lock()
+	device, ok := b.devices[s.Id]
+	b.sensors[s.Id] = s
+	b.mu.Unlock()
+
+	if !ok {
+		id := s.Id
+		var err error
+		device, err = b.backend.AddThermostat(fmt.Sprintf("roth-sensor-%d", id), s.Name, func(w Write) error {
+			return b.write(id, w)
+		})
+		if err != nil {
+			b.report(s.Id, err)
+			return
+		}
+		b.mu.Lock()
+		b.devices[s.Id] = device
+		b.mu.Unlock()
+	}
+	b.update(s.Id, device, s, true)
+}
+
+func (b *Bridge) update(sensorID int, device Device, s roth.Sensor, reachable bool) {
+	if err := device.Update(State(s, reachable)); err != nil {
+		b.report(sensorID, err)
+	}
+}
+
+func (b *Bridge) report(sensorID int, err error) {
+	if b.OnError != nil {
+		b.OnError(sensorID, err)
+	}
+}
+
+//State returns the thermostat attributes of a sensor, converting its temperatures to °C
+func State(s roth.Sensor, reachable bool) ThermostatState {
+	state := ThermostatState{
+		OccupiedHeatingSetpoint: centiDegrees(s.Target()),
+		SystemMode:              SystemModeHeat,
+		Reachable:               reachable,
+	}
+	if s.Valid.Has(roth.FieldRoomTemperature) {
+		t := centiDegrees(s.Room())
+		state.LocalTemperature = &t
+	}
+	if s.Mode == roth.ModeHoliday {
+		state.SystemMode = SystemModeOff
+	}
+	return state
+}
+
+func centiDegrees(t roth.Temperature) int16 {
+	return int16(math.Round(float64(t.Celsius()) * 100))
+}
+
+//write applies an attribute write to the controller
+func (b *Bridge) write(sensorID int, w Write) error {
+	ctx := context.Background()
+	if w.OccupiedHeatingSetpoint != nil {
+		target := roth.ConvertTemperature(float32(*w.OccupiedHeatingSetpoint)/100, roth.Celsius, b.client.Unit)
+		if err := b.client.SetTargetTemperature(ctx, sensorID, target); err != nil {
+			return err
+		}
+	}
+	if w.SystemMode != nil {
+		var mode roth.Mode
+		switch *w.SystemMode {
+		case SystemModeOff:
+			mode = roth.ModeHoliday
+		case SystemModeHeat:
+			b.mu.Lock()
+			current := b.sensors[sensorID].Mode
+			b.mu.Unlock()
+			if current != roth.ModeHoliday {
+				return nil
+			}
+			mode = roth.ModeDay
+		default:
+			return fmt.Errorf("unsupported system mode %d", *w.SystemMode)
+		}
+		if err := b.client.SetMode(ctx, sensorID, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
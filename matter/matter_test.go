@@ -0,0 +1,79 @@
+package matter_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/matter"
+	"github.com/kvantetore/rothTouchline/rothtest"
+)
+
+//fakeDevice records the states of a thermostat endpoint
+type fakeDevice struct {
+	states []matter.ThermostatState
+	write  func(matter.Write) error
+}
+
+func (d *fakeDevice) Update(state matter.ThermostatState) error {
+	d.states = append(d.states, state)
+	return nil
+}
+
+type fakeBackend map[string]*fakeDevice
+
+func (b fakeBackend) AddThermostat(uniqueID string, name string, write func(matter.Write) error) (matter.Device, error) {
+	d := &fakeDevice{write: write}
+	b[uniqueID] = d
+	return d, nil
+}
+
+func TestBridge(t *testing.T) {
+	for _, unit := range []roth.Unit{roth.Celsius, roth.Fahrenheit} {
+		t.Run(unit.String(), func(t *testing.T) {
+			srv := rothtest.NewServer(roth.Sensor{Id: 0, Name: "Living room", RoomTemperature: 20.86, TargetTemperature: 21, Program: roth.Program1, Mode: roth.ModeHoliday})
+			defer srv.Close()
+			client := roth.NewClient(srv.URL, roth.WithLogger(roth.DiscardLogger), roth.WithUnit(unit))
+
+			backend := fakeBackend{}
+			b := matter.NewBridge(client, backend)
+			w := roth.NewWatcher(client, time.Minute)
+			b.Attach(w)
+			if p := w.Poll(context.Background()); p.Err != nil {
+				t.Fatalf("Poll: %v", p.Err)
+			}
+
+			device := backend["roth-sensor-0"]
+			if device == nil || len(device.states) != 1 {
+				t.Fatalf("got devices %v, want one update of roth-sensor-0", backend)
+			}
+			//the cluster is in °C, whatever the unit of the client
+			state := device.states[0]
+			if state.LocalTemperature == nil {
+				t.Fatal("got no local temperature")
+			}
+			if *state.LocalTemperature != 2086 || state.OccupiedHeatingSetpoint != 2100 {
+				t.Errorf("got local temperature %v setpoint %v, want 2086 and 2100", *state.LocalTemperature, state.OccupiedHeatingSetpoint)
+			}
+			if state.SystemMode != matter.SystemModeOff || !state.Reachable {
+				t.Errorf("got system mode %v reachable %v, want off and reachable", state.SystemMode, state.Reachable)
+			}
+
+			setpoint, heat := int16(2250), matter.SystemModeHeat
+			if err := device.write(matter.Write{OccupiedHeatingSetpoint: &setpoint, SystemMode: &heat}); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			for name, want := range map[string]string{"G0.SollTemp": "2250", "G0.OPMode": "0"} {
+				if got, _ := srv.Value(name); got != want {
+					t.Errorf("got %v = %q, want %q", name, got, want)
+				}
+			}
+
+			invalid := uint8(3)
+			if err := device.write(matter.Write{SystemMode: &invalid}); err == nil {
+				t.Error("write of system mode cool succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package roth
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//Metadata is information about a sensor kept by the client rather than the controller, which
+//only stores a short name, e.g. for grouping and labelling rooms on dashboards
+type Metadata struct {
+	Floor       string `json:"floor,omitempty"`
+	Orientation string `json:"orientation,omitempty"`
+	//Area is the floor area of the room in m²
+	Area float64  `json:"area,omitempty"`
+	Tags []string `json:"tags,omitempty"`
+	//Labels holds any other annotations, by name
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+//metadataKey is the storage key of the sensor metadata
+const metadataKey = "metadata.json"
+
+//HasTag returns whether the sensor has the tag, compared case insensitively
+func (m Metadata) HasTag(tag string) bool {
+	for _, t := range m.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+//MetricLabels returns the metadata as labels for metrics, with names valid in Prometheus and
+//OpenTelemetry: floor, orientation, area, tags as a sorted comma separated list, and the
+//entries of Labels with invalid characters replaced by _. Empty values are left out.
+func (m Metadata) MetricLabels() map[string]string {
+	labels := make(map[string]string)
+	for name, value := range m.Labels {
+		if value != "" {
+			labels[labelName(name)] = value
+		}
+	}
+	if m.Floor != "" {
+		labels["floor"] = m.Floor
+	}
+	if m.Orientation != "" {
+		labels["orientation"] = m.Orientation
+	}
+	if m.Area != 0 {
+		labels["area"] = strconv.FormatFloat(m.Area, 'f', -1, 64)
+	}
+	if len(m.Tags) > 0 {
+		tags := append([]string{}, m.Tags...)
+		sort.Strings(tags)
+		labels["tags"] = strings.Join(tags, ",")
+	}
+	return labels
+}
+
+//labelName replaces the characters not allowed in metric label names
+func labelName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c >= '0' && c <= '9' && i > 0) {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 {
+		return "_"
+	}
+	return string(b)
+}
+
+//metadataStore holds the metadata of the sensors of a client
+type metadataStore struct {
+	mu      sync.Mutex
+	byID    map[int]*Metadata
+	storage Storage
+}
+
+//SetMetadata replaces the metadata of a sensor, and stores it if persisted with PersistMetadata.
+//Sensors read afterwards carry the metadata in Sensor.Metadata.
+func (c *Client) SetMetadata(sensorID int, m Metadata) error {
+	c.metadata.mu.Lock()
+	defer c.metadata.mu.Unlock()
+	if c.metadata.byID == nil {
+		c.metadata.byID = make(map[int]*Metadata)
+	}
+	//sensors share the stored value, so it is replaced rather than modified
+	c.metadata.byID[sensorID] = &m
+	return c.saveMetadata()
+}
+
+//ClearMetadata removes the metadata of a sensor
+func (c *Client) ClearMetadata(sensorID int) error {
+	c.metadata.mu.Lock()
+	defer c.metadata.mu.Unlock()
+	delete(c.metadata.byID, sensorID)
+	return c.saveMetadata()
+}
+
+//saveMetadata stores the metadata, if persisted. The caller must hold c.metadata.mu.
+func (c *Client) saveMetadata() error {
+	if c.metadata.storage == nil {
+		return nil
+	}
+	return StoreJSON(c.metadata.storage, metadataKey, c.metadata.byID)
+}
+
+//PersistMetadata loads the metadata stored in the storage, replacing any set before, and stores
+//every later change
+func (c *Client) PersistMetadata(storage Storage) error {
+	byID := make(map[int]*Metadata)
+	if _, err := LoadJSON(storage, metadataKey, &byID); err != nil {
+		return err
+	}
+	c.metadata.mu.Lock()
+	defer c.metadata.mu.Unlock()
+	c.metadata.byID = byID
+	c.metadata.storage = storage
+	return nil
+}
+
+//Metadata returns the metadata of a sensor
+func (c *Client) Metadata(sensorID int) (Metadata, bool) {
+	c.metadata.mu.Lock()
+	defer c.metadata.mu.Unlock()
+	m, ok := c.metadata.byID[sensorID]
+	if !ok {
+		return Metadata{}, false
+	}
+	return *m, true
+}
+
+//SensorsTagged returns the ids of the sensors with the tag, sorted
+func (c *Client) SensorsTagged(tag string) []int {
+	c.metadata.mu.Lock()
+	defer c.metadata.mu.Unlock()
+	var ids []int
+	for id, m := range c.metadata.byID {
+		if m.HasTag(tag) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+//annotate sets the metadata of the sensors
+func (c *Client) annotate(sensors []Sensor) {
+	c.metadata.mu.Lock()
+	defer c.metadata.mu.Unlock()
+	if len(c.metadata.byID) == 0 {
+		return
+	}
+	for i := range sensors {
+		sensors[i].Metadata = c.metadata.byID[sensors[i].Id]
+	}
+}
@@ -0,0 +1,52 @@
+package roth
+
+import "net/http"
+
+//RoundTripFunc performs a single http request to the controller
+type RoundTripFunc func(req *http.Request) (*http.Response, error)
+
+//Middleware wraps the requests of a client, e.g. for logging, metrics, modifying requests or
+//injecting faults. It must call next to perform the request, or return a response of its own.
+type Middleware func(next RoundTripFunc) RoundTripFunc
+
+//Hooks are callbacks observing the requests of a client. Any of them may be nil.
+type Hooks struct {
+	//OnRequest is called before each request is sent, and may modify it
+	OnRequest func(req *http.Request)
+	//OnResponse is called for each response received, before its body is read
+	OnResponse func(req *http.Request, resp *http.Response)
+	//OnError is called for each request which failed without a response
+	OnError func(req *http.Request, err error)
+}
+
+//Middleware returns a middleware calling the hooks
+func (h Hooks) Middleware() Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			if h.OnRequest != nil {
+				h.OnRequest(req)
+			}
+			resp, err := next(req)
+			if err != nil {
+				if h.OnError != nil {
+					h.OnError(req, err)
+				}
+				return resp, err
+			}
+			if h.OnResponse != nil {
+				h.OnResponse(req, resp)
+			}
+			return resp, nil
+		}
+	}
+}
+
+//roundTrip performs a request through the middleware of the client, the first middleware
+//being the outermost
+func (c *Client) roundTrip(req *http.Request) (*http.Response, error) {
+	next := RoundTripFunc(c.httpClient().Do)
+	for i := len(c.Middleware) - 1; i >= 0; i-- {
+		next = c.Middleware[i](next)
+	}
+	return next(req)
+}
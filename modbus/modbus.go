@@ -0,0 +1,345 @@
+/*
+Package modbus exposes sensors as Modbus TCP registers, so building management systems and PLCs
+can read and control the Touchline system without custom code.
+
+Each sensor occupies a block of Stride registers starting at sensor id × Stride, in both the
+input and the holding register tables. With DefaultMap, the registers of sensor n are
+
+	input register   n×10+0  room temperature × 10, signed
+	input register   n×10+1  target temperature × 10, signed
+	input register   n×10+2  mode (0 day, 1 night, 2 holiday)
+	input register   n×10+3  program (0 constant, 1-3 program 1-3)
+	input register   n×10+4  valve (0 closed, 1 open)
+	holding register n×10+0  target temperature × 10, signed, writable
+	holding register n×10+1  mode, writable
+	holding register n×10+2  program, writable
+
+Values not read from the controller, and registers outside the map, read as 0x8000.
+*/
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+//Value names usable in a register map
+const (
+	RoomTemperature   = "roomTemperature"
+	TargetTemperature = "targetTemperature"
+	Mode              = "mode"
+	Program           = "program"
+	Valve             = "valve"
+)
+
+//Invalid is the register value of values not read from the controller
+const Invalid = 0x8000
+
+//Map assigns sensor values to registers
+type Map struct {
+	//Stride is the number of registers reserved for each sensor
+	Stride int
+	//Scale multiplies temperatures before they are rounded to integers, e.g. 10 for tenths of
+	//degrees
+	Scale float64
+	//Input and Holding list the values of the input and holding registers of a sensor, by
+	//offset in its block. Empty names leave a register unused. Holding registers are
+	//writable, except for the room temperature and valve.
+	Input   []string
+	Holding []string
+}
+
+//DefaultMap is the documented register map of the package
+var DefaultMap = Map{
+	Stride:  10,
+	Scale:   10,
+	Input:   []string{RoomTemperature, TargetTemperature, Mode, Program, Valve},
+	Holding: []string{TargetTemperature, Mode, Program},
+}
+
+//Modbus function and exception codes
+const (
+	readHoldingRegisters   = 0x03
+	readInputRegisters     = 0x04
+	writeSingleRegister    = 0x06
+	writeMultipleRegisters = 0x10
+
+	illegalFunction    = 0x01
+	illegalAddress     = 0x02
+	illegalValue       = 0x03
+	deviceFailure      = 0x04
+	maxRegistersPerPDU = 125
+)
+
+//Gateway serves the registers over Modbus TCP. Values are taken from the latest poll of the
+//attached watcher, writes are sent to the controller through the client.
+type Gateway struct {
+	client    *roth.Client
+	registers Map
+
+	//WriteTimeout limits the time to apply a write, before a device failure is reported. 10
+	//seconds if zero.
+	WriteTimeout time.Duration
+	//OnError is called when a connection fails or a write is rejected
+	OnError func(err error)
+
+	mu      sync.Mutex
+	sensors map[int]roth.Sensor
+}
+
+//NewGateway creates a gateway for the client with the given register map
+func NewGateway(client *roth.Client, m Map) (*Gateway, error) {
+	if m.Stride <= 0 || len(m.Input) > m.Stride || len(m.Holding) > m.Stride {
+		return nil, fmt.Errorf("invalid register map: stride %d does not fit %d input and %d holding registers", m.Stride, len(m.Input), len(m.Holding))
+	}
+	if m.Scale == 0 {
+		m.Scale = 1
+	}
+	for _, name := range append(append([]string{}, m.Input...), m.Holding...) {
+		switch name {
+		case "", RoomTemperature, TargetTemperature, Mode, Program, Valve:
+		default:
+			return nil, fmt.Errorf("invalid register map: unknown value %q", name)
+		}
+	}
+	return &Gateway{client: client, registers: m, sensors: make(map[int]roth.Sensor)}, nil
+}
+
+//Attach updates the registers on every successful poll of the watcher
+func (g *Gateway) Attach(w *roth.Watcher) {
+	w.Subscribe(func(p roth.Poll) {
+		if p.Err != nil {
+			return
+		}
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		for _, s := range p.Sensors {
+			g.sensors[s.Id] = s
+		}
+	})
+}
+
+//ListenAndServe listens on the tcp address, e.g. ":502", and serves until the context is
+//cancelled
+func (g *Gateway) ListenAndServe(ctx context.Context, address string) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return g.Serve(ctx, l)
+}
+
+//Serve serves connections from the listener until the context is cancelled
+func (g *Gateway) Serve(ctx context.Context, l net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go g.serveConn(ctx, conn)
+	}
+}
+
+//serveConn handles the requests of a connection. Each request is a 7 byte MBAP header followed
+//by the PDU.
+func (g *Gateway) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	header := make([]byte, 7)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				g.report(err)
+			}
+			return
+		}
+		length := binary.BigEndian.Uint16(header[4:6])
+		if length < 2 || length > 254 {
+			g.report(fmt.Errorf("invalid modbus frame length %d", length))
+			return
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			g.report(err)
+			return
+		}
+
+		response := g.handle(ctx, pdu)
+		frame := make([]byte, 7, 7+len(response))
+		copy(frame, header[:4])
+		binary.BigEndian.PutUint16(frame[4:6], uint16(len(response)+1))
+		frame[6] = header[6]
+		if _, err := conn.Write(append(frame, response...)); err != nil {
+			g.report(err)
+			return
+		}
+	}
+}
+
+func (g *Gateway) report(err error) {
+	if g.OnError != nil {
+		g.OnError(err)
+	}
+}
+
+//handle executes a request PDU and returns the response PDU
+func (g *Gateway) handle(ctx context.Context, pdu []byte) []byte {
+	function := pdu[0]
+	exception := func(code byte) []byte {
+		return []byte{function | 0x80, code}
+	}
+
+	switch function {
+	case readHoldingRegisters, readInputRegisters:
+		if len(pdu) != 5 {
+			return exception(illegalValue)
+		}
+		address := int(binary.BigEndian.Uint16(pdu[1:3]))
+		count := int(binary.BigEndian.Uint16(pdu[3:5]))
+		if count < 1 || count > maxRegistersPerPDU {
+			return exception(illegalValue)
+		}
+		names := g.registers.Input
+		if function == readHoldingRegisters {
+			names = g.registers.Holding
+		}
+		response := []byte{function, byte(2 * count)}
+		for i := 0; i < count; i++ {
+			response = append(response, 0, 0)
+			binary.BigEndian.PutUint16(response[len(response)-2:], g.read(names, address+i))
+		}
+		return response
+
+	case writeSingleRegister:
+		if len(pdu) != 5 {
+			return exception(illegalValue)
+		}
+		address := int(binary.BigEndian.Uint16(pdu[1:3]))
+		if code := g.write(ctx, address, []uint16{binary.BigEndian.Uint16(pdu[3:5])}); code != 0 {
+			return exception(code)
+		}
+		return pdu
+
+	case writeMultipleRegisters:
+		if len(pdu) < 6 {
+			return exception(illegalValue)
+		}
+		address := int(binary.BigEndian.Uint16(pdu[1:3]))
+		count := int(binary.BigEndian.Uint16(pdu[3:5]))
+		if count < 1 || int(pdu[5]) != 2*count || len(pdu) != 6+2*count {
+			return exception(illegalValue)
+		}
+		values := make([]uint16, count)
+		for i := range values {
+			values[i] = binary.BigEndian.Uint16(pdu[6+2*i:])
+		}
+		if code := g.write(ctx, address, values); code != 0 {
+			return exception(code)
+		}
+		return pdu[:5]
+	}
+	return exception(illegalFunction)
+}
+
+//read returns the value of a register
+func (g *Gateway) read(names []string, address int) uint16 {
+	sensorID, offset := address/g.registers.Stride, address%g.registers.Stride
+	if offset >= len(names) {
+		return Invalid
+	}
+	g.mu.Lock()
+	s, ok := g.sensors[sensorID]
+	g.mu.Unlock()
+	if !ok {
+		return Invalid
+	}
+
+	switch names[offset] {
+	case RoomTemperature:
+		if s.Valid.Has(roth.FieldRoomTemperature) {
+			return g.encodeTemperature(s.RoomTemperature)
+		}
+	case TargetTemperature:
+		if s.Valid.Has(roth.FieldTargetTemperature) {
+			return g.encodeTemperature(s.TargetTemperature)
+		}
+	case Mode:
+		if s.Valid.Has(roth.FieldMode) {
+			return uint16(s.Mode)
+		}
+	case Program:
+		if s.Valid.Has(roth.FieldProgram) {
+			return uint16(s.Program)
+		}
+	case Valve:
+		if s.Valid.Has(roth.FieldRoomTemperature | roth.FieldTargetTemperature) {
+			return uint16(s.GetValveValue())
+		}
+	}
+	return Invalid
+}
+
+func (g *Gateway) encodeTemperature(t float32) uint16 {
+	return uint16(int16(math.Round(float64(t) * g.registers.Scale)))
+}
+
+//write applies writes to consecutive holding registers, returning a modbus exception code if
+//they failed
+func (g *Gateway) write(ctx context.Context, address int, values []uint16) byte {
+	timeout := g.WriteTimeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for i, value := range values {
+		sensorID, offset := (address+i)/g.registers.Stride, (address+i)%g.registers.Stride
+		if offset >= len(g.registers.Holding) {
+			return illegalAddress
+		}
+
+		var err error
+		switch g.registers.Holding[offset] {
+		case TargetTemperature:
+			t := float32(float64(int16(value)) / g.registers.Scale)
+			err = g.client.SetTargetTemperature(ctx, sensorID, t)
+		case Mode:
+			if !roth.Mode(value).Valid() {
+				return illegalValue
+			}
+			err = g.client.SetMode(ctx, sensorID, roth.Mode(value))
+		case Program:
+			if !roth.Program(value).Valid() {
+				return illegalValue
+			}
+			err = g.client.SetProgram(ctx, sensorID, roth.Program(value))
+		default:
+			return illegalAddress
+		}
+		if err != nil {
+			g.report(fmt.Errorf("write to register %d failed: %v", address+i, err))
+			return deviceFailure
+		}
+	}
+	return 0
+}
@@ -0,0 +1,213 @@
+package modbus_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/modbus"
+	"github.com/kvantetore/rothTouchline/rothtest"
+)
+
+//startGateway serves a gateway with DefaultMap for a fake controller, after one poll
+func startGateway(t *testing.T) (srv *rothtest.Server, address string, errs chan error) {
+	t.Helper()
+	srv = rothtest.NewServer(
+		roth.Sensor{Id: 0, Name: "Living room", RoomTemperature: 20.86, TargetTemperature: 21, Program: roth.Program1, Mode: roth.ModeDay},
+		roth.Sensor{Id: 1, Name: "Bedroom", RoomTemperature: -2.5, TargetTemperature: 17.5, Program: roth.ProgramConstant, Mode: roth.ModeNight},
+	)
+	t.Cleanup(srv.Close)
+	client := roth.NewClient(srv.URL, roth.WithLogger(roth.DiscardLogger))
+
+	g, err := modbus.NewGateway(client, modbus.DefaultMap)
+	if err != nil {
+		t.Fatalf("NewGateway: %v", err)
+	}
+	errs = make(chan error, 10)
+	g.OnError = func(err error) { errs <- err }
+	w := roth.NewWatcher(client, time.Minute)
+	g.Attach(w)
+	if p := w.Poll(context.Background()); p.Err != nil {
+		t.Fatalf("Poll: %v", p.Err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go g.Serve(ctx, l)
+	return srv, l.Addr().String(), errs
+}
+
+func dial(t *testing.T, address string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+//transact sends a request PDU for unit 1, and returns the response PDU after checking the MBAP
+//header
+func transact(t *testing.T, conn net.Conn, transaction uint16, pdu []byte) []byte {
+	t.Helper()
+	request := make([]byte, 7, 7+len(pdu))
+	binary.BigEndian.PutUint16(request[0:2], transaction)
+	binary.BigEndian.PutUint16(request[4:6], uint16(len(pdu)+1))
+	request[6] = 1
+	if _, err := conn.Write(append(request, pdu...)); err != nil {
+		t.Fatalf("write request: %v", err)
+	}
+
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatalf("read response header: %v", err)
+	}
+	if got := binary.BigEndian.Uint16(header[0:2]); got != transaction {
+		t.Errorf("got transaction %v, want %v", got, transaction)
+	}
+	if protocol := binary.BigEndian.Uint16(header[2:4]); protocol != 0 || header[6] != 1 {
+		t.Errorf("got protocol %v unit %v, want 0 and 1", protocol, header[6])
+	}
+	response := make([]byte, binary.BigEndian.Uint16(header[4:6])-1)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	return response
+}
+
+func TestGateway(t *testing.T) {
+	tests := []struct {
+		name    string
+		request []byte
+		want    []byte
+		//wantValues are the raw controller values after the request
+		wantValues map[string]string
+	}{
+		{
+			name:    "read input registers",
+			request: []byte{0x04, 0x00, 0x00, 0x00, 0x05},
+			want:    []byte{0x04, 10, 0x00, 0xd1, 0x00, 0xd2, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01},
+		},
+		{
+			name:    "read negative temperature",
+			request: []byte{0x04, 0x00, 0x0a, 0x00, 0x02},
+			want:    []byte{0x04, 4, 0xff, 0xe7, 0x00, 0xaf},
+		},
+		{
+			name:    "read holding registers",
+			request: []byte{0x03, 0x00, 0x0a, 0x00, 0x03},
+			want:    []byte{0x03, 6, 0x00, 0xaf, 0x00, 0x01, 0x00, 0x00},
+		},
+		{
+			name:    "read outside the map",
+			request: []byte{0x04, 0x00, 0x09, 0x00, 0x01},
+			want:    []byte{0x04, 2, 0x80, 0x00},
+		},
+		{
+			name:    "read of unknown sensor",
+			request: []byte{0x04, 0x00, 0x46, 0x00, 0x01},
+			want:    []byte{0x04, 2, 0x80, 0x00},
+		},
+		{
+			name:    "read no registers",
+			request: []byte{0x04, 0x00, 0x00, 0x00, 0x00},
+			want:    []byte{0x84, 0x03},
+		},
+		{
+			name:    "read too many registers",
+			request: []byte{0x03, 0x00, 0x00, 0x00, 0x7e},
+			want:    []byte{0x83, 0x03},
+		},
+		{
+			name:    "read with short request",
+			request: []byte{0x03, 0x00, 0x00},
+			want:    []byte{0x83, 0x03},
+		},
+		{
+			name:    "unsupported function",
+			request: []byte{0x01, 0x00, 0x00, 0x00, 0x01},
+			want:    []byte{0x81, 0x01},
+		},
+		{
+			name:       "write single register",
+			request:    []byte{0x06, 0x00, 0x00, 0x00, 0xe1},
+			want:       []byte{0x06, 0x00, 0x00, 0x00, 0xe1},
+			wantValues: map[string]string{"G0.SollTemp": "2250"},
+		},
+		{
+			name:       "write invalid mode",
+			request:    []byte{0x06, 0x00, 0x01, 0x00, 0x09},
+			want:       []byte{0x86, 0x03},
+			wantValues: map[string]string{"G0.OPMode": "0"},
+		},
+		{
+			name:    "write read only register",
+			request: []byte{0x06, 0x00, 0x05, 0x00, 0x01},
+			want:    []byte{0x86, 0x02},
+		},
+		{
+			name:       "write multiple registers",
+			request:    []byte{0x10, 0x00, 0x0a, 0x00, 0x03, 6, 0x00, 0xc8, 0x00, 0x00, 0x00, 0x02},
+			want:       []byte{0x10, 0x00, 0x0a, 0x00, 0x03},
+			wantValues: map[string]string{"G1.SollTemp": "2000", "G1.OPMode": "0", "G1.WeekProg": "2"},
+		},
+		{
+			name:       "write multiple with wrong byte count",
+			request:    []byte{0x10, 0x00, 0x0a, 0x00, 0x02, 6, 0x00, 0xc8, 0x00, 0x00, 0x00, 0x02},
+			want:       []byte{0x90, 0x03},
+			wantValues: map[string]string{"G1.SollTemp": "1750"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv, address, _ := startGateway(t)
+			conn := dial(t, address)
+
+			if got := transact(t, conn, 0x1234, test.request); !bytes.Equal(got, test.want) {
+				t.Errorf("got response % x, want % x", got, test.want)
+			}
+			for name, want := range test.wantValues {
+				if got, _ := srv.Value(name); got != want {
+					t.Errorf("got %v = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGatewayFraming(t *testing.T) {
+	_, address, errs := startGateway(t)
+
+	//several requests on one connection keep their transaction ids
+	conn := dial(t, address)
+	for transaction := uint16(1); transaction <= 3; transaction++ {
+		transact(t, conn, transaction, []byte{0x04, 0x00, 0x00, 0x00, 0x01})
+	}
+
+	//an invalid length closes the connection
+	conn = dial(t, address)
+	conn.Write([]byte{0x00, 0x01, 0x00, 0x00, 0x01, 0x00, 0x01})
+	if n, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("read %v bytes after an invalid frame, want the connection closed", n)
+	}
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("got a nil error reported")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("invalid frame not reported")
+	}
+}
@@ -0,0 +1,457 @@
+//Package mqtt bridges a controller to an MQTT broker. The values of every sensor are published as
+//retained json messages whenever they change, and target temperatures published to the set
+//topic of a sensor are written to the controller. It implements the subset of MQTT 3.1.1 it
+//needs, with QoS 0, so no client library is required.
+//
+//Topics, with the default prefix:
+//
+//	roth/status                 online or offline, retained, set by the will if the bridge dies
+//	roth/sensor/<id>            the sensor as json, retained
+//	roth/sensor/<id>/set        a target temperature to write, e.g. 21.5
+//
+//SetTopic replaces sensor/<id> with a template, so topics can carry the metadata of the sensors,
+//e.g. roth/1st/south/Bedroom.
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/internal/mqttwire"
+)
+
+//Bridge publishes the sensors polled by a watcher to a broker, and writes the target
+//temperatures received from it. It reconnects after the connection to the broker is lost, and
+//republishes every sensor when it does.
+type Bridge struct {
+	//Addr is the host:port of the broker
+	Addr     string
+	ClientID string
+	Username string
+	Password string
+	//Prefix is the first level of all topics, "roth" if empty
+	Prefix string
+	//KeepAlive is the interval of pings to the broker, 30 seconds if zero
+	KeepAlive time.Duration
+	//ReconnectDelay is the wait before reconnecting to the broker, 5 seconds if zero
+	ReconnectDelay time.Duration
+	//OnError is called when the connection to the broker fails, or a received value could not be
+	//written
+	OnError func(err error)
+
+	client *roth.Client
+
+	mu sync.Mutex
+	//last holds the last reading of every sensor, republished after reconnecting
+	last map[int]roth.Sensor
+	//pending holds the sensors to publish
+	pending map[int]roth.Sensor
+	notify  chan struct{}
+	//commands maps the set topics to the ids of their sensors
+	commands map[string]int
+	//topics holds the topic each sensor was last published on
+	topics   map[int]string
+	template *template.Template
+	stopped  bool
+	writes   sync.WaitGroup
+
+	runner roth.Runner
+}
+
+//NewBridge creates a bridge to the broker at addr, writing to the controller of client
+func NewBridge(addr string, client *roth.Client) *Bridge {
+	return &Bridge{
+		Addr:     addr,
+		client:   client,
+		last:     make(map[int]roth.Sensor),
+		pending:  make(map[int]roth.Sensor),
+		notify:   make(chan struct{}, 1),
+		commands: make(map[string]int),
+		topics:   make(map[int]string),
+	}
+}
+
+//TopicData is the data of the topic template, see SetTopic
+type TopicData struct {
+	Id   int
+	Name string
+	//Metadata is empty for sensors without metadata, see roth.Client.SetMetadata
+	roth.Metadata
+}
+
+//SetTopic sets the template of the sensor topics below the prefix, executed with TopicData, e.g.
+//{{.Floor}}/{{.Orientation}}/{{.Name}} or {{index .Labels "wing"}}/{{.Id}}. Empty levels, e.g. of
+//sensors without a floor, are published as _, and the wildcards + and # are replaced by _. When
+//the topic of a sensor changes, because its name or metadata changed, the retained message on
+//the old topic is cleared.
+func (b *Bridge) SetTopic(text string) error {
+	tmpl, err := template.New("topic").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return fmt.Errorf("invalid topic template: %v", err)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.template = tmpl
+	return nil
+}
+
+//Attach publishes the sensors read by every poll of the watcher: all of them the first time,
+//and afterwards the ones which changed
+func (b *Bridge) Attach(w *roth.Watcher) {
+	w.Subscribe(b.publishPoll)
+}
+
+func (b *Bridge) publishPoll(p roth.Poll) {
+	if p.Err != nil {
+		return
+	}
+	b.mu.Lock()
+	for _, s := range p.Sensors {
+		//metadata is replaced rather than modified, so a new pointer is new metadata
+		if last, ok := b.last[s.Id]; !ok || last.Metadata != s.Metadata {
+			b.pending[s.Id] = s
+		}
+		b.last[s.Id] = s
+	}
+	for _, c := range p.Changes {
+		b.pending[c.Current.Id] = b.last[c.Current.Id]
+	}
+	b.mu.Unlock()
+
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+}
+
+//Run connects to the broker and publishes until the context is cancelled, reconnecting
+//whenever the connection fails
+func (b *Bridge) Run(ctx context.Context) {
+	for {
+		err := b.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		b.report(err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(b.reconnectDelay()):
+		}
+	}
+}
+
+//Start publishes in the background, see Run. It accepts values from the broker again after
+//Stop.
+func (b *Bridge) Start(ctx context.Context) error {
+	b.mu.Lock()
+	b.stopped = false
+	b.mu.Unlock()
+	return b.runner.Start(ctx, b.Run)
+}
+
+//Stop ignores further values from the broker, waits until the values being written are
+//written, publishes the pending sensors and offline, and disconnects
+func (b *Bridge) Stop(ctx context.Context) error {
+	b.mu.Lock()
+	b.stopped = true
+	b.mu.Unlock()
+
+	written := make(chan struct{})
+	go func() {
+		b.writes.Wait()
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-ctx.Done():
+		b.runner.Stop(ctx)
+		return ctx.Err()
+	}
+	return b.runner.Stop(ctx)
+}
+
+//session connects to the broker, and publishes until the context is cancelled or the
+//connection fails
+func (b *Bridge) session(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", b.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	keepAlive := b.keepAlive()
+	clientID := b.ClientID
+	if clientID == "" {
+		clientID = fmt.Sprintf("roth-bridge-%d", time.Now().UnixNano()%1000000)
+	}
+	conn.SetDeadline(time.Now().Add(keepAlive))
+	err = mqttwire.WritePacket(conn, mqttwire.PacketConnect, mqttwire.ConnectPacket(mqttwire.ConnectOptions{
+		ClientID:    clientID,
+		Username:    b.Username,
+		Password:    b.Password,
+		KeepAlive:   int(keepAlive / time.Second),
+		WillTopic:   b.topic("status"),
+		WillMessage: "offline",
+	}))
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+	header, body, err := mqttwire.ReadPacket(reader)
+	if err != nil {
+		return err
+	}
+	if err := mqttwire.CheckConnAck(header, body); err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+
+	s := &session{conn: conn, timeout: keepAlive, subscribed: make(map[string]bool)}
+	if err := s.publish(b.topic("status"), []byte("online"), true); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	for id, sensor := range b.last {
+		b.pending[id] = sensor
+	}
+	b.mu.Unlock()
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- b.read(reader, conn, keepAlive)
+	}()
+	ping := time.NewTicker(keepAlive)
+	defer ping.Stop()
+	for {
+		if err := b.flush(s); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			if err := b.flush(s); err != nil {
+				return err
+			}
+			if err := s.publish(b.topic("status"), []byte("offline"), true); err != nil {
+				return err
+			}
+			return s.write(mqttwire.PacketDisconnect, nil)
+		case <-b.notify:
+		case <-ping.C:
+			if err := s.write(mqttwire.PacketPingReq, nil); err != nil {
+				return err
+			}
+		case err := <-readErr:
+			return err
+		}
+	}
+}
+
+//flush publishes the pending sensors, and subscribes to their set topics
+func (b *Bridge) flush(s *session) error {
+	b.mu.Lock()
+	pending := make([]roth.Sensor, 0, len(b.pending))
+	for _, sensor := range b.pending {
+		pending = append(pending, sensor)
+	}
+	b.pending = make(map[int]roth.Sensor)
+	b.mu.Unlock()
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Id < pending[j].Id })
+
+	for i, sensor := range pending {
+		topic := b.sensorTopic(sensor)
+		payload, err := json.Marshal(sensor)
+		if err != nil {
+			b.report(fmt.Errorf("error encoding sensor %v: %v", sensor.Id, err))
+			continue
+		}
+		err = b.move(s, sensor.Id, topic)
+		if err == nil {
+			err = s.publish(topic, payload, true)
+		}
+		if err == nil {
+			err = b.subscribe(s, topic+"/set", sensor.Id)
+		}
+		if err != nil {
+			//publish the rest again once reconnected
+			b.mu.Lock()
+			for _, sensor := range pending[i:] {
+				if _, ok := b.pending[sensor.Id]; !ok {
+					b.pending[sensor.Id] = sensor
+				}
+			}
+			b.mu.Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
+//move clears the retained message of a sensor, and forgets its set topic, if the sensor was
+//published on another topic before
+func (b *Bridge) move(s *session, sensorID int, topic string) error {
+	b.mu.Lock()
+	old, ok := b.topics[sensorID]
+	b.topics[sensorID] = topic
+	if ok && old != topic {
+		delete(b.commands, old+"/set")
+	}
+	b.mu.Unlock()
+	if !ok || old == topic {
+		return nil
+	}
+	return s.publish(old, nil, true)
+}
+
+//subscribe subscribes to the set topic of a sensor, unless already subscribed in this session
+func (b *Bridge) subscribe(s *session, topic string, sensorID int) error {
+	b.mu.Lock()
+	b.commands[topic] = sensorID
+	b.mu.Unlock()
+	if s.subscribed[topic] {
+		return nil
+	}
+	s.packetID++
+	if s.packetID == 0 {
+		s.packetID = 1
+	}
+	if err := s.write(mqttwire.PacketSubscribe, mqttwire.SubscribePacket(s.packetID, topic)); err != nil {
+		return err
+	}
+	s.subscribed[topic] = true
+	return nil
+}
+
+//read handles the packets sent by the broker, until the connection fails. The broker is
+//considered gone if it does not send anything, not even a ping response, for twice the keep
+//alive interval.
+func (b *Bridge) read(r *bufio.Reader, conn net.Conn, keepAlive time.Duration) error {
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * keepAlive))
+		header, body, err := mqttwire.ReadPacket(r)
+		if err != nil {
+			return err
+		}
+		if header&0xf0 != mqttwire.PacketPublish {
+			//acknowledgements and ping responses
+			continue
+		}
+		topic, payload, err := mqttwire.ParsePublish(header, body)
+		if err != nil {
+			return err
+		}
+		b.receive(topic, payload)
+	}
+}
+
+//receive writes a target temperature received on the set topic of a sensor
+func (b *Bridge) receive(topic string, payload []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sensorID, ok := b.commands[topic]
+	if !ok || b.stopped {
+		return
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(string(payload)), 32)
+	if err != nil {
+		b.report(fmt.Errorf("invalid value %q on %v", payload, topic))
+		return
+	}
+	b.writes.Add(1)
+	go func() {
+		defer b.writes.Done()
+		//not cancelled by Stop, which waits for the write instead
+		ctx := roth.WithOrigin(context.Background(), "mqtt")
+		if err := b.client.SetTargetTemperature(ctx, sensorID, float32(value)); err != nil {
+			b.report(fmt.Errorf("error writing %v from %v: %v", value, topic, err))
+		}
+	}()
+}
+
+func (b *Bridge) report(err error) {
+	if b.OnError != nil {
+		b.OnError(err)
+	}
+}
+
+//topic returns a topic below the prefix
+func (b *Bridge) topic(name string) string {
+	prefix := b.Prefix
+	if prefix == "" {
+		prefix = "roth"
+	}
+	return prefix + "/" + name
+}
+
+//sensorTopic returns the topic of a sensor
+func (b *Bridge) sensorTopic(s roth.Sensor) string {
+	b.mu.Lock()
+	tmpl := b.template
+	b.mu.Unlock()
+	if tmpl == nil {
+		return b.topic("sensor/" + strconv.Itoa(s.Id))
+	}
+
+	data := TopicData{Id: s.Id, Name: s.Name}
+	if s.Metadata != nil {
+		data.Metadata = *s.Metadata
+	}
+	var text strings.Builder
+	if err := tmpl.Execute(&text, data); err != nil {
+		b.report(fmt.Errorf("error in topic of sensor %v: %v", s.Id, err))
+		return b.topic("sensor/" + strconv.Itoa(s.Id))
+	}
+	levels := strings.Split(text.String(), "/")
+	for i, level := range levels {
+		level = strings.NewReplacer("+", "_", "#", "_", "\x00", "_").Replace(strings.TrimSpace(level))
+		if level == "" {
+			level = "_"
+		}
+		levels[i] = level
+	}
+	return b.topic(strings.Join(levels, "/"))
+}
+
+func (b *Bridge) keepAlive() time.Duration {
+	if b.KeepAlive <= 0 {
+		return 30 * time.Second
+	}
+	return b.KeepAlive
+}
+
+func (b *Bridge) reconnectDelay() time.Duration {
+	if b.ReconnectDelay <= 0 {
+		return 5 * time.Second
+	}
+	return b.ReconnectDelay
+}
+
+//session is a connection to the broker. Packets are only written by the goroutine running the
+//session.
+type session struct {
+	conn       net.Conn
+	timeout    time.Duration
+	packetID   uint16
+	subscribed map[string]bool
+}
+
+func (s *session) write(header byte, body []byte) error {
+	s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	return mqttwire.WritePacket(s.conn, header, body)
+}
+
+func (s *session) publish(topic string, payload []byte, retain bool) error {
+	header, body := mqttwire.PublishPacket(topic, payload, retain)
+	return s.write(header, body)
+}
@@ -0,0 +1,251 @@
+package mqtt_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/internal/mqttwire"
+	"github.com/kvantetore/rothTouchline/mqtt"
+	"github.com/kvantetore/rothTouchline/rothtest"
+)
+
+//packet is a packet received by the fake broker
+type packet struct {
+	header byte
+	body   []byte
+}
+
+//fakeBroker accepts connections, acknowledges them, and passes every other packet received to
+//packets
+type fakeBroker struct {
+	t        *testing.T
+	listener net.Listener
+	packets  chan packet
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	b := &fakeBroker{t: t, listener: l, packets: make(chan packet, 100)}
+	t.Cleanup(func() { l.Close() })
+	go b.serve()
+	return b
+}
+
+func (b *fakeBroker) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.conn = conn
+		b.mu.Unlock()
+		go func() {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			for {
+				header, body, err := mqttwire.ReadPacket(r)
+				if err != nil {
+					return
+				}
+				switch header & 0xf0 {
+				case mqttwire.PacketConnect:
+					mqttwire.WritePacket(conn, mqttwire.PacketConnAck, []byte{0, 0})
+				case mqttwire.PacketPingReq:
+					mqttwire.WritePacket(conn, mqttwire.PacketPingResp, nil)
+					continue
+				}
+				b.packets <- packet{header, body}
+			}
+		}()
+	}
+}
+
+//next returns the next packet received
+func (b *fakeBroker) next() packet {
+	b.t.Helper()
+	select {
+	case p := <-b.packets:
+		return p
+	case <-time.After(5 * time.Second):
+		b.t.Fatal("broker received no packet")
+	}
+	return packet{}
+}
+
+//expectPublish checks that the next packet is a retained publish to topic, and returns its
+//payload
+func (b *fakeBroker) expectPublish(topic string) []byte {
+	b.t.Helper()
+	p := b.next()
+	if p.header != mqttwire.PacketPublish|0x01 {
+		b.t.Fatalf("got packet %#x, want a retained publish to %v", p.header, topic)
+	}
+	got, payload, err := mqttwire.ParsePublish(p.header, p.body)
+	if err != nil || got != topic {
+		b.t.Fatalf("got publish to %q (%v), want %q", got, err, topic)
+	}
+	return payload
+}
+
+func (b *fakeBroker) expectSubscribe(topic string) {
+	b.t.Helper()
+	p := b.next()
+	if p.header != mqttwire.PacketSubscribe || !strings.Contains(string(p.body), topic) {
+		b.t.Fatalf("got packet %#x % x, want a subscription to %v", p.header, p.body, topic)
+	}
+}
+
+//publish sends a message to the bridge
+func (b *fakeBroker) publish(topic, payload string) {
+	b.t.Helper()
+	b.mu.Lock()
+	conn := b.conn
+	b.mu.Unlock()
+	header, body := mqttwire.PublishPacket(topic, []byte(payload), false)
+	if err := mqttwire.WritePacket(conn, header, body); err != nil {
+		b.t.Fatalf("publish: %v", err)
+	}
+}
+
+func newBridge(t *testing.T, broker *fakeBroker, sensors ...roth.Sensor) (*rothtest.Server, *roth.Watcher, *mqtt.Bridge, chan error) {
+	t.Helper()
+	srv := rothtest.NewServer(sensors...)
+	t.Cleanup(srv.Close)
+	client := roth.NewClient(srv.URL, roth.WithLogger(roth.DiscardLogger))
+	w := roth.NewWatcher(client, time.Minute)
+
+	bridge := mqtt.NewBridge(broker.listener.Addr().String(), client)
+	errs := make(chan error, 10)
+	bridge.OnError = func(err error) { errs <- err }
+	bridge.Attach(w)
+	return srv, w, bridge, errs
+}
+
+func TestBridge(t *testing.T) {
+	broker := newFakeBroker(t)
+	srv, w, bridge, errs := newBridge(t, broker,
+		roth.Sensor{Id: 0, Name: "Living room", RoomTemperature: 20.86, TargetTemperature: 21, Program: roth.Program1, Mode: roth.ModeDay},
+		roth.Sensor{Id: 1, Name: "Bedroom", RoomTemperature: 18.5, TargetTemperature: 17.5, Program: roth.ProgramConstant, Mode: roth.ModeNight},
+	)
+	if p := w.Poll(context.Background()); p.Err != nil {
+		t.Fatalf("Poll: %v", p.Err)
+	}
+	if err := bridge.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	connect := broker.next()
+	if connect.header != mqttwire.PacketConnect || !strings.Contains(string(connect.body), "roth/status") {
+		t.Fatalf("got packet %#x % x, want a connect with a will on roth/status", connect.header, connect.body)
+	}
+	if status := broker.expectPublish("roth/status"); string(status) != "online" {
+		t.Errorf("got status %q, want online", status)
+	}
+	for _, want := range []struct {
+		topic  string
+		target float32
+	}{{"roth/sensor/0", 21}, {"roth/sensor/1", 17.5}} {
+		var s roth.Sensor
+		if err := json.Unmarshal(broker.expectPublish(want.topic), &s); err != nil || s.TargetTemperature != want.target {
+			t.Errorf("got sensor %+v (%v) on %v, want target %v", s, err, want.topic, want.target)
+		}
+		broker.expectSubscribe(want.topic + "/set")
+	}
+
+	//only the sensors which changed are published again
+	srv.SetValue("G1.SollTemp", "1900")
+	if p := w.Poll(context.Background()); p.Err != nil {
+		t.Fatalf("Poll: %v", p.Err)
+	}
+	var s roth.Sensor
+	if err := json.Unmarshal(broker.expectPublish("roth/sensor/1"), &s); err != nil || s.TargetTemperature != 19 {
+		t.Errorf("got sensor %+v (%v), want target 19", s, err)
+	}
+
+	//values on the set topics are written to the controller
+	broker.publish("roth/sensor/0/set", "warm")
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "warm") {
+			t.Errorf("got error %v, want the invalid value", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("invalid value not reported")
+	}
+	broker.publish("roth/other/set", "30")
+	broker.publish("roth/sensor/0/set", " 22.5\n")
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		value, _ := srv.Value("G0.SollTemp")
+		if value == "2250" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got G0.SollTemp %q after the set message, want 2250", value)
+		}
+	}
+
+	//Stop publishes offline and disconnects
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := bridge.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if status := broker.expectPublish("roth/status"); string(status) != "offline" {
+		t.Errorf("got status %q, want offline", status)
+	}
+	if p := broker.next(); p.header != mqttwire.PacketDisconnect {
+		t.Errorf("got packet %#x, want disconnect", p.header)
+	}
+}
+
+func TestBridgeTopicTemplate(t *testing.T) {
+	broker := newFakeBroker(t)
+	srv, w, bridge, _ := newBridge(t, broker,
+		roth.Sensor{Id: 0, Name: "Living+room", TargetTemperature: 21},
+	)
+	if err := bridge.SetTopic("{{.Floor}}/{{.Name}}"); err != nil {
+		t.Fatalf("SetTopic: %v", err)
+	}
+	if err := bridge.SetTopic("{{.Name"); err == nil {
+		t.Error("invalid template accepted")
+	}
+	if p := w.Poll(context.Background()); p.Err != nil {
+		t.Fatalf("Poll: %v", p.Err)
+	}
+	if err := bridge.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer bridge.Stop(context.Background())
+
+	broker.next()
+	broker.expectPublish("roth/status")
+	//empty levels and wildcards are replaced by _
+	broker.expectPublish("roth/_/Living_room")
+	broker.expectSubscribe("roth/_/Living_room/set")
+
+	//a renamed sensor moves, clearing the retained message on the old topic
+	srv.SetValue("G0.name", "Lounge")
+	if p := w.Poll(context.Background()); p.Err != nil {
+		t.Fatalf("Poll: %v", p.Err)
+	}
+	if payload := broker.expectPublish("roth/_/Living_room"); len(payload) != 0 {
+		t.Errorf("got %q on the old topic, want it cleared", payload)
+	}
+	broker.expectPublish("roth/_/Lounge")
+	broker.expectSubscribe("roth/_/Lounge/set")
+}
@@ -0,0 +1,276 @@
+//Package occupancy heats rooms for the bookings of an iCalendar feed, e.g. the booking calendar
+//of a holiday home: rooms are kept at a setback temperature, and raised to the comfort
+//temperature ahead of every booking, early enough to be warm on arrival, using the heating rates
+//learned by preheat.
+package occupancy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/ical"
+)
+
+//Room is a room heated for bookings
+type Room struct {
+	SensorID int     `json:"sensor"`
+	Comfort  float32 `json:"comfort"`
+	Setback  float32 `json:"setback"`
+}
+
+//RateSource provides the heating rate of rooms in °C per hour, like a preheat.Scheduler
+//learning them from history
+type RateSource interface {
+	Rate(sensorID int) float64
+}
+
+//Heater switches rooms between comfort and setback temperatures around the bookings of a feed
+type Heater struct {
+	client *roth.Client
+	feed   string
+
+	//Rooms are the rooms heated for bookings
+	Rooms []Room
+	//Rates provides the heating rates for the pre-heat lead time. If nil, or for rates which are
+	//not above 0, DefaultRate is used.
+	Rates RateSource
+	//DefaultRate is the heating rate in °C per hour assumed without a rate from Rates. It must
+	//be above 0.
+	DefaultRate float64
+	//MaxLead limits how early pre-heating may start. Rooms without a room temperature reading
+	//are pre-heated MaxLead ahead.
+	MaxLead time.Duration
+	//CheckIn and CheckOut are the times of day, as hh:mm, at which bookings given as whole days
+	//begin and end, as exported by booking platforms
+	CheckIn  string
+	CheckOut string
+	//Ignore lists texts of events which are no bookings, e.g. "Not available" for blocked days.
+	//Events whose summary contains any of them, compared case insensitively, are skipped.
+	Ignore []string
+	//RefreshInterval is how often the feed is read again
+	RefreshInterval time.Duration
+	//HTTPClient is used to read the feed. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	//OnChange is called when the heater writes a setpoint
+	OnChange func(sensorID int, setpoint float32, reason string)
+	//OnError is called when the feed could not be read, in which case the bookings read before
+	//are kept, and by Attach when a setpoint could not be written
+	OnError func(err error)
+
+	mu       sync.Mutex
+	calendar *ical.Calendar
+	readAt   time.Time
+}
+
+//NewHeater creates a heater for the bookings of a feed, given as http, https or webcal url or as
+//file, see ical.Load. Bookings given as whole days check in at 15:00 and out at 11:00, the feed
+//is read every 30 minutes, and pre-heating starts at most 12 hours ahead, at 0.5 °C per hour
+//unless rates are set.
+func NewHeater(client *roth.Client, feed string, rooms ...Room) *Heater {
+	return &Heater{
+		client:          client,
+		feed:            feed,
+		Rooms:           rooms,
+		DefaultRate:     0.5,
+		MaxLead:         12 * time.Hour,
+		CheckIn:         "15:00",
+		CheckOut:        "11:00",
+		RefreshInterval: 30 * time.Minute,
+	}
+}
+
+//Refresh reads the feed
+func (h *Heater) Refresh(ctx context.Context) error {
+	calendar, err := ical.Load(ctx, h.HTTPClient, h.feed)
+	if err != nil {
+		return fmt.Errorf("error reading booking feed: %v", err)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.calendar, h.readAt = calendar, time.Now()
+	return nil
+}
+
+//Booking is the period a booking occupies the rooms
+type Booking struct {
+	Summary string
+	Arrival time.Time
+	//Departure is when the rooms are vacated, exclusive
+	Departure time.Time
+}
+
+//Bookings returns the bookings overlapping the period from from to to, as of the last read of
+//the feed, ordered by arrival
+func (h *Heater) Bookings(from, to time.Time) ([]Booking, error) {
+	checkIn, err := parseClock(h.CheckIn)
+	if err != nil {
+		return nil, err
+	}
+	checkOut, err := parseClock(h.CheckOut)
+	if err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	calendar := h.calendar
+	h.mu.Unlock()
+	if calendar == nil {
+		return nil, nil
+	}
+
+	var bookings []Booking
+	//whole day events are widened by a day, as they begin after check in and end after check out
+	for _, e := range calendar.Occurrences(from.AddDate(0, 0, -1), to.AddDate(0, 0, 1)) {
+		if h.ignored(e.Summary) {
+			continue
+		}
+		b := Booking{Summary: e.Summary, Arrival: e.Start, Departure: e.End}
+		if e.AllDay {
+			b.Arrival = e.Start.Add(checkIn)
+			b.Departure = e.End.Add(checkOut)
+		}
+		if b.Departure.After(from) && b.Arrival.Before(to) {
+			bookings = append(bookings, b)
+		}
+	}
+	return bookings, nil
+}
+
+func (h *Heater) ignored(summary string) bool {
+	for _, text := range h.Ignore {
+		if text != "" && strings.Contains(strings.ToLower(summary), strings.ToLower(text)) {
+			return true
+		}
+	}
+	return false
+}
+
+//Setpoint returns the setpoint a sensor should have at the given time, and the reason. ok is
+//false if the sensor is in no room. Pre-heating starts as early as the room needs to reach the
+//comfort temperature by arrival at its heating rate.
+func (h *Heater) Setpoint(sensor roth.Sensor, now time.Time) (setpoint float32, reason string, ok bool) {
+	var room *Room
+	for i := range h.Rooms {
+		if h.Rooms[i].SensorID == sensor.Id {
+			room = &h.Rooms[i]
+		}
+	}
+	if room == nil {
+		return 0, "", false
+	}
+
+	//once pre-heating started, it continues, as the lead shrinks while the room warms up
+	lead := h.MaxLead
+	preheating := sensor.Valid.Has(roth.FieldTargetTemperature) && !h.client.TargetDiffers(sensor.Id, sensor.TargetTemperature, room.Comfort)
+	if sensor.Valid.Has(roth.FieldRoomTemperature) && !preheating {
+		lead = 0
+		if sensor.RoomTemperature < room.Comfort {
+			//without a positive rate, the lead can not be estimated, and MaxLead is used
+			lead = h.MaxLead
+			if rate := h.rate(sensor.Id); rate > 0 {
+				lead = time.Duration(float64(room.Comfort-sensor.RoomTemperature) / rate * float64(time.Hour))
+			}
+		}
+		if lead > h.MaxLead {
+			lead = h.MaxLead
+		}
+	}
+
+	bookings, err := h.Bookings(now, now.Add(lead+time.Minute))
+	if err != nil {
+		return room.Setback, "setback", true
+	}
+	for _, b := range bookings {
+		if !now.Before(b.Arrival) {
+			return room.Comfort, "booking " + b.Summary, true
+		}
+		return room.Comfort, fmt.Sprintf("pre-heating %v ahead of booking %v", lead.Round(time.Minute), b.Summary), true
+	}
+	return room.Setback, "setback", true
+}
+
+//rate returns the heating rate of a sensor in °C per hour, from Rates if it has a positive one
+func (h *Heater) rate(sensorID int) float64 {
+	if h.Rates != nil {
+		if rate := h.Rates.Rate(sensorID); rate > 0 {
+			return rate
+		}
+	}
+	return h.DefaultRate
+}
+
+//Evaluate reads the feed if due, and writes the setpoint of every room whose current target
+//differs at the resolution of the client. The writes have the origin occupancy, unless the
+//context has an origin already.
+func (h *Heater) Evaluate(ctx context.Context, sensors []roth.Sensor, now time.Time) error {
+	if h.DefaultRate <= 0 {
+		return fmt.Errorf("invalid default heating rate %v: must be above 0", h.DefaultRate)
+	}
+	if _, err := parseClock(h.CheckIn); err != nil {
+		return err
+	}
+	if _, err := parseClock(h.CheckOut); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	loaded := h.calendar != nil
+	due := !loaded || now.Sub(h.readAt) >= h.RefreshInterval
+	h.mu.Unlock()
+	if due {
+		if err := h.Refresh(ctx); err != nil {
+			//without any bookings read, rooms are left alone rather than set back
+			if !loaded {
+				return err
+			}
+			if h.OnError != nil {
+				h.OnError(err)
+			}
+		}
+	}
+
+	if roth.OriginFromContext(ctx) == "" {
+		ctx = roth.WithOrigin(ctx, "occupancy")
+	}
+	var firstErr error
+	for _, sensor := range sensors {
+		setpoint, reason, ok := h.Setpoint(sensor, now)
+		if !ok || (sensor.Valid.Has(roth.FieldTargetTemperature) && !h.client.TargetDiffers(sensor.Id, sensor.TargetTemperature, setpoint)) {
+			continue
+		}
+		if err := h.client.SetTargetTemperature(ctx, sensor.Id, setpoint); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if h.OnChange != nil {
+			h.OnChange(sensor.Id, setpoint, reason)
+		}
+	}
+	return firstErr
+}
+
+//Attach evaluates the bookings on every successful poll of the watcher
+func (h *Heater) Attach(w *roth.Watcher) {
+	w.Subscribe(func(p roth.Poll) {
+		if p.Err == nil {
+			if err := h.Evaluate(context.Background(), p.Sensors, p.Time); err != nil && h.OnError != nil {
+				h.OnError(err)
+			}
+		}
+	})
+}
+
+//parseClock parses hh:mm into the duration since midnight
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected hh:mm", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
@@ -0,0 +1,156 @@
+package occupancy_test
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/occupancy"
+	"github.com/kvantetore/rothTouchline/rothtest"
+)
+
+//bookings is a feed with a booking from march 1 to 3, checking in at 15:00 and out at 11:00, and
+//a blocked day
+const bookings = "BEGIN:VCALENDAR\r\n" +
+	"BEGIN:VEVENT\r\nUID:a\r\nSUMMARY:Smith\r\nDTSTART;VALUE=DATE:20240301\r\nDTEND;VALUE=DATE:20240303\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:b\r\nSUMMARY:Not available\r\nDTSTART;VALUE=DATE:20240310\r\nEND:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func startFeed(t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(bookings))
+	}))
+}
+
+//rates is a RateSource with the same rate for all rooms
+type rates float64
+
+func (r rates) Rate(int) float64 { return float64(r) }
+
+func TestSetpoint(t *testing.T) {
+	feed := startFeed(t)
+	defer feed.Close()
+
+	at := func(day, hour, minute int) time.Time { return time.Date(2024, 3, day, hour, minute, 0, 0, time.Local) }
+	cold := roth.Sensor{Id: 0, RoomTemperature: 20.5, TargetTemperature: 16, Valid: roth.AllFields}
+	tests := []struct {
+		name    string
+		sensor  roth.Sensor
+		comfort float32
+		rates   occupancy.RateSource
+		now     time.Time
+		want    float32
+		//wantReason is a text the reason contains
+		wantReason string
+	}{
+		{name: "during the booking", sensor: cold, now: at(2, 12, 0), want: 21, wantReason: "booking Smith"},
+		{name: "after check out", sensor: cold, now: at(3, 11, 0), want: 16, wantReason: "setback"},
+		{name: "before the lead", sensor: cold, now: at(1, 13, 30), want: 16, wantReason: "setback"},
+		{name: "within the lead", sensor: cold, now: at(1, 14, 30), want: 21, wantReason: "pre-heating 1h0m0s ahead"},
+		{name: "learned rate", sensor: cold, rates: rates(0.25), now: at(1, 13, 30), want: 21, wantReason: "pre-heating 2h0m0s ahead"},
+		{name: "zero learned rate uses the default", sensor: cold, rates: rates(0), now: at(1, 13, 30), want: 16},
+		{name: "invalid learned rate uses the default", sensor: cold, rates: rates(math.NaN()), now: at(1, 13, 30), want: 16},
+		{name: "no room temperature pre-heats at the maximum lead", sensor: roth.Sensor{Id: 0, Valid: roth.FieldTargetTemperature}, now: at(1, 3, 30), want: 21},
+		{name: "no room temperature before the maximum lead", sensor: roth.Sensor{Id: 0, Valid: roth.FieldTargetTemperature}, now: at(1, 2, 30), want: 16},
+		{
+			//the thermostat reports the comfort temperature rounded to the resolution, which still
+			//counts as pre-heating, so it continues although the room is nearly warm
+			name:    "pre-heating continues at the rounded comfort temperature",
+			sensor:  roth.Sensor{Id: 0, RoomTemperature: 21, TargetTemperature: 21.5, Valid: roth.AllFields},
+			comfort: 21.3,
+			now:     at(1, 12, 0),
+			want:    21.3,
+		},
+		{name: "ignored event", sensor: cold, now: at(10, 16, 0), want: 16, wantReason: "setback"},
+		{name: "unheated room", sensor: roth.Sensor{Id: 1, Valid: roth.AllFields}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := roth.NewClient("http://localhost", roth.WithTemperatureResolution(0.5, roth.RoundNearest))
+			comfort := test.comfort
+			if comfort == 0 {
+				comfort = 21
+			}
+			h := occupancy.NewHeater(client, feed.URL, occupancy.Room{SensorID: 0, Comfort: comfort, Setback: 16})
+			h.Rates = test.rates
+			h.Ignore = []string{"not available"}
+			if err := h.Refresh(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			setpoint, reason, ok := h.Setpoint(test.sensor, test.now)
+			if ok != (test.want != 0) || setpoint != test.want || !strings.Contains(reason, test.wantReason) {
+				t.Errorf("got setpoint %v (%q, %v), want %v (%q)", setpoint, reason, ok, test.want, test.wantReason)
+			}
+		})
+	}
+}
+
+func TestBookings(t *testing.T) {
+	feed := startFeed(t)
+	defer feed.Close()
+	h := occupancy.NewHeater(roth.NewClient("http://localhost"), feed.URL)
+	h.CheckIn, h.CheckOut = "16:00", "10:00"
+	if err := h.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := h.Bookings(time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local), time.Date(2024, 4, 1, 0, 0, 0, 0, time.Local))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %v bookings, want 2", len(got))
+	}
+	arrival := time.Date(2024, 3, 1, 16, 0, 0, 0, time.Local)
+	departure := time.Date(2024, 3, 3, 10, 0, 0, 0, time.Local)
+	if got[0].Summary != "Smith" || !got[0].Arrival.Equal(arrival) || !got[0].Departure.Equal(departure) {
+		t.Errorf("got booking %+v, want Smith from %v to %v", got[0], arrival, departure)
+	}
+
+	h.CheckIn = "4pm"
+	if _, err := h.Bookings(arrival, departure); err == nil {
+		t.Error("got no error for an invalid check in time")
+	}
+}
+
+func TestEvaluateWritesOnce(t *testing.T) {
+	feed := startFeed(t)
+	defer feed.Close()
+	srv := rothtest.NewServer(roth.Sensor{Id: 0, Name: "Cabin", RoomTemperature: 12, TargetTemperature: 16})
+	defer srv.Close()
+	client := roth.NewClient(srv.URL, roth.WithLogger(roth.DiscardLogger), roth.WithTemperatureResolution(0.5, roth.RoundNearest))
+
+	h := occupancy.NewHeater(client, feed.URL, occupancy.Room{SensorID: 0, Comfort: 21.3, Setback: 16})
+	writes := 0
+	h.OnChange = func(int, float32, string) { writes++ }
+
+	now := time.Date(2024, 3, 2, 12, 0, 0, 0, time.Local)
+	for i := 0; i < 3; i++ {
+		sensors, err := client.ForceRefresh(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := h.Evaluate(context.Background(), sensors, now); err != nil {
+			t.Fatalf("Evaluate: %v", err)
+		}
+	}
+	if writes != 1 {
+		t.Errorf("got %v writes, want 1", writes)
+	}
+	if got, _ := srv.Value("G0.SollTemp"); got != "2150" {
+		t.Errorf("got target %v, want 2150", got)
+	}
+
+	h.DefaultRate = 0
+	if err := h.Evaluate(context.Background(), nil, now); err == nil {
+		t.Error("Evaluate accepted a default rate of 0")
+	}
+}
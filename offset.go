@@ -0,0 +1,57 @@
+package roth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+//offsetDatapoint is the measurement offset of a thermostat, in centidegrees of its unit. Older
+//firmware does not have it; use Client.SoftwareOffsets for those thermostats.
+const offsetDatapoint = "RaumTempKorr"
+
+//GetTemperatureOffset returns the measurement offset configured on a thermostat, in the client
+//unit. The offset is added by the thermostat to the temperature it measures.
+func (c *Client) GetTemperatureOffset(ctx context.Context, sensorID int) (float32, error) {
+	name := fmt.Sprintf("G%v.%v", sensorID, offsetDatapoint)
+	resp, err := c.readValues(ctx, readRequest{Items: []readRequestItem{{Name: name}}})
+	if err != nil {
+		return 0, err
+	}
+
+	value, ok := resp.Value(name)
+	if !ok {
+		return 0, errors.New("no values returned")
+	}
+	intValue, err := strconv.ParseInt(value, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid temperature offset %q", value)
+	}
+	return convertDelta(float32(intValue)/100, c.units.get(sensorID), c.Unit), nil
+}
+
+//SetTemperatureOffset changes the measurement offset of a thermostat, given in the client unit,
+//e.g. -1.5 for a sensor reading 1.5 degrees high
+func (c *Client) SetTemperatureOffset(ctx context.Context, sensorID int, offset float32) error {
+	value := formatTemperature(convertDelta(offset, c.Unit, c.units.get(sensorID)))
+	return c.writeValue(ctx, sensorID, offsetDatapoint, value)
+}
+
+//convertDelta converts a temperature difference between units
+func convertDelta(d float32, from, to Unit) float32 {
+	return ConvertTemperature(d, from, to) - ConvertTemperature(0, from, to)
+}
+
+//applySoftwareOffsets adds the configured software offsets to the room temperature of freshly
+//read sensors, which are already in the client unit
+func (c *Client) applySoftwareOffsets(sensors []Sensor) {
+	if len(c.SoftwareOffsets) == 0 {
+		return
+	}
+	for i := range sensors {
+		if offset, ok := c.SoftwareOffsets[sensors[i].Id]; ok && sensors[i].Valid.Has(FieldRoomTemperature) {
+			sensors[i].RoomTemperature += offset
+		}
+	}
+}
@@ -0,0 +1,329 @@
+//Package openhab connects sensors to openHAB. It generates item and thing definitions for the
+//openHAB HTTP binding, serves the sensor states the binding polls and accepts its commands, and
+//can push state updates to openHAB's REST API as the sensors change.
+package openhab
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+//channel is a value of a sensor exposed to openHAB
+type channel struct {
+	name, itemType, label, format, category string
+	//writable channels accept commands
+	writable bool
+	state    func(s roth.Sensor) (string, bool)
+}
+
+var channels = []channel{
+	{"RoomTemperature", "Number:Temperature", "room temperature", "%.1f %unit%", "temperature", false, func(s roth.Sensor) (string, bool) {
+		return formatFloat(s.RoomTemperature), s.Valid.Has(roth.FieldRoomTemperature)
+	}},
+	{"TargetTemperature", "Number:Temperature", "target temperature", "%.1f %unit%", "heating", true, func(s roth.Sensor) (string, bool) {
+		return formatFloat(s.TargetTemperature), s.Valid.Has(roth.FieldTargetTemperature)
+	}},
+	{"Mode", "String", "mode", "%s", "heating", true, func(s roth.Sensor) (string, bool) {
+		return s.Mode.String(), s.Valid.Has(roth.FieldMode)
+	}},
+	{"Program", "String", "program", "%s", "heating", true, func(s roth.Sensor) (string, bool) {
+		return s.Program.String(), s.Valid.Has(roth.FieldProgram)
+	}},
+	{"Valve", "Switch", "valve", "%s", "radiator", false, func(s roth.Sensor) (string, bool) {
+		if s.GetValveState() == roth.ValveOpen {
+			return "ON", true
+		}
+		return "OFF", s.Valid.Has(roth.FieldRoomTemperature | roth.FieldTargetTemperature)
+	}},
+}
+
+func formatFloat(f float32) string {
+	return strconv.FormatFloat(float64(f), 'f', -1, 32)
+}
+
+//Options configures the generated definitions
+type Options struct {
+	//Prefix of the item names, "Roth" if empty
+	Prefix string
+	//Group the items are added to, none if empty
+	Group string
+	//BaseURL is the url of the Handler as reached from openHAB, e.g. http://gateway:8080/openhab
+	BaseURL string
+	//Refresh is the polling interval of the HTTP binding in seconds, 60 if zero
+	Refresh int
+}
+
+func (o Options) prefix() string {
+	if o.Prefix == "" {
+		return "Roth"
+	}
+	return o.Prefix
+}
+
+//ItemName returns the name of the item of a sensor value, e.g. Roth_3_RoomTemperature
+func ItemName(prefix string, sensorID int, value string) string {
+	return fmt.Sprintf("%v_%d_%v", prefix, sensorID, value)
+}
+
+//channelID is the id of the HTTP binding channel of a sensor value
+func channelID(sensorID int, value string) string {
+	return fmt.Sprintf("sensor%d_%v", sensorID, strings.ToLower(value))
+}
+
+//WriteItems writes an openHAB .items file for the sensors, linked to the channels of
+//WriteThings
+func WriteItems(w io.Writer, sensors []roth.Sensor, o Options) error {
+	prefix := o.prefix()
+	group := ""
+	if o.Group != "" {
+		group = fmt.Sprintf(" (%v)", o.Group)
+	}
+	for _, s := range sensors {
+		for _, c := range channels {
+			_, err := fmt.Fprintf(w, "%v %v \"%v %v [%v]\" <%v>%v { channel=\"http:url:%v:%v\" }\n",
+				c.itemType, ItemName(prefix, s.Id, c.name), s.Name, c.label, c.format, c.category, group,
+				strings.ToLower(prefix), channelID(s.Id, c.name))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//WriteThings writes an openHAB .things file defining an HTTP binding thing, polling the
+//Handler at BaseURL. Writable values send commands back to the Handler.
+func WriteThings(w io.Writer, sensors []roth.Sensor, o Options) error {
+	refresh := o.Refresh
+	if refresh == 0 {
+		refresh = 60
+	}
+	prefix := o.prefix()
+	_, err := fmt.Fprintf(w, "Thing http:url:%v \"Roth Touchline\" [ baseURL=\"%v\", refresh=%d, commandMethod=\"PUT\" ] {\n    Channels:\n",
+		strings.ToLower(prefix), strings.TrimSuffix(o.BaseURL, "/"), refresh)
+	if err != nil {
+		return err
+	}
+	for _, s := range sensors {
+		for _, c := range channels {
+			channelType := "string"
+			switch c.itemType {
+			case "Number:Temperature":
+				channelType = "number"
+			case "Switch":
+				channelType = "switch"
+			}
+			config := fmt.Sprintf("stateExtension=\"/state\", stateTransformation=\"JSONPATH:$.%v\"", ItemName(prefix, s.Id, c.name))
+			if c.writable {
+				config += fmt.Sprintf(", commandExtension=\"/sensor/%d/%v\"", s.Id, strings.ToLower(c.name))
+			} else if channelType == "switch" {
+				config += ", onValue=\"ON\", offValue=\"OFF\", mode=\"READONLY\""
+			} else {
+				config += ", mode=\"READONLY\""
+			}
+			_, err := fmt.Fprintf(w, "        Type %v : %v \"%v %v\" [ %v ]\n", channelType, channelID(s.Id, c.name), s.Name, c.label, config)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, err = fmt.Fprintln(w, "}")
+	return err
+}
+
+//States returns the states of the sensor values by item name. Values not read from the
+//controller are left out.
+func States(prefix string, sensors []roth.Sensor) map[string]string {
+	states := make(map[string]string)
+	for _, s := range sensors {
+		for _, c := range channels {
+			if state, ok := c.state(s); ok {
+				states[ItemName(prefix, s.Id, c.name)] = state
+			}
+		}
+	}
+	return states
+}
+
+/*
+Handler serves the sensor states polled by the HTTP binding as a json object keyed by item name
+at /state, and applies the commands sent to /sensor/<id>/targettemperature, /sensor/<id>/mode
+and /sensor/<id>/program. States are taken from the latest poll of the attached watcher.
+
+	http.Handle("/openhab/", http.StripPrefix("/openhab", openhab.NewHandler(client, "Roth")))
+*/
+type Handler struct {
+	client *roth.Client
+	prefix string
+
+	mu     sync.Mutex
+	states map[string]string
+}
+
+//NewHandler creates a handler writing commands to the client. Prefix is the prefix of the item
+//names, as in Options.
+func NewHandler(client *roth.Client, prefix string) *Handler {
+	if prefix == "" {
+		prefix = "Roth"
+	}
+	return &Handler{client: client, prefix: prefix, states: make(map[string]string)}
+}
+
+//Attach updates the states on every successful poll of the watcher
+func (h *Handler) Attach(w *roth.Watcher) {
+	w.Subscribe(func(p roth.Poll) {
+		if p.Err != nil {
+			return
+		}
+		states := States(h.prefix, p.Sensors)
+		h.mu.Lock()
+		h.states = states
+		h.mu.Unlock()
+	})
+}
+
+//ServeHTTP serves the states and applies commands
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/state" {
+		h.mu.Lock()
+		body, err := json.Marshal(h.states)
+		h.mu.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "sensor" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sensorID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1024))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.command(r.Context(), sensorID, parts[2], strings.TrimSpace(string(body))); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//command applies a command sent by openHAB. Temperatures may carry a unit, e.g. "21.5 °C".
+func (h *Handler) command(ctx context.Context, sensorID int, value string, command string) error {
+	switch value {
+	case "targettemperature":
+		fields := strings.Fields(command)
+		if len(fields) == 0 {
+			return fmt.Errorf("invalid temperature %q", command)
+		}
+		t, err := strconv.ParseFloat(fields[0], 32)
+		if err != nil {
+			return fmt.Errorf("invalid temperature %q", command)
+		}
+		return h.client.SetTargetTemperature(ctx, sensorID, float32(t))
+	case "mode":
+		mode, err := roth.ParseMode(command)
+		if err != nil {
+			return err
+		}
+		return h.client.SetMode(ctx, sensorID, mode)
+	case "program":
+		program, err := roth.ParseProgram(command)
+		if err != nil {
+			return err
+		}
+		return h.client.SetProgram(ctx, sensorID, program)
+	}
+	return fmt.Errorf("%v is not writable", value)
+}
+
+//Publisher pushes state updates of changed sensors to the openHAB REST API, for items not
+//linked to the HTTP binding
+type Publisher struct {
+	//URL of openHAB, e.g. http://openhab:8080
+	URL string
+	//Token is an openHAB API token, sent as a bearer token if set
+	Token string
+	//Prefix of the item names, "Roth" if empty
+	Prefix string
+	//HTTPClient is used for the requests. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+	//OnError is called when a state could not be updated
+	OnError func(item string, err error)
+}
+
+//Attach pushes the changed values found by every poll of the watcher
+func (p *Publisher) Attach(w *roth.Watcher) {
+	w.Subscribe(func(poll roth.Poll) {
+		var changed []roth.Sensor
+		for _, c := range poll.Changes {
+			changed = append(changed, c.Current)
+		}
+		p.Publish(context.Background(), changed...)
+	})
+}
+
+//Publish updates the states of all values of the sensors
+func (p *Publisher) Publish(ctx context.Context, sensors ...roth.Sensor) {
+	prefix := p.Prefix
+	if prefix == "" {
+		prefix = "Roth"
+	}
+	for item, state := range States(prefix, sensors) {
+		if err := p.update(ctx, item, state); err != nil && p.OnError != nil {
+			p.OnError(item, err)
+		}
+	}
+}
+
+func (p *Publisher) update(ctx context.Context, item string, state string) error {
+	url := fmt.Sprintf("%v/rest/items/%v/state", strings.TrimSuffix(p.URL, "/"), item)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader([]byte(state)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+
+	client := p.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("openhab returned %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package roth
+
+import (
+	"net/http"
+	"time"
+)
+
+//Option configures a Client created by NewClient. Options are applied in order, and set the
+//corresponding exported fields of the client.
+type Option func(c *Client)
+
+//WithTimeout limits the duration of each request to the controller
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.Timeout = timeout
+	}
+}
+
+//WithRetry repeats failed requests up to retries times, waiting backoff before the first retry
+//and doubling the wait for every following one
+func WithRetry(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.Retries = retries
+		c.RetryBackoff = backoff
+	}
+}
+
+//WithCircuitBreaker stops sending requests to the controller for cooldown after threshold
+//consecutive requests failed, see Client.BreakerThreshold
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(c *Client) {
+		c.BreakerThreshold = threshold
+		c.BreakerCooldown = cooldown
+	}
+}
+
+//WithLogger sets the logger receiving diagnostic messages
+func WithLogger(logger Logger) Option {
+	return func(c *Client) {
+		c.Logger = logger
+	}
+}
+
+//WithCache makes reads return the previous result if it is younger than ttl
+func WithCache(ttl time.Duration) Option {
+	return func(c *Client) {
+		c.CacheTTL = ttl
+	}
+}
+
+//WithHTTPClient sets the http client used for all requests
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.HTTPClient = client
+	}
+}
+
+//WithUnit sets the unit of all temperatures read and written through the client
+func WithUnit(unit Unit) Option {
+	return func(c *Client) {
+		c.Unit = unit
+	}
+}
+
+//WithFallbackURLs adds alternative base urls of the controller
+func WithFallbackURLs(urls ...string) Option {
+	return func(c *Client) {
+		c.FallbackURLs = append(c.FallbackURLs, urls...)
+	}
+}
+
+//WithBasicAuth sets the credentials sent with every request
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) {
+		c.Username = username
+		c.Password = password
+	}
+}
+
+//WithTelemetry sets the tracer and meter instrumenting reads and writes. Either may be nil.
+func WithTelemetry(tracer Tracer, meter Meter) Option {
+	return func(c *Client) {
+		c.Tracer = tracer
+		c.Meter = meter
+	}
+}
+
+//WithMiddleware adds middleware wrapping every request to the controller
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(c *Client) {
+		c.Middleware = append(c.Middleware, middleware...)
+	}
+}
+
+//WithHooks adds callbacks observing every request to the controller
+func WithHooks(hooks Hooks) Option {
+	return WithMiddleware(hooks.Middleware())
+}
+
+//WithStrict makes GetSensors fail if any value in the response can not be parsed
+func WithStrict() Option {
+	return func(c *Client) {
+		c.Strict = true
+	}
+}
+
+//WithDryRun logs and publishes writes instead of sending them, see Client.DryRun
+func WithDryRun() Option {
+	return func(c *Client) {
+		c.DryRun = true
+	}
+}
+
+//WithAudit records every write in the sink
+func WithAudit(sink AuditSink) Option {
+	return func(c *Client) {
+		c.Audit = sink
+	}
+}
+
+//WithLastKnownGood serves the last values read, marked stale, while the controller is
+//unreachable, see Client.LastKnownGood
+func WithLastKnownGood(maxAge time.Duration) Option {
+	return func(c *Client) {
+		c.LastKnownGood = maxAge
+	}
+}
+
+//WithAlarms reads the alarms of the thermostats, see Client.ReadAlarms
+func WithAlarms() Option {
+	return func(c *Client) {
+		c.ReadAlarms = true
+	}
+}
+
+//WithTemperatureResolution rounds target temperatures to steps of the given size, see
+//Client.TemperatureResolution
+func WithTemperatureResolution(step float32, rounding Rounding) Option {
+	return func(c *Client) {
+		c.TemperatureResolution = step
+		c.Rounding = rounding
+	}
+}
+
+//WithDebugCapture keeps the most recent size requests and responses, see Client.DebugCapture
+func WithDebugCapture(size int) Option {
+	return func(c *Client) {
+		c.DebugCapture = size
+	}
+}
+
+//WithNameFallback reads missing sensor names from the device list page at the given path, or
+//at DefaultDeviceListPath if empty, see Client.NameFallback
+func WithNameFallback(path string) Option {
+	return func(c *Client) {
+		c.NameFallback = true
+		c.DeviceListPath = path
+	}
+}
+
+//WithConcurrentReads requests up to concurrency chunks of a large read in parallel, limiting the
+//whole read to deadline if it is not zero
+func WithConcurrentReads(concurrency int, deadline time.Duration) Option {
+	return func(c *Client) {
+		c.ReadConcurrency = concurrency
+		c.ReadDeadline = deadline
+	}
+}
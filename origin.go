@@ -0,0 +1,36 @@
+package roth
+
+import (
+	"sync"
+	"time"
+)
+
+//writeOrigins holds the origin of the last write to each sensor, so changes seen by a Watcher
+//can be attributed to the writes causing them
+type writeOrigins struct {
+	mu        sync.Mutex
+	bySensor  map[int]string
+	writtenAt map[int]time.Time
+}
+
+func (o *writeOrigins) record(sensorID int, origin string, at time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.bySensor == nil {
+		o.bySensor = make(map[int]string)
+		o.writtenAt = make(map[int]time.Time)
+	}
+	o.bySensor[sensorID] = origin
+	o.writtenAt[sensorID] = at
+}
+
+//since returns the origin of the last write to a sensor after the given time, or an empty string
+//if there was none, or it had no origin
+func (o *writeOrigins) since(sensorID int, t time.Time) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.writtenAt[sensorID].After(t) {
+		return o.bySensor[sensorID]
+	}
+	return ""
+}
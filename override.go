@@ -0,0 +1,171 @@
+package roth
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+//Override is a temporary target temperature, reverted when it expires
+type Override struct {
+	SensorID          int       `json:"sensor"`
+	TargetTemperature float32   `json:"targetTemperature"`
+	Start             time.Time `json:"start"`
+	Until             time.Time `json:"until"`
+	//PreviousTargetTemperature and PreviousProgram are restored when the override expires.
+	//Writing the program back resumes it on the wall unit.
+	PreviousTargetTemperature float32 `json:"previousTargetTemperature"`
+	PreviousProgram           Program `json:"previousProgram"`
+}
+
+//overridesKey is the storage key of the overrides
+const overridesKey = "overrides.json"
+
+//Overrides manages temporary overrides, like "boost the bathroom for 2 hours". Overrides are
+//persisted in a storage, so they are still reverted after a restart, and expired overrides are
+//reverted by Run.
+type Overrides struct {
+	client  *Client
+	storage Storage
+
+	//OnReverted is called when an override was reverted, with the error if reverting failed.
+	//Failed reverts are retried on the next check.
+	OnReverted func(o Override, err error)
+
+	mu        sync.Mutex
+	overrides map[int]Override
+}
+
+//NewOverrides creates an override manager persisting its state in the storage, and loads the
+//overrides stored there. If storage is nil, overrides are kept in memory only.
+func NewOverrides(client *Client, storage Storage) (*Overrides, error) {
+	if storage == nil {
+		storage = &MemoryStorage{}
+	}
+	o := &Overrides{client: client, storage: storage, overrides: make(map[int]Override)}
+
+	var overrides []Override
+	if _, err := LoadJSON(storage, overridesKey, &overrides); err != nil {
+		return nil, err
+	}
+	for _, override := range overrides {
+		o.overrides[override.SensorID] = override
+	}
+	return o, nil
+}
+
+//Override sets the target temperature of a sensor for the given duration. Overriding a sensor
+//which is already overridden extends the override, and keeps the original values to restore.
+func (o *Overrides) Override(ctx context.Context, sensorID int, targetTemperature float32, duration time.Duration) (Override, error) {
+	o.mu.Lock()
+	existing, overridden := o.overrides[sensorID]
+	o.mu.Unlock()
+
+	now := time.Now()
+	override := Override{SensorID: sensorID, TargetTemperature: targetTemperature, Start: now, Until: now.Add(duration)}
+	if overridden {
+		override.PreviousTargetTemperature = existing.PreviousTargetTemperature
+		override.PreviousProgram = existing.PreviousProgram
+	} else {
+		current, err := o.client.GetSensor(ctx, sensorID, FieldTargetTemperature|FieldProgram)
+		if err != nil {
+			return Override{}, err
+		}
+		override.PreviousTargetTemperature = current.TargetTemperature
+		override.PreviousProgram = current.Program
+	}
+
+	if err := o.client.SetTargetTemperature(ctx, sensorID, targetTemperature); err != nil {
+		return Override{}, err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.overrides[sensorID] = override
+	return override, o.save()
+}
+
+//Cancel reverts the override of a sensor now
+func (o *Overrides) Cancel(ctx context.Context, sensorID int) error {
+	o.mu.Lock()
+	override, ok := o.overrides[sensorID]
+	o.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return o.revert(ctx, override)
+}
+
+//Active returns the overrides not yet reverted, ordered by sensor id
+func (o *Overrides) Active() []Override {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	active := make([]Override, 0, len(o.overrides))
+	for _, override := range o.overrides {
+		active = append(active, override)
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].SensorID < active[j].SensorID })
+	return active
+}
+
+//Check reverts all overrides expired at the given time, including those which expired while the
+//program was not running
+func (o *Overrides) Check(ctx context.Context, now time.Time) {
+	for _, override := range o.Active() {
+		if !now.Before(override.Until) {
+			o.revert(ctx, override)
+		}
+	}
+}
+
+//revert restores the previous values of an override, and forgets it if successful
+func (o *Overrides) revert(ctx context.Context, override Override) error {
+	err := o.client.SetTargetTemperature(ctx, override.SensorID, override.PreviousTargetTemperature)
+	if err == nil {
+		err = o.client.SetProgram(ctx, override.SensorID, override.PreviousProgram)
+	}
+	if err == nil {
+		o.mu.Lock()
+		//the override may have been replaced meanwhile
+		if o.overrides[override.SensorID] == override {
+			delete(o.overrides, override.SensorID)
+			err = o.save()
+		}
+		o.mu.Unlock()
+	}
+	if err != nil {
+		o.client.logf(LogWarning, "reverting override of sensor %v failed: %v", override.SensorID, err)
+	}
+	if o.OnReverted != nil {
+		o.OnReverted(override, err)
+	}
+	return err
+}
+
+//Run reverts expired overrides at the given interval until the context is cancelled
+func (o *Overrides) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		o.Check(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//save stores all overrides. The caller must hold o.mu.
+func (o *Overrides) save() error {
+	overrides := make([]Override, 0, len(o.overrides))
+	for _, override := range o.overrides {
+		overrides = append(overrides, override)
+	}
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].SensorID < overrides[j].SensorID })
+	return StoreJSON(o.storage, overridesKey, overrides)
+}
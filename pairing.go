@@ -0,0 +1,128 @@
+package roth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+//controller items used for pairing (teach-in) of new thermostats. Like the items of the
+//administrative commands, pairingCommandItem and pairingStateItem are undocumented and unverified
+//on real firmware, so they are checked before use, and ErrUnsupported returned if the controller
+//does not know them.
+const (
+	//pairingCommandItem starts (1) or cancels (0) pairing mode
+	pairingCommandItem = "R0.PairingCmd"
+	//pairingStateItem is 1 while the controller is in pairing mode
+	pairingStateItem = "R0.PairingState"
+	//pairedDevicesItem is the number of thermostats paired with the controller
+	pairedDevicesItem = "R0.numberOfPairedDevices"
+)
+
+//PairingStatus describes the pairing mode of the controller
+type PairingStatus struct {
+	//Active is whether the controller is accepting new thermostats
+	Active bool
+	//PairedDevices is the number of thermostats paired with the controller
+	PairedDevices int
+}
+
+//StartPairing puts the controller in pairing mode, in which it accepts new thermostats put in
+//pairing mode at the wall unit
+func (c *Client) StartPairing(ctx context.Context) error {
+	return c.Admin().command(ctx, pairingCommandItem, "1", false)
+}
+
+//CancelPairing ends pairing mode
+func (c *Client) CancelPairing(ctx context.Context) error {
+	return c.Admin().command(ctx, pairingCommandItem, "0", false)
+}
+
+//PairingStatus returns whether the controller is in pairing mode, and the number of paired
+//thermostats
+func (c *Client) PairingStatus(ctx context.Context) (PairingStatus, error) {
+	values, err := c.ReadRaw(ctx, pairingStateItem, pairedDevicesItem)
+	var respErr *ResponseError
+	if err != nil && !errors.As(err, &respErr) {
+		return PairingStatus{}, err
+	}
+
+	state, count := values[pairingStateItem], values[pairedDevicesItem]
+	if state == "" {
+		return PairingStatus{}, fmt.Errorf("%v: %w", pairingStateItem, ErrUnsupported)
+	}
+	paired, err := strconv.Atoi(count)
+	if err != nil {
+		return PairingStatus{}, fmt.Errorf("unexpected value %v for %v", count, pairedDevicesItem)
+	}
+	return PairingStatus{Active: state == "1", PairedDevices: paired}, nil
+}
+
+//Pair starts pairing mode and waits until a new thermostat is paired, checking the controller
+//every interval. Pairing mode is ended when a thermostat is paired, or when ctx is done. It
+//returns the sensor id of the new thermostat.
+func (c *Client) Pair(ctx context.Context, interval time.Duration) (sensorID int, err error) {
+	before, err := c.PairingStatus(ctx)
+	if err != nil {
+		return 0, err
+	}
+	//the indices may have gaps, which the new thermostat may fill, so its index is found by
+	//comparing the indices before and after
+	known, _, err := c.readAllSensors(ctx, before.PairedDevices)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.StartPairing(ctx); err != nil {
+		return 0, err
+	}
+	defer func() {
+		//end pairing mode even if ctx is done
+		if cancelErr := c.CancelPairing(context.Background()); cancelErr != nil && err == nil {
+			err = cancelErr
+		}
+		//the number of sensors changed
+		c.InvalidateCache()
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-ticker.C:
+		}
+
+		status, err := c.PairingStatus(ctx)
+		if err != nil {
+			c.logf(LogWarning, "error reading pairing status: %v", err)
+			continue
+		}
+		if status.PairedDevices > before.PairedDevices {
+			return c.newSensorID(ctx, known, status.PairedDevices)
+		}
+		if !status.Active {
+			return 0, errors.New("controller left pairing mode without pairing a thermostat")
+		}
+	}
+}
+
+//newSensorID returns the index of a sensor paired after the known sensors were read
+func (c *Client) newSensorID(ctx context.Context, known []Sensor, sensorCount int) (int, error) {
+	sensors, _, err := c.readAllSensors(ctx, sensorCount)
+	if err != nil {
+		return 0, fmt.Errorf("thermostat paired, error finding its index: %v", err)
+	}
+	ids := make(map[int]bool, len(known))
+	for _, s := range known {
+		ids[s.Id] = true
+	}
+	for _, s := range sensors {
+		if !ids[s.Id] {
+			return s.Id, nil
+		}
+	}
+	return 0, errors.New("thermostat paired, but no new sensor index found")
+}
@@ -0,0 +1,68 @@
+package roth_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/rothtest"
+)
+
+func TestPairingUnsupported(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(c *roth.Client) error
+	}{
+		{"StartPairing", func(c *roth.Client) error { return c.StartPairing(context.Background()) }},
+		{"CancelPairing", func(c *roth.Client) error { return c.CancelPairing(context.Background()) }},
+		{"PairingStatus", func(c *roth.Client) error {
+			_, err := c.PairingStatus(context.Background())
+			return err
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := rothtest.NewServer(testSensors()...)
+			defer srv.Close()
+
+			if err := test.call(newTestClient(srv)); !errors.Is(err, roth.ErrUnsupported) {
+				t.Errorf("got error %v, want ErrUnsupported", err)
+			}
+			if value, ok := srv.Value("R0.PairingCmd"); ok {
+				t.Errorf("unknown pairing command written as %v", value)
+			}
+		})
+	}
+}
+
+func TestPairingCommands(t *testing.T) {
+	srv := rothtest.NewServer(testSensors()...)
+	defer srv.Close()
+	srv.SetValue("R0.PairingCmd", "0")
+	srv.SetValue("R0.PairingState", "0")
+	srv.SetValue("R0.numberOfPairedDevices", "2")
+	c := newTestClient(srv)
+
+	if err := c.StartPairing(context.Background()); err != nil {
+		t.Fatalf("StartPairing: %v", err)
+	}
+	if got, _ := srv.Value("R0.PairingCmd"); got != "1" {
+		t.Errorf("got pairing command %v, want 1", got)
+	}
+	srv.SetValue("R0.PairingState", "1")
+	status, err := c.PairingStatus(context.Background())
+	if err != nil {
+		t.Fatalf("PairingStatus: %v", err)
+	}
+	if want := (roth.PairingStatus{Active: true, PairedDevices: 2}); status != want {
+		t.Errorf("got status %+v, want %+v", status, want)
+	}
+	if err := c.CancelPairing(context.Background()); err != nil {
+		t.Fatalf("CancelPairing: %v", err)
+	}
+	if got, _ := srv.Value("R0.PairingCmd"); got != "0" {
+		t.Errorf("got pairing command %v, want 0", got)
+	}
+}
@@ -0,0 +1,190 @@
+package roth
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kvantetore/rothTouchline/protocol"
+)
+
+//ParseWarning describes a value in a controller response which could not be parsed and was skipped
+type ParseWarning struct {
+	//Item is the datapoint name as returned by the controller, e.g. G0.RaumTemp
+	Item string
+	//Value is the raw value returned by the controller
+	Value string
+	//Message describes the problem
+	Message string
+	//Snippet is the raw xml of the item in the response
+	Snippet string
+}
+
+func (w ParseWarning) String() string {
+	return fmt.Sprintf("%v (item %v, value %q)", w.Message, describeItem(w.Item), w.Value)
+}
+
+//ParseError is returned in strict mode when any value in a controller response can not be parsed
+type ParseError struct {
+	Warnings []ParseWarning
+}
+
+func (e *ParseError) Error() string {
+	if len(e.Warnings) == 0 {
+		return "error parsing response"
+	}
+	w := e.Warnings[0]
+	location := w.Snippet
+	if location == "" {
+		location = w.Item
+	}
+	msg := fmt.Sprintf("error parsing response: %v in %v", w.Message, location)
+	if len(e.Warnings) > 1 {
+		msg += fmt.Sprintf(" (and %v more)", len(e.Warnings)-1)
+	}
+	return msg
+}
+
+//parseSensors converts a response to a list of the sensors with the given ids, matching
+//datapoint names case insensitively. If keepRaw is set, the unparsed values are kept in Sensor.Raw.
+//Datapoints without a Parse function are decoded into Sensor.Values by the codec returned by
+//codecFor, if any. Sensors whose items are all in the response, but empty, are indices without
+//a thermostat, e.g. of a removed one, and are left out of the result without warnings. Sensors
+//whose items are missing from the response are kept, with a warning for every missing item.
+func parseSensors(resp response, ids []int, datapoints []Datapoint, keepRaw bool, codecFor func(item string) (Codec, bool)) (sensors []Sensor, warnings []ParseWarning) {
+	sensors = make([]Sensor, len(ids))
+	index := make(map[int]int, len(ids))
+	for i, id := range ids {
+		sensors[i].Id = id
+		index[id] = i
+	}
+	//present marks the datapoints in the response, parsed or not, by sensor position and
+	//datapoint index
+	present := make([]bool, len(ids)*len(datapoints))
+	//gap marks the sensors without a thermostat, by sensor position
+	gap := findGaps(resp, index, len(ids), datapoints)
+
+	var item responseItem
+	warn := func(format string, args ...interface{}) {
+		warnings = append(warnings, ParseWarning{
+			Item:    item.Name,
+			Value:   item.Value,
+			Message: fmt.Sprintf(format, args...),
+			Snippet: item.Snippet(),
+		})
+	}
+
+	for i := 0; i < len(resp.Items); i++ {
+		item = resp.Items[i]
+
+		id, valueName, ok := protocol.SplitItemName(item.Name)
+		if !ok {
+			warn("error parsing sensor info name")
+			continue
+		}
+
+		//parse sensor index from name
+		sensorIndex, err := strconv.Atoi(id)
+		position, ok := index[sensorIndex]
+		if err != nil || !ok {
+			warn("invalid sensor index %v", id)
+			continue
+		}
+		if gap[position] {
+			continue
+		}
+		sensor := &sensors[position]
+
+		d := datapointIndex(datapoints, valueName)
+		if d < 0 {
+			warn("unexpected value name %v", valueName)
+			continue
+		}
+		datapoint := datapoints[d]
+		present[position*len(datapoints)+d] = true
+		if keepRaw {
+			if sensor.Raw == nil {
+				sensor.Raw = make(map[string]string, len(datapoints))
+			}
+			sensor.Raw[datapoint.Name] = item.Value
+		}
+		if err := datapoint.parse(sensor, item.Value); err != nil {
+			warn("%v for %v", err, datapoint.Name)
+			continue
+		}
+		if datapoint.Parse == nil {
+			if codec, ok := codecFor(item.Name); ok {
+				value, err := codec.Decode(item.Value)
+				if err != nil {
+					warn("%v for %v", err, datapoint.Name)
+					continue
+				}
+				if sensor.Values == nil {
+					sensor.Values = make(map[string]interface{})
+				}
+				sensor.Values[datapoint.Name] = value
+			}
+		}
+	}
+
+	//report datapoints the controller left out
+	for position, id := range ids {
+		if gap[position] {
+			continue
+		}
+		for i, d := range datapoints {
+			if !present[position*len(datapoints)+i] {
+				name := fmt.Sprintf("G%v.%v", id, d.Name)
+				warnings = append(warnings, ParseWarning{Item: name, Message: "missing datapoint"})
+			}
+		}
+	}
+
+	found := sensors[:0]
+	for position := range sensors {
+		if !gap[position] {
+			found = append(found, sensors[position])
+		}
+	}
+	return found, warnings
+}
+
+//findGaps returns which of the sensors, by position, are indices without a thermostat: the
+//controller answers every item of such an index, but with empty values. A sensor with items
+//missing from the response is no gap, as the response may just have been truncated.
+func findGaps(resp response, index map[int]int, sensorCount int, datapoints []Datapoint) []bool {
+	present := make([]int, sensorCount)
+	populated := make([]bool, sensorCount)
+	for _, item := range resp.Items {
+		id, valueName, ok := protocol.SplitItemName(item.Name)
+		if !ok {
+			continue
+		}
+		sensorIndex, err := strconv.Atoi(id)
+		position, ok := index[sensorIndex]
+		if err != nil || !ok || datapointIndex(datapoints, valueName) < 0 {
+			continue
+		}
+		present[position]++
+		if item.Value != "" {
+			populated[position] = true
+		}
+	}
+
+	gap := make([]bool, sensorCount)
+	for position := range gap {
+		gap[position] = !populated[position] && present[position] >= len(datapoints) && len(datapoints) > 0
+	}
+	return gap
+}
+
+//datapointIndex returns the index of the datapoint with the given name, compared case
+//insensitively, or -1. There are few datapoints, so a scan is cheaper than a map.
+func datapointIndex(datapoints []Datapoint, name string) int {
+	for i, d := range datapoints {
+		if strings.EqualFold(d.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
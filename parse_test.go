@@ -0,0 +1,38 @@
+package roth
+
+import (
+	"fmt"
+	"testing"
+)
+
+//benchmarkSensorResponse is the response to a poll of eight thermostats
+func benchmarkSensorResponse() (response, []int) {
+	var resp response
+	ids := make([]int, 8)
+	for id := range ids {
+		ids[id] = id
+		resp.Items = append(resp.Items,
+			responseItem{Name: fmt.Sprintf("G%v.name", id), Value: fmt.Sprintf("Room %v", id)},
+			responseItem{Name: fmt.Sprintf("G%v.RaumTemp", id), Value: "2086"},
+			responseItem{Name: fmt.Sprintf("G%v.SollTemp", id), Value: "2100"},
+			responseItem{Name: fmt.Sprintf("G%v.WeekProg", id), Value: "0"},
+			responseItem{Name: fmt.Sprintf("G%v.OPMode", id), Value: "0"},
+			responseItem{Name: fmt.Sprintf("G%v.TempSIUnit", id), Value: "0"},
+		)
+	}
+	return resp, ids
+}
+
+func noCodec(item string) (Codec, bool) {
+	return Codec{}, false
+}
+
+func BenchmarkParseSensors(b *testing.B) {
+	resp, ids := benchmarkSensorResponse()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, warnings := parseSensors(resp, ids, sensorFields, false, noCodec); len(warnings) > 0 {
+			b.Fatal(warnings)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package roth
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+//pollSchedule holds the timing of the polls of a Watcher besides its interval
+type pollSchedule struct {
+	//fieldIntervals polls some fields more often than the full poll
+	fieldIntervals map[Field]time.Duration
+	jitter         time.Duration
+	random         *rand.Rand
+
+	//quiet hours, as offsets from local midnight
+	quietFrom, quietTo time.Duration
+	quietInterval      time.Duration
+}
+
+//SetFieldInterval polls the given fields at their own interval, shorter than the interval of the
+//watcher, e.g. FieldRoomTemperature every 30 seconds while names and programs are polled every
+//10 minutes. Between full polls, subscribers get the polled fields merged into the previous
+//readings. An interval of zero removes the field interval.
+func (w *Watcher) SetFieldInterval(fields Field, interval time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if interval <= 0 {
+		delete(w.schedule.fieldIntervals, fields)
+		return
+	}
+	if w.schedule.fieldIntervals == nil {
+		w.schedule.fieldIntervals = make(map[Field]time.Duration)
+	}
+	w.schedule.fieldIntervals[fields] = interval
+}
+
+//SetJitter delays every poll by a random duration up to jitter, so several watchers started at
+//the same time do not poll the controller in bursts
+func (w *Watcher) SetJitter(jitter time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.schedule.jitter = jitter
+	if w.schedule.random == nil {
+		w.schedule.random = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+}
+
+//SetQuietHours polls at the given interval between from and to, given as time of day in local
+//time, e.g. 23*time.Hour and 6*time.Hour for the night. Field intervals are paused in quiet
+//hours. The controller serves its own web interface slowly while polled, so quiet hours keep it
+//responsive when it is rarely looked at anyway. An interval of zero removes the quiet hours.
+func (w *Watcher) SetQuietHours(from, to time.Duration, interval time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.schedule.quietFrom, w.schedule.quietTo = from, to
+	w.schedule.quietInterval = interval
+}
+
+//quietUntil returns the end of the quiet hours if t is in them, or the zero time
+func (s *pollSchedule) quietUntil(t time.Time) time.Time {
+	if s.quietInterval <= 0 || s.quietFrom == s.quietTo {
+		return time.Time{}
+	}
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	switch {
+	case s.quietFrom < s.quietTo && offset >= s.quietFrom && offset < s.quietTo:
+		return midnight.Add(s.quietTo)
+	case s.quietFrom > s.quietTo && offset >= s.quietFrom:
+		return midnight.AddDate(0, 0, 1).Add(s.quietTo)
+	case s.quietFrom > s.quietTo && offset < s.quietTo:
+		return midnight.Add(s.quietTo)
+	}
+	return time.Time{}
+}
+
+//PollFields reads only the given fields, and passes the sensors to all subscribers with the other
+//fields kept from the previous poll. Changes are reported for the fields read only. Without a
+//previous successful poll, all fields are read as by Poll.
+func (w *Watcher) PollFields(ctx context.Context, fields Field) Poll {
+	w.mu.Lock()
+	lastRaw := w.lastRaw
+	lastSensors := make([]Sensor, len(lastRaw))
+	for i, s := range lastRaw {
+		lastSensors[i] = w.last[s.Id]
+	}
+	w.mu.Unlock()
+	if len(lastRaw) == 0 || fields.Has(AllFields) {
+		return w.Poll(ctx)
+	}
+
+	//virtual sensors follow the physical ones, and are computed again from the merged readings
+	sensorCount := 0
+	for sensorCount < len(lastRaw) && lastRaw[sensorCount].Id < FirstVirtualID {
+		sensorCount++
+	}
+	ids := make([]int, sensorCount)
+	for i := range ids {
+		ids[i] = lastRaw[i].Id
+	}
+	poll := Poll{Time: time.Now()}
+	fresh, err := w.client.GetSensorsByID(ctx, ids, fields)
+	if err != nil {
+		poll.Err = err
+		return w.deliver(poll)
+	}
+	poll.Raw = w.client.withVirtualSensors(mergeFields(lastRaw[:sensorCount], fresh, fields))
+	w.filters.apply(fresh)
+	poll.Sensors = w.client.withVirtualSensors(mergeFields(lastSensors[:sensorCount], fresh, fields))
+	w.client.annotate(poll.Raw)
+	w.client.annotate(poll.Sensors)
+	if fields.Has(FieldTargetTemperature) {
+		w.client.correctFrost(ctx, fresh)
+	}
+	return w.deliver(poll)
+}
+
+//mergeFields returns a copy of the sensors, with the given fields set from fresh readings of the
+//same sensors, matched by id
+func mergeFields(sensors []Sensor, fresh []Sensor, fields Field) []Sensor {
+	byID := make(map[int]Sensor, len(fresh))
+	for _, f := range fresh {
+		byID[f.Id] = f
+	}
+	merged := make([]Sensor, len(sensors))
+	copy(merged, sensors)
+	for i := range merged {
+		f, ok := byID[merged[i].Id]
+		if !ok {
+			continue
+		}
+		s := &merged[i]
+		if fields.Has(FieldName) {
+			s.Name = f.Name
+		}
+		if fields.Has(FieldRoomTemperature) {
+			s.RoomTemperature = f.RoomTemperature
+		}
+		if fields.Has(FieldTargetTemperature) {
+			s.TargetTemperature = f.TargetTemperature
+		}
+		if fields.Has(FieldProgram) {
+			s.Program = f.Program
+		}
+		if fields.Has(FieldMode) {
+			s.Mode = f.Mode
+		}
+		if fields.Has(FieldUnit) {
+			s.Unit = f.Unit
+		}
+		s.Valid = s.Valid&^fields | f.Valid&fields
+		s.ReadAt, s.Stale = f.ReadAt, f.Stale
+	}
+	return merged
+}
+
+//Run polls at the configured interval until the context is cancelled, along with the field
+//intervals, jitter and quiet hours set on the watcher
+func (w *Watcher) Run(ctx context.Context) {
+	var lastFull time.Time
+	lastFields := make(map[Field]time.Time)
+
+	for {
+		now := time.Now()
+		w.mu.Lock()
+		interval := w.interval
+		quietUntil := w.schedule.quietUntil(now)
+		if !quietUntil.IsZero() {
+			interval = w.schedule.quietInterval
+		}
+		fieldIntervals := make(map[Field]time.Duration, len(w.schedule.fieldIntervals))
+		for fields, fieldInterval := range w.schedule.fieldIntervals {
+			fieldIntervals[fields] = fieldInterval
+		}
+		w.mu.Unlock()
+
+		var poll Poll
+		if lastFull.IsZero() || !now.Before(lastFull.Add(interval)) {
+			poll = w.Poll(ctx)
+			lastFull = now
+			for fields := range fieldIntervals {
+				lastFields[fields] = now
+			}
+		} else if quietUntil.IsZero() {
+			var due Field
+			for fields, fieldInterval := range fieldIntervals {
+				if !now.Before(lastFields[fields].Add(fieldInterval)) {
+					due |= fields
+					lastFields[fields] = now
+				}
+			}
+			if due != 0 {
+				poll = w.PollFields(ctx, due)
+			}
+		}
+		if poll.Err != nil && ctx.Err() == nil {
+			w.client.logf(LogWarning, "poll failed: %v", poll.Err)
+		}
+
+		next := lastFull.Add(interval)
+		if !quietUntil.IsZero() {
+			if quietUntil.Before(next) {
+				next = quietUntil
+			}
+		} else {
+			for fields, fieldInterval := range fieldIntervals {
+				if t := lastFields[fields].Add(fieldInterval); t.Before(next) {
+					next = t
+				}
+			}
+		}
+
+		timer := time.NewTimer(time.Until(next) + w.jitter())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+//jitter returns a random delay up to the jitter of the watcher
+func (w *Watcher) jitter() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.schedule.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(w.schedule.random.Int63n(int64(w.schedule.jitter)))
+}
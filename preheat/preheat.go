@@ -0,0 +1,254 @@
+//Package preheat schedules comfort temperatures with an adaptive lead time. It learns how fast
+//each room heats up from recorded history, and raises the setpoint early enough for the room to
+//reach the comfort temperature at the scheduled time, which fixed schedules can not do for
+//underfloor heating with its large lag.
+package preheat
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/history"
+)
+
+//Period is a daily comfort period of a sensor. Outside the period, and before pre-heating
+//starts, the setback temperature is used.
+type Period struct {
+	SensorID int `json:"sensor"`
+	//Start and End are given as hh:mm. Periods spanning midnight are not supported.
+	Start   string  `json:"start"`
+	End     string  `json:"end"`
+	Comfort float32 `json:"comfort"`
+	Setback float32 `json:"setback"`
+}
+
+//storageKey is the key of the periods in a storage
+const storageKey = "schedule.json"
+
+//Scheduler writes comfort or setback setpoints according to the periods, starting comfort
+//periods early by the time each room needs to heat up
+type Scheduler struct {
+	client *roth.Client
+	store  history.Store
+
+	//DefaultRate is the heating rate in °C per hour assumed for rooms without enough history. It
+	//must be above 0.
+	DefaultRate float64
+	//MaxLead limits how early pre-heating may start
+	MaxLead time.Duration
+	//OnChange is called when the scheduler writes a setpoint
+	OnChange func(sensorID int, setpoint float32, reason string)
+
+	mu      sync.Mutex
+	periods []Period
+	rates   map[int]float64
+	storage roth.Storage
+}
+
+//NewScheduler creates a scheduler learning from the given history store
+func NewScheduler(client *roth.Client, store history.Store) *Scheduler {
+	return &Scheduler{
+		client:      client,
+		store:       store,
+		DefaultRate: 0.5,
+		MaxLead:     6 * time.Hour,
+		rates:       make(map[int]float64),
+	}
+}
+
+//Add adds a comfort period
+func (s *Scheduler) Add(p Period) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.periods = append(s.periods, p)
+	if s.storage != nil {
+		return roth.StoreJSON(s.storage, storageKey, s.periods)
+	}
+	return nil
+}
+
+//Persist loads the periods stored in the storage, replacing any periods added, and stores every
+//period added later, so the schedule survives restarts
+func (s *Scheduler) Persist(storage roth.Storage) error {
+	var periods []Period
+	if _, err := roth.LoadJSON(storage, storageKey, &periods); err != nil {
+		return err
+	}
+	for _, p := range periods {
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("error loading schedule: %v", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.periods = periods
+	s.storage = storage
+	return nil
+}
+
+func (p Period) validate() error {
+	start, err := parseClock(p.Start)
+	if err != nil {
+		return err
+	}
+	end, err := parseClock(p.End)
+	if err != nil {
+		return err
+	}
+	if end <= start {
+		return fmt.Errorf("period %v-%v must end after it starts", p.Start, p.End)
+	}
+	return nil
+}
+
+//Learn estimates the heating rate of every scheduled sensor from the history between from and to
+func (s *Scheduler) Learn(from, to time.Time) error {
+	s.mu.Lock()
+	ids := make(map[int]bool)
+	for _, p := range s.periods {
+		ids[p.SensorID] = true
+	}
+	s.mu.Unlock()
+
+	for id := range ids {
+		samples, err := s.store.Query(id, from, to)
+		if err != nil {
+			return err
+		}
+		if rate, ok := HeatingRate(samples); ok {
+			s.mu.Lock()
+			s.rates[id] = rate
+			s.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+//Rate returns the learned heating rate of a sensor in °C per hour, or the default rate
+func (s *Scheduler) Rate(sensorID int) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rate, ok := s.rates[sensorID]; ok {
+		return rate
+	}
+	return s.DefaultRate
+}
+
+//HeatingRate estimates how fast a room heats up, in °C per hour, from consecutive samples taken
+//while the valve was open. The median is used, so single glitches do not skew the estimate.
+func HeatingRate(samples []history.Sample) (rate float64, ok bool) {
+	var rates []float64
+	for i := 1; i < len(samples); i++ {
+		a, b := samples[i-1], samples[i]
+		hours := b.Time.Sub(a.Time).Hours()
+		if !a.ValveOpen || hours <= 0 || hours > 1 {
+			continue
+		}
+		if r := float64(b.RoomTemperature-a.RoomTemperature) / hours; r > 0 {
+			rates = append(rates, r)
+		}
+	}
+	//require some evidence before trusting the estimate
+	if len(rates) < 5 {
+		return 0, false
+	}
+	sort.Float64s(rates)
+	return rates[len(rates)/2], true
+}
+
+//Setpoint returns the setpoint a sensor should have at the given time, and the reason
+func (s *Scheduler) Setpoint(sensor roth.Sensor, now time.Time) (setpoint float32, reason string, ok bool) {
+	s.mu.Lock()
+	var periods []Period
+	for _, p := range s.periods {
+		if p.SensorID == sensor.Id {
+			periods = append(periods, p)
+		}
+	}
+	s.mu.Unlock()
+	if len(periods) == 0 {
+		return 0, "", false
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+	rate := s.Rate(sensor.Id)
+	for _, p := range periods {
+		start, _ := parseClock(p.Start)
+		end, _ := parseClock(p.End)
+		if minute >= start && minute < end {
+			return p.Comfort, "comfort period", true
+		}
+
+		if !sensor.Valid.Has(roth.FieldRoomTemperature) || sensor.RoomTemperature >= p.Comfort {
+			continue
+		}
+		lead := s.MaxLead
+		if rate > 0 {
+			lead = time.Duration(float64(p.Comfort-sensor.RoomTemperature) / rate * float64(time.Hour))
+		}
+		if lead > s.MaxLead {
+			lead = s.MaxLead
+		}
+		until := time.Duration(start-minute) * time.Minute
+		if until < 0 {
+			//the period starts tomorrow
+			until += 24 * time.Hour
+		}
+		if until <= lead {
+			return p.Comfort, fmt.Sprintf("pre-heating %v ahead of %v", lead.Round(time.Minute), p.Start), true
+		}
+	}
+	return periods[0].Setback, "setback", true
+}
+
+//Evaluate writes the setpoint of every scheduled sensor whose current target differs, at the
+//resolution of the client
+func (s *Scheduler) Evaluate(ctx context.Context, sensors []roth.Sensor, now time.Time) error {
+	if s.DefaultRate <= 0 {
+		return fmt.Errorf("invalid default heating rate %v: must be above 0", s.DefaultRate)
+	}
+	var firstErr error
+	for _, sensor := range sensors {
+		setpoint, reason, ok := s.Setpoint(sensor, now)
+		if !ok || (sensor.Valid.Has(roth.FieldTargetTemperature) && !s.client.TargetDiffers(sensor.Id, sensor.TargetTemperature, setpoint)) {
+			continue
+		}
+		if err := s.client.SetTargetTemperature(ctx, sensor.Id, setpoint); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if s.OnChange != nil {
+			s.OnChange(sensor.Id, setpoint, reason)
+		}
+	}
+	return firstErr
+}
+
+//Attach evaluates the schedule on every successful poll of the watcher
+func (s *Scheduler) Attach(w *roth.Watcher) {
+	w.Subscribe(func(p roth.Poll) {
+		if p.Err == nil {
+			s.Evaluate(context.Background(), p.Sensors, p.Time)
+		}
+	})
+}
+
+//parseClock parses hh:mm into minutes since midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected hh:mm", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
@@ -0,0 +1,81 @@
+package preheat_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/preheat"
+	"github.com/kvantetore/rothTouchline/rothtest"
+)
+
+func TestSetpoint(t *testing.T) {
+	day := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		defaultRate float64
+		now         time.Duration
+		room        float32
+		want        float32
+	}{
+		{name: "comfort period", defaultRate: 0.5, now: 8 * time.Hour, room: 18, want: 21},
+		{name: "warm room waits", defaultRate: 0.5, now: 6 * time.Hour, room: 20.9, want: 17},
+		{name: "cold room pre-heats", defaultRate: 0.5, now: 6 * time.Hour, room: 20, want: 21},
+		{name: "after the period", defaultRate: 0.5, now: 10 * time.Hour, room: 18, want: 17},
+		//without a positive rate, the lead can not be estimated, and MaxLead is used
+		{name: "zero rate pre-heats at the maximum lead", defaultRate: 0, now: 2 * time.Hour, room: 20.9, want: 21},
+		{name: "negative rate pre-heats at the maximum lead", defaultRate: -1, now: 2 * time.Hour, room: 20.9, want: 21},
+		{name: "zero rate before the maximum lead", defaultRate: 0, now: 30 * time.Minute, room: 20.9, want: 17},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := preheat.NewScheduler(roth.NewClient("http://localhost"), nil)
+			s.DefaultRate = test.defaultRate
+			if err := s.Add(preheat.Period{SensorID: 0, Start: "07:00", End: "09:00", Comfort: 21, Setback: 17}); err != nil {
+				t.Fatal(err)
+			}
+			sensor := roth.Sensor{Id: 0, RoomTemperature: test.room, Valid: roth.AllFields}
+			setpoint, reason, ok := s.Setpoint(sensor, day.Add(test.now))
+			if !ok || setpoint != test.want {
+				t.Errorf("got setpoint %v (%v), want %v", setpoint, reason, test.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateWritesOnce(t *testing.T) {
+	srv := rothtest.NewServer(roth.Sensor{Id: 0, Name: "Bath", RoomTemperature: 18, TargetTemperature: 17})
+	defer srv.Close()
+	client := roth.NewClient(srv.URL, roth.WithLogger(roth.DiscardLogger), roth.WithTemperatureResolution(0.5, roth.RoundNearest))
+
+	s := preheat.NewScheduler(client, nil)
+	if err := s.Add(preheat.Period{SensorID: 0, Start: "07:00", End: "09:00", Comfort: 21.3, Setback: 17}); err != nil {
+		t.Fatal(err)
+	}
+	writes := 0
+	s.OnChange = func(int, float32, string) { writes++ }
+
+	now := time.Date(2024, 1, 15, 8, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		sensors, err := client.ForceRefresh(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Evaluate(context.Background(), sensors, now); err != nil {
+			t.Fatalf("Evaluate: %v", err)
+		}
+	}
+	if writes != 1 {
+		t.Errorf("got %v writes, want 1", writes)
+	}
+	if got, _ := srv.Value("G0.SollTemp"); got != "2150" {
+		t.Errorf("got target %v, want 2150", got)
+	}
+
+	s.DefaultRate = 0
+	if err := s.Evaluate(context.Background(), nil, now); err == nil {
+		t.Error("Evaluate accepted a default rate of 0")
+	}
+}
@@ -0,0 +1,220 @@
+//Package presence lowers the setpoints while nobody is home, and restores the comfort
+//temperatures on arrival. Presence comes from any Source, e.g. a geofence on a phone or an MQTT
+//topic; push based sources report changes with Manager.Set instead.
+package presence
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+//Everyone is the name of the person used by global sources, which only know whether anybody is
+//home
+const Everyone = "*"
+
+//Source reports whether people are home, by name. A global source, like an alarm system, returns
+//a single entry for Everyone.
+type Source interface {
+	Presence(ctx context.Context) (map[string]bool, error)
+}
+
+//SourceFunc adapts an ordinary function to the Source interface
+type SourceFunc func(ctx context.Context) (map[string]bool, error)
+
+//Presence calls f(ctx)
+func (f SourceFunc) Presence(ctx context.Context) (map[string]bool, error) {
+	return f(ctx)
+}
+
+//Setback configures a room for presence control
+type Setback struct {
+	SensorID int
+	//Setback is how far the target temperature is lowered while the room is away
+	Setback float32
+	//People are the people using the room. The room is away when all of them are away; if
+	//empty, when everybody is away.
+	People []string
+}
+
+//Change describes a setpoint written on departure or arrival
+type Change struct {
+	Time     time.Time
+	SensorID int
+	//Home is set on arrival, when the comfort temperature is restored
+	Home     bool
+	Setpoint float32
+	Err      error
+}
+
+//Manager applies the setbacks of rooms as people leave and arrive
+type Manager struct {
+	client *roth.Client
+
+	//OnChange is called for every setpoint written
+	OnChange func(Change)
+
+	mu       sync.Mutex
+	setbacks map[int]Setback
+	people   map[string]bool
+	//comfort holds the target temperatures before departure, by sensor id, for rooms which are
+	//away
+	comfort map[int]float32
+}
+
+//NewManager creates a manager without any rooms, assuming everybody is home
+func NewManager(client *roth.Client) *Manager {
+	return &Manager{
+		client:   client,
+		setbacks: make(map[int]Setback),
+		people:   make(map[string]bool),
+		comfort:  make(map[int]float32),
+	}
+}
+
+//AddSetback includes a room in presence control, replacing its previous setback
+func (m *Manager) AddSetback(s Setback) error {
+	if s.Setback < 0 {
+		return fmt.Errorf("setback of sensor %v is negative", s.SensorID)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setbacks[s.SensorID] = s
+	return nil
+}
+
+//RemoveSetback excludes a room from presence control. A room which is away keeps its lowered
+//target temperature.
+func (m *Manager) RemoveSetback(sensorID int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.setbacks, sensorID)
+	delete(m.comfort, sensorID)
+}
+
+//Home returns whether anybody is home
+func (m *Manager) Home() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.anybodyHome(nil)
+}
+
+//anybodyHome returns whether any of the people is home, or anybody if people is empty. People
+//never reported are assumed home. The caller must hold m.mu.
+func (m *Manager) anybodyHome(people []string) bool {
+	if len(people) == 0 {
+		for _, home := range m.people {
+			if home {
+				return true
+			}
+		}
+		return len(m.people) == 0
+	}
+	for _, person := range people {
+		if home, known := m.people[person]; home || !known {
+			return true
+		}
+	}
+	return false
+}
+
+//Set records whether a person is home, and applies or restores the setbacks of the affected
+//rooms. Use Everyone for global presence.
+func (m *Manager) Set(ctx context.Context, person string, home bool) []Change {
+	return m.Update(ctx, map[string]bool{person: home})
+}
+
+//Update records the presence of several people, as reported by a source, and applies or
+//restores the setbacks of the affected rooms
+func (m *Manager) Update(ctx context.Context, people map[string]bool) []Change {
+	m.mu.Lock()
+	for person, home := range people {
+		m.people[person] = home
+	}
+	var leaving, arriving []Setback
+	for id, s := range m.setbacks {
+		_, away := m.comfort[id]
+		switch home := m.anybodyHome(s.People); {
+		case !home && !away:
+			leaving = append(leaving, s)
+		case home && away:
+			arriving = append(arriving, s)
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(leaving, func(i, j int) bool { return leaving[i].SensorID < leaving[j].SensorID })
+	sort.Slice(arriving, func(i, j int) bool { return arriving[i].SensorID < arriving[j].SensorID })
+
+	var changes []Change
+	for _, s := range leaving {
+		changes = append(changes, m.leave(ctx, s))
+	}
+	for _, s := range arriving {
+		changes = append(changes, m.arrive(ctx, s))
+	}
+	return changes
+}
+
+//leave lowers the target temperature of a room, remembering the comfort temperature
+func (m *Manager) leave(ctx context.Context, s Setback) Change {
+	change := Change{Time: time.Now(), SensorID: s.SensorID}
+	current, err := m.client.GetSensor(ctx, s.SensorID, roth.FieldTargetTemperature)
+	if err == nil {
+		change.Setpoint = current.TargetTemperature - s.Setback
+		err = m.client.SetTargetTemperature(ctx, s.SensorID, change.Setpoint)
+	}
+	if err == nil {
+		m.mu.Lock()
+		m.comfort[s.SensorID] = current.TargetTemperature
+		m.mu.Unlock()
+	}
+	return m.changed(change, err)
+}
+
+//arrive restores the comfort temperature of a room
+func (m *Manager) arrive(ctx context.Context, s Setback) Change {
+	m.mu.Lock()
+	comfort := m.comfort[s.SensorID]
+	m.mu.Unlock()
+
+	change := Change{Time: time.Now(), SensorID: s.SensorID, Home: true, Setpoint: comfort}
+	err := m.client.SetTargetTemperature(ctx, s.SensorID, comfort)
+	if err == nil {
+		m.mu.Lock()
+		delete(m.comfort, s.SensorID)
+		m.mu.Unlock()
+	}
+	return m.changed(change, err)
+}
+
+func (m *Manager) changed(change Change, err error) Change {
+	change.Err = err
+	if m.OnChange != nil {
+		m.OnChange(change)
+	}
+	return change
+}
+
+//Run polls the source at the given interval until the context is cancelled. Failed writes are
+//retried at the next poll.
+func (m *Manager) Run(ctx context.Context, source Source, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if people, err := source.Presence(ctx); err == nil {
+			m.Update(ctx, people)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+//XMLError is returned when a controller response is not well-formed xml
+type XMLError struct {
+	//Offset is the byte offset in the response at which parsing failed
+	Offset int64
+	//Context is the part of the response around Offset
+	Context string
+	Err     error
+}
+
+func (e *XMLError) Error() string {
+	return fmt.Sprintf("error parsing xml at byte %v: %v (near %q)", e.Offset, e.Err, e.Context)
+}
+
+func (e *XMLError) Unwrap() error {
+	return e.Err
+}
+
+//ParseResponse decodes an ILRReadValues response. It walks the xml tokens rather than decoding
+//by reflection, which allocates much less on small devices polling frequently.
+func ParseResponse(body []byte) (resp Response, err error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	xmlError := func(err error) error {
+		offset := decoder.InputOffset()
+		start, end := offset-40, offset+40
+		if start < 0 {
+			start = 0
+		}
+		if end > int64(len(body)) {
+			end = int64(len(body))
+		}
+		return &XMLError{Offset: offset, Context: string(body[start:end]), Err: err}
+	}
+
+	//the items are at body>item_list>i, with the name and value in its n and v children. Raw
+	//tokens are cheaper than checked ones, so the nesting is checked here.
+	var (
+		open      []string
+		inList    bool
+		item      *Item
+		itemStart int64
+		field     *string
+	)
+	for {
+		offset := decoder.InputOffset()
+		token, err := decoder.RawToken()
+		if err == io.EOF && len(open) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			//a response without a root element is as invalid as a truncated one
+			return Response{}, xmlError(err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			open = append(open, t.Name.Local)
+			depth := len(open)
+			switch {
+			case depth == 2 && t.Name.Local == "item_list":
+				inList = true
+			case depth == 3 && inList && t.Name.Local == "i":
+				resp.Items = append(resp.Items, Item{})
+				item = &resp.Items[len(resp.Items)-1]
+				itemStart = decoder.InputOffset()
+			case depth == 4 && item != nil && t.Name.Local == "n":
+				field = &item.Name
+				*field = ""
+			case depth == 4 && item != nil && t.Name.Local == "v":
+				field = &item.Value
+				*field = ""
+			}
+		case xml.EndElement:
+			depth := len(open)
+			if depth == 0 {
+				return Response{}, xmlError(fmt.Errorf("unexpected end element </%v>", t.Name.Local))
+			}
+			if open[depth-1] != t.Name.Local {
+				return Response{}, xmlError(fmt.Errorf("element <%v> closed by </%v>", open[depth-1], t.Name.Local))
+			}
+			open = open[:depth-1]
+			switch depth {
+			case 1:
+				//only the first root element is read
+				return resp, nil
+			case 2:
+				inList = false
+			case 3:
+				if item != nil {
+					item.Raw = body[itemStart:offset]
+				}
+				item = nil
+			case 4:
+				field = nil
+			}
+		case xml.CharData:
+			if field != nil {
+				*field += string(t)
+			}
+		}
+	}
+}
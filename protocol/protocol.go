@@ -0,0 +1,153 @@
+//Package protocol implements the wire format of the Roth Touchline controller: the xml bodies
+//of ILRReadValues.cgi, the query of writeVal.cgi and the item names. It has no dependencies
+//besides the standard library, for embedders talking to the controller themselves.
+//
+//Example request: POST http://ROTH-10A6D5/cgi-bin/ILRReadValues.cgi
+//
+//	<body>
+//		<item_list>
+//			<i><n>G0.RaumTemp</n></i>
+//			<i><n>G1.RaumTemp</n></i>
+//		</item_list>
+//	</body>
+//
+//Example response
+//
+//	<body>
+//		<item_list>
+//			<i>
+//				<n>G0.RaumTemp</n>
+//				<v>2086</v>
+//			</i>
+//			<i>
+//				<n>G1.RaumTemp</n>
+//				<v>1903</v>
+//			</i>
+//		</item_list>
+//	</body>
+package protocol
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+//Paths of the endpoints of the controller
+const (
+	ReadPath  = "/cgi-bin/ILRReadValues.cgi"
+	WritePath = "/cgi-bin/writeVal.cgi"
+)
+
+//ReadRequest is the body of a request to ILRReadValues.cgi
+type ReadRequest struct {
+	Items []RequestItem `xml:"item_list>i"`
+}
+
+//RequestItem is an item requested from the controller
+type RequestItem struct {
+	Name string `xml:"n"`
+}
+
+//Chunks splits the request into requests of at most size items. If size is not positive, the
+//request is returned unsplit.
+func (r ReadRequest) Chunks(size int) []ReadRequest {
+	if size <= 0 {
+		return []ReadRequest{r}
+	}
+	var chunks []ReadRequest
+	for start := 0; start < len(r.Items); start += size {
+		end := start + size
+		if end > len(r.Items) {
+			end = len(r.Items)
+		}
+		chunks = append(chunks, ReadRequest{Items: r.Items[start:end]})
+	}
+	return chunks
+}
+
+//Response is a response of ILRReadValues.cgi
+type Response struct {
+	Items []Item `xml:"item_list>i"`
+}
+
+//Item is an item of a response
+type Item struct {
+	Name  string `xml:"n"`
+	Value string `xml:"v"`
+	//Raw is the content of the item in the response body, for error messages
+	Raw []byte `xml:"-"`
+}
+
+//Snippet returns the item as it appeared in the response, for error messages
+func (i Item) Snippet() string {
+	return "<i>" + string(i.Raw) + "</i>"
+}
+
+//Value returns the value of the named item in the response, regardless of order. Names are
+//compared case insensitively.
+func (r Response) Value(name string) (string, bool) {
+	for _, item := range r.Items {
+		if strings.EqualFold(item.Name, name) {
+			return item.Value, true
+		}
+	}
+	return "", false
+}
+
+//MarshalRequest serializes a read request, indented like the xml encoder would. It is written
+//by hand, as reflection dominated the cost of frequent polls. Item names are escaped, so names
+//containing markup characters can not corrupt the request.
+func MarshalRequest(req ReadRequest) ([]byte, error) {
+	if len(req.Items) == 0 {
+		return []byte("<body>\n   <item_list></item_list>\n</body>"), nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(40 + 40*len(req.Items))
+	buf.WriteString("<body>\n   <item_list>")
+	for _, item := range req.Items {
+		buf.WriteString("\n      <i>\n         <n>")
+		//escaping allocates, and item names rarely need it
+		if !strings.ContainsAny(item.Name, "<>&'\"\t\n\r") {
+			buf.WriteString(item.Name)
+		} else if err := xml.EscapeText(&buf, []byte(item.Name)); err != nil {
+			return nil, err
+		}
+		buf.WriteString("</n>\n      </i>")
+	}
+	buf.WriteString("\n   </item_list>\n</body>")
+	return buf.Bytes(), nil
+}
+
+//WriteParam returns the escaped name=value parameter writing a value with writeVal.cgi
+func WriteParam(name string, value string) string {
+	return url.QueryEscape(name) + "=" + url.QueryEscape(value)
+}
+
+//WriteURI returns the path and query writing the given parameters, see WriteParam
+func WriteURI(params []string) string {
+	return WritePath + "?" + strings.Join(params, "&")
+}
+
+//ItemName returns the name of a datapoint of a thermostat, e.g. G3.RaumTemp
+func ItemName(id int, datapoint string) string {
+	return "G" + strconv.Itoa(id) + "." + datapoint
+}
+
+//SplitItemName splits an item name like G12.RaumTemp into the sensor id and the datapoint name.
+//It replaces a regular expression, which was the largest cost of parsing a response.
+func SplitItemName(name string) (id string, datapoint string, ok bool) {
+	if len(name) < 2 || name[0] != 'G' {
+		return "", "", false
+	}
+	i := 1
+	for i < len(name) && name[i] >= '0' && name[i] <= '9' {
+		i++
+	}
+	if i == 1 || i+1 >= len(name) || name[i] != '.' {
+		return "", "", false
+	}
+	return name[1:i], name[i+1:], true
+}
@@ -0,0 +1,73 @@
+package protocol
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+//benchmarkItems are the items of a poll of eight thermostats
+func benchmarkItems() []RequestItem {
+	var items []RequestItem
+	for id := 0; id < 8; id++ {
+		for _, datapoint := range []string{"name", "RaumTemp", "SollTemp", "WeekProg", "OPMode", "TempSIUnit"} {
+			items = append(items, RequestItem{Name: ItemName(id, datapoint)})
+		}
+	}
+	return items
+}
+
+//benchmarkResponse is the response to a poll of eight thermostats
+func benchmarkResponse() []byte {
+	var b strings.Builder
+	b.WriteString("<body>\n<item_list>\n")
+	for i, item := range benchmarkItems() {
+		fmt.Fprintf(&b, "<i>\n<n>%v</n>\n<v>%v</v>\n</i>\n", item.Name, 1900+i)
+	}
+	b.WriteString("</item_list>\n</body>")
+	return []byte(b.String())
+}
+
+func TestChunks(t *testing.T) {
+	items := benchmarkItems()[:5]
+	tests := []struct {
+		size    int
+		lengths []int
+	}{
+		{2, []int{2, 2, 1}},
+		{5, []int{5}},
+		{10, []int{5}},
+		{0, []int{5}},
+		{-1, []int{5}},
+	}
+	for _, test := range tests {
+		chunks := ReadRequest{Items: items}.Chunks(test.size)
+		var lengths []int
+		for _, c := range chunks {
+			lengths = append(lengths, len(c.Items))
+		}
+		if fmt.Sprint(lengths) != fmt.Sprint(test.lengths) {
+			t.Errorf("Chunks(%v) returned chunks of %v items, expected %v", test.size, lengths, test.lengths)
+		}
+	}
+}
+
+func BenchmarkParseResponse(b *testing.B) {
+	body := benchmarkResponse()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseResponse(body); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMarshalRequest(b *testing.B) {
+	req := ReadRequest{Items: benchmarkItems()}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := MarshalRequest(req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
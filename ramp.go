@@ -0,0 +1,157 @@
+package roth
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+//DefaultRampStep is the setpoint change of each step of a ramp, unless Client.RampStep is set.
+//It matches the resolution of the wall units.
+const DefaultRampStep = 0.5
+
+//Ramp is a gradual change of the target temperature of a sensor, see
+//Client.SetTargetTemperatureRamped
+type Ramp struct {
+	SensorID int
+	From, To float32
+	Start    time.Time
+	Duration time.Duration
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+//Cancel stops the ramp, leaving the target temperature at the last step written
+func (r *Ramp) Cancel() {
+	r.cancel()
+}
+
+//Done is closed when the ramp has reached its target, failed or was cancelled
+func (r *Ramp) Done() <-chan struct{} {
+	return r.done
+}
+
+//Err returns why the ramp stopped early, once Done is closed: the error of a failed write, or
+//context.Canceled if the ramp was cancelled or replaced. It returns nil for a completed ramp.
+func (r *Ramp) Err() error {
+	<-r.done
+	return r.err
+}
+
+//rampState holds the running ramps of a client, by sensor id
+type rampState struct {
+	mu    sync.Mutex
+	ramps map[int]*Ramp
+}
+
+//replace registers a ramp, cancelling the previous ramp of the sensor
+func (s *rampState) replace(r *Ramp) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ramps == nil {
+		s.ramps = make(map[int]*Ramp)
+	}
+	if previous, ok := s.ramps[r.SensorID]; ok {
+		previous.cancel()
+	}
+	s.ramps[r.SensorID] = r
+}
+
+//cancel stops the ramp of a sensor, if any
+func (s *rampState) cancel(sensorID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.ramps[sensorID]; ok {
+		r.cancel()
+		delete(s.ramps, sensorID)
+	}
+}
+
+func (s *rampState) remove(r *Ramp) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ramps[r.SensorID] == r {
+		delete(s.ramps, r.SensorID)
+	}
+}
+
+//Ramps returns the running ramps
+func (c *Client) Ramps() []*Ramp {
+	c.ramps.mu.Lock()
+	defer c.ramps.mu.Unlock()
+	ramps := make([]*Ramp, 0, len(c.ramps.ramps))
+	for _, r := range c.ramps.ramps {
+		ramps = append(ramps, r)
+	}
+	return ramps
+}
+
+//SetTargetTemperatureRamped changes the target temperature of a sensor gradually over the
+//given duration, in steps of RampStep, to avoid overshooting on floors with a high thermal
+//inertia or for a gentle warm-up in the morning. The steps are written in the background at
+//equal intervals, the last one reaching the target at the end of the duration, until the ramp
+//completes, it is cancelled, or ctx is done. Starting another ramp or calling
+//SetTargetTemperature for the sensor cancels the ramp.
+func (c *Client) SetTargetTemperatureRamped(ctx context.Context, sensorID int, target float32, duration time.Duration) (*Ramp, error) {
+	if _, err := c.formatTarget(sensorID, target); err != nil {
+		return nil, err
+	}
+	current, err := c.GetSensor(ctx, sensorID, FieldTargetTemperature)
+	if err != nil {
+		return nil, err
+	}
+
+	step := c.RampStep
+	if step <= 0 {
+		step = DefaultRampStep
+	}
+	steps := int(math.Ceil(math.Abs(float64(target-current.TargetTemperature)) / float64(step)))
+	if steps < 1 {
+		steps = 1
+	}
+
+	rampCtx, cancel := context.WithCancel(ctx)
+	r := &Ramp{
+		SensorID: sensorID,
+		From:     current.TargetTemperature,
+		To:       target,
+		Start:    time.Now(),
+		Duration: duration,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	c.ramps.replace(r)
+
+	setpoint := func(i int) float32 {
+		if i == steps {
+			return target
+		}
+		return r.From + (target-r.From)*float32(i)/float32(steps)
+	}
+	go func() {
+		defer cancel()
+		defer c.ramps.remove(r)
+		defer close(r.done)
+
+		//step i is written at i/steps of the duration, step 0 being the current setpoint
+		for i := 1; i <= steps; i++ {
+			timer := time.NewTimer(time.Until(r.Start.Add(duration * time.Duration(i) / time.Duration(steps))))
+			select {
+			case <-rampCtx.Done():
+				timer.Stop()
+				r.err = rampCtx.Err()
+				return
+			case <-timer.C:
+			}
+			if err := c.setTargetTemperature(rampCtx, sensorID, setpoint(i)); err != nil {
+				c.logf(LogWarning, "ramp of sensor %v stopped: %v", sensorID, err)
+				r.err = err
+				return
+			}
+		}
+	}()
+	return r, nil
+}
@@ -0,0 +1,64 @@
+package roth_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/rothtest"
+)
+
+func TestRampSchedule(t *testing.T) {
+	srv := rothtest.NewServer(testSensors()...)
+	defer srv.Close()
+	client := newTestClient(srv)
+
+	var mu sync.Mutex
+	var writes []roth.ValueWritten
+	client.Events().Subscribe(func(e roth.Event) {
+		if w, ok := e.(roth.ValueWritten); ok {
+			mu.Lock()
+			writes = append(writes, w)
+			mu.Unlock()
+		}
+	})
+
+	//21 to 22 is two steps of 0.5, due at half and at the end of the duration
+	duration := 200 * time.Millisecond
+	r, err := client.SetTargetTemperatureRamped(context.Background(), 0, 22, duration)
+	if err != nil {
+		t.Fatalf("SetTargetTemperatureRamped: %v", err)
+	}
+	select {
+	case <-r.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("ramp did not complete")
+	}
+	if err := r.Err(); err != nil {
+		t.Fatalf("ramp failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"2150", "2200"}
+	if len(writes) != len(want) {
+		t.Fatalf("got %v writes, want %v", len(writes), len(want))
+	}
+	for i, w := range writes {
+		due := r.Start.Add(duration * time.Duration(i+1) / time.Duration(len(want)))
+		if w.Value != want[i] || w.Time.Before(due) {
+			t.Errorf("step %v: wrote %v at %v, want %v at %v or later", i+1, w.Value, w.Time.Sub(r.Start), want[i], due.Sub(r.Start))
+		}
+	}
+}
+
+func TestRampInvalidTarget(t *testing.T) {
+	srv := rothtest.NewServer(testSensors()...)
+	defer srv.Close()
+
+	if _, err := newTestClient(srv).SetTargetTemperatureRamped(context.Background(), 0, 50, time.Hour); err == nil {
+		t.Error("ramp to 50° succeeded")
+	}
+}
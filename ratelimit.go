@@ -0,0 +1,43 @@
+package roth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//rateLimiter spaces operations at least a given interval apart
+type rateLimiter struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+//wait blocks until the next slot is available, or the context is done. Slots are reserved in
+//call order, so waiting callers are served first come, first served.
+func (r *rateLimiter) wait(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return nil
+	}
+
+	r.mu.Lock()
+	now := time.Now()
+	slot := r.next
+	if slot.Before(now) {
+		slot = now
+	}
+	r.next = slot.Add(interval)
+	r.mu.Unlock()
+
+	delay := slot.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
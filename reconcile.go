@@ -0,0 +1,197 @@
+package roth
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+//DesiredState is the state a sensor should be kept in by a Reconciler. Only the fields in
+//Fields are enforced; FieldTargetTemperature, FieldMode and FieldProgram are supported.
+type DesiredState struct {
+	Fields            Field   `json:"fields"`
+	TargetTemperature float32 `json:"targetTemperature"`
+	Mode              Mode    `json:"mode"`
+	Program           Program `json:"program"`
+}
+
+//desiredKey is the storage key of the desired state
+const desiredKey = "desired.json"
+
+//Correction describes a field found to differ from the desired state, and the attempt to
+//re-apply the desired value
+type Correction struct {
+	SensorID int
+	Field    Field
+	Actual   string
+	Desired  string
+	Time     time.Time
+	//Err is set if writing the desired value failed
+	Err error
+}
+
+func (c Correction) String() string {
+	result := "corrected"
+	if c.Err != nil {
+		result = fmt.Sprintf("correction failed: %v", c.Err)
+	}
+	return fmt.Sprintf("sensor %v %v was %v, desired %v: %v", c.SensorID, c.Field, c.Actual, c.Desired, result)
+}
+
+//Reconciler periodically verifies that the controller matches a declared desired state, and
+//re-applies any drift, e.g. after the controller reverted settings on a power cycle
+type Reconciler struct {
+	client   *Client
+	interval time.Duration
+
+	//OnCorrection is called for every field found to differ from the desired state
+	OnCorrection func(Correction)
+
+	mu      sync.Mutex
+	desired map[int]DesiredState
+	storage Storage
+
+	runner Runner
+}
+
+//NewReconciler creates a reconciler checking the controller at the given interval
+func NewReconciler(client *Client, interval time.Duration) *Reconciler {
+	return &Reconciler{
+		client:   client,
+		interval: interval,
+		desired:  make(map[int]DesiredState),
+	}
+}
+
+//Persist loads the desired state stored in the storage, replacing any declared state, and
+//stores every later change, so the desired state survives restarts
+func (r *Reconciler) Persist(storage Storage) error {
+	desired := make(map[int]DesiredState)
+	if _, err := LoadJSON(storage, desiredKey, &desired); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.desired = desired
+	r.storage = storage
+	return nil
+}
+
+//SetDesired declares the desired state of a sensor, replacing any previous declaration
+func (r *Reconciler) SetDesired(sensorID int, state DesiredState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.desired[sensorID] = state
+	r.save()
+}
+
+//ClearDesired stops enforcing any state on a sensor
+func (r *Reconciler) ClearDesired(sensorID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.desired, sensorID)
+	r.save()
+}
+
+//save stores the desired state, if persisted. The caller must hold r.mu.
+func (r *Reconciler) save() {
+	if r.storage == nil {
+		return
+	}
+	if err := StoreJSON(r.storage, desiredKey, r.desired); err != nil {
+		r.client.logf(LogWarning, "error storing desired state: %v", err)
+	}
+}
+
+//Desired returns the declared desired state of all sensors
+func (r *Reconciler) Desired() map[int]DesiredState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	desired := make(map[int]DesiredState, len(r.desired))
+	for id, state := range r.desired {
+		desired[id] = state
+	}
+	return desired
+}
+
+//Reconcile reads the controller once, and corrects every field differing from the desired
+//state. Fields the controller did not report are left alone.
+func (r *Reconciler) Reconcile(ctx context.Context) ([]Correction, error) {
+	desired := r.Desired()
+	if len(desired) == 0 {
+		return nil, nil
+	}
+
+	sensorCount, err := r.client.GetSensorCount(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sensors, err := r.client.ForceRefresh(ctx, sensorCount)
+	if err != nil {
+		return nil, err
+	}
+
+	var corrections []Correction
+	for _, sensor := range sensors {
+		state, ok := desired[sensor.Id]
+		if !ok {
+			continue
+		}
+
+		check := func(field Field, differs bool, actual, want interface{}, apply func() error) {
+			if !state.Fields.Has(field) || !sensor.Valid.Has(field) || !differs {
+				return
+			}
+			correction := Correction{
+				SensorID: sensor.Id,
+				Field:    field,
+				Actual:   fmt.Sprint(actual),
+				Desired:  fmt.Sprint(want),
+				Time:     time.Now(),
+				Err:      apply(),
+			}
+			r.client.logf(LogInfo, "%v", correction)
+			corrections = append(corrections, correction)
+			r.client.Events().Publish(Corrected{Time: time.Now(), Correction: correction})
+			if r.OnCorrection != nil {
+				r.OnCorrection(correction)
+			}
+		}
+
+		id := sensor.Id
+		check(FieldTargetTemperature, r.client.TargetDiffers(id, sensor.TargetTemperature, state.TargetTemperature),
+			sensor.TargetTemperature, state.TargetTemperature,
+			func() error { return r.client.SetTargetTemperature(ctx, id, state.TargetTemperature) })
+		check(FieldMode, sensor.Mode != state.Mode, sensor.Mode, state.Mode,
+			func() error { return r.client.SetMode(ctx, id, state.Mode) })
+		check(FieldProgram, sensor.Program != state.Program, sensor.Program, state.Program,
+			func() error { return r.client.SetProgram(ctx, id, state.Program) })
+	}
+
+	return corrections, nil
+}
+
+//temperatureDiffers compares temperatures at the resolution of the controller
+func temperatureDiffers(a, b float32) bool {
+	return formatTemperature(a) != formatTemperature(b)
+}
+
+//Run reconciles at the configured interval until the context is cancelled
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Reconcile(ctx); err != nil && ctx.Err() == nil {
+			r.client.logf(LogWarning, "reconcile failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
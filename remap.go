@@ -0,0 +1,65 @@
+package roth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+//uniqueIDDatapoint is the radio id of a thermostat, which is kept when it is re-paired while its
+//index changes
+const uniqueIDDatapoint = "kurzID"
+
+//uniqueIDs reads the unique id of every sensor, by sensor id
+func (c *Client) uniqueIDs(ctx context.Context, sensorCount int) (map[int]string, error) {
+	sensorIDs := c.sensorIDs(sensorCount)
+	names := make([]string, len(sensorIDs))
+	for i, id := range sensorIDs {
+		names[i] = fmt.Sprintf("G%v.%v", id, uniqueIDDatapoint)
+	}
+	values, err := c.ReadRaw(ctx, names...)
+	ids := make(map[int]string, sensorCount)
+	for i, name := range names {
+		if value := values[name]; value != "" {
+			ids[sensorIDs[i]] = value
+		}
+	}
+	return ids, err
+}
+
+//RemapConfig returns a copy of a config dumped before thermostats were re-paired, with the sensor
+//ids changed to the current index of each thermostat, found by its unique id. Restore the result
+//with RestoreConfig to move names and settings back to the right rooms. Thermostats without a
+//unique id in the config, or no longer paired, are left out and returned in unmatched.
+func (c *Client) RemapConfig(ctx context.Context, config *Config) (remapped *Config, unmatched []SensorConfig, err error) {
+	if config == nil {
+		return nil, nil, errors.New("no config to remap")
+	}
+	sensorCount, err := c.GetSensorCount(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	current, err := c.uniqueIDs(ctx, sensorCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	byUniqueID := make(map[string]int, len(current))
+	for id, uniqueID := range current {
+		byUniqueID[uniqueID] = id
+	}
+
+	remapped = &Config{Time: config.Time, Unit: config.Unit}
+	for _, sc := range config.Sensors {
+		id, ok := byUniqueID[sc.UniqueID]
+		if sc.UniqueID == "" || !ok {
+			unmatched = append(unmatched, sc)
+			continue
+		}
+		if id != sc.Id {
+			c.logf(LogInfo, "thermostat %v moved from sensor %v to %v", sc.UniqueID, sc.Id, id)
+		}
+		sc.Id = id
+		remapped.Sensors = append(remapped.Sensors, sc)
+	}
+	return remapped, unmatched, nil
+}
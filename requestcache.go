@@ -0,0 +1,78 @@
+package roth
+
+import (
+	"sync"
+
+	"github.com/kvantetore/rothTouchline/protocol"
+)
+
+//maxCachedRequests limits the number of serialized requests kept. Polls repeat a handful of
+//requests, one per chunk of the sensor read, so the limit is only reached if the requests keep
+//changing, in which case caching does not help anyway.
+const maxCachedRequests = 64
+
+//requestCache holds serialized read requests, so polls repeating the same request do not
+//serialize it again
+type requestCache struct {
+	mu     sync.Mutex
+	bodies map[uint64]cachedRequest
+}
+
+type cachedRequest struct {
+	items []readRequestItem
+	body  []byte
+}
+
+//body returns the serialized request. The returned slice is shared, and must not be modified.
+func (rc *requestCache) body(req readRequest) ([]byte, error) {
+	key := requestKey(req)
+
+	rc.mu.Lock()
+	cached, ok := rc.bodies[key]
+	rc.mu.Unlock()
+	if ok && sameItems(cached.items, req.Items) {
+		return cached.body, nil
+	}
+
+	body, err := protocol.MarshalRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]readRequestItem, len(req.Items))
+	copy(items, req.Items)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.bodies == nil || len(rc.bodies) >= maxCachedRequests {
+		rc.bodies = make(map[uint64]cachedRequest)
+	}
+	rc.bodies[key] = cachedRequest{items: items, body: body}
+	return body, nil
+}
+
+//requestKey hashes the item names with FNV-1a, without allocating
+func requestKey(req readRequest) uint64 {
+	const prime = 1099511628211
+	key := uint64(14695981039346656037)
+	for _, item := range req.Items {
+		for i := 0; i < len(item.Name); i++ {
+			key ^= uint64(item.Name[i])
+			key *= prime
+		}
+		//separate the names, so G1.a,b and G1.,ab differ
+		key *= prime
+	}
+	return key
+}
+
+func sameItems(a, b []readRequestItem) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package roth
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+//Rounding is how target temperatures are rounded to Client.TemperatureResolution
+type Rounding int
+
+const (
+	//RoundNearest rounds to the nearest step, halfway values up
+	RoundNearest Rounding = iota
+	//RoundDown rounds to the step below, e.g. to never heat more than asked for
+	RoundDown
+	//RoundUp rounds to the step above
+	RoundUp
+)
+
+var roundingNames = []string{"nearest", "down", "up"}
+
+func (r Rounding) String() string {
+	if r < RoundNearest || r > RoundUp {
+		return fmt.Sprintf("Rounding(%d)", int(r))
+	}
+	return roundingNames[r]
+}
+
+//limits of the target temperature accepted by the thermostats, in Celsius
+const (
+	MinTargetTemperature = 5
+	MaxTargetTemperature = 30
+)
+
+//formatTarget converts a target temperature in the client unit to the raw value expected by
+//the controller for the given sensor, rounded to the resolution of the client. Sensors not read
+//yet are assumed to use Celsius. Targets the thermostats can not be set to are an error.
+func (c *Client) formatTarget(sensorID int, t float32) (string, error) {
+	if err := c.checkTarget(t); err != nil {
+		return "", err
+	}
+	converted := ConvertTemperature(t, c.Unit, c.targetUnit(sensorID))
+	return strconv.FormatInt(roundToStep(converted, c.TemperatureResolution, c.Rounding), 10), nil
+}
+
+//targetUnit returns the unit target temperatures of the given sensor are written in
+func (c *Client) targetUnit(sensorID int) Unit {
+	//writes to a virtual sensor are fanned out to its members, using the unit of the first
+	if v, ok := c.virtualSensor(sensorID); ok && len(v.Members) > 0 {
+		sensorID = v.Members[0]
+	}
+	return c.units.get(sensorID)
+}
+
+//RoundTarget returns the target temperature a sensor reports after t is written to it, in the
+//client unit: rounded to the resolution of the client, and converted to the unit of the
+//thermostat and back. Code comparing the target read with the one it wants should compare
+//with the rounded one, see TargetDiffers.
+func (c *Client) RoundTarget(sensorID int, t float32) float32 {
+	unit := c.targetUnit(sensorID)
+	raw := roundToStep(ConvertTemperature(t, c.Unit, unit), c.TemperatureResolution, c.Rounding)
+	return ConvertTemperature(float32(raw)/100, unit, c.Unit)
+}
+
+//TargetDiffers returns whether a sensor reporting the target temperature current would change
+//if desired was written to it, i.e. whether they differ at the resolution of the client
+func (c *Client) TargetDiffers(sensorID int, current, desired float32) bool {
+	return temperatureDiffers(current, c.RoundTarget(sensorID, desired))
+}
+
+//checkTarget returns an error for target temperatures in the client unit which are not numbers,
+//or outside the limits of the thermostats
+func (c *Client) checkTarget(t float32) error {
+	return checkTargetIn(t, c.Unit)
+}
+
+//checkTargetIn is checkTarget for target temperatures in the given unit
+func checkTargetIn(t float32, unit Unit) error {
+	if math.IsNaN(float64(t)) || math.IsInf(float64(t), 0) {
+		return fmt.Errorf("invalid target temperature %v", t)
+	}
+	min := ConvertTemperature(MinTargetTemperature, Celsius, unit)
+	max := ConvertTemperature(MaxTargetTemperature, Celsius, unit)
+	if t < min-0.005 || t > max+0.005 {
+		return fmt.Errorf("invalid target temperature %v: must be between %v and %v", t, min, max)
+	}
+	return nil
+}
+
+//roundToStep rounds a temperature to the given step, and returns it in the centidegrees used by
+//the controller. The temperature is rounded to centidegrees first, so 21.05 is not taken for
+//21.0499. A step below 0.01 rounds to centidegrees only.
+func roundToStep(t float32, step float32, rounding Rounding) int64 {
+	centi := int64(math.Round(float64(t) * 100))
+	stepCenti := int64(math.Round(float64(step) * 100))
+	if stepCenti <= 1 {
+		return centi
+	}
+	steps := centi / stepCenti
+	rest := centi % stepCenti
+	if rest < 0 {
+		steps, rest = steps-1, rest+stepCenti
+	}
+	switch {
+	case rest == 0:
+	case rounding == RoundUp:
+		steps++
+	case rounding == RoundNearest && 2*rest >= stepCenti:
+		steps++
+	}
+	return steps * stepCenti
+}
@@ -0,0 +1,179 @@
+package roth_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/rothtest"
+)
+
+//targetWriters are the ways of writing a target temperature, which must all check and round it
+//the same way
+var targetWriters = []struct {
+	name  string
+	write func(c *roth.Client, sensorID int, t float32) error
+}{
+	{"SetTargetTemperature", func(c *roth.Client, sensorID int, t float32) error {
+		return c.SetTargetTemperature(context.Background(), sensorID, t)
+	}},
+	{"WriteDatapoint", func(c *roth.Client, sensorID int, t float32) error {
+		return c.WriteDatapoint(context.Background(), sensorID, "targetTemperature", float64(t))
+	}},
+	{"Batch", func(c *roth.Client, sensorID int, t float32) error {
+		return c.NewBatch().SetTargetTemperature(sensorID, t).Send(context.Background())[sensorID]
+	}},
+}
+
+func TestSetTargetTemperature(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []roth.Option
+		target  float32
+		//want is the raw value written, or empty if the target is rejected
+		want string
+	}{
+		{name: "centidegrees", target: 21.37, want: "2137"},
+		{name: "lower limit", target: roth.MinTargetTemperature, want: "500"},
+		{name: "upper limit", target: roth.MaxTargetTemperature, want: "3000"},
+		{name: "below lower limit", target: 4.9},
+		{name: "above upper limit", target: 30.1},
+		{name: "not a number", target: float32(math.NaN())},
+		{name: "infinite", target: float32(math.Inf(1))},
+		{
+			name:    "nearest half degree",
+			options: []roth.Option{roth.WithTemperatureResolution(0.5, roth.RoundNearest)},
+			target:  21.3,
+			want:    "2150",
+		},
+		{
+			name:    "halfway rounds up",
+			options: []roth.Option{roth.WithTemperatureResolution(0.5, roth.RoundNearest)},
+			target:  21.25,
+			want:    "2150",
+		},
+		{
+			name:    "half degree down",
+			options: []roth.Option{roth.WithTemperatureResolution(0.5, roth.RoundDown)},
+			target:  21.45,
+			want:    "2100",
+		},
+		{
+			name:    "half degree up",
+			options: []roth.Option{roth.WithTemperatureResolution(0.5, roth.RoundUp)},
+			target:  21.05,
+			want:    "2150",
+		},
+		{
+			//21.05 is 21.049999 as a float32, and must not be taken for below the halfway point
+			name:    "tenth degree halfway",
+			options: []roth.Option{roth.WithTemperatureResolution(0.1, roth.RoundNearest)},
+			target:  21.05,
+			want:    "2110",
+		},
+		{
+			name:    "fahrenheit",
+			options: []roth.Option{roth.WithUnit(roth.Fahrenheit)},
+			target:  70,
+			want:    "2111",
+		},
+		{
+			name:    "fahrenheit above upper limit",
+			options: []roth.Option{roth.WithUnit(roth.Fahrenheit)},
+			target:  30,
+		},
+	}
+
+	for _, writer := range targetWriters {
+		for _, test := range tests {
+			t.Run(writer.name+"/"+test.name, func(t *testing.T) {
+				srv := rothtest.NewServer(testSensors()...)
+				defer srv.Close()
+				before, _ := srv.Value("G0.SollTemp")
+
+				err := writer.write(newTestClient(srv, test.options...), 0, test.target)
+				got, _ := srv.Value("G0.SollTemp")
+				if test.want == "" {
+					if err == nil {
+						t.Errorf("target %v accepted, want an error", test.target)
+					}
+					if got != before {
+						t.Errorf("rejected target written as %v", got)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("target %v: %v", test.target, err)
+				}
+				if got != test.want {
+					t.Errorf("target %v written as %v, want %v", test.target, got, test.want)
+				}
+			})
+		}
+	}
+}
+
+//TestTargetComparedAsWritten checks that targets between two steps of the resolution are
+//written once, and not reported as drift again once the thermostat reports the rounded target
+func TestTargetComparedAsWritten(t *testing.T) {
+	tests := []struct {
+		name string
+		//unit is the unit of the client and of desired
+		unit    roth.Unit
+		desired float32
+		want    string
+	}{
+		{"half degree", roth.Celsius, 21.3, "2150"},
+		{"fahrenheit", roth.Fahrenheit, 72, "2200"},
+	}
+
+	for _, test := range tests {
+		t.Run("Reconcile/"+test.name, func(t *testing.T) {
+			srv := rothtest.NewServer(testSensors()...)
+			defer srv.Close()
+			r := roth.NewReconciler(newTestClient(srv, roth.WithUnit(test.unit), roth.WithTemperatureResolution(0.5, roth.RoundNearest)), time.Minute)
+			r.SetDesired(0, roth.DesiredState{Fields: roth.FieldTargetTemperature, TargetTemperature: test.desired})
+
+			for i, wantCorrections := range []int{1, 0, 0} {
+				corrections, err := r.Reconcile(context.Background())
+				if err != nil {
+					t.Fatalf("Reconcile: %v", err)
+				}
+				if len(corrections) != wantCorrections {
+					t.Errorf("cycle %v: got corrections %v, want %v", i, corrections, wantCorrections)
+				}
+			}
+			if got, _ := srv.Value("G0.SollTemp"); got != test.want {
+				t.Errorf("got target %v, want %v", got, test.want)
+			}
+		})
+
+		t.Run("CompareConfig/"+test.name, func(t *testing.T) {
+			srv := rothtest.NewServer(testSensors()...)
+			defer srv.Close()
+			c := newTestClient(srv, roth.WithUnit(test.unit), roth.WithTemperatureResolution(0.5, roth.RoundNearest))
+			config := &roth.DeclaredConfig{
+				Unit:    test.unit,
+				Sensors: []roth.DeclaredSensor{{Id: 0, TargetTemperature: &test.desired}},
+			}
+
+			for i, wantDrift := range []int{1, 0, 0} {
+				drift, err := c.CompareConfig(context.Background(), config)
+				if err != nil {
+					t.Fatalf("CompareConfig: %v", err)
+				}
+				if len(drift) != wantDrift {
+					t.Errorf("cycle %v: got drift %v, want %v", i, drift, wantDrift)
+				}
+				if err := c.ApplyDrift(context.Background(), drift).Err(); err != nil {
+					t.Fatalf("ApplyDrift: %v", err)
+				}
+			}
+			if got, _ := srv.Value("G0.SollTemp"); got != test.want {
+				t.Errorf("got target %v, want %v", got, test.want)
+			}
+		})
+	}
+}
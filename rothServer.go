@@ -1,56 +1,74 @@
 package roth
 
 import (
-	"bytes"
-	"encoding/xml"
-	"errors"
-	"fmt"
-	"io/ioutil"
-	"net/http"
-	"regexp"
-	"strconv"
-)
-
-const (
-	//ProgramConstant is no program, i.e. the same temperature setting throughout the day and week
-	ProgramConstant = 0
-	//Program1 is one of the three programmable programs on the thermostat
-	Program1 = 1
-	//Program2 is one of the three programmable programs on the thermostat
-	Program2 = 2
-	//Program3 is one of the three programmable programs on the thermostat
-	Program3 = 3
-
-	//ModeDay is the normal operating mode
-	ModeDay = 0
-	//ModeNight is night operating mode
-	ModeNight = 1
-	//ModeHoliday is holiday mode (no frost)
-	ModeHoliday = 2
+	"context"
+	"time"
 )
 
 //Sensor represents a state of one of the Roth thermostat sensors.
 type Sensor struct {
+	//Id is the index of the thermostat on the controller, as in its item names like G3.RaumTemp.
+	//It is not the position in the slice returned by GetSensors, as the controller keeps the
+	//indices of the other thermostats when one is removed.
 	Id                int
 	Name              string
 	RoomTemperature   float32
 	TargetTemperature float32
-	Program           int
-	Mode              int
-}
+	Program           Program
+	Mode              Mode
 
-const (
-	//ValveOpen represents a valve in its open state
-	ValveOpen = "open"
+	//Unit is the unit of RoomTemperature and TargetTemperature, as configured on the client
+	Unit Unit
 
-	//ValveClosed represents a valve in its closed state
-	ValveClosed = "closed"
-)
+	//Valid is the set of fields actually populated from the controller response. Fields not
+	//in the set have their zero value, and should not be trusted.
+	Valid Field
+
+	//Extra holds the raw values of datapoints registered with Client.RegisterDatapoint without
+	//a Parse function, by datapoint name. It is shared between copies of the sensor, and must
+	//not be modified.
+	Extra map[string]string
+
+	//Values holds the values of datapoints in Extra decoded by a codec registered with
+	//Client.RegisterCodec, by datapoint name. Like Extra, it must not be modified.
+	Values map[string]interface{}
+
+	//Raw holds the values of all datapoints as returned by the controller, before any parsing
+	//or scaling, by datapoint name. It is only populated if Client.KeepRawValues is set, and
+	//must not be modified.
+	Raw map[string]string
+
+	//ReadAt is when the values were read from the controller, see Age
+	ReadAt time.Time
+
+	//Stale is set if the controller could not be reached, and the values are those of an
+	//earlier read, see Client.LastKnownGood
+	Stale bool
+
+	//Step is the active step of the week program, if resolved with Client.ResolveProgramSteps
+	Step *ProgramStep
+
+	//Metadata holds the annotations set with Client.SetMetadata, or nil. Like Extra, it is
+	//shared between copies of the sensor, and must not be modified.
+	Metadata *Metadata
+
+	//Members holds the ids of the sensors a virtual sensor is computed from, see
+	//Client.AddVirtualSensor, or nil for physical sensors. Like Extra, it must not be modified.
+	Members []int
+
+	//Alarms holds the error conditions of the thermostat if read, see Client.ReadAlarms, or nil
+	Alarms *Alarms
+}
+
+//Missing returns the set of fields not populated from the controller response
+func (s Sensor) Missing() Field {
+	return AllFields &^ s.Valid
+}
 
 //GetValveState returns the current state of the valve connected (open/closed) to the sensor.
 //This is currently derived from room and target temperature, as the roth server does not expose
 //the valve state directly.
-func (s Sensor) GetValveState() string {
+func (s Sensor) GetValveState() ValveState {
 	if s.RoomTemperature < s.TargetTemperature {
 		return ValveOpen
 	}
@@ -67,201 +85,27 @@ func (s Sensor) GetValveValue() int32 {
 	return 0
 }
 
-/*
-Example request: POST http://ROTH-10A6D5/cgi-bin/ILRReadValues.cgi
-<body>
-	<item_list>
-		<i><n>G0.RaumTemp</n></i>
-		<i><n>G1.RaumTemp</n></i>
-	</item_list>
-</body>
-
-Example response
-<body>
-	<item_list>
-		<i>
-			<n>G0.RaumTemp</n>
-			<v>2086</v>
-		</i>
-		<i>
-			<n>G1.RaumTemp</n>
-			<v>1903</v>
-		</i>
-	</item_list>
-</body>
-*/
-
-type readRequest struct {
-	Items []readRequestItem `xml:"item_list>i"`
-}
-
-type readRequestItem struct {
-	Name string `xml:"n"`
-}
-
-type response struct {
-	Items []responseItem `xml:"item_list>i"`
-}
-
-type responseItem struct {
-	Name  string `xml:"n"`
-	Value string `xml:"v"`
-}
-
-func marshalRequest(req readRequest) ([]byte, error) {
-	tmp := struct {
-		readRequest
-		XMLName struct{} `xml:"body"`
-	}{readRequest: req}
-
-	return xml.MarshalIndent(tmp, "", "   ")
-}
-
-func readValues(managementURL string, req readRequest) (resp response, err error) {
-	//Serialize request
-	requstData, err := marshalRequest(req)
-	if err != nil {
-		fmt.Printf("error: %v\n", err)
-		return
-	}
-
-	//Send request
-	url := fmt.Sprintf("%v/cgi-bin/ILRReadValues.cgi", managementURL)
-	httpResponse, err := http.Post(url, "text/xml", bytes.NewReader(requstData))
-	if err != nil {
-		return response{}, errors.New("error requesting data from server")
-	}
-	defer httpResponse.Body.Close()
-	body, err := ioutil.ReadAll(httpResponse.Body)
-	if err != nil {
-		return response{}, errors.New("error reading response")
-	}
-
-	//read into struct
-	err = xml.Unmarshal(body, &resp)
-	if err != nil {
-		return response{}, errors.New("error parsing xml")
-	}
-
-	return resp, nil
-}
-
-func writeValue(managementURL string, sensorID int, valueName string, value string) error {
-	//Send request
-	url := fmt.Sprintf("%v/cgi-bin/writeVal.cgi?G%v.%v=%v", managementURL, sensorID, valueName, value)
-	result, err := http.Get(url)
-	if err != nil {
-		return errors.New("error sending data to server")
-	}
-	defer result.Body.Close()
-	_, err = ioutil.ReadAll(result.Body)
-	if err != nil {
-		return errors.New("error reading response")
-	}
-
-	return nil
-}
-
 //GetSensorCount returns the total number of sensors on the server
 func GetSensorCount(managementURL string) (sensorCount int, err error) {
-	req := readRequest{Items: []readRequestItem{readRequestItem{Name: "totalNumberOfDevices"}}}
-
-	resp, err := readValues(managementURL, req)
-	if err != nil {
-		return 0, err
-	}
-
-	if len(resp.Items) == 0 {
-		return 0, errors.New("no values returned")
-	}
-
-	intValue, err := strconv.ParseInt(resp.Items[0].Value, 10, 8)
-	if err != nil {
-		return 0, fmt.Errorf("Unexpected value %v", resp.Items[0].Value)
-	}
-
-	return int(intValue), nil
+	return NewClient(managementURL).GetSensorCount(context.Background())
 }
 
 //SetTargetTemperature changes the target temperature of a given sensor
 func SetTargetTemperature(managementURL string, sensorID int, targetTemperature float32) error {
-	value := strconv.FormatFloat(float64(targetTemperature*100), 'f', 0, 32)
-	return writeValue(managementURL, sensorID, "SollTemp", value)
+	return NewClient(managementURL).SetTargetTemperature(context.Background(), sensorID, targetTemperature)
 }
 
 //SetProgram changes the active week program of the thermostat
-func SetProgram(managementURL string, sensorID int, program int) error {
-	value := strconv.Itoa(program)
-	return writeValue(managementURL, sensorID, "WeekProg", value)
+func SetProgram(managementURL string, sensorID int, program Program) error {
+	return NewClient(managementURL).SetProgram(context.Background(), sensorID, program)
 }
 
 //SetMode changes the active operating mode
-func SetMode(managementURL string, sensorID int, mode int) error {
-	value := strconv.Itoa(mode)
-	return writeValue(managementURL, sensorID, "OPMode", value)
+func SetMode(managementURL string, sensorID int, mode Mode) error {
+	return NewClient(managementURL).SetMode(context.Background(), sensorID, mode)
 }
 
 //GetSensors returns current sensor data for the sensors on the server
 func GetSensors(managementURL string, sensorCount int) (sensors []Sensor, err error) {
-	//Create request for all values
-	req := readRequest{}
-	req.Items = make([]readRequestItem, sensorCount*5)
-	for i := 0; i < sensorCount; i++ {
-		req.Items[i*5+0].Name = fmt.Sprintf("G%v.RaumTemp", i)
-		req.Items[i*5+1].Name = fmt.Sprintf("G%v.SollTemp", i)
-		req.Items[i*5+2].Name = fmt.Sprintf("G%v.name", i)
-		req.Items[i*5+3].Name = fmt.Sprintf("G%v.WeekProg", i)
-		req.Items[i*5+4].Name = fmt.Sprintf("G%v.OPMode", i)
-	}
-
-	resp, err := readValues(managementURL, req)
-	if err != nil {
-		return []Sensor{}, err
-	}
-
-	//parse response to list of sensors
-	var sensorInfoParser = regexp.MustCompile(`^G([0-9]+)\.(.+)$`)
-	sensors = make([]Sensor, sensorCount)
-	for i := 0; i < len(resp.Items); i++ {
-		item := resp.Items[i]
-
-		sensorInfo := sensorInfoParser.FindStringSubmatch(item.Name)
-		if len(sensorInfo) > 0 {
-			//parse sensor index from name
-			sensorIndex, err := strconv.ParseInt(sensorInfo[1], 10, 8)
-			if err != nil {
-				fmt.Printf("Error parsing sensor index %v\n", sensorInfo[1])
-			}
-			sensor := &sensors[int(sensorIndex)]
-
-			//try to parse value as float (int)
-			var floatValue float32
-			intValue, err := strconv.ParseInt(item.Value, 10, 16)
-			if err == nil {
-				floatValue = float32(intValue) / 100
-			}
-
-			valueName := sensorInfo[2]
-			sensors[int(sensorIndex)].Id = int(sensorIndex)
-			switch valueName {
-			case "RaumTemp":
-				sensor.RoomTemperature = floatValue
-			case "SollTemp":
-				sensor.TargetTemperature = floatValue
-			case "name":
-				sensor.Name = item.Value
-			case "WeekProg":
-				sensor.Program = int(intValue)
-			case "OPMode":
-				sensor.Mode = int(intValue)
-			default:
-				fmt.Printf("Unexpected value name %v\n", valueName)
-			}
-
-		} else {
-			fmt.Printf("error parsing sensor info name: %v\n", item.Name)
-		}
-	}
-
-	return sensors, nil
+	return NewClient(managementURL).GetSensors(context.Background(), sensorCount)
 }
@@ -0,0 +1,226 @@
+//Package rothsim simulates a Roth Touchline installation in software. Rooms heat up while their
+//valve is open and cool down toward the outdoor temperature while it is closed, with the lag
+//typical for underfloor heating. Automations can be developed and demoed against the simulator
+//using an ordinary roth.Client.
+package rothsim
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/rothtest"
+)
+
+//Period is a part of the day, in hours since midnight, where a program uses the comfort temperature
+type Period struct {
+	Start float64
+	End   float64
+}
+
+//Model holds the physical parameters of the simulation. The defaults returned by DefaultModel
+//roughly resemble a well insulated house with a concrete floor.
+type Model struct {
+	//OutdoorTemperature in °C
+	OutdoorTemperature float64
+	//FloorHeatingRate is the floor temperature increase per hour while the valve is open
+	FloorHeatingRate float64
+	//FloorTransfer is the fraction of the floor/room temperature difference exchanged per hour
+	FloorTransfer float64
+	//HeatLoss is the fraction of the room/outdoor temperature difference lost per hour
+	HeatLoss float64
+	//Hysteresis is the temperature below the setpoint where the valve opens
+	Hysteresis float64
+	//NightSetback is subtracted from the setpoint in night mode and outside program periods
+	NightSetback float64
+	//HolidayTemperature is the setpoint used in holiday mode
+	HolidayTemperature float64
+	//Programs contains the comfort periods of Program1, Program2 and Program3
+	Programs [3][]Period
+}
+
+//DefaultModel returns a model with plausible parameters for underfloor heating
+func DefaultModel() Model {
+	return Model{
+		OutdoorTemperature: 0,
+		FloorHeatingRate:   4,
+		FloorTransfer:      0.5,
+		HeatLoss:           0.05,
+		Hysteresis:         0.2,
+		NightSetback:       3,
+		HolidayTemperature: 8,
+		Programs: [3][]Period{
+			{{Start: 6, End: 22}},
+			{{Start: 6, End: 8}, {Start: 16, End: 22}},
+			{{Start: 7, End: 23}},
+		},
+	}
+}
+
+type room struct {
+	id    int
+	floor float64
+	air   float64
+	valve bool
+}
+
+//Simulator is a virtual controller with a thermal model per room. Setpoints, modes and programs
+//written by clients are picked up by the model on the next step.
+type Simulator struct {
+	controller *rothtest.Controller
+
+	mu    sync.Mutex
+	model Model
+	now   time.Time
+	rooms []*room
+}
+
+//New creates a simulator for the given sensors. The sensor room temperature is used as the
+//initial room and floor temperature.
+func New(model Model, sensors ...roth.Sensor) *Simulator {
+	s := &Simulator{
+		controller: rothtest.NewController(sensors...),
+		model:      model,
+		now:        time.Now(),
+	}
+	for _, sensor := range sensors {
+		t := float64(sensor.RoomTemperature)
+		s.rooms = append(s.rooms, &room{id: sensor.Id, floor: t, air: t})
+	}
+	return s
+}
+
+//Controller returns the virtual controller, e.g. for fault injection
+func (s *Simulator) Controller() *rothtest.Controller {
+	return s.controller
+}
+
+//ServeHTTP serves the controller endpoints, so the simulator can be exposed on the network
+func (s *Simulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.controller.ServeHTTP(w, r)
+}
+
+//Client returns a roth.Client talking to the simulator in-process
+func (s *Simulator) Client() *roth.Client {
+	client := roth.NewClient("http://simulator.invalid")
+	client.HTTPClient = &http.Client{Transport: s.controller.Transport()}
+	return client
+}
+
+//Now returns the simulated time
+func (s *Simulator) Now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.now
+}
+
+//SetOutdoorTemperature changes the outdoor temperature of the model
+func (s *Simulator) SetOutdoorTemperature(t float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.model.OutdoorTemperature = t
+}
+
+//Valve returns whether the valve of the given sensor is currently open in the model
+func (s *Simulator) Valve(sensorID int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.rooms {
+		if r.id == sensorID {
+			return r.valve
+		}
+	}
+	return false
+}
+
+//Step advances the simulation by the given duration of simulated time
+func (s *Simulator) Step(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	//integrate in steps of at most one simulated minute to keep the model stable
+	for d > 0 {
+		dt := d
+		if dt > time.Minute {
+			dt = time.Minute
+		}
+		s.step(dt.Hours())
+		s.now = s.now.Add(dt)
+		d -= dt
+	}
+
+	for _, r := range s.rooms {
+		s.controller.SetValue(fmt.Sprintf("G%v.RaumTemp", r.id), strconv.Itoa(int(r.air*100+0.5)))
+	}
+}
+
+func (s *Simulator) step(hours float64) {
+	m := s.model
+	for _, r := range s.rooms {
+		setpoint := s.setpoint(r.id)
+		if r.air < setpoint-m.Hysteresis {
+			r.valve = true
+		} else if r.air >= setpoint {
+			r.valve = false
+		}
+
+		transfer := (r.floor - r.air) * m.FloorTransfer * hours
+		if r.valve {
+			r.floor += m.FloorHeatingRate * hours
+		}
+		r.floor -= transfer
+		r.air += transfer - (r.air-m.OutdoorTemperature)*m.HeatLoss*hours
+	}
+}
+
+//setpoint returns the effective setpoint of a room, taking mode and program into account
+func (s *Simulator) setpoint(id int) float64 {
+	m := s.model
+	target := float64(s.intValue(id, "SollTemp")) / 100
+
+	switch roth.Mode(s.intValue(id, "OPMode")) {
+	case roth.ModeNight:
+		return target - m.NightSetback
+	case roth.ModeHoliday:
+		return m.HolidayTemperature
+	}
+
+	program := roth.Program(s.intValue(id, "WeekProg"))
+	if program < roth.Program1 || program > roth.Program3 {
+		return target
+	}
+	hour := float64(s.now.Hour()) + float64(s.now.Minute())/60
+	for _, p := range m.Programs[program-1] {
+		if hour >= p.Start && hour < p.End {
+			return target
+		}
+	}
+	return target - m.NightSetback
+}
+
+func (s *Simulator) intValue(id int, name string) int {
+	value, _ := s.controller.Value(fmt.Sprintf("G%v.%v", id, name))
+	i, _ := strconv.Atoi(value)
+	return i
+}
+
+//Run advances the simulation in real time until the context is cancelled. Every tick of the
+//given interval advances simulated time by interval*speed, so a speed of 60 simulates an hour
+//per minute.
+func (s *Simulator) Run(ctx context.Context, interval time.Duration, speed float64) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Step(time.Duration(float64(interval) * speed))
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package rothtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+//Interaction is a single request/response pair exchanged with a controller
+type Interaction struct {
+	Method       string `json:"method"`
+	URL          string `json:"url"`
+	RequestBody  string `json:"requestBody,omitempty"`
+	StatusCode   int    `json:"statusCode"`
+	ResponseBody string `json:"responseBody"`
+}
+
+//Cassette is a sequence of recorded interactions, stored on disk as json
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+//LoadCassette reads a cassette previously written by Save
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cassette Cassette
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		return nil, fmt.Errorf("error parsing cassette %v: %v", path, err)
+	}
+	return &cassette, nil
+}
+
+//Save writes the cassette to the given file
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+//requestURL returns the part of the request url identifying an interaction. The host is left
+//out, so a cassette recorded against one controller address can be replayed against any other.
+func requestURL(req *http.Request) string {
+	return req.URL.RequestURI()
+}
+
+func readRequestBody(req *http.Request) (string, error) {
+	if req.Body == nil {
+		return "", nil
+	}
+	data, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	return string(data), nil
+}
+
+//Recorder is an http.RoundTripper capturing the traffic between a client and a real controller.
+//Install it as the transport of roth.Client.HTTPClient and call Save when done.
+type Recorder struct {
+	transport http.RoundTripper
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+//NewRecorder creates a recorder forwarding requests to the given transport. If transport is nil,
+//http.DefaultTransport is used.
+func NewRecorder(transport http.RoundTripper) *Recorder {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &Recorder{transport: transport}
+}
+
+//RoundTrip forwards the request and records the exchange
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	requestBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(responseBody))
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Method:       req.Method,
+		URL:          requestURL(req),
+		RequestBody:  requestBody,
+		StatusCode:   resp.StatusCode,
+		ResponseBody: string(responseBody),
+	})
+	r.mu.Unlock()
+
+	return resp, nil
+}
+
+//Cassette returns a copy of the interactions recorded so far
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	interactions := make([]Interaction, len(r.cassette.Interactions))
+	copy(interactions, r.cassette.Interactions)
+	return &Cassette{Interactions: interactions}
+}
+
+//Save writes the interactions recorded so far to the given file
+func (r *Recorder) Save(path string) error {
+	return r.Cassette().Save(path)
+}
+
+//ErrNoInteraction is returned by Replayer when a request has no matching recorded interaction
+var ErrNoInteraction = errors.New("no recorded interaction matches request")
+
+//Replayer is an http.RoundTripper answering requests from a cassette, without contacting any
+//controller. Interactions are matched on method, url and request body and are replayed in the
+//order they were recorded; once all matching interactions are used, the last one is repeated.
+type Replayer struct {
+	mu       sync.Mutex
+	cassette *Cassette
+	used     []bool
+}
+
+//NewReplayer creates a replayer serving the given cassette
+func NewReplayer(cassette *Cassette) *Replayer {
+	return &Replayer{
+		cassette: cassette,
+		used:     make([]bool, len(cassette.Interactions)),
+	}
+}
+
+//RoundTrip returns the recorded response matching the request
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	requestBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	url := requestURL(req)
+
+	r.mu.Lock()
+	match := -1
+	for i, interaction := range r.cassette.Interactions {
+		if interaction.Method != req.Method || interaction.URL != url ||
+			strings.TrimSpace(interaction.RequestBody) != strings.TrimSpace(requestBody) {
+			continue
+		}
+		match = i
+		if !r.used[i] {
+			break
+		}
+	}
+	if match >= 0 {
+		r.used[match] = true
+	}
+	r.mu.Unlock()
+
+	if match < 0 {
+		return nil, fmt.Errorf("%w: %v %v", ErrNoInteraction, req.Method, url)
+	}
+
+	interaction := r.cassette.Interactions[match]
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.StatusCode, http.StatusText(interaction.StatusCode)),
+		StatusCode:    interaction.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"text/xml"}},
+		Body:          ioutil.NopCloser(strings.NewReader(interaction.ResponseBody)),
+		ContentLength: int64(len(interaction.ResponseBody)),
+		Request:       req,
+	}, nil
+}
+
+//NewReplayClient creates a roth.Client running in simulation mode, answering every request
+//from the given cassette
+func NewReplayClient(cassette *Cassette) *roth.Client {
+	client := roth.NewClient("http://replay.invalid")
+	client.HTTPClient = &http.Client{Transport: NewReplayer(cassette)}
+	return client
+}
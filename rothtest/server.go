@@ -0,0 +1,265 @@
+//Package rothtest provides a fake Roth Touchline controller, so code built on the roth package
+//can be tested without access to live hardware.
+package rothtest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+//Fault selects an error condition the fake controller injects into its responses
+type Fault int
+
+const (
+	//FaultNone makes the controller respond normally
+	FaultNone Fault = iota
+	//FaultMalformedXML makes the controller respond with a truncated xml document
+	FaultMalformedXML
+	//FaultMissingItems makes the controller leave out the last requested item from its response
+	FaultMissingItems
+	//FaultTimeout makes the controller never respond, until the client gives up
+	FaultTimeout
+)
+
+type itemList struct {
+	XMLName struct{} `xml:"body"`
+	Items   []item   `xml:"item_list>i"`
+}
+
+type item struct {
+	Name  string `xml:"n"`
+	Value string `xml:"v,omitempty"`
+}
+
+//Controller is an http.Handler emulating the ILRReadValues.cgi and writeVal.cgi endpoints
+//of a Roth Touchline controller. Datapoints are kept as raw strings, exactly as the controller
+//reports them.
+type Controller struct {
+	mu      sync.Mutex
+	values  map[string]string
+	latency time.Duration
+	fault   Fault
+	closed  chan struct{}
+	once    sync.Once
+
+	//changes counts the changes of values, reported as R0.ChangeCounter if enabled
+	changeCounter bool
+	changes       uint64
+}
+
+//NewController creates a controller with datapoints for the given sensors. The sensor Id is
+//used as the datapoint index.
+func NewController(sensors ...roth.Sensor) *Controller {
+	c := &Controller{
+		values: make(map[string]string),
+		closed: make(chan struct{}),
+	}
+	c.values["totalNumberOfDevices"] = strconv.Itoa(len(sensors))
+	for _, s := range sensors {
+		c.SetSensor(s)
+	}
+	return c
+}
+
+//SetSensor replaces all datapoints of the given sensor
+func (c *Controller) SetSensor(s roth.Sensor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[fmt.Sprintf("G%v.name", s.Id)] = s.Name
+	c.values[fmt.Sprintf("G%v.RaumTemp", s.Id)] = formatTemperature(s.RoomTemperature)
+	c.values[fmt.Sprintf("G%v.SollTemp", s.Id)] = formatTemperature(s.TargetTemperature)
+	c.values[fmt.Sprintf("G%v.WeekProg", s.Id)] = strconv.Itoa(int(s.Program))
+	c.values[fmt.Sprintf("G%v.OPMode", s.Id)] = strconv.Itoa(int(s.Mode))
+	c.values[fmt.Sprintf("G%v.TempSIUnit", s.Id)] = strconv.Itoa(int(s.Unit))
+	c.changes++
+}
+
+//SetValue sets a raw datapoint value, e.g. SetValue("G0.RaumTemp", "2086")
+func (c *Controller) SetValue(name string, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[name] = value
+	c.changes++
+}
+
+//EnableChangeCounter makes the controller report the number of changes of its values as
+//R0.ChangeCounter, like newer firmware, so watchers use delta reads
+func (c *Controller) EnableChangeCounter() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.changeCounter = true
+}
+
+//Value returns a raw datapoint value, and whether the datapoint exists
+func (c *Controller) Value(name string) (value string, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok = c.values[name]
+	return value, ok
+}
+
+//SetLatency delays every response by the given duration
+func (c *Controller) SetLatency(latency time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.latency = latency
+}
+
+//SetFault selects the fault injected into subsequent responses
+func (c *Controller) SetFault(fault Fault) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fault = fault
+}
+
+//Close releases requests blocked by FaultTimeout
+func (c *Controller) Close() {
+	c.once.Do(func() { close(c.closed) })
+}
+
+func (c *Controller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	latency, fault := c.latency, c.fault
+	c.mu.Unlock()
+
+	if fault == FaultTimeout {
+		select {
+		case <-r.Context().Done():
+		case <-c.closed:
+		}
+		return
+	}
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	switch r.URL.Path {
+	case "/cgi-bin/ILRReadValues.cgi":
+		c.serveRead(w, r, fault)
+	case "/cgi-bin/writeVal.cgi":
+		c.serveWrite(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (c *Controller) serveRead(w http.ResponseWriter, r *http.Request, fault Fault) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "error reading request", http.StatusBadRequest)
+		return
+	}
+
+	var req itemList
+	if err := xml.Unmarshal(body, &req); err != nil {
+		http.Error(w, "error parsing xml", http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	resp := itemList{Items: make([]item, 0, len(req.Items))}
+	for _, i := range req.Items {
+		value := c.values[i.Name]
+		if i.Name == "R0.ChangeCounter" && c.changeCounter {
+			value = strconv.FormatUint(c.changes, 10)
+		}
+		resp.Items = append(resp.Items, item{Name: i.Name, Value: value})
+	}
+	c.mu.Unlock()
+
+	if fault == FaultMissingItems && len(resp.Items) > 0 {
+		resp.Items = resp.Items[:len(resp.Items)-1]
+	}
+
+	data, err := xml.Marshal(resp)
+	if err != nil {
+		http.Error(w, "error creating xml", http.StatusInternalServerError)
+		return
+	}
+	if fault == FaultMalformedXML {
+		data = data[:len(data)/2]
+	}
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write(data)
+}
+
+func (c *Controller) serveWrite(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	for name, values := range r.URL.Query() {
+		if len(values) > 0 {
+			c.values[name] = values[len(values)-1]
+			c.changes++
+		}
+	}
+	c.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("OK"))
+}
+
+//Transport returns an http.RoundTripper serving requests directly from the controller, without
+//any network traffic. The host of the request url is ignored.
+func (c *Controller) Transport() http.RoundTripper {
+	return handlerTransport{handler: c}
+}
+
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorder := httptest.NewRecorder()
+	t.handler.ServeHTTP(recorder, req)
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	resp := recorder.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+func formatTemperature(t float32) string {
+	return strconv.FormatFloat(float64(t*100), 'f', 0, 32)
+}
+
+//Server is a Controller listening on a local loopback address
+type Server struct {
+	*httptest.Server
+	*Controller
+}
+
+//NewServer starts a fake controller with the given sensors. The base URL to pass to the
+//roth package is available in the URL field. The caller should call Close when finished.
+func NewServer(sensors ...roth.Sensor) *Server {
+	c := NewController(sensors...)
+	return &Server{
+		Server:     httptest.NewServer(c),
+		Controller: c,
+	}
+}
+
+//Close releases blocked requests and shuts down the server
+func (s *Server) Close() {
+	s.Controller.Close()
+	s.Server.Close()
+}
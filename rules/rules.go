@@ -0,0 +1,361 @@
+//Package rules implements simple automations for a Roth installation: conditions on sensor
+//values and the time of day trigger actions like changing a setpoint or calling a webhook.
+//Rules are defined in code or loaded from a json file, and evaluated on every poll of a
+//roth.Watcher. Conditions may also be given as expressions of package expr.
+package rules
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/expr"
+)
+
+//Condition is a test on the value of a sensor field, on the time of day, or both. All tests set
+//on a condition must hold for it to match.
+type Condition struct {
+	//Field is one of roomTemperature, targetTemperature, mode or program. If empty, only the
+	//time window is tested.
+	Field string `json:"field,omitempty"`
+	//Op is one of <, <=, >, >=, == or !=
+	Op    string  `json:"op,omitempty"`
+	Value float64 `json:"value,omitempty"`
+
+	//After and Before limit the condition to a time window, given as hh:mm. Windows spanning
+	//midnight (After 22:00, Before 06:00) are supported.
+	After  string `json:"after,omitempty"`
+	Before string `json:"before,omitempty"`
+
+	//Expr is an additional test given as an expression, see package expr, e.g.
+	//"self.Room < self.Target - 2 && sensor('Outside').Room < 0". self is the sensor of the rule.
+	Expr string `json:"expr,omitempty"`
+
+	//Func is an additional test for conditions defined in code
+	Func func(s roth.Sensor, now time.Time) bool `json:"-"`
+
+	compiled *expr.Expression
+}
+
+//Action is an operation performed when a rule triggers
+type Action struct {
+	SetTargetTemperature *float32      `json:"setTargetTemperature,omitempty"`
+	SetMode              *roth.Mode    `json:"setMode,omitempty"`
+	SetProgram           *roth.Program `json:"setProgram,omitempty"`
+	//Webhook is a url receiving a json POST describing the rule and sensor
+	Webhook string `json:"webhook,omitempty"`
+
+	//Func is an additional operation for actions defined in code
+	Func func(ctx context.Context, client *roth.Client, s roth.Sensor) error `json:"-"`
+}
+
+//Rule performs its actions when all its conditions start to hold for a sensor. Actions are
+//performed once on the transition, not on every poll while the conditions keep holding.
+type Rule struct {
+	Name   string      `json:"name"`
+	Sensor int         `json:"sensor"`
+	When   []Condition `json:"when"`
+	Then   []Action    `json:"then"`
+}
+
+//Firing describes a rule triggering, and the outcome of its actions
+type Firing struct {
+	Rule   string
+	Sensor roth.Sensor
+	Time   time.Time
+	//Err is set if any action failed
+	Err error
+}
+
+//Engine evaluates a set of rules against sensor readings
+type Engine struct {
+	client *roth.Client
+
+	//HTTPClient is used for webhook actions. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+	//OnFire is called every time a rule triggers
+	OnFire func(Firing)
+	//OnError is called when the expression of a condition can not be evaluated, e.g. as a
+	//sensor it refers to was not read. The condition does not hold.
+	OnError func(err error)
+
+	mu     sync.Mutex
+	rules  []Rule
+	active map[string]bool
+}
+
+//NewEngine creates an engine performing actions through the given client
+func NewEngine(client *roth.Client) *Engine {
+	return &Engine{client: client, active: make(map[string]bool)}
+}
+
+//LoadFile adds the rules defined in a json file containing a list of rules
+func (e *Engine) LoadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("error parsing rules in %v: %v", path, err)
+	}
+	for _, r := range rules {
+		if err := e.Add(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Add validates a rule and adds it to the engine
+func (e *Engine) Add(r Rule) error {
+	when := make([]Condition, len(r.When))
+	for i, c := range r.When {
+		if err := c.validate(); err != nil {
+			return fmt.Errorf("rule %v: %v", r.Name, err)
+		}
+		if c.Expr != "" {
+			compiled, err := expr.Compile(c.Expr)
+			if err != nil {
+				return fmt.Errorf("rule %v: %v", r.Name, err)
+			}
+			c.compiled = compiled
+		}
+		when[i] = c
+	}
+	r.When = when
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, existing := range e.rules {
+		if existing.Name == r.Name {
+			return fmt.Errorf("duplicate rule %v", r.Name)
+		}
+	}
+	e.rules = append(e.rules, r)
+	return nil
+}
+
+//Attach evaluates the rules after every successful poll of the watcher
+func (e *Engine) Attach(w *roth.Watcher) {
+	w.Subscribe(func(p roth.Poll) {
+		if p.Err == nil {
+			e.Evaluate(context.Background(), p.Sensors, p.Time)
+		}
+	})
+}
+
+//Evaluate tests all rules against the given readings, and performs the actions of rules whose
+//conditions started to hold
+func (e *Engine) Evaluate(ctx context.Context, sensors []roth.Sensor, now time.Time) []Firing {
+	bySensor := make(map[int]roth.Sensor, len(sensors))
+	for _, s := range sensors {
+		bySensor[s.Id] = s
+	}
+
+	e.mu.Lock()
+	var triggered []Rule
+	for _, r := range e.rules {
+		s, ok := bySensor[r.Sensor]
+		if !ok {
+			continue
+		}
+		matched := r.matches(s, sensors, now, e.OnError)
+		if matched && !e.active[r.Name] {
+			triggered = append(triggered, r)
+		}
+		e.active[r.Name] = matched
+	}
+	e.mu.Unlock()
+
+	var firings []Firing
+	for _, r := range triggered {
+		s := bySensor[r.Sensor]
+		firing := Firing{Rule: r.Name, Sensor: s, Time: now, Err: e.perform(ctx, r, s)}
+		firings = append(firings, firing)
+		if e.OnFire != nil {
+			e.OnFire(firing)
+		}
+	}
+	return firings
+}
+
+func (r Rule) matches(s roth.Sensor, sensors []roth.Sensor, now time.Time, onError func(error)) bool {
+	for _, c := range r.When {
+		if !c.matches(s, sensors, now, onError) {
+			return false
+		}
+	}
+	return len(r.When) > 0
+}
+
+func (c Condition) validate() error {
+	if c.Field != "" {
+		if _, ok := fields[c.Field]; !ok {
+			return fmt.Errorf("unknown field %v", c.Field)
+		}
+		if _, ok := operators[c.Op]; !ok {
+			return fmt.Errorf("unknown operator %v", c.Op)
+		}
+	}
+	for _, t := range []string{c.After, c.Before} {
+		if t == "" {
+			continue
+		}
+		if _, err := parseClock(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var fields = map[string]struct {
+	field roth.Field
+	value func(s roth.Sensor) float64
+}{
+	"roomTemperature":   {roth.FieldRoomTemperature, func(s roth.Sensor) float64 { return float64(s.RoomTemperature) }},
+	"targetTemperature": {roth.FieldTargetTemperature, func(s roth.Sensor) float64 { return float64(s.TargetTemperature) }},
+	"mode":              {roth.FieldMode, func(s roth.Sensor) float64 { return float64(s.Mode) }},
+	"program":           {roth.FieldProgram, func(s roth.Sensor) float64 { return float64(s.Program) }},
+}
+
+var operators = map[string]func(a, b float64) bool{
+	"<":  func(a, b float64) bool { return a < b },
+	"<=": func(a, b float64) bool { return a <= b },
+	">":  func(a, b float64) bool { return a > b },
+	">=": func(a, b float64) bool { return a >= b },
+	"==": func(a, b float64) bool { return a == b },
+	"!=": func(a, b float64) bool { return a != b },
+}
+
+func (c Condition) matches(s roth.Sensor, sensors []roth.Sensor, now time.Time, onError func(error)) bool {
+	if c.Field != "" {
+		f, ok := fields[c.Field]
+		if !ok || !s.Valid.Has(f.field) {
+			return false
+		}
+		op, ok := operators[c.Op]
+		if !ok || !op(f.value(s), c.Value) {
+			return false
+		}
+	}
+	if (c.After != "" || c.Before != "") && !inWindow(now, c.After, c.Before) {
+		return false
+	}
+	if c.compiled != nil {
+		ok, err := c.compiled.Bool(expr.Env{Sensors: sensors, Self: &s, Time: now})
+		if err != nil && onError != nil {
+			onError(err)
+		}
+		if !ok {
+			return false
+		}
+	}
+	if c.Func != nil && !c.Func(s, now) {
+		return false
+	}
+	return true
+}
+
+//parseClock parses hh:mm into minutes since midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected hh:mm", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func inWindow(now time.Time, after, before string) bool {
+	minute := now.Hour()*60 + now.Minute()
+	start, end := 0, 24*60
+	if after != "" {
+		start, _ = parseClock(after)
+	}
+	if before != "" {
+		end, _ = parseClock(before)
+	}
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+//perform carries out the actions of a rule. Its writes have the origin rule:<name>, unless the
+//context has an origin already.
+func (e *Engine) perform(ctx context.Context, r Rule, s roth.Sensor) error {
+	if roth.OriginFromContext(ctx) == "" {
+		ctx = roth.WithOrigin(ctx, "rule:"+r.Name)
+	}
+	var firstErr error
+	for _, a := range r.Then {
+		if err := e.performAction(ctx, r, a, s); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (e *Engine) performAction(ctx context.Context, r Rule, a Action, s roth.Sensor) error {
+	if a.SetMode != nil {
+		if err := e.client.SetMode(ctx, s.Id, *a.SetMode); err != nil {
+			return err
+		}
+	}
+	if a.SetProgram != nil {
+		if err := e.client.SetProgram(ctx, s.Id, *a.SetProgram); err != nil {
+			return err
+		}
+	}
+	if a.SetTargetTemperature != nil {
+		if err := e.client.SetTargetTemperature(ctx, s.Id, *a.SetTargetTemperature); err != nil {
+			return err
+		}
+	}
+	if a.Webhook != "" {
+		if err := e.callWebhook(ctx, a.Webhook, r, s); err != nil {
+			return err
+		}
+	}
+	if a.Func != nil {
+		return a.Func(ctx, e.client, s)
+	}
+	return nil
+}
+
+func (e *Engine) callWebhook(ctx context.Context, url string, r Rule, s roth.Sensor) error {
+	body, err := json.Marshal(struct {
+		Rule   string      `json:"rule"`
+		Sensor roth.Sensor `json:"sensor"`
+	}{r.Name, s})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := e.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %v returned %v", url, resp.Status)
+	}
+	return nil
+}
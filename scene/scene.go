@@ -0,0 +1,234 @@
+//Package scene implements named setpoint presets ("Movie night", "Away", "Guests"), which can be
+//captured from and applied to a Roth controller, and are persisted in a json file or a
+//roth.Storage.
+package scene
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+//Setting is the state of a single sensor in a scene. Nil fields are left unchanged when the
+//scene is applied.
+type Setting struct {
+	TargetTemperature *float32      `json:"targetTemperature,omitempty"`
+	Mode              *roth.Mode    `json:"mode,omitempty"`
+	Program           *roth.Program `json:"program,omitempty"`
+}
+
+//Scene is a named set of sensor settings, keyed by sensor id
+type Scene struct {
+	Name     string          `json:"name"`
+	Settings map[int]Setting `json:"settings"`
+}
+
+//ApplyError lists the sensors which could not be updated when applying a scene
+type ApplyError struct {
+	Scene  string
+	Errors map[int]error
+}
+
+func (e *ApplyError) Error() string {
+	ids := make([]int, 0, len(e.Errors))
+	for id := range e.Errors {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprintf("sensor %v: %v", id, e.Errors[id])
+	}
+	return fmt.Sprintf("error applying scene %v: %v", e.Scene, strings.Join(parts, ", "))
+}
+
+//storageKey is the key of the scenes in a storage
+const storageKey = "scenes.json"
+
+//Store holds the scenes of a controller, persisted as json
+type Store struct {
+	client  *roth.Client
+	storage roth.Storage
+	key     string
+
+	mu     sync.Mutex
+	scenes map[string]Scene
+}
+
+//Open loads the scenes stored in the given file. The file is created when the first scene is
+//saved.
+func Open(client *roth.Client, path string) (*Store, error) {
+	storage, err := roth.NewFileStorage(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	return open(client, storage, filepath.Base(path))
+}
+
+//OpenStorage loads the scenes persisted in a storage, e.g. shared with other automation state
+func OpenStorage(client *roth.Client, storage roth.Storage) (*Store, error) {
+	return open(client, storage, storageKey)
+}
+
+func open(client *roth.Client, storage roth.Storage, key string) (*Store, error) {
+	s := &Store{
+		client:  client,
+		storage: storage,
+		key:     key,
+		scenes:  make(map[string]Scene),
+	}
+
+	var scenes []Scene
+	if _, err := roth.LoadJSON(storage, key, &scenes); err != nil {
+		return nil, err
+	}
+	for _, scene := range scenes {
+		s.scenes[scene.Name] = scene
+	}
+	return s, nil
+}
+
+//Names returns the names of all stored scenes, sorted
+func (s *Store) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.scenes))
+	for name := range s.scenes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Scene returns the scene with the given name
+func (s *Store) Scene(name string) (Scene, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scene, ok := s.scenes[name]
+	return scene, ok
+}
+
+//PutScene stores a scene defined in code, replacing any scene with the same name
+func (s *Store) PutScene(scene Scene) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scenes[scene.Name] = scene
+	return s.save()
+}
+
+//DeleteScene removes a scene
+func (s *Store) DeleteScene(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.scenes, name)
+	return s.save()
+}
+
+//SaveScene captures the current target temperature, mode and program of the given sensors
+//(all sensors if none are given) as a scene
+func (s *Store) SaveScene(ctx context.Context, name string, sensorIDs ...int) (Scene, error) {
+	sensorCount, err := s.client.GetSensorCount(ctx)
+	if err != nil {
+		return Scene{}, err
+	}
+	sensors, err := s.client.ForceRefresh(ctx, sensorCount)
+	if err != nil {
+		return Scene{}, err
+	}
+
+	include := make(map[int]bool, len(sensorIDs))
+	for _, id := range sensorIDs {
+		include[id] = true
+	}
+
+	scene := Scene{Name: name, Settings: make(map[int]Setting)}
+	for _, sensor := range sensors {
+		if len(include) > 0 && !include[sensor.Id] {
+			continue
+		}
+
+		var setting Setting
+		if sensor.Valid.Has(roth.FieldTargetTemperature) {
+			t := sensor.TargetTemperature
+			setting.TargetTemperature = &t
+		}
+		if sensor.Valid.Has(roth.FieldMode) {
+			m := sensor.Mode
+			setting.Mode = &m
+		}
+		if sensor.Valid.Has(roth.FieldProgram) {
+			p := sensor.Program
+			setting.Program = &p
+		}
+		scene.Settings[sensor.Id] = setting
+	}
+
+	return scene, s.PutScene(scene)
+}
+
+//ApplyScene writes the settings of a scene to the controller in a batch. Sensors which fail do
+//not stop the remaining sensors from being updated; the failures are returned as an *ApplyError.
+//The writes have the origin scene:<name>, unless the context has an origin already.
+func (s *Store) ApplyScene(ctx context.Context, name string) error {
+	scene, ok := s.Scene(name)
+	if !ok {
+		return fmt.Errorf("unknown scene %v", name)
+	}
+	if roth.OriginFromContext(ctx) == "" {
+		ctx = roth.WithOrigin(ctx, "scene:"+name)
+	}
+
+	batch := s.client.NewBatch()
+	for id, setting := range scene.Settings {
+		if setting.Mode != nil {
+			batch.SetMode(id, *setting.Mode)
+		}
+		if setting.Program != nil {
+			batch.SetProgram(id, *setting.Program)
+		}
+		if setting.TargetTemperature != nil {
+			batch.SetTargetTemperature(id, *setting.TargetTemperature)
+		}
+	}
+
+	failed := make(map[int]error)
+	for id, err := range batch.Send(ctx) {
+		if err != nil {
+			failed[id] = err
+		}
+	}
+	s.client.Events().Publish(SceneApplied{Time: time.Now(), Scene: name, Errors: failed})
+	if len(failed) > 0 {
+		return &ApplyError{Scene: name, Errors: failed}
+	}
+	return nil
+}
+
+//SceneApplied is published on the event bus of the client when a scene was applied
+type SceneApplied struct {
+	Time  time.Time
+	Scene string
+	//Errors holds the error per sensor which could not be updated
+	Errors map[int]error
+}
+
+//EventTime returns when the scene was applied
+func (e SceneApplied) EventTime() time.Time { return e.Time }
+
+//save stores all scenes. The caller must hold s.mu.
+func (s *Store) save() error {
+	scenes := make([]Scene, 0, len(s.scenes))
+	for _, scene := range s.scenes {
+		scenes = append(scenes, scene)
+	}
+	sort.Slice(scenes, func(i, j int) bool { return scenes[i].Name < scenes[j].Name })
+	return roth.StoreJSON(s.storage, s.key, scenes)
+}
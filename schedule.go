@@ -0,0 +1,333 @@
+package roth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//ComfortPeriodsPerDay is the number of comfort periods of each day of a week program
+const ComfortPeriodsPerDay = 3
+
+//controllerTimeItem is the current time of the controller, in seconds since the unix epoch
+const controllerTimeItem = "R0.DateTime"
+
+//dayNames are the day names used in program items, indexed by time.Weekday
+var dayNames = [7]string{"So", "Mo", "Di", "Mi", "Do", "Fr", "Sa"}
+
+//ComfortPeriod is a period of a day in which a week program heats to the day temperature, given
+//in minutes after midnight. Periods with Start equal to End are unused. In JSON, periods are
+//written as e.g. "06:30-08:00".
+type ComfortPeriod struct {
+	Start int
+	End   int
+}
+
+func (p ComfortPeriod) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", p.Start/60, p.Start%60, p.End/60, p.End%60)
+}
+
+//MarshalText formats the period as e.g. 06:30-08:00
+func (p ComfortPeriod) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+//UnmarshalText parses a period formatted as e.g. 06:30-08:00. The end may be 24:00.
+func (p *ComfortPeriod) UnmarshalText(text []byte) error {
+	parts := strings.Split(string(text), "-")
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid comfort period %q", text)
+	}
+	var minutes [2]int
+	for i, part := range parts {
+		t := strings.Split(strings.TrimSpace(part), ":")
+		if len(t) != 2 {
+			return fmt.Errorf("invalid comfort period %q", text)
+		}
+		hour, errHour := strconv.Atoi(t[0])
+		minute, errMinute := strconv.Atoi(t[1])
+		if errHour != nil || errMinute != nil || minute < 0 || minute > 59 || hour < 0 || hour*60+minute > 24*60 {
+			return fmt.Errorf("invalid comfort period %q", text)
+		}
+		minutes[i] = hour*60 + minute
+	}
+	p.Start, p.End = minutes[0], minutes[1]
+	return p.validate()
+}
+
+func (p ComfortPeriod) validate() error {
+	if p.Start < 0 || p.End > 24*60 || p.Start > p.End {
+		return fmt.Errorf("invalid comfort period %v", p)
+	}
+	return nil
+}
+
+//WeekSchedule holds the comfort periods of a week program, indexed by time.Weekday. Outside the
+//comfort periods the thermostat uses the night temperature.
+type WeekSchedule [7][ComfortPeriodsPerDay]ComfortPeriod
+
+//programDatapoint returns the datapoint of a switching time of a week program, e.g. P1.Mo.2.On
+//for the start of the second comfort period on mondays
+func programDatapoint(program Program, day time.Weekday, period int, start bool) string {
+	edge := "Off"
+	if start {
+		edge = "On"
+	}
+	return fmt.Sprintf("P%v.%v.%v.%v", int(program), dayNames[day], period+1, edge)
+}
+
+//programItem returns the item of a switching time of a week program of a sensor, e.g.
+//G0.P1.Mo.2.On
+func programItem(sensorID int, program Program, day time.Weekday, period int, start bool) string {
+	return fmt.Sprintf("G%v.%v", sensorID, programDatapoint(program, day, period, start))
+}
+
+func scheduleItems(sensorID int, program Program) []readRequestItem {
+	var items []readRequestItem
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		for period := 0; period < ComfortPeriodsPerDay; period++ {
+			items = append(items,
+				readRequestItem{Name: programItem(sensorID, program, day, period, true)},
+				readRequestItem{Name: programItem(sensorID, program, day, period, false)})
+		}
+	}
+	return items
+}
+
+//responseValues returns the values of a response by lower case item name, for looking up many
+//items
+func responseValues(resp response) map[string]string {
+	values := make(map[string]string, len(resp.Items))
+	for _, item := range resp.Items {
+		values[strings.ToLower(item.Name)] = item.Value
+	}
+	return values
+}
+
+//parseSchedule reads a week program from the values of a response containing its items
+func parseSchedule(values map[string]string, sensorID int, program Program) (WeekSchedule, error) {
+	var schedule WeekSchedule
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		for period := 0; period < ComfortPeriodsPerDay; period++ {
+			for _, start := range []bool{true, false} {
+				item := programItem(sensorID, program, day, period, start)
+				value, ok := values[strings.ToLower(item)]
+				if !ok {
+					return WeekSchedule{}, fmt.Errorf("missing %v", item)
+				}
+				minutes, err := strconv.Atoi(value)
+				if err != nil || minutes < 0 || minutes > 24*60 {
+					return WeekSchedule{}, fmt.Errorf("invalid switching time %q in %v", value, item)
+				}
+				if start {
+					schedule[day][period].Start = minutes
+				} else {
+					schedule[day][period].End = minutes
+				}
+			}
+		}
+	}
+	return schedule, nil
+}
+
+//GetWeekSchedule reads a week program of a sensor
+func (c *Client) GetWeekSchedule(ctx context.Context, sensorID int, program Program) (WeekSchedule, error) {
+	if program < Program1 || program > Program3 {
+		return WeekSchedule{}, fmt.Errorf("%v has no schedule", program)
+	}
+	resp, err := c.readValues(ctx, readRequest{Items: scheduleItems(sensorID, program)})
+	if err != nil {
+		return WeekSchedule{}, err
+	}
+	return parseSchedule(responseValues(resp), sensorID, program)
+}
+
+//Validate checks that all periods are within the day, and end after they start
+func (s WeekSchedule) Validate() error {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		for _, p := range s[day] {
+			if err := p.validate(); err != nil {
+				return fmt.Errorf("%v: %v", day, err)
+			}
+		}
+	}
+	return nil
+}
+
+//SetWeekSchedule writes a week program of a sensor, all switching times in a single request
+func (c *Client) SetWeekSchedule(ctx context.Context, sensorID int, program Program, schedule WeekSchedule) error {
+	if program < Program1 || program > Program3 {
+		return fmt.Errorf("%v has no schedule", program)
+	}
+	if err := schedule.Validate(); err != nil {
+		return err
+	}
+	return c.writeValues(ctx, scheduleWrites(sensorID, program, schedule))
+}
+
+func scheduleWrites(sensorID int, program Program, schedule WeekSchedule) []datapointWrite {
+	writes := make([]datapointWrite, 0, 7*ComfortPeriodsPerDay*2)
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		for period, p := range schedule[day] {
+			writes = append(writes,
+				datapointWrite{sensorID, programDatapoint(program, day, period, true), strconv.Itoa(p.Start)},
+				datapointWrite{sensorID, programDatapoint(program, day, period, false), strconv.Itoa(p.End)})
+		}
+	}
+	return writes
+}
+
+//comfortAt returns whether the given minute of a day is in a comfort period
+func (s WeekSchedule) comfortAt(day time.Weekday, minute int) bool {
+	for _, p := range s[day] {
+		if p.Start < p.End && minute >= p.Start && minute < p.End {
+			return true
+		}
+	}
+	return false
+}
+
+//boundaries returns the minutes of a day at which the schedule may switch, in ascending order
+func (s WeekSchedule) boundaries(day time.Weekday) []int {
+	minutes := []int{0}
+	for _, p := range s[day] {
+		if p.Start < p.End {
+			minutes = append(minutes, p.Start, p.End)
+		}
+	}
+	//few values, insertion sort
+	for i := 1; i < len(minutes); i++ {
+		for j := i; j > 0 && minutes[j] < minutes[j-1]; j-- {
+			minutes[j], minutes[j-1] = minutes[j-1], minutes[j]
+		}
+	}
+	return minutes
+}
+
+//ProgramStep is the part of a week program a thermostat is in
+type ProgramStep struct {
+	Program Program `json:"program"`
+	//Comfort is set during a comfort period, when the day temperature is used
+	Comfort bool `json:"comfort"`
+	//Since is when the step started, and Until when the next step starts. Both are zero if the
+	//program never switches.
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+}
+
+func (s ProgramStep) String() string {
+	period := "night"
+	if s.Comfort {
+		period = "comfort"
+	}
+	if s.Until.IsZero() {
+		return fmt.Sprintf("%v %v", s.Program, period)
+	}
+	return fmt.Sprintf("%v %v until %v", s.Program, period, s.Until.Format("Mon 15:04"))
+}
+
+//StepAt returns the step of the program at the given time, evaluated in the location of t
+func (s WeekSchedule) StepAt(program Program, t time.Time) ProgramStep {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	minute := t.Hour()*60 + t.Minute()
+	comfort := s.comfortAt(t.Weekday(), minute)
+	step := ProgramStep{Program: program, Comfort: comfort}
+
+	//the next switch is within a week, if the program switches at all
+	for offset := 0; offset <= 7 && step.Until.IsZero(); offset++ {
+		day := midnight.AddDate(0, 0, offset)
+		for _, m := range s.boundaries(day.Weekday()) {
+			if offset == 0 && m <= minute {
+				continue
+			}
+			if m < 24*60 && s.comfortAt(day.Weekday(), m) != comfort {
+				step.Until = day.Add(time.Duration(m) * time.Minute)
+				break
+			}
+		}
+	}
+	if step.Until.IsZero() {
+		return step
+	}
+
+	//the last switch, found as the latest boundary before which the state differs
+	for offset := 0; offset <= 7 && step.Since.IsZero(); offset++ {
+		day := midnight.AddDate(0, 0, -offset)
+		bounds := s.boundaries(day.Weekday())
+		for i := len(bounds) - 1; i >= 0; i-- {
+			m := bounds[i]
+			if offset == 0 && m > minute {
+				continue
+			}
+			before := day.Add(time.Duration(m)*time.Minute - time.Minute)
+			if s.comfortAt(before.Weekday(), before.Hour()*60+before.Minute()) != comfort {
+				step.Since = day.Add(time.Duration(m) * time.Minute)
+				break
+			}
+		}
+	}
+	return step
+}
+
+//GetControllerTime reads the clock of the controller, which the thermostats follow for their
+//week programs
+func (c *Client) GetControllerTime(ctx context.Context) (time.Time, error) {
+	resp, err := c.readValues(ctx, readRequest{Items: []readRequestItem{{Name: controllerTimeItem}}})
+	if err != nil {
+		return time.Time{}, err
+	}
+	value, ok := resp.Value(controllerTimeItem)
+	if !ok {
+		return time.Time{}, errors.New("no values returned")
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid controller time %q", value)
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+//ResolveProgramSteps sets Step of the given sensors running a week program, for showing e.g.
+//"18 °C until 06:30". The schedules are read in a single request, and evaluated at the time of
+//the controller, or the local time if the controller does not report it. Sensors without a valid
+//program, running the constant program, or virtual, are left unchanged.
+func (c *Client) ResolveProgramSteps(ctx context.Context, sensors []Sensor) error {
+	req := readRequest{Items: []readRequestItem{{Name: controllerTimeItem}}}
+	for _, s := range sensors {
+		if s.Valid.Has(FieldProgram) && s.Program >= Program1 && s.Program <= Program3 && s.Members == nil {
+			req.Items = append(req.Items, scheduleItems(s.Id, s.Program)...)
+		}
+	}
+	if len(req.Items) == 1 {
+		return nil
+	}
+
+	resp, err := c.readValues(ctx, req)
+	var respErr *ResponseError
+	if err != nil && !errors.As(err, &respErr) {
+		return err
+	}
+
+	values := responseValues(resp)
+	now := time.Now()
+	if value, ok := values[strings.ToLower(controllerTimeItem)]; ok {
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+			now = time.Unix(seconds, 0)
+		}
+	}
+	for i, s := range sensors {
+		if !s.Valid.Has(FieldProgram) || s.Program < Program1 || s.Program > Program3 || s.Members != nil {
+			continue
+		}
+		schedule, err := parseSchedule(values, s.Id, s.Program)
+		if err != nil {
+			return fmt.Errorf("error reading schedule of sensor %v: %v", s.Id, err)
+		}
+		step := schedule.StepAt(s.Program, now)
+		sensors[i].Step = &step
+	}
+	return nil
+}
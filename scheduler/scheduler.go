@@ -0,0 +1,379 @@
+//Package scheduler drives the target temperatures of thermostats from schedules kept in
+//software, for schedules the week programs of the controller can not express: setpoints per
+//weekday at any time of day, dated exceptions, and holidays from a calendar file or feed. The
+//scheduled thermostats are switched to the constant program, so their programs do not interfere.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/ical"
+)
+
+//Setpoint sets the target temperature at a time of day
+type Setpoint struct {
+	//At is the time of day as hh:mm
+	At string `json:"at"`
+	//Target is in the unit of the client
+	Target float32 `json:"target"`
+}
+
+//Day holds the setpoints of a day, in any order. Until the first setpoint of a day, the last
+//setpoint of the day before applies.
+type Day []Setpoint
+
+//Exception replaces the setpoints of the days from From to To, both included and given as
+//YYYY-MM-DD, e.g. for a week away
+type Exception struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Reason string `json:"reason,omitempty"`
+	Day    Day    `json:"day"`
+}
+
+//Schedule holds the setpoints of one or more thermostats
+type Schedule struct {
+	Name    string `json:"name"`
+	Sensors []int  `json:"sensors"`
+	//Days holds the setpoints by day: monday to sunday, or weekdays, weekend and all for
+	//several days. The most specific entry applies.
+	Days map[string]Day `json:"days"`
+	//Holiday holds the setpoints of days with an event in the holiday calendar. If empty, holidays
+	//use the setpoints of sunday.
+	Holiday Day `json:"holiday,omitempty"`
+	//Exceptions replace the setpoints of dates, before holidays
+	Exceptions []Exception `json:"exceptions,omitempty"`
+}
+
+//storageKey is the key of the schedules in a storage
+const storageKey = "scheduler.json"
+
+//dateLayout is the layout of the dates of exceptions
+const dateLayout = "2006-01-02"
+
+var dayNames = [7]string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+
+//Scheduler writes the setpoints of the schedules to the thermostats
+type Scheduler struct {
+	client *roth.Client
+
+	//OnChange is called when the scheduler writes a setpoint
+	OnChange func(sensorID int, setpoint float32, reason string)
+
+	mu        sync.Mutex
+	schedules []Schedule
+	holidays  *ical.Calendar
+	storage   roth.Storage
+}
+
+//NewScheduler creates a scheduler without schedules
+func NewScheduler(client *roth.Client) *Scheduler {
+	return &Scheduler{client: client}
+}
+
+//Add adds a schedule, replacing the schedule of the same name. A thermostat can only be in one
+//schedule.
+func (s *Scheduler) Add(schedule Schedule) error {
+	if err := schedule.validate(s.client.Unit); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schedules := make([]Schedule, 0, len(s.schedules)+1)
+	for _, other := range s.schedules {
+		if other.Name == schedule.Name {
+			continue
+		}
+		for _, id := range other.Sensors {
+			for _, sensorID := range schedule.Sensors {
+				if id == sensorID {
+					return fmt.Errorf("sensor %v is already in schedule %v", id, other.Name)
+				}
+			}
+		}
+		schedules = append(schedules, other)
+	}
+	s.schedules = append(schedules, schedule)
+	return s.save()
+}
+
+//Remove removes the schedule of the given name. The thermostats keep their last setpoint and
+//the constant program.
+func (s *Scheduler) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, schedule := range s.schedules {
+		if schedule.Name == name {
+			s.schedules = append(s.schedules[:i:i], s.schedules[i+1:]...)
+			return s.save()
+		}
+	}
+	return fmt.Errorf("unknown schedule %v", name)
+}
+
+//Schedules returns the schedules
+func (s *Scheduler) Schedules() []Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Schedule(nil), s.schedules...)
+}
+
+//Persist loads the schedules stored in the storage, replacing any schedules added, and stores
+//every later change, so the schedules survive restarts
+func (s *Scheduler) Persist(storage roth.Storage) error {
+	var schedules []Schedule
+	if _, err := roth.LoadJSON(storage, storageKey, &schedules); err != nil {
+		return err
+	}
+	for _, schedule := range schedules {
+		if err := schedule.validate(s.client.Unit); err != nil {
+			return fmt.Errorf("error loading schedules: %v", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schedules = schedules
+	s.storage = storage
+	return nil
+}
+
+//save stores the schedules, if persisted. The caller must hold s.mu.
+func (s *Scheduler) save() error {
+	if s.storage == nil {
+		return nil
+	}
+	return roth.StoreJSON(s.storage, storageKey, s.schedules)
+}
+
+//SetHolidays sets the holiday calendar. Days with any event in it are holidays.
+func (s *Scheduler) SetHolidays(holidays *ical.Calendar) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.holidays = holidays
+}
+
+//LoadHolidays reads the holiday calendar from an iCalendar file or url, see ical.Load
+func (s *Scheduler) LoadHolidays(ctx context.Context, source string) error {
+	holidays, err := ical.Load(ctx, nil, source)
+	if err != nil {
+		return fmt.Errorf("error loading holidays: %v", err)
+	}
+	s.SetHolidays(holidays)
+	return nil
+}
+
+//Setpoint returns the setpoint a sensor should have at the given time, and the reason, e.g.
+//"monday 06:30". ok is false if the sensor is in no schedule.
+func (s *Scheduler) Setpoint(sensorID int, now time.Time) (setpoint float32, reason string, ok bool) {
+	s.mu.Lock()
+	holidays := s.holidays
+	var schedule *Schedule
+	for i := range s.schedules {
+		for _, id := range s.schedules[i].Sensors {
+			if id == sensorID {
+				schedule = &s.schedules[i]
+			}
+		}
+	}
+	s.mu.Unlock()
+	if schedule == nil {
+		return 0, "", false
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+	day := now
+	//until the first setpoint of a day, the last one of an earlier day applies
+	for i := 0; i < 8; i++ {
+		setpoints, dayReason := schedule.day(day, holidays)
+		var last *Setpoint
+		lastMinute := -1
+		for j := range setpoints {
+			at, _ := parseClock(setpoints[j].At)
+			if at <= minute && at > lastMinute {
+				last, lastMinute = &setpoints[j], at
+			}
+		}
+		if last != nil {
+			return last.Target, dayReason + " " + last.At, true
+		}
+		day = day.AddDate(0, 0, -1)
+		minute = 24 * 60
+	}
+	return 0, "", false
+}
+
+//day returns the setpoints of the day of t, and why they apply
+func (schedule *Schedule) day(t time.Time, holidays *ical.Calendar) (Day, string) {
+	date := t.Format(dateLayout)
+	for _, e := range schedule.Exceptions {
+		if date >= e.From && date <= e.To {
+			if e.Reason != "" {
+				return e.Day, e.Reason
+			}
+			return e.Day, "exception " + e.From + " to " + e.To
+		}
+	}
+	if holidays != nil {
+		if events := holidays.On(t); len(events) > 0 {
+			if len(schedule.Holiday) > 0 {
+				return schedule.Holiday, events[0].Summary
+			}
+			return schedule.weekday(time.Sunday), events[0].Summary
+		}
+	}
+	return schedule.weekday(t.Weekday()), dayNames[t.Weekday()]
+}
+
+//weekday returns the setpoints of a day of the week, from the most specific entry of Days
+func (schedule *Schedule) weekday(day time.Weekday) Day {
+	if setpoints, ok := schedule.Days[dayNames[day]]; ok {
+		return setpoints
+	}
+	group := "weekdays"
+	if day == time.Saturday || day == time.Sunday {
+		group = "weekend"
+	}
+	if setpoints, ok := schedule.Days[group]; ok {
+		return setpoints
+	}
+	return schedule.Days["all"]
+}
+
+//validate checks a schedule, with its targets in the given unit
+func (schedule Schedule) validate(unit roth.Unit) error {
+	if schedule.Name == "" {
+		return errors.New("schedule has no name")
+	}
+	if len(schedule.Sensors) == 0 {
+		return fmt.Errorf("schedule %v has no sensors", schedule.Name)
+	}
+	days := []Day{schedule.Holiday}
+	names := make([]string, 0, len(schedule.Days))
+	for name := range schedule.Days {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		valid := name == "weekdays" || name == "weekend" || name == "all"
+		for _, dayName := range dayNames {
+			valid = valid || name == dayName
+		}
+		if !valid {
+			return fmt.Errorf("schedule %v: invalid day %q, expected monday to sunday, weekdays, weekend or all", schedule.Name, name)
+		}
+		days = append(days, schedule.Days[name])
+	}
+	empty := 0
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if len(schedule.weekday(day)) == 0 {
+			empty++
+		}
+	}
+	if empty == 7 {
+		return fmt.Errorf("schedule %v has no setpoints", schedule.Name)
+	}
+	for _, e := range schedule.Exceptions {
+		from, errFrom := time.Parse(dateLayout, e.From)
+		to, errTo := time.Parse(dateLayout, e.To)
+		if errFrom != nil || errTo != nil || to.Before(from) {
+			return fmt.Errorf("schedule %v: invalid exception %v to %v, expected dates as YYYY-MM-DD", schedule.Name, e.From, e.To)
+		}
+		days = append(days, e.Day)
+	}
+	min := roth.ConvertTemperature(roth.MinTargetTemperature, roth.Celsius, unit)
+	max := roth.ConvertTemperature(roth.MaxTargetTemperature, roth.Celsius, unit)
+	for _, day := range days {
+		for _, setpoint := range day {
+			if _, err := parseClock(setpoint.At); err != nil {
+				return fmt.Errorf("schedule %v: %v", schedule.Name, err)
+			}
+			//the converted limits may be off in the last bits, so they are matched at centidegrees
+			t := setpoint.Target
+			if math.IsNaN(float64(t)) || t < min-0.005 || t > max+0.005 {
+				return fmt.Errorf("schedule %v: target %v at %v out of range %v to %v", schedule.Name, t, setpoint.At, min, max)
+			}
+		}
+	}
+	return nil
+}
+
+//Evaluate writes the setpoint of every scheduled sensor whose current target differs at the
+//resolution of the client, and switches scheduled sensors running a week program to the constant
+//program. The writes have the origin schedule:<name>, unless the context has an origin already.
+func (s *Scheduler) Evaluate(ctx context.Context, sensors []roth.Sensor, now time.Time) error {
+	var firstErr error
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, sensor := range sensors {
+		setpoint, reason, ok := s.Setpoint(sensor.Id, now)
+		if !ok {
+			continue
+		}
+		ctx := ctx
+		if roth.OriginFromContext(ctx) == "" {
+			ctx = roth.WithOrigin(ctx, "schedule:"+s.scheduleOf(sensor.Id))
+		}
+		if sensor.Valid.Has(roth.FieldProgram) && sensor.Program != roth.ProgramConstant {
+			if err := s.client.SetProgram(ctx, sensor.Id, roth.ProgramConstant); err != nil {
+				fail(err)
+				continue
+			}
+		}
+		if sensor.Valid.Has(roth.FieldTargetTemperature) && !s.client.TargetDiffers(sensor.Id, sensor.TargetTemperature, setpoint) {
+			continue
+		}
+		if err := s.client.SetTargetTemperature(ctx, sensor.Id, setpoint); err != nil {
+			fail(err)
+			continue
+		}
+		if s.OnChange != nil {
+			s.OnChange(sensor.Id, setpoint, reason)
+		}
+	}
+	return firstErr
+}
+
+//scheduleOf returns the name of the schedule of a sensor
+func (s *Scheduler) scheduleOf(sensorID int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, schedule := range s.schedules {
+		for _, id := range schedule.Sensors {
+			if id == sensorID {
+				return schedule.Name
+			}
+		}
+	}
+	return ""
+}
+
+//Attach evaluates the schedules on every successful poll of the watcher
+func (s *Scheduler) Attach(w *roth.Watcher) {
+	w.Subscribe(func(p roth.Poll) {
+		if p.Err == nil {
+			s.Evaluate(context.Background(), p.Sensors, p.Time)
+		}
+	})
+}
+
+//parseClock parses hh:mm into minutes since midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected hh:mm", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
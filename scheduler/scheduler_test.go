@@ -0,0 +1,191 @@
+package scheduler_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/ical"
+	"github.com/kvantetore/rothTouchline/rothtest"
+	"github.com/kvantetore/rothTouchline/scheduler"
+)
+
+const holidays = `BEGIN:VCALENDAR
+BEGIN:VEVENT
+UID:new-year
+SUMMARY:New Year
+DTSTART;VALUE=DATE:20240101
+DTEND;VALUE=DATE:20240102
+END:VEVENT
+END:VCALENDAR
+`
+
+func testSchedule() scheduler.Schedule {
+	return scheduler.Schedule{
+		Name:    "house",
+		Sensors: []int{0},
+		Days: map[string]scheduler.Day{
+			"weekdays": {{At: "22:00", Target: 17}, {At: "06:30", Target: 21}},
+			"friday":   {{At: "06:30", Target: 20}},
+			"weekend":  {{At: "08:00", Target: 22}},
+		},
+		Exceptions: []scheduler.Exception{
+			{From: "2024-02-12", To: "2024-02-16", Reason: "away", Day: scheduler.Day{{At: "00:00", Target: 12}}},
+		},
+	}
+}
+
+func TestSetpoint(t *testing.T) {
+	calendar, err := ical.Parse(strings.NewReader(holidays))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := scheduler.NewScheduler(roth.NewClient("http://localhost"))
+	if err := s.Add(testSchedule()); err != nil {
+		t.Fatal(err)
+	}
+	s.SetHolidays(calendar)
+
+	tests := []struct {
+		name       string
+		sensor     int
+		now        time.Time
+		want       float32
+		wantReason string
+		wantOK     bool
+	}{
+		{name: "weekday", now: time.Date(2024, 1, 15, 7, 0, 0, 0, time.Local), want: 21, wantReason: "monday 06:30", wantOK: true},
+		{name: "weekday evening", now: time.Date(2024, 1, 16, 23, 0, 0, 0, time.Local), want: 17, wantReason: "tuesday 22:00", wantOK: true},
+		{name: "before the first setpoint", now: time.Date(2024, 1, 15, 5, 0, 0, 0, time.Local), want: 22, wantReason: "sunday 08:00", wantOK: true},
+		{name: "specific day over group", now: time.Date(2024, 1, 19, 23, 0, 0, 0, time.Local), want: 20, wantReason: "friday 06:30", wantOK: true},
+		{name: "weekend", now: time.Date(2024, 1, 20, 9, 0, 0, 0, time.Local), want: 22, wantReason: "saturday 08:00", wantOK: true},
+		{name: "holiday as sunday", now: time.Date(2024, 1, 1, 9, 0, 0, 0, time.Local), want: 22, wantReason: "New Year 08:00", wantOK: true},
+		{name: "exception", now: time.Date(2024, 2, 14, 7, 0, 0, 0, time.Local), want: 12, wantReason: "away 00:00", wantOK: true},
+		{name: "after the exception", now: time.Date(2024, 2, 17, 7, 0, 0, 0, time.Local), want: 12, wantReason: "away 00:00", wantOK: true},
+		{name: "unscheduled sensor", sensor: 1, now: time.Date(2024, 1, 15, 7, 0, 0, 0, time.Local)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setpoint, reason, ok := s.Setpoint(test.sensor, test.now)
+			if ok != test.wantOK || setpoint != test.want || reason != test.wantReason {
+				t.Errorf("got setpoint %v (%q, %v), want %v (%q, %v)", setpoint, reason, ok, test.want, test.wantReason, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestAddValidates(t *testing.T) {
+	day := func(target float32) map[string]scheduler.Day {
+		return map[string]scheduler.Day{"all": {{At: "06:00", Target: target}}}
+	}
+	tests := []struct {
+		name     string
+		unit     roth.Unit
+		schedule scheduler.Schedule
+		wantErr  bool
+	}{
+		{name: "valid", schedule: scheduler.Schedule{Name: "a", Sensors: []int{1}, Days: day(21)}},
+		{name: "lowest target", schedule: scheduler.Schedule{Name: "a", Sensors: []int{1}, Days: day(5)}},
+		{name: "target too high", schedule: scheduler.Schedule{Name: "a", Sensors: []int{1}, Days: day(68)}, wantErr: true},
+		{name: "fahrenheit target", unit: roth.Fahrenheit, schedule: scheduler.Schedule{Name: "a", Sensors: []int{1}, Days: day(68)}},
+		{name: "fahrenheit lowest target", unit: roth.Fahrenheit, schedule: scheduler.Schedule{Name: "a", Sensors: []int{1}, Days: day(41)}},
+		{name: "celsius target on a fahrenheit client", unit: roth.Fahrenheit, schedule: scheduler.Schedule{Name: "a", Sensors: []int{1}, Days: day(21)}, wantErr: true},
+		{name: "no name", schedule: scheduler.Schedule{Sensors: []int{1}, Days: day(21)}, wantErr: true},
+		{name: "no sensors", schedule: scheduler.Schedule{Name: "a", Days: day(21)}, wantErr: true},
+		{name: "no setpoints", schedule: scheduler.Schedule{Name: "a", Sensors: []int{1}, Holiday: scheduler.Day{{At: "06:00", Target: 21}}}, wantErr: true},
+		{name: "invalid day", schedule: scheduler.Schedule{Name: "a", Sensors: []int{1}, Days: map[string]scheduler.Day{"mondays": {{At: "06:00", Target: 21}}}}, wantErr: true},
+		{name: "invalid time", schedule: scheduler.Schedule{Name: "a", Sensors: []int{1}, Days: map[string]scheduler.Day{"all": {{At: "6am", Target: 21}}}}, wantErr: true},
+		{
+			name: "invalid exception",
+			schedule: scheduler.Schedule{Name: "a", Sensors: []int{1}, Days: day(21), Exceptions: []scheduler.Exception{
+				{From: "2024-02-16", To: "2024-02-12", Day: scheduler.Day{{At: "00:00", Target: 12}}},
+			}},
+			wantErr: true,
+		},
+		{name: "sensor in another schedule", schedule: scheduler.Schedule{Name: "a", Sensors: []int{0}, Days: day(21)}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := roth.NewClient("http://localhost")
+			client.Unit = test.unit
+			s := scheduler.NewScheduler(client)
+			if err := s.Add(scheduler.Schedule{Name: "other", Sensors: []int{0}, Days: day(roth.ConvertTemperature(20, roth.Celsius, client.Unit))}); err != nil {
+				t.Fatal(err)
+			}
+			err := s.Add(test.schedule)
+			if (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestEvaluateWritesOnce(t *testing.T) {
+	srv := rothtest.NewServer(roth.Sensor{Id: 0, Name: "Bath", RoomTemperature: 18, TargetTemperature: 17, Program: roth.Program1})
+	defer srv.Close()
+	client := roth.NewClient(srv.URL, roth.WithLogger(roth.DiscardLogger), roth.WithTemperatureResolution(0.5, roth.RoundNearest))
+
+	s := scheduler.NewScheduler(client)
+	if err := s.Add(scheduler.Schedule{Name: "bath", Sensors: []int{0}, Days: map[string]scheduler.Day{"all": {{At: "00:00", Target: 21.3}}}}); err != nil {
+		t.Fatal(err)
+	}
+	writes := 0
+	s.OnChange = func(int, float32, string) { writes++ }
+
+	now := time.Date(2024, 1, 15, 8, 0, 0, 0, time.Local)
+	for i := 0; i < 3; i++ {
+		sensors, err := client.ForceRefresh(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Evaluate(context.Background(), sensors, now); err != nil {
+			t.Fatalf("Evaluate: %v", err)
+		}
+	}
+	if writes != 1 {
+		t.Errorf("got %v writes, want 1", writes)
+	}
+	if got, _ := srv.Value("G0.SollTemp"); got != "2150" {
+		t.Errorf("got target %v, want 2150", got)
+	}
+	if got, _ := srv.Value("G0.WeekProg"); got != "0" {
+		t.Errorf("got program %v, want the constant program", got)
+	}
+}
+
+func TestPersist(t *testing.T) {
+	storage := &roth.MemoryStorage{}
+	s := scheduler.NewScheduler(roth.NewClient("http://localhost"))
+	if err := s.Persist(storage); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add(testSchedule()); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := scheduler.NewScheduler(roth.NewClient("http://localhost"))
+	if err := restored.Persist(storage); err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.Schedules(); len(got) != 1 || got[0].Name != "house" {
+		t.Fatalf("got schedules %+v, want house", got)
+	}
+
+	if err := restored.Remove("house"); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Remove("house"); err == nil {
+		t.Error("removed an unknown schedule")
+	}
+	s = scheduler.NewScheduler(roth.NewClient("http://localhost"))
+	if err := s.Persist(storage); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Schedules(); len(got) != 0 {
+		t.Errorf("got schedules %+v after removing them, want none", got)
+	}
+}
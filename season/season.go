@@ -0,0 +1,444 @@
+//Package season switches the installation between the heating season and summer mode. In summer
+//mode every room is set to a standby setpoint; when the heating season begins, the setpoints
+//saved when switching to summer mode are restored. The season follows date ranges, a rolling
+//average of the outdoor temperature from a weather.Source, or a manual override.
+package season
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/weather"
+)
+
+//Season is the season the installation is in
+type Season int
+
+const (
+	//Heating is the heating season, with the setpoints of the rooms as configured
+	Heating Season = iota
+	//Summer is summer mode, with all rooms at the standby setpoint
+	Summer
+)
+
+func (s Season) String() string {
+	if s == Summer {
+		return "summer"
+	}
+	return "heating"
+}
+
+//MarshalText encodes the season as its name, e.g. in json
+func (s Season) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+//UnmarshalText decodes a season name
+func (s *Season) UnmarshalText(text []byte) error {
+	switch strings.ToLower(string(text)) {
+	case "heating":
+		*s = Heating
+	case "summer":
+		*s = Summer
+	default:
+		return fmt.Errorf("invalid season %q: must be heating or summer", text)
+	}
+	return nil
+}
+
+//Date is a day of the year, encoded as MM-DD, e.g. 05-15
+type Date struct {
+	Month time.Month
+	Day   int
+}
+
+//ParseDate parses a date as MM-DD
+func ParseDate(s string) (Date, error) {
+	t, err := time.Parse("01-02", s)
+	if err != nil {
+		return Date{}, fmt.Errorf("invalid date %q: must be MM-DD", s)
+	}
+	return Date{t.Month(), t.Day()}, nil
+}
+
+func (d Date) String() string {
+	return fmt.Sprintf("%02d-%02d", int(d.Month), d.Day)
+}
+
+//MarshalText encodes the date as MM-DD
+func (d Date) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+//UnmarshalText decodes a date given as MM-DD
+func (d *Date) UnmarshalText(text []byte) error {
+	date, err := ParseDate(string(text))
+	if err != nil {
+		return err
+	}
+	*d = date
+	return nil
+}
+
+func (d Date) before(other Date) bool {
+	return d.Month < other.Month || d.Month == other.Month && d.Day < other.Day
+}
+
+//Period is a range of days of the year, including From and To. Periods may span the new year,
+//e.g. 11-01 to 02-28.
+type Period struct {
+	From Date `json:"from"`
+	To   Date `json:"to"`
+}
+
+//Contains returns whether the day of t is in the period
+func (p Period) Contains(t time.Time) bool {
+	d := Date{t.Month(), t.Day()}
+	if p.To.before(p.From) {
+		return !d.before(p.From) || !p.To.before(d)
+	}
+	return !d.before(p.From) && !p.To.before(d)
+}
+
+//Sample is a reading of the outdoor temperature
+type Sample struct {
+	Time    time.Time `json:"time"`
+	Outdoor float64   `json:"outdoor"`
+}
+
+//State is the persisted state of a Switcher
+type State struct {
+	Season Season    `json:"season"`
+	Since  time.Time `json:"since"`
+	//Reason describes why the season was entered
+	Reason string `json:"reason,omitempty"`
+	//Override is the season forced with SetOverride, if any
+	Override *Season `json:"override,omitempty"`
+	//Saved holds the target temperatures of the rooms before summer mode, restored when the
+	//heating season begins
+	Saved map[int]float32 `json:"saved,omitempty"`
+	//Average is the rolling average of the outdoor temperature, if a source is configured
+	Average *float64 `json:"average,omitempty"`
+
+	//Samples are the outdoor temperatures in the window of the rolling average
+	Samples []Sample `json:"samples,omitempty"`
+}
+
+//storageKey is the key of the state in a storage
+const storageKey = "season.json"
+
+//Changed is published on the event bus of the client when the season changes
+type Changed struct {
+	Time   time.Time
+	Season Season
+	Reason string
+	//Errors holds the error per sensor which could not be updated
+	Errors map[int]error
+}
+
+//EventTime returns when the season changed
+func (e Changed) EventTime() time.Time { return e.Time }
+
+//Switcher switches between the heating season and summer mode. Summer mode is entered on the
+//days of SummerPeriods, or when the rolling average outdoor temperature rises to SummerAbove;
+//the heating season resumes outside the periods once the average falls to HeatingBelow. The
+//gap between the two keeps a few mild days in autumn from switching back and forth.
+type Switcher struct {
+	client   *roth.Client
+	interval time.Duration
+
+	//Standby is the target temperature of all rooms in summer mode, in the unit of the client
+	Standby float32
+	//SummerPeriods are the days of the year in summer mode, e.g. 05-15 to 09-15
+	SummerPeriods []Period
+	//Outdoor is the source of the outdoor temperature, optional if SummerPeriods are set
+	Outdoor weather.Source
+	//Window is the period of the rolling average of the outdoor temperature. Switching by
+	//temperature waits until the samples cover half of the window.
+	Window time.Duration
+	//SummerAbove and HeatingBelow are the averages switching to summer mode and back, in °C like
+	//the weather sources
+	SummerAbove  float64
+	HeatingBelow float64
+
+	//OnError is called when the season could not be evaluated or applied
+	OnError func(err error)
+
+	mu      sync.Mutex
+	state   State
+	storage roth.Storage
+
+	runner roth.Runner
+}
+
+//NewSwitcher creates a switcher evaluating the season at the given interval, with a standby
+//setpoint of 12 °C and a three day average switching to summer mode from 16 °C and back to the
+//heating season from 12 °C
+func NewSwitcher(client *roth.Client, interval time.Duration) *Switcher {
+	return &Switcher{
+		client:       client,
+		interval:     interval,
+		Standby:      roth.ConvertTemperature(12, roth.Celsius, client.Unit),
+		Window:       72 * time.Hour,
+		SummerAbove:  16,
+		HeatingBelow: 12,
+	}
+}
+
+//Persist loads the state stored in the storage, and stores every later change, so the saved
+//setpoints and the outdoor samples survive restarts
+func (s *Switcher) Persist(storage roth.Storage) error {
+	var state State
+	if _, err := roth.LoadJSON(storage, storageKey, &state); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = state
+	s.storage = storage
+	return nil
+}
+
+//save stores the state, if persisted. The caller must hold s.mu.
+func (s *Switcher) save() error {
+	if s.storage == nil {
+		return nil
+	}
+	return roth.StoreJSON(s.storage, storageKey, s.state)
+}
+
+//State returns the current state, without the outdoor samples
+func (s *Switcher) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.state
+	state.Samples = nil
+	state.Saved = make(map[int]float32, len(s.state.Saved))
+	for id, t := range s.state.Saved {
+		state.Saved[id] = t
+	}
+	return state
+}
+
+//SetOverride forces a season regardless of dates and temperatures, and applies it
+func (s *Switcher) SetOverride(ctx context.Context, season Season) error {
+	s.mu.Lock()
+	s.state.Override = &season
+	err := s.save()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.Evaluate(ctx)
+}
+
+//ClearOverride returns to switching by dates and temperatures, and applies the season
+func (s *Switcher) ClearOverride(ctx context.Context) error {
+	s.mu.Lock()
+	s.state.Override = nil
+	err := s.save()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.Evaluate(ctx)
+}
+
+//Evaluate samples the outdoor temperature, and switches the season if it changed
+func (s *Switcher) Evaluate(ctx context.Context) error {
+	now := time.Now()
+	var outdoor *float64
+	if s.Outdoor != nil {
+		t, err := s.Outdoor.OutdoorTemperature(ctx)
+		if err != nil {
+			return fmt.Errorf("error reading outdoor temperature: %v", err)
+		}
+		outdoor = &t
+	}
+
+	s.mu.Lock()
+	if outdoor != nil {
+		s.addSample(now, *outdoor)
+	}
+	current := s.state.Season
+	season, reason := s.decide(now)
+	s.mu.Unlock()
+
+	if season == current {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.save()
+	}
+	return s.switchTo(ctx, season, reason)
+}
+
+//addSample records an outdoor temperature, and updates the rolling average. The caller must
+//hold s.mu.
+func (s *Switcher) addSample(now time.Time, outdoor float64) {
+	samples := append(s.state.Samples, Sample{Time: now, Outdoor: outdoor})
+	for len(samples) > 0 && now.Sub(samples[0].Time) > s.Window {
+		samples = samples[1:]
+	}
+	s.state.Samples = append([]Sample{}, samples...)
+
+	var sum float64
+	for _, sample := range samples {
+		sum += sample.Outdoor
+	}
+	average := sum / float64(len(samples))
+	s.state.Average = &average
+}
+
+//decide returns the season for the current state. The caller must hold s.mu.
+func (s *Switcher) decide(now time.Time) (Season, string) {
+	if s.state.Override != nil {
+		return *s.state.Override, "override"
+	}
+	for _, p := range s.SummerPeriods {
+		if p.Contains(now) {
+			return Summer, fmt.Sprintf("summer period %v to %v", p.From, p.To)
+		}
+	}
+
+	samples := s.state.Samples
+	covered := len(samples) > 0 && now.Sub(samples[0].Time) >= s.Window/2
+	if s.Outdoor == nil || !covered || s.state.Average == nil {
+		if s.Outdoor == nil && len(s.SummerPeriods) > 0 {
+			return Heating, "outside the summer periods"
+		}
+		return s.state.Season, s.state.Reason
+	}
+	average := *s.state.Average
+	switch {
+	case average >= s.SummerAbove:
+		return Summer, fmt.Sprintf("average outdoor temperature %.1f °C", average)
+	case average <= s.HeatingBelow:
+		return Heating, fmt.Sprintf("average outdoor temperature %.1f °C", average)
+	}
+	return s.state.Season, s.state.Reason
+}
+
+//switchTo sets all rooms to the standby setpoint for summer mode, or restores the saved
+//setpoints for the heating season
+func (s *Switcher) switchTo(ctx context.Context, season Season, reason string) error {
+	batch := s.client.NewBatch()
+	var saved map[int]float32
+	if season == Summer {
+		sensorCount, err := s.client.GetSensorCount(ctx)
+		if err != nil {
+			return err
+		}
+		sensors, err := s.client.ForceRefresh(ctx, sensorCount)
+		if err != nil {
+			return err
+		}
+		saved = make(map[int]float32)
+		for _, sensor := range sensors {
+			if sensor.Valid.Has(roth.FieldTargetTemperature) {
+				saved[sensor.Id] = sensor.TargetTemperature
+				batch.SetTargetTemperature(sensor.Id, s.Standby)
+			}
+		}
+	} else {
+		for id, t := range s.State().Saved {
+			batch.SetTargetTemperature(id, t)
+		}
+	}
+
+	failed := make(map[int]error)
+	for id, err := range batch.Send(ctx) {
+		if err != nil {
+			failed[id] = err
+		}
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	s.state.Season = season
+	s.state.Since = now
+	s.state.Reason = reason
+	if season == Summer {
+		s.state.Saved = saved
+	} else {
+		//setpoints which could not be restored are kept for the next attempt
+		for id := range s.state.Saved {
+			if _, ok := failed[id]; !ok {
+				delete(s.state.Saved, id)
+			}
+		}
+	}
+	err := s.save()
+	s.mu.Unlock()
+
+	s.client.Events().Publish(Changed{Time: now, Season: season, Reason: reason, Errors: failed})
+	if len(failed) > 0 {
+		return fmt.Errorf("error switching to %v: %v sensors failed", season, len(failed))
+	}
+	return err
+}
+
+//Run evaluates the season at the configured interval until the context is cancelled
+func (s *Switcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Evaluate(ctx); err != nil && ctx.Err() == nil && s.OnError != nil {
+			s.OnError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//Start evaluates the season in the background, see Run
+func (s *Switcher) Start(ctx context.Context) error {
+	return s.runner.Start(ctx, s.Run)
+}
+
+//Stop ends evaluating the season. A switch in progress is cancelled.
+func (s *Switcher) Stop(ctx context.Context) error {
+	return s.runner.Stop(ctx)
+}
+
+//ServeHTTP serves the state as json on GET, and sets or clears the override on POST with a
+//body like {"override":"summer"} or {"override":null}
+func (s *Switcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		var body struct {
+			Override *Season `json:"override"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		var err error
+		if body.Override != nil {
+			err = s.SetOverride(r.Context(), *body.Override)
+		} else {
+			err = s.ClearOverride(r.Context())
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.State())
+}
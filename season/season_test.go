@@ -0,0 +1,194 @@
+package season
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/rothtest"
+	"github.com/kvantetore/rothTouchline/weather"
+)
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Date
+		wantErr bool
+	}{
+		{in: "05-15", want: Date{time.May, 15}},
+		{in: "12-31", want: Date{time.December, 31}},
+		{in: "02-29", want: Date{time.February, 29}},
+		{in: "02-30", wantErr: true},
+		{in: "13-01", wantErr: true},
+		{in: "5-15", wantErr: true},
+		{in: "05/15", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ParseDate(test.in)
+		if (err != nil) != test.wantErr || !test.wantErr && got != test.want {
+			t.Errorf("ParseDate(%q) = %v, %v, want %v", test.in, got, err, test.want)
+		}
+		if err == nil && got.String() != test.in {
+			t.Errorf("ParseDate(%q) formats as %q", test.in, got)
+		}
+	}
+
+	var s Season
+	if err := s.UnmarshalText([]byte("Summer")); err != nil || s != Summer {
+		t.Errorf("got %v, %v, want summer", s, err)
+	}
+	if err := s.UnmarshalText([]byte("winter")); err == nil {
+		t.Error("winter accepted as season")
+	}
+}
+
+func TestPeriodContains(t *testing.T) {
+	summer := Period{Date{time.May, 15}, Date{time.September, 15}}
+	//a period spanning the new year
+	winter := Period{Date{time.November, 1}, Date{time.February, 28}}
+
+	tests := []struct {
+		period Period
+		day    string
+		want   bool
+	}{
+		{summer, "2026-05-14", false},
+		{summer, "2026-05-15", true},
+		{summer, "2026-07-01", true},
+		{summer, "2026-09-15", true},
+		{summer, "2026-09-16", false},
+		{summer, "2026-01-01", false},
+		{winter, "2026-10-31", false},
+		{winter, "2026-11-01", true},
+		{winter, "2026-12-31", true},
+		{winter, "2027-01-01", true},
+		{winter, "2027-02-28", true},
+		{winter, "2028-02-29", false},
+		{winter, "2027-03-01", false},
+		{winter, "2027-07-01", false},
+	}
+	for _, test := range tests {
+		day, _ := time.Parse("2006-01-02", test.day)
+		//late in the day, as only the date counts
+		if got := test.period.Contains(day.Add(23 * time.Hour)); got != test.want {
+			t.Errorf("%v to %v contains %v = %v, want %v", test.period.From, test.period.To, test.day, got, test.want)
+		}
+	}
+}
+
+func newTestSwitcher(t *testing.T, unit roth.Unit) (*rothtest.Server, *Switcher) {
+	t.Helper()
+	srv := rothtest.NewServer(
+		roth.Sensor{Id: 0, Name: "Living room", RoomTemperature: 20.86, TargetTemperature: 21, Program: roth.Program1, Mode: roth.ModeDay},
+		roth.Sensor{Id: 1, Name: "Bedroom", RoomTemperature: 18.5, TargetTemperature: 17.5, Program: roth.ProgramConstant, Mode: roth.ModeNight},
+	)
+	t.Cleanup(srv.Close)
+	client := roth.NewClient(srv.URL, roth.WithLogger(roth.DiscardLogger), roth.WithUnit(unit))
+	return srv, NewSwitcher(client, time.Hour)
+}
+
+func compareTargets(t *testing.T, srv *rothtest.Server, want ...string) {
+	t.Helper()
+	for id, w := range want {
+		name := fmt.Sprintf("G%v.SollTemp", id)
+		if got, _ := srv.Value(name); got != w {
+			t.Errorf("got %v = %q, want %q", name, got, w)
+		}
+	}
+}
+
+func TestSwitchByOutdoorTemperature(t *testing.T) {
+	srv, s := newTestSwitcher(t, roth.Celsius)
+	outdoor := 20.0
+	s.Outdoor = weather.SourceFunc(func(ctx context.Context) (float64, error) { return outdoor, nil })
+	//only the latest sample is averaged
+	s.Window = 0
+	storage := &roth.MemoryStorage{}
+	if err := s.Persist(storage); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+
+	var changes []Changed
+	s.client.Events().Subscribe(func(e roth.Event) {
+		if c, ok := e.(Changed); ok {
+			changes = append(changes, c)
+		}
+	})
+
+	steps := []struct {
+		outdoor     float64
+		want        Season
+		wantTargets []string
+	}{
+		{20, Summer, []string{"1200", "1200"}},
+		//between the thresholds the season is kept
+		{14, Summer, []string{"1200", "1200"}},
+		{12, Heating, []string{"2100", "1750"}},
+		{14, Heating, []string{"2100", "1750"}},
+	}
+	for i, step := range steps {
+		outdoor = step.outdoor
+		if err := s.Evaluate(context.Background()); err != nil {
+			t.Fatalf("step %v: Evaluate: %v", i, err)
+		}
+		state := s.State()
+		if state.Season != step.want || state.Average == nil || *state.Average != step.outdoor {
+			t.Errorf("step %v: got %v with average %v, want %v at %v", i, state.Season, state.Average, step.want, step.outdoor)
+		}
+		compareTargets(t, srv, step.wantTargets...)
+	}
+	if len(changes) != 2 || changes[0].Season != Summer || changes[1].Season != Heating {
+		t.Errorf("got changes %+v, want summer and heating", changes)
+	}
+
+	//the state survives a restart
+	_, restarted := newTestSwitcher(t, roth.Celsius)
+	if err := restarted.Persist(storage); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	if state := restarted.State(); state.Season != Heating || !strings.Contains(state.Reason, "12.0") {
+		t.Errorf("got restored state %+v", state)
+	}
+}
+
+func TestSwitchByPeriodAndOverride(t *testing.T) {
+	srv, s := newTestSwitcher(t, roth.Fahrenheit)
+	today := time.Now()
+	s.SummerPeriods = []Period{{Date{today.Month(), today.Day()}, Date{today.Month(), today.Day()}}}
+
+	//the standby setpoint of 12 °C is written as such by a client using Fahrenheit
+	if err := s.Evaluate(context.Background()); err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+	if state := s.State(); state.Season != Summer || len(state.Saved) != 2 {
+		t.Errorf("got %v with saved setpoints %v, want summer with 2", state.Season, state.Saved)
+	}
+	compareTargets(t, srv, "1200", "1200")
+
+	//the override wins over the period, through the http handler
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"override":"heating"}`)))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"season":"heating"`) {
+		t.Errorf("got %v %s, want the heating state", w.Code, w.Body)
+	}
+	compareTargets(t, srv, "2100", "1750")
+
+	if err := s.ClearOverride(context.Background()); err != nil {
+		t.Fatalf("ClearOverride: %v", err)
+	}
+	if state := s.State(); state.Season != Summer || state.Override != nil {
+		t.Errorf("got %v with override %v, want summer without override", state.Season, state.Override)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"override":"winter"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %v for an invalid season, want %v", w.Code, http.StatusBadRequest)
+	}
+}
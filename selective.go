@@ -0,0 +1,50 @@
+package roth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+//GetSensorFields reads only the given fields of all sensors, e.g. FieldRoomTemperature for a
+//dashboard refreshing every few seconds. Fields not read are not in Sensor.Valid. Temperatures
+//are converted using the unit learned from the last full read, and the sensors are those found by
+//it, which may have gaps in their ids. Selective reads bypass the cache.
+func (c *Client) GetSensorFields(ctx context.Context, sensorCount int, fields Field) ([]Sensor, error) {
+	return c.GetSensorsByID(ctx, c.sensorIDs(sensorCount), fields)
+}
+
+//GetSensor reads the given fields of a single sensor
+func (c *Client) GetSensor(ctx context.Context, sensorID int, fields Field) (Sensor, error) {
+	sensors, err := c.GetSensorsByID(ctx, []int{sensorID}, fields)
+	if err != nil {
+		return Sensor{}, err
+	}
+	if len(sensors) == 0 {
+		return Sensor{}, fmt.Errorf("no sensor %v", sensorID)
+	}
+	return sensors[0], nil
+}
+
+//GetSensorsByID reads the given fields of the sensors with the given ids, in a single request.
+//Registered custom datapoints are only read if fields is AllFields. For virtual sensors, their
+//members are read. Ids without a thermostat are left out of the result.
+func (c *Client) GetSensorsByID(ctx context.Context, ids []int, fields Field) ([]Sensor, error) {
+	var datapoints []Datapoint
+	for _, d := range c.datapoints() {
+		if (d.Field == 0 && fields == AllFields) || (d.Field != 0 && fields.Has(d.Field)) {
+			datapoints = append(datapoints, d)
+		}
+	}
+	if len(datapoints) == 0 {
+		return nil, errors.New("no fields to read")
+	}
+
+	read, virtual := c.splitVirtual(ids)
+	sensors, _, err := c.readSensorDatapoints(ctx, read, datapoints)
+	if err == nil && virtual {
+		sensors = c.selectSensors(sensors, ids)
+	}
+	c.annotate(sensors)
+	return sensors, err
+}
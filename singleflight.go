@@ -0,0 +1,141 @@
+package roth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//flightCall is an in-flight call shared by all callers asking for the same key
+type flightCall struct {
+	done    chan struct{}
+	val     interface{}
+	err     error
+	waiters int
+	ctx     *flightContext
+}
+
+//flightGroup makes concurrent calls with the same key share a single execution. The shared
+//call runs with its own context, cancelled once every caller waiting for it has given up. It
+//carries the values of the context of the first caller, e.g. its trace span, and fails with
+//the error of the last caller giving up, so a call abandoned for a deadline counts as timed out.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+func (g *flightGroup) do(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	call, ok := g.calls[key]
+	if !ok {
+		call = &flightCall{
+			done: make(chan struct{}),
+			ctx:  &flightContext{detachedContext: detachedContext{ctx}, done: make(chan struct{})},
+		}
+		g.calls[key] = call
+
+		go func() {
+			call.val, call.err = fn(call.ctx)
+			call.ctx.cancel(context.Canceled)
+
+			g.mu.Lock()
+			delete(g.calls, key)
+			g.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	call.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.val, call.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			call.ctx.cancel(ctx.Err())
+			//let the next caller start afresh rather than join a cancelled call
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+//detachedContext carries the values of its parent, but not its deadline or cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (d detachedContext) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
+
+//flightContext is the context of a shared call, done once cancel is called
+type flightContext struct {
+	detachedContext
+	done chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+func (f *flightContext) Done() <-chan struct{} {
+	return f.done
+}
+
+func (f *flightContext) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+//cancel makes the context done with the given error, unless it already is
+func (f *flightContext) cancel(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err == nil {
+		f.err = err
+		close(f.done)
+	}
+}
+
+//sensorLocks serializes writes to the same sensor
+type sensorLocks struct {
+	mu    sync.Mutex
+	locks map[int]*sync.Mutex
+}
+
+func (l *sensorLocks) lock(sensorID int) (unlock func()) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[int]*sync.Mutex)
+	}
+	m, ok := l.locks[sensorID]
+	if !ok {
+		m = &sync.Mutex{}
+		l.locks[sensorID] = m
+	}
+	l.mu.Unlock()
+
+	m.Lock()
+	return m.Unlock
+}
@@ -0,0 +1,283 @@
+//Package site serves several controllers, e.g. of different buildings, from one process. Each
+//site has its own client, watcher and http handlers, mounted under /<site>/, its own MQTT topics
+//below roth/<site>/, and its own credentials, so one daemon can serve every building of a
+//property manager.
+package site
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+	"github.com/kvantetore/rothTouchline/mqtt"
+)
+
+//Site is a controller served by a Gateway
+type Site struct {
+	Name   string
+	Client *roth.Client
+	//Watcher polls the controller of the site only, so a slow or unreachable controller does not
+	//delay the other sites
+	Watcher *roth.Watcher
+
+	mu                 sync.Mutex
+	username, password string
+	//tokens maps the hashes of the tokens to their roles
+	tokens map[string]Role
+	mux    *http.ServeMux
+	//readOnly holds the patterns of the handlers mounted with HandleRead
+	readOnly map[string]bool
+	bridge   *mqtt.Bridge
+}
+
+//SetCredentials protects the handlers of the site with basic authentication, granting RoleWrite.
+//The credentials are those of the site, independent of the credentials of the controller
+//itself.
+func (s *Site) SetCredentials(username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.username, s.password = username, password
+}
+
+//Handle mounts a handler of the site, e.g. Handle("/openhab/", h) for a handler served under
+//the path /<site>/openhab/. Handlers see paths without the site prefix, and usually need
+//http.StripPrefix for their own prefix.
+func (s *Site) Handle(pattern string, handler http.Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mux.Handle(pattern, handler)
+}
+
+//HandleRead mounts a handler like Handle, for a handler which only reads whatever the method
+//of its requests, so RoleRead may use it. E.g. the Grafana datasource, which is queried with POST
+//requests to /search and /query.
+func (s *Site) HandleRead(pattern string, handler http.Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mux.Handle(pattern, handler)
+	if s.readOnly == nil {
+		s.readOnly = make(map[string]bool)
+	}
+	s.readOnly[pattern] = true
+}
+
+//AddMQTT bridges the site to the MQTT broker at addr, publishing its sensors below
+//roth/<site>/, e.g. roth/house-a/sensor/3. The bridge has a connection of its own, so the
+//credentials set on it only grant access to the topics of this site. It is started and stopped
+//with the gateway.
+func (s *Site) AddMQTT(addr string) *mqtt.Bridge {
+	b := mqtt.NewBridge(addr, s.Client)
+	b.Prefix = "roth/" + s.Name
+	b.ClientID = "roth-" + s.Name
+	b.Attach(s.Watcher)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bridge = b
+	return b
+}
+
+//MQTT returns the MQTT bridge of the site, if added with AddMQTT
+func (s *Site) MQTT() (*mqtt.Bridge, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bridge, s.bridge != nil
+}
+
+//Path returns the path of a site handler as served by the gateway, e.g. /house-a/openhab/
+func (s *Site) Path(path string) string {
+	return "/" + s.Name + "/" + strings.TrimPrefix(path, "/")
+}
+
+//role checks the token or basic authentication of a request against the site
+func (s *Site) role(r *http.Request) Role {
+	s.mu.Lock()
+	username, password := s.username, s.password
+	open := username == "" && password == "" && len(s.tokens) == 0
+	tokenRole, tokenOK := s.tokens[hashToken(requestToken(r))]
+	s.mu.Unlock()
+	if open {
+		return RoleWrite
+	}
+	if tokenOK {
+		return tokenRole
+	}
+	if username == "" && password == "" {
+		return RoleNone
+	}
+	u, p, ok := r.BasicAuth()
+	//compare both in constant time, so the response time does not reveal which one is wrong
+	userOK := subtle.ConstantTimeCompare([]byte(u), []byte(username)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+	if ok && userOK && passwordOK {
+		return RoleWrite
+	}
+	return RoleNone
+}
+
+//Gateway serves the sites under /<site>/, and runs their watchers
+type Gateway struct {
+	//Addr is the address served by Start, e.g. ":8080". If empty, Start only runs the watchers,
+	//and the gateway is served as an http.Handler by the embedding server.
+	Addr string
+
+	mu     sync.Mutex
+	sites  map[string]*Site
+	server *http.Server
+}
+
+//NewGateway creates a gateway without sites
+func NewGateway() *Gateway {
+	return &Gateway{sites: make(map[string]*Site)}
+}
+
+//Add adds a site polled at the given interval. Names are used in paths, and may only contain
+//letters, digits, - and _.
+func (g *Gateway) Add(name string, client *roth.Client, interval time.Duration) (*Site, error) {
+	if !validName(name) {
+		return nil, fmt.Errorf("invalid site name %q", name)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.sites[name]; ok {
+		return nil, fmt.Errorf("site %v already exists", name)
+	}
+	s := &Site{
+		Name:    name,
+		Client:  client,
+		Watcher: roth.NewWatcher(client, interval),
+		mux:     http.NewServeMux(),
+	}
+	g.sites[name] = s
+	return s, nil
+}
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+//Site returns a site by name
+func (g *Gateway) Site(name string) (*Site, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s, ok := g.sites[name]
+	return s, ok
+}
+
+//Sites returns all sites, sorted by name
+func (g *Gateway) Sites() []*Site {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	sites := make([]*Site, 0, len(g.sites))
+	for _, s := range g.sites {
+		sites = append(sites, s)
+	}
+	sort.Slice(sites, func(i, j int) bool { return sites[i].Name < sites[j].Name })
+	return sites
+}
+
+//ServeHTTP passes requests for /<site>/... to the handlers of the site, after checking the
+//credentials and tokens of the site. Requests the role of the client does not allow for the
+//handler they are routed to are forbidden. Unknown sites are not found, rather than unauthorized, so the names of other sites
+//can not be probed.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	name := path
+	rest := "/"
+	if i := strings.Index(path, "/"); i >= 0 {
+		name, rest = path[:i], path[i:]
+	}
+	s, ok := g.Site(name)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	role := s.role(r)
+	if role == RoleNone {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", s.Name))
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	r2 := r.Clone(context.WithValue(r.Context(), roleKey{}, role))
+	r2.URL.Path = rest
+	r2.URL.RawPath = ""
+	s.mu.Lock()
+	mux := s.mux
+	_, pattern := mux.Handler(r2)
+	readOnly := s.readOnly[pattern]
+	s.mu.Unlock()
+	if !role.allows(r.Method, readOnly) {
+		http.Error(w, "forbidden for role "+role.String(), http.StatusForbidden)
+		return
+	}
+	mux.ServeHTTP(w, r2)
+}
+
+//Start starts the watchers and MQTT bridges of all sites, and serves the gateway on Addr if set
+func (g *Gateway) Start(ctx context.Context) error {
+	for _, s := range g.Sites() {
+		if err := s.Watcher.Start(ctx); err != nil {
+			return fmt.Errorf("error starting site %v: %v", s.Name, err)
+		}
+		if b, ok := s.MQTT(); ok {
+			if err := b.Start(ctx); err != nil {
+				return fmt.Errorf("error starting mqtt of site %v: %v", s.Name, err)
+			}
+		}
+	}
+	if g.Addr == "" {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.server != nil {
+		return roth.ErrRunning
+	}
+	listener, err := net.Listen("tcp", g.Addr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: g, BaseContext: func(net.Listener) context.Context { return ctx }}
+	g.server = server
+	go server.Serve(listener)
+	return nil
+}
+
+//Stop stops serving, waiting for the requests in progress, e.g. writes to a controller, to
+//complete, and then stops the watchers and MQTT bridges of all sites
+func (g *Gateway) Stop(ctx context.Context) error {
+	g.mu.Lock()
+	server := g.server
+	g.server = nil
+	g.mu.Unlock()
+	var firstErr error
+	if server != nil {
+		firstErr = server.Shutdown(ctx)
+	}
+	for _, s := range g.Sites() {
+		if err := s.Watcher.Stop(ctx); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("error stopping site %v: %v", s.Name, err)
+		}
+		if b, ok := s.MQTT(); ok {
+			if err := b.Stop(ctx); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("error stopping mqtt of site %v: %v", s.Name, err)
+			}
+		}
+	}
+	return firstErr
+}
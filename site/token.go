@@ -0,0 +1,105 @@
+package site
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+//Role is what a client of a site may do
+type Role int
+
+const (
+	//RoleNone is the role of unauthenticated requests to a site with credentials or tokens
+	RoleNone Role = iota
+	//RoleRead may read, e.g. a wall mounted tablet showing temperatures. It may make GET, HEAD
+	//and OPTIONS requests, and any request to handlers mounted with HandleRead.
+	RoleRead
+	//RoleWrite may also change setpoints and other settings
+	RoleWrite
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleRead:
+		return "read"
+	case RoleWrite:
+		return "write"
+	}
+	return "none"
+}
+
+//allows returns whether the role may make requests with the given method to a handler. readOnly
+//is set for handlers mounted with HandleRead, whose requests only read whatever their method.
+func (r Role) allows(method string, readOnly bool) bool {
+	if readOnly {
+		return r >= RoleRead
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return r >= RoleRead
+	}
+	return r >= RoleWrite
+}
+
+type roleKey struct{}
+
+//RoleFromContext returns the role of the client making a request to a site handler. Requests to
+//sites without credentials and tokens have RoleWrite.
+func RoleFromContext(ctx context.Context) Role {
+	role, _ := ctx.Value(roleKey{}).(Role)
+	return role
+}
+
+//GenerateToken returns a new random token, to pass to AddToken and to the client
+func GenerateToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+//hashToken returns the key tokens are stored under, so the tokens themselves are not kept in
+//memory and lookups do not depend on their content
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+//AddToken allows requests with the token, sent as "Authorization: Bearer <token>". Adding a
+//token again changes its role.
+func (s *Site) AddToken(token string, role Role) error {
+	if len(token) < 16 {
+		return errors.New("token too short, use at least 16 characters")
+	}
+	if role != RoleRead && role != RoleWrite {
+		return errors.New("invalid role")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tokens == nil {
+		s.tokens = make(map[string]Role)
+	}
+	s.tokens[hashToken(token)] = role
+	return nil
+}
+
+//RevokeToken removes a token
+func (s *Site) RevokeToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, hashToken(token))
+}
+
+//requestToken returns the token of a request, if any
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
@@ -0,0 +1,82 @@
+package site
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+func TestTokenRoles(t *testing.T) {
+	g := NewGateway()
+	s, err := g.Add("house-a", roth.NewClient("http://127.0.0.1:1", roth.WithLogger(roth.DiscardLogger)), time.Minute)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(RoleFromContext(r.Context()).String()))
+	})
+	s.Handle("/setpoint/", ok)
+	s.HandleRead("/grafana/", ok)
+
+	readToken, writeToken := "read-token-0123456789", "write-token-0123456789"
+	if err := s.AddToken(readToken, RoleRead); err != nil {
+		t.Fatalf("AddToken: %v", err)
+	}
+	if err := s.AddToken(writeToken, RoleWrite); err != nil {
+		t.Fatalf("AddToken: %v", err)
+	}
+	if err := s.AddToken("short", RoleRead); err == nil {
+		t.Error("short token accepted")
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{"read token reads", http.MethodGet, "/house-a/setpoint/3", readToken, http.StatusOK},
+		{"read token can not write", http.MethodPut, "/house-a/setpoint/3", readToken, http.StatusForbidden},
+		{"read token queries grafana", http.MethodPost, "/house-a/grafana/query", readToken, http.StatusOK},
+		{"read token searches grafana", http.MethodPost, "/house-a/grafana/search", readToken, http.StatusOK},
+		{"write token writes", http.MethodPut, "/house-a/setpoint/3", writeToken, http.StatusOK},
+		{"no token", http.MethodGet, "/house-a/setpoint/3", "", http.StatusUnauthorized},
+		{"unknown token", http.MethodPost, "/house-a/grafana/query", "unknown-token-0123456789", http.StatusUnauthorized},
+		{"unknown site", http.MethodGet, "/house-b/setpoint/3", writeToken, http.StatusNotFound},
+		//read only handlers do not extend to paths outside their pattern
+		{"read token outside grafana", http.MethodPost, "/house-a/grafanax", readToken, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.path, nil)
+			if test.token != "" {
+				r.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			w := httptest.NewRecorder()
+			g.ServeHTTP(w, r)
+			if w.Code != test.want {
+				t.Errorf("got status %v, want %v: %s", w.Code, test.want, w.Body)
+			}
+		})
+	}
+
+	//tokens are only accepted in the Authorization header
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/house-a/setpoint/3?token="+writeToken, nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %v for a token in the query, want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	s.RevokeToken(readToken)
+	r := httptest.NewRequest(http.MethodGet, "/house-a/setpoint/3", nil)
+	r.Header.Set("Authorization", "Bearer "+readToken)
+	w = httptest.NewRecorder()
+	g.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %v for a revoked token, want %v", w.Code, http.StatusUnauthorized)
+	}
+}
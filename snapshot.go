@@ -0,0 +1,118 @@
+package roth
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kvantetore/rothTouchline/protocol"
+)
+
+//Snapshot is the state of all sensors and the controller, captured at one point in time
+type Snapshot struct {
+	//Sequence numbers the snapshots of a client, starting at 1. It increases with every
+	//snapshot, so recorders can order them and tell if one was lost.
+	Sequence uint64 `json:"sequence"`
+	//Time is when the values were received from the controller. It is also the ReadAt of every
+	//sensor.
+	Time time.Time `json:"time"`
+	//Requests is the number of requests the snapshot was read in, 1 unless the sensors changed
+	//since the previous read
+	Requests   int             `json:"requests"`
+	Controller ControllerState `json:"controller"`
+	Sensors    []Sensor        `json:"sensors"`
+	Warnings   []ParseWarning  `json:"warnings,omitempty"`
+}
+
+//Snapshot reads all sensors and the state of the controller, bypassing the cache. Once the
+//sensors are known from an earlier read, both are read in a single request, so the values are
+//consistent; otherwise, or if the number of sensors changed, the sensors are read again in a
+//second request, and Time is when that completed. Virtual sensors are included.
+func (c *Client) Snapshot(ctx context.Context) (*Snapshot, error) {
+	ids := c.knownSensorIDs()
+	datapoints := c.datapoints()
+	req := readRequest{Items: make([]readRequestItem, 0, len(controllerStateItems)+len(ids)*len(datapoints))}
+	for _, item := range controllerStateItems {
+		req.Items = append(req.Items, readRequestItem{Name: item})
+	}
+	for _, id := range ids {
+		for _, d := range datapoints {
+			req.Items = append(req.Items, readRequestItem{Name: "G" + strconv.Itoa(id) + "." + d.Name})
+		}
+	}
+
+	resp, err := c.readValues(ctx, req)
+	var respErr *ResponseError
+	if err != nil && !errors.As(err, &respErr) {
+		return nil, err
+	}
+	snapshot := &Snapshot{Time: time.Now(), Requests: 1}
+	if snapshot.Controller, err = parseControllerState(resp); err != nil {
+		return nil, err
+	}
+
+	//the controller items are left out, as they are no sensor items
+	var sensorResp response
+	for _, item := range resp.Items {
+		if _, _, ok := protocol.SplitItemName(item.Name); ok {
+			sensorResp.Items = append(sensorResp.Items, item)
+		}
+	}
+	if respErr != nil {
+		err = respErr
+	}
+	var sensors []Sensor
+	var warnings []ParseWarning
+	if len(ids) > 0 {
+		sensors, warnings, err = c.parseSensorResponse(sensorResp, err, ids, datapoints)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if count := snapshot.Controller.DeviceCount; count > 0 && (len(ids) == 0 || len(sensors) != count) {
+		snapshot.Requests++
+		sensors, warnings, err = c.readAllSensors(ctx, count)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Time = time.Now()
+	}
+	for i := range sensors {
+		sensors[i].ReadAt = snapshot.Time
+	}
+
+	if c.CacheTTL > 0 {
+		c.cache.put(sensors, warnings)
+	}
+	if c.LastKnownGood > 0 {
+		c.lastGood.put(sensors, warnings)
+	}
+	sensors = c.withVirtualSensors(sensors)
+	c.annotate(sensors)
+	snapshot.Sensors = sensors
+	snapshot.Warnings = warnings
+	snapshot.Sequence = c.snapshots.next()
+	return snapshot, nil
+}
+
+//sequence hands out increasing numbers
+type sequence struct {
+	mu   sync.Mutex
+	last uint64
+}
+
+func (s *sequence) next() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last++
+	return s.last
+}
+
+//knownSensorIDs returns the indices of the sensors found by the last full read, if any
+func (c *Client) knownSensorIDs() []int {
+	c.indices.mu.Lock()
+	defer c.indices.mu.Unlock()
+	return append([]int(nil), c.indices.ids...)
+}
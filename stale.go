@@ -0,0 +1,86 @@
+package roth
+
+import (
+	"sync"
+	"time"
+)
+
+//lastKnownGood holds the last successful read, served by GetSensors and GetSensorCount while the
+//controller is unreachable, see Client.LastKnownGood
+type lastKnownGood struct {
+	mu       sync.Mutex
+	sensors  []Sensor
+	warnings []ParseWarning
+	readAt   time.Time
+
+	sensorCount int
+	countReadAt time.Time
+}
+
+func (l *lastKnownGood) put(sensors []Sensor, warnings []ParseWarning) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sensors = make([]Sensor, len(sensors))
+	copy(l.sensors, sensors)
+	l.warnings = make([]ParseWarning, len(warnings))
+	copy(l.warnings, warnings)
+	l.readAt = time.Now()
+}
+
+//get returns copies of the last sensors read, marked stale, if they are no older than maxAge
+func (l *lastKnownGood) get(sensorCount int, maxAge time.Duration) ([]Sensor, []ParseWarning, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.sensors == nil || len(l.sensors) != sensorCount || time.Since(l.readAt) > maxAge {
+		return nil, nil, false
+	}
+	sensors := make([]Sensor, len(l.sensors))
+	for i, s := range l.sensors {
+		s.Stale = true
+		sensors[i] = s
+	}
+	warnings := make([]ParseWarning, len(l.warnings))
+	copy(warnings, l.warnings)
+	return sensors, warnings, true
+}
+
+func (l *lastKnownGood) putCount(sensorCount int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sensorCount = sensorCount
+	l.countReadAt = time.Now()
+}
+
+func (l *lastKnownGood) getCount(maxAge time.Duration) (int, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.countReadAt.IsZero() || time.Since(l.countReadAt) > maxAge {
+		return 0, false
+	}
+	return l.sensorCount, true
+}
+
+//Age returns how long ago the values of the sensor were read from the controller, or zero if
+//the read time is unknown
+func (s Sensor) Age() time.Duration {
+	if s.ReadAt.IsZero() {
+		return 0
+	}
+	return time.Since(s.ReadAt)
+}
+
+//serveStale returns the last known good sensors in place of a failed read, if enabled and they
+//are recent enough
+func (c *Client) serveStale(sensorCount int, err error) ([]Sensor, []ParseWarning, bool) {
+	if c.LastKnownGood <= 0 {
+		return nil, nil, false
+	}
+	sensors, warnings, ok := c.lastGood.get(sensorCount, c.LastKnownGood)
+	if ok {
+		c.logf(LogInfo, "serving last known values, read failed: %v", err)
+		c.stats.update(func(s *Stats) { s.StaleReads++ })
+	}
+	return sensors, warnings, ok
+}
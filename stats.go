@@ -0,0 +1,85 @@
+package roth
+
+import (
+	"sync"
+	"time"
+)
+
+//Stats are counters describing the activity of a client since it was created
+type Stats struct {
+	//Requests is the number of http requests sent to the controller, including retries
+	Requests int64 `json:"requests"`
+	//FailedRequests is the number of http requests which failed
+	FailedRequests int64 `json:"failedRequests"`
+	//Retries is the number of requests repeated after a failure
+	Retries int64 `json:"retries"`
+	//Reads and Writes count the read and write operations, which may span several requests
+	Reads  int64 `json:"reads"`
+	Writes int64 `json:"writes"`
+	//ParseWarnings is the number of values which could not be parsed, or were missing
+	ParseWarnings int64 `json:"parseWarnings"`
+	//CacheHits and CacheMisses count reads answered from the cache, and reads which were not
+	CacheHits   int64 `json:"cacheHits"`
+	CacheMisses int64 `json:"cacheMisses"`
+	//StaleReads is the number of failed reads answered with the last known good values
+	StaleReads int64 `json:"staleReads"`
+	//DeltaPolls is the number of watcher polls answered with the previous readings, as the
+	//change counter of the controller was unchanged
+	DeltaPolls int64 `json:"deltaPolls"`
+	//CircuitRejected is the number of requests rejected by the open circuit breaker
+	CircuitRejected int64 `json:"circuitRejected"`
+	//TotalLatency is the summed duration of all requests
+	TotalLatency time.Duration `json:"totalLatency"`
+	//LastError is the time of the last failed request
+	LastError time.Time `json:"lastError,omitempty"`
+}
+
+//AverageLatency returns the mean duration of a request, or zero if none were sent
+func (s Stats) AverageLatency() time.Duration {
+	if s.Requests == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Requests)
+}
+
+//statsCounter collects the stats of a client
+type statsCounter struct {
+	mu    sync.Mutex
+	stats Stats
+}
+
+func (sc *statsCounter) update(fn func(s *Stats)) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	fn(&sc.stats)
+}
+
+//Stats returns a snapshot of the counters of the client
+func (c *Client) Stats() Stats {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+	return c.stats.stats
+}
+
+//countRequest records the outcome of a single http request
+func (c *Client) countRequest(latency time.Duration, err error) {
+	c.stats.update(func(s *Stats) {
+		s.Requests++
+		s.TotalLatency += latency
+		if err != nil {
+			s.FailedRequests++
+			s.LastError = time.Now()
+		}
+	})
+}
+
+//countCache records whether a read was answered from the cache
+func (c *Client) countCache(hit bool) {
+	c.stats.update(func(s *Stats) {
+		if hit {
+			s.CacheHits++
+		} else {
+			s.CacheMisses++
+		}
+	})
+}
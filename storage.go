@@ -0,0 +1,160 @@
+package roth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+//ErrNotStored is returned by Storage.Load for keys never stored
+var ErrNotStored = errors.New("not stored")
+
+//Storage persists automation state, like desired setpoints, scenes, overrides and schedules, so
+//it survives restarts of the daemon. Values are json documents stored by key, e.g.
+//"overrides.json". Databases like bolt or SQLite are supported by implementing the three methods.
+type Storage interface {
+	//Load returns the value stored for key, or ErrNotStored
+	Load(key string) ([]byte, error)
+	//Store replaces the value of key. A failed store must leave the previous value intact.
+	Store(key string, value []byte) error
+	Delete(key string) error
+}
+
+//FileStorage stores each key in a file of a directory, named like the key
+type FileStorage struct {
+	dir string
+}
+
+//NewFileStorage creates a file storage in the given directory, creating it if necessary
+func NewFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStorage{dir: dir}, nil
+}
+
+func (f *FileStorage) path(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, `/\`) || key == "." || key == ".." {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return filepath.Join(f.dir, key), nil
+}
+
+//Load reads the file of key
+func (f *FileStorage) Load(key string) ([]byte, error) {
+	path, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+	value, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotStored
+	}
+	return value, err
+}
+
+//Store replaces the file of key
+func (f *FileStorage) Store(key string, value []byte) error {
+	path, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, value)
+}
+
+//Delete removes the file of key
+func (f *FileStorage) Delete(key string) error {
+	path, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+//MemoryStorage keeps values in memory, e.g. for tests or when nothing needs to be persisted
+type MemoryStorage struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+//Load returns the value of key
+func (m *MemoryStorage) Load(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.values[key]
+	if !ok {
+		return nil, ErrNotStored
+	}
+	return append([]byte(nil), value...), nil
+}
+
+//Store replaces the value of key
+func (m *MemoryStorage) Store(key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.values == nil {
+		m.values = make(map[string][]byte)
+	}
+	m.values[key] = append([]byte(nil), value...)
+	return nil
+}
+
+//Delete removes key
+func (m *MemoryStorage) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.values, key)
+	return nil
+}
+
+//LoadJSON decodes the value of key into v. If the key was never stored, v is left unchanged and
+//found is false.
+func LoadJSON(s Storage, key string, v interface{}) (found bool, err error) {
+	value, err := s.Load(key)
+	if errors.Is(err, ErrNotStored) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(value, v); err != nil {
+		return false, fmt.Errorf("error parsing %v: %v", key, err)
+	}
+	return true, nil
+}
+
+//StoreJSON encodes v as the value of key
+func StoreJSON(s Storage, key string, v interface{}) error {
+	value, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.Store(key, value)
+}
+
+//writeFileAtomic writes to a temporary file first, so a crash can not leave a truncated file
+//behind
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
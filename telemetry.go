@@ -0,0 +1,65 @@
+package roth
+
+import (
+	"context"
+	"time"
+)
+
+//Attributes annotate spans and measurements, e.g. "roth.endpoint": "ILRReadValues.cgi"
+type Attributes map[string]interface{}
+
+//Tracer creates a span for each read and write of the client. It is a small subset of an
+//OpenTelemetry tracer, so an adapter is a few lines, see the README.
+type Tracer interface {
+	Start(ctx context.Context, name string, attributes Attributes) (context.Context, Span)
+}
+
+//Span is an operation started by a Tracer
+type Span interface {
+	//End completes the span, with the error of the operation if it failed
+	End(err error, attributes Attributes)
+}
+
+//Meter receives measurements of each read and write of the client
+type Meter interface {
+	//Add increments a counter, e.g. roth.requests
+	Add(name string, value int64, attributes Attributes)
+	//Record adds a value to a histogram, e.g. roth.request.duration in seconds
+	Record(name string, value float64, attributes Attributes)
+}
+
+//instrument starts a span for an operation, and returns the function completing it
+func (c *Client) instrument(ctx context.Context, operation string, endpoint string, items int) (context.Context, func(err error)) {
+	if c.Tracer == nil && c.Meter == nil {
+		return ctx, func(error) {}
+	}
+
+	attributes := Attributes{
+		"roth.operation": operation,
+		"roth.endpoint":  endpoint,
+		"roth.items":     items,
+	}
+	var span Span
+	if c.Tracer != nil {
+		ctx, span = c.Tracer.Start(ctx, "roth."+operation, attributes)
+	}
+	start := time.Now()
+
+	return ctx, func(err error) {
+		duration := time.Since(start)
+		outcome := "ok"
+		if err != nil {
+			outcome = "error"
+		}
+		result := Attributes{"roth.outcome": outcome, "roth.duration_ms": duration.Milliseconds()}
+		if span != nil {
+			span.End(err, result)
+		}
+		if c.Meter != nil {
+			metric := Attributes{"roth.operation": operation, "roth.outcome": outcome}
+			c.Meter.Add("roth.requests", 1, metric)
+			c.Meter.Add("roth.items", int64(items), metric)
+			c.Meter.Record("roth.request.duration", duration.Seconds(), metric)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package roth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+//templateDays are the keys of Template.Days covering several days
+var templateDays = map[string][]time.Weekday{
+	"daily":    {time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekend":  {time.Saturday, time.Sunday},
+}
+
+//Template is a week program defined once and applied to many rooms, e.g. from JSON:
+//
+//	{"name": "office", "days": {"weekdays": ["07:00-17:00"], "sat": ["09:00-13:00"]}}
+//
+//Days maps days to at most ComfortPeriodsPerDay comfort periods. Keys are the days mon to sun, or
+//daily, weekdays and weekend; single days take precedence over weekdays and weekend, which take
+//precedence over daily. Days not covered have no comfort period.
+type Template struct {
+	Name string                     `json:"name"`
+	Days map[string][]ComfortPeriod `json:"days"`
+}
+
+//LoadTemplate reads a template in JSON
+func LoadTemplate(r io.Reader) (Template, error) {
+	var t Template
+	if err := json.NewDecoder(r).Decode(&t); err != nil {
+		return Template{}, fmt.Errorf("error reading template: %v", err)
+	}
+	if _, err := t.Schedule(); err != nil {
+		return Template{}, err
+	}
+	return t, nil
+}
+
+//Schedule returns the week program of the template
+func (t Template) Schedule() (WeekSchedule, error) {
+	var schedule WeekSchedule
+	set := func(key string, days []time.Weekday) error {
+		periods, ok := t.Days[key]
+		if !ok {
+			return nil
+		}
+		if len(periods) > ComfortPeriodsPerDay {
+			return fmt.Errorf("%v has %v comfort periods, at most %v are supported", key, len(periods), ComfortPeriodsPerDay)
+		}
+		for _, day := range days {
+			schedule[day] = [ComfortPeriodsPerDay]ComfortPeriod{}
+			copy(schedule[day][:], periods)
+		}
+		return nil
+	}
+
+	for key := range t.Days {
+		if _, ok := templateDays[strings.ToLower(key)]; ok {
+			continue
+		}
+		if _, ok := templateDay(key); !ok {
+			return WeekSchedule{}, fmt.Errorf("unknown day %q in template %v", key, t.Name)
+		}
+	}
+	for _, key := range []string{"daily", "weekdays", "weekend"} {
+		if err := set(key, templateDays[key]); err != nil {
+			return WeekSchedule{}, err
+		}
+	}
+	for key := range t.Days {
+		if day, ok := templateDay(key); ok {
+			if err := set(key, []time.Weekday{day}); err != nil {
+				return WeekSchedule{}, err
+			}
+		}
+	}
+	if err := schedule.Validate(); err != nil {
+		return WeekSchedule{}, fmt.Errorf("template %v: %v", t.Name, err)
+	}
+	return schedule, nil
+}
+
+//templateDay parses the day keys of a template, e.g. mon or Monday
+func templateDay(key string) (time.Weekday, bool) {
+	key = strings.ToLower(key)
+	if len(key) < 3 {
+		return 0, false
+	}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		name := strings.ToLower(day.String())
+		if strings.HasPrefix(name, key) {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+//SetWeekSchedule adds the switching times of a week program. An invalid program or schedule
+//fails the sensor when the batch is sent.
+func (b *Batch) SetWeekSchedule(sensorID int, program Program, schedule WeekSchedule) *Batch {
+	if program < Program1 || program > Program3 {
+		return b.reject(sensorID, fmt.Errorf("%v has no schedule", program))
+	}
+	if err := schedule.Validate(); err != nil {
+		return b.reject(sensorID, err)
+	}
+	for _, w := range scheduleWrites(sensorID, program, schedule) {
+		b.add(w.sensorID, w.datapoint, w.value)
+	}
+	return b
+}
+
+//ApplyTemplate writes the template as the given week program of the sensors
+func (c *Client) ApplyTemplate(ctx context.Context, t Template, program Program, sensorIDs ...int) (BulkResult, error) {
+	schedule, err := t.Schedule()
+	if err != nil {
+		return nil, err
+	}
+	return c.applySchedule(ctx, schedule, program, sensorIDs), nil
+}
+
+//CopyWeekSchedule copies a week program from one sensor to others, e.g. after programming one
+//thermostat by hand
+func (c *Client) CopyWeekSchedule(ctx context.Context, fromID int, program Program, toIDs ...int) (BulkResult, error) {
+	schedule, err := c.GetWeekSchedule(ctx, fromID, program)
+	if err != nil {
+		return nil, fmt.Errorf("error reading schedule of sensor %v: %v", fromID, err)
+	}
+	return c.applySchedule(ctx, schedule, program, toIDs), nil
+}
+
+func (c *Client) applySchedule(ctx context.Context, schedule WeekSchedule, program Program, sensorIDs []int) BulkResult {
+	batch := c.NewBatch()
+	for _, id := range sensorIDs {
+		batch.SetWeekSchedule(id, program, schedule)
+	}
+	return batch.Send(ctx)
+}
@@ -0,0 +1,125 @@
+package roth
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+//controller items of the actuator test. Like the items of the administrative commands, they are
+//undocumented and unconfirmed, so they are checked before writing, and read back after.
+const (
+	//testModeItem enters (1) or leaves (0) test mode. Leaving it releases all forced outputs.
+	testModeItem = "R0.TestMode"
+	//pumpTestItem forces the pump relay on (1) or off (0) in test mode
+	pumpTestItem = "R0.PumpTest"
+	//valveExerciseItem starts (1) a run opening and closing every valve once
+	valveExerciseItem = "R0.ValveExercise"
+	//valveTestDatapoint forces the valve of a thermostat open (1) or closed (0) in test mode
+	valveTestDatapoint = "TestOutput"
+)
+
+//TestStatus describes the actuator test of the controller
+type TestStatus struct {
+	//Active is whether the controller is in test mode, with its outputs under manual control
+	Active bool
+	//Pump is whether the pump relay is forced on
+	Pump bool
+}
+
+//StartTestMode puts the controller in test mode, in which ForceValve and ForcePump control its
+//outputs instead of the thermostats, e.g. to commission new heating loops. Heating is not
+//regulated until StopTestMode is called. confirm must be true.
+func (a *Admin) StartTestMode(ctx context.Context, confirm bool) error {
+	if !confirm {
+		return ErrNotConfirmed
+	}
+	a.client.logf(LogInfo, "entering actuator test mode")
+	return a.command(ctx, testModeItem, "1", true)
+}
+
+//StopTestMode ends test mode, releasing all forced outputs to the thermostats again
+func (a *Admin) StopTestMode(ctx context.Context) error {
+	a.client.logf(LogInfo, "leaving actuator test mode")
+	return a.command(ctx, testModeItem, "0", true)
+}
+
+//TestStatus returns whether the controller is in test mode, and the state of the pump relay
+func (a *Admin) TestStatus(ctx context.Context) (TestStatus, error) {
+	req := readRequest{Items: []readRequestItem{{Name: testModeItem}, {Name: pumpTestItem}}}
+	resp, err := a.client.readValues(ctx, req)
+	if err != nil {
+		return TestStatus{}, err
+	}
+	mode, _ := resp.Value(testModeItem)
+	pump, _ := resp.Value(pumpTestItem)
+	return TestStatus{Active: mode == "1", Pump: pump == "1"}, nil
+}
+
+//ForceValve opens or closes the valve of a thermostat while in test mode
+func (a *Admin) ForceValve(ctx context.Context, sensorID int, open bool) error {
+	item := fmt.Sprintf("G%v.%v", sensorID, valveTestDatapoint)
+	return a.write(ctx, item, formatBool(open), true, func() error {
+		return a.client.writeValue(ctx, sensorID, valveTestDatapoint, formatBool(open))
+	})
+}
+
+//ForcePump switches the pump relay on or off while in test mode
+func (a *Admin) ForcePump(ctx context.Context, on bool) error {
+	return a.command(ctx, pumpTestItem, formatBool(on), true)
+}
+
+//ExerciseValves makes the controller open and close every valve once, as it does periodically
+//against seizing in summer, e.g. to check all actuators after installation. confirm must be true.
+func (a *Admin) ExerciseValves(ctx context.Context, confirm bool) error {
+	if !confirm {
+		return ErrNotConfirmed
+	}
+	//the item resets when the run is done, so it is not read back
+	a.client.logf(LogInfo, "exercising valves")
+	return a.command(ctx, valveExerciseItem, "1", false)
+}
+
+//TestLoop tests the heating loop of a thermostat: it enters test mode, opens its valve and runs
+//the pump for the given duration, so the installer can check that the loop warms up. The outputs
+//are released and test mode ended afterwards, even if ctx is done. confirm must be true.
+func (a *Admin) TestLoop(ctx context.Context, sensorID int, duration time.Duration, confirm bool) (err error) {
+	if !confirm {
+		return ErrNotConfirmed
+	}
+	if err := a.StartTestMode(ctx, true); err != nil {
+		return err
+	}
+	defer func() {
+		//release the outputs even if ctx is done, and also explicitly, in case the firmware keeps
+		//them forced after test mode
+		ctx := context.Background()
+		a.ForcePump(ctx, false)
+		a.ForceValve(ctx, sensorID, false)
+		if stopErr := a.StopTestMode(ctx); stopErr != nil && err == nil {
+			err = stopErr
+		}
+	}()
+
+	if err := a.ForceValve(ctx, sensorID, true); err != nil {
+		return fmt.Errorf("error opening valve of sensor %v: %v", sensorID, err)
+	}
+	if err := a.ForcePump(ctx, true); err != nil {
+		return fmt.Errorf("error starting pump: %v", err)
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	}
+	return nil
+}
+
+func formatBool(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
@@ -0,0 +1,193 @@
+package roth
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//defaultHTTPClient is shared by all clients without an explicit HTTPClient, so connections
+//to the controller are kept alive and reused between polls
+var defaultHTTPClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        20,
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
+//readChunks reads the request in chunks of at most ChunkSize items, running up to
+//ReadConcurrency chunk requests in parallel, within ReadDeadline. Items are returned in request
+//order. The first chunk to fail cancels the others, and its error is returned.
+func (c *Client) readChunks(ctx context.Context, req readRequest) (resp response, err error) {
+	chunks := req.Chunks(c.chunkSize())
+	if len(chunks) > 1 && c.ReadDeadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.ReadDeadline)
+		defer cancel()
+	}
+	results := make([]response, len(chunks))
+
+	workers := c.ReadConcurrency
+	if workers > len(chunks) {
+		workers = len(chunks)
+	}
+	if workers <= 1 {
+		for i, chunk := range chunks {
+			if results[i], err = c.readChunk(ctx, chunk); err != nil {
+				return response{}, err
+			}
+		}
+	} else {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var mu sync.Mutex
+		fail := func(chunkErr error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				err = chunkErr
+				cancel()
+			}
+		}
+
+		sem := make(chan struct{}, workers)
+		var wg sync.WaitGroup
+	dispatch:
+		for i, chunk := range chunks {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				fail(ctx.Err())
+				break dispatch
+			}
+			wg.Add(1)
+			go func(i int, chunk readRequest) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				result, chunkErr := c.readChunk(ctx, chunk)
+				if chunkErr != nil {
+					fail(chunkErr)
+					return
+				}
+				results[i] = result
+			}(i, chunk)
+		}
+		wg.Wait()
+		if err != nil {
+			return response{}, err
+		}
+	}
+
+	for i := range chunks {
+		resp.Items = append(resp.Items, results[i].Items...)
+	}
+	return resp, nil
+}
+
+//readBatch is a set of reads waiting to be sent to the controller as a single request
+type readBatch struct {
+	items   []readRequestItem
+	names   map[string]bool
+	waiters int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	resp   response
+	err    error
+}
+
+//coalescer merges reads issued while another read is in flight
+type coalescer struct {
+	mu       sync.Mutex
+	inFlight bool
+	next     *readBatch
+}
+
+func (c *Client) coalescedRead(ctx context.Context, req readRequest) (response, error) {
+	co := &c.coalescer
+	co.mu.Lock()
+	if !co.inFlight {
+		co.inFlight = true
+		co.mu.Unlock()
+		resp, err := c.readChunks(ctx, req)
+		c.sendNextBatch()
+		return resp, err
+	}
+
+	//join the batch sent when the current read completes. A batch abandoned by all its
+	//waiters is replaced, as its context is already cancelled.
+	b := co.next
+	if b == nil || b.ctx.Err() != nil {
+		batchCtx, cancel := context.WithCancel(detachedContext{ctx})
+		b = &readBatch{names: make(map[string]bool), ctx: batchCtx, cancel: cancel, done: make(chan struct{})}
+		co.next = b
+	}
+	for _, item := range req.Items {
+		if !b.names[item.Name] {
+			b.names[item.Name] = true
+			b.items = append(b.items, item)
+		}
+	}
+	b.waiters++
+	co.mu.Unlock()
+
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		co.mu.Lock()
+		b.waiters--
+		if b.waiters == 0 {
+			b.cancel()
+		}
+		co.mu.Unlock()
+		return response{}, ctx.Err()
+	}
+
+	if b.err != nil {
+		return response{}, b.err
+	}
+
+	//pick out the items of this request
+	names := make(map[string]bool, len(req.Items))
+	for _, item := range req.Items {
+		names[item.Name] = true
+	}
+	var resp response
+	for _, item := range b.resp.Items {
+		if names[item.Name] {
+			resp.Items = append(resp.Items, item)
+		}
+	}
+	return resp, nil
+}
+
+//sendNextBatch is called when a read completes, and sends the reads queued in the meantime
+func (c *Client) sendNextBatch() {
+	co := &c.coalescer
+	co.mu.Lock()
+	b := co.next
+	co.next = nil
+	if b == nil {
+		co.inFlight = false
+		co.mu.Unlock()
+		return
+	}
+	co.mu.Unlock()
+
+	go func() {
+		b.resp, b.err = c.readChunks(b.ctx, readRequest{Items: b.items})
+		b.cancel()
+		close(b.done)
+		c.sendNextBatch()
+	}()
+}
@@ -0,0 +1,119 @@
+package roth
+
+import (
+	"fmt"
+	"sync"
+)
+
+//Unit is a temperature unit. The values match the TempSIUnit datapoint of the controller.
+type Unit int
+
+const (
+	//Celsius is degrees Celsius
+	Celsius Unit = 0
+	//Fahrenheit is degrees Fahrenheit
+	Fahrenheit Unit = 1
+)
+
+func (u Unit) String() string {
+	switch u {
+	case Celsius:
+		return "°C"
+	case Fahrenheit:
+		return "°F"
+	}
+	return fmt.Sprintf("Unit(%d)", int(u))
+}
+
+//ConvertTemperature converts a temperature between units
+func ConvertTemperature(t float32, from, to Unit) float32 {
+	if from == to {
+		return t
+	}
+	if from == Fahrenheit {
+		return (t - 32) * 5 / 9
+	}
+	return t*9/5 + 32
+}
+
+//Temperature is a temperature in degrees Celsius, with accessors for other units
+type Temperature float32
+
+//FromFahrenheit creates a temperature from degrees Fahrenheit
+func FromFahrenheit(f float32) Temperature {
+	return Temperature(ConvertTemperature(f, Fahrenheit, Celsius))
+}
+
+//Celsius returns the temperature in degrees Celsius
+func (t Temperature) Celsius() float32 {
+	return float32(t)
+}
+
+//Fahrenheit returns the temperature in degrees Fahrenheit
+func (t Temperature) Fahrenheit() float32 {
+	return ConvertTemperature(float32(t), Celsius, Fahrenheit)
+}
+
+//In returns the temperature in the given unit
+func (t Temperature) In(u Unit) float32 {
+	return ConvertTemperature(float32(t), Celsius, u)
+}
+
+//Room returns the room temperature of the sensor, independent of the unit it is expressed in
+func (s Sensor) Room() Temperature {
+	return Temperature(ConvertTemperature(s.RoomTemperature, s.Unit, Celsius))
+}
+
+//Target returns the target temperature of the sensor, independent of the unit it is expressed in
+func (s Sensor) Target() Temperature {
+	return Temperature(ConvertTemperature(s.TargetTemperature, s.Unit, Celsius))
+}
+
+//convertTo expresses the temperatures of the sensor in the given unit. Temperatures not read
+//from the controller keep their zero value.
+func (s *Sensor) convertTo(u Unit) {
+	if s.Unit == u {
+		return
+	}
+	if s.Valid.Has(FieldRoomTemperature) {
+		s.RoomTemperature = ConvertTemperature(s.RoomTemperature, s.Unit, u)
+	}
+	if s.Valid.Has(FieldTargetTemperature) {
+		s.TargetTemperature = ConvertTemperature(s.TargetTemperature, s.Unit, u)
+	}
+	s.Unit = u
+}
+
+//controllerUnits remembers the unit each sensor uses on the controller, as learned from reads
+type controllerUnits struct {
+	mu    sync.Mutex
+	units map[int]Unit
+}
+
+func (cu *controllerUnits) get(sensorID int) Unit {
+	cu.mu.Lock()
+	defer cu.mu.Unlock()
+	return cu.units[sensorID]
+}
+
+func (cu *controllerUnits) set(sensorID int, u Unit) {
+	cu.mu.Lock()
+	defer cu.mu.Unlock()
+	if cu.units == nil {
+		cu.units = make(map[int]Unit)
+	}
+	cu.units[sensorID] = u
+}
+
+//normalizeUnits converts freshly parsed sensors from the controller unit to the client unit.
+//Sensors read without their unit are assumed to use the unit last seen.
+func (c *Client) normalizeUnits(sensors []Sensor) {
+	for i := range sensors {
+		if sensors[i].Valid.Has(FieldUnit) {
+			c.units.set(sensors[i].Id, sensors[i].Unit)
+		} else {
+			sensors[i].Unit = c.units.get(sensors[i].Id)
+		}
+		sensors[i].convertTo(c.Unit)
+	}
+}
@@ -0,0 +1,76 @@
+package roth
+
+import (
+	"fmt"
+	"strings"
+)
+
+//ResponseError is returned when the items in a controller response do not match the request,
+//e.g. because the controller truncated a large request. The items which were returned are
+//still available to the caller.
+type ResponseError struct {
+	//Missing lists requested items absent from the response
+	Missing []string
+	//Extra lists items in the response which were not requested
+	Extra []string
+	//Duplicate lists items occurring more than once in the response
+	Duplicate []string
+}
+
+func (e *ResponseError) Error() string {
+	var parts []string
+	if len(e.Missing) > 0 {
+		parts = append(parts, fmt.Sprintf("%v missing items (%v)", len(e.Missing), summarize(e.Missing)))
+	}
+	if len(e.Extra) > 0 {
+		parts = append(parts, fmt.Sprintf("%v unexpected items (%v)", len(e.Extra), summarize(e.Extra)))
+	}
+	if len(e.Duplicate) > 0 {
+		parts = append(parts, fmt.Sprintf("%v duplicate items (%v)", len(e.Duplicate), summarize(e.Duplicate)))
+	}
+	return "response does not match request: " + strings.Join(parts, ", ")
+}
+
+//summarize lists the first few names, so errors stay readable for large requests
+func summarize(names []string) string {
+	const max = 5
+	if len(names) <= max {
+		return strings.Join(names, ", ")
+	}
+	return strings.Join(names[:max], ", ") + ", ..."
+}
+
+//validateResponse checks that the response contains every requested item exactly once, in any
+//order and casing. It returns nil if the response is complete.
+func validateResponse(req readRequest, resp response) *ResponseError {
+	requested := make(map[string]bool, len(req.Items))
+	for _, item := range req.Items {
+		requested[strings.ToLower(item.Name)] = true
+	}
+
+	result := &ResponseError{}
+	seen := make(map[string]int, len(resp.Items))
+	for _, item := range resp.Items {
+		key := strings.ToLower(item.Name)
+		seen[key]++
+		if seen[key] == 2 {
+			result.Duplicate = append(result.Duplicate, item.Name)
+		}
+		if !requested[key] && seen[key] == 1 {
+			result.Extra = append(result.Extra, item.Name)
+		}
+	}
+	for _, item := range req.Items {
+		key := strings.ToLower(item.Name)
+		if seen[key] == 0 {
+			result.Missing = append(result.Missing, item.Name)
+			//only report names requested twice once
+			seen[key] = -1
+		}
+	}
+
+	if len(result.Missing) == 0 && len(result.Extra) == 0 && len(result.Duplicate) == 0 {
+		return nil
+	}
+	return result
+}
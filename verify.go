@@ -0,0 +1,101 @@
+package roth
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//DefaultVerifyDelay is the delay before reading back written values, unless Client.VerifyDelay
+//is set
+const DefaultVerifyDelay = 500 * time.Millisecond
+
+//WriteError is returned when the controller accepted a write request, but did not apply the
+//written value
+type WriteError struct {
+	SensorID  int
+	Datapoint string
+	//Written is the raw value written
+	Written string
+	//Actual is the raw value read back from the controller
+	Actual string
+}
+
+func (e *WriteError) Error() string {
+	if e.SensorID < 0 {
+		return fmt.Sprintf("controller did not apply %v=%v (value is %v)", describeItem(e.Datapoint), e.Written, e.Actual)
+	}
+	return fmt.Sprintf("controller did not apply %v=%v to sensor %v (value is %v)", describeItem(e.Datapoint), e.Written, e.SensorID, e.Actual)
+}
+
+func (c *Client) verifyDelay() time.Duration {
+	if c.VerifyDelay > 0 {
+		return c.VerifyDelay
+	}
+	return DefaultVerifyDelay
+}
+
+//verifyWrites reads the written values back, and repeats the writes which were not applied
+func (c *Client) verifyWrites(ctx context.Context, writes []datapointWrite) error {
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-time.After(c.verifyDelay()):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		failed, err := c.unappliedWrites(ctx, writes)
+		if err != nil {
+			return fmt.Errorf("error verifying write: %w", err)
+		}
+		if len(failed) == 0 {
+			return nil
+		}
+		if attempt >= c.VerifyRetries {
+			return failed[0]
+		}
+
+		c.logf(LogWarning, "%v, retrying", failed[0])
+		writes = writes[:0:0]
+		for _, f := range failed {
+			writes = append(writes, datapointWrite{f.SensorID, f.Datapoint, f.Written})
+		}
+		if err := c.sendWrites(ctx, writes); err != nil {
+			return err
+		}
+	}
+}
+
+//unappliedWrites reads the written datapoints, and returns a WriteError for every value which
+//differs from the value written
+func (c *Client) unappliedWrites(ctx context.Context, writes []datapointWrite) ([]*WriteError, error) {
+	req := readRequest{}
+	for _, w := range writes {
+		req.Items = append(req.Items, readRequestItem{Name: fmt.Sprintf("G%v.%v", w.sensorID, w.datapoint)})
+	}
+	resp, err := c.readValues(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var failed []*WriteError
+	for _, w := range writes {
+		actual, _ := resp.Value(fmt.Sprintf("G%v.%v", w.sensorID, w.datapoint))
+		if !sameValue(actual, w.value) {
+			failed = append(failed, &WriteError{SensorID: w.sensorID, Datapoint: w.datapoint, Written: w.value, Actual: actual})
+		}
+	}
+	return failed, nil
+}
+
+//sameValue compares raw values, numerically if both are numbers
+func sameValue(a, b string) bool {
+	x, errA := strconv.ParseInt(strings.TrimSpace(a), 10, 64)
+	y, errB := strconv.ParseInt(strings.TrimSpace(b), 10, 64)
+	if errA == nil && errB == nil {
+		return x == y
+	}
+	return a == b
+}
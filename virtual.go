@@ -0,0 +1,295 @@
+package roth
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//FirstVirtualID is the lowest id of a virtual sensor. The controller pairs far fewer
+//thermostats, so virtual ids never collide with physical ones.
+const FirstVirtualID = 1000
+
+//Aggregate is how a virtual sensor combines the temperatures of its members
+type Aggregate int
+
+const (
+	//AggregateAverage averages the temperatures, e.g. for a large room with two thermostats
+	AggregateAverage Aggregate = iota
+	//AggregateMin takes the lowest temperatures, e.g. of the coldest bathroom
+	AggregateMin
+	//AggregateMax takes the highest temperatures
+	AggregateMax
+)
+
+var aggregateNames = []string{"average", "min", "max"}
+
+func (a Aggregate) String() string {
+	if a < AggregateAverage || a > AggregateMax {
+		return fmt.Sprintf("Aggregate(%d)", int(a))
+	}
+	return aggregateNames[a]
+}
+
+//ParseAggregate parses an aggregate name as returned by String
+func ParseAggregate(s string) (Aggregate, error) {
+	i, err := parseEnum(s, aggregateNames)
+	if err != nil {
+		return 0, fmt.Errorf("invalid aggregate %q: must be one of %v", s, strings.Join(aggregateNames, ", "))
+	}
+	return Aggregate(i), nil
+}
+
+//MarshalText encodes the aggregate as its name, e.g. in json
+func (a Aggregate) MarshalText() ([]byte, error) {
+	if a < AggregateAverage || a > AggregateMax {
+		return nil, fmt.Errorf("invalid aggregate %d", int(a))
+	}
+	return []byte(a.String()), nil
+}
+
+//UnmarshalText decodes an aggregate name
+func (a *Aggregate) UnmarshalText(text []byte) error {
+	aggregate, err := ParseAggregate(string(text))
+	if err != nil {
+		return err
+	}
+	*a = aggregate
+	return nil
+}
+
+//VirtualSensor is a sensor computed from physical ones. Virtual sensors are returned after the
+//physical sensors by GetSensors and friends, so they show up in polls, events, metrics and the
+//handlers built on them like any other sensor.
+type VirtualSensor struct {
+	//Id must be FirstVirtualID or above
+	Id        int       `json:"id"`
+	Name      string    `json:"name"`
+	Members   []int     `json:"members"`
+	Aggregate Aggregate `json:"aggregate"`
+	//FanOut sends writes to the virtual sensor to all of its members. Without it, writes to
+	//the virtual sensor fail.
+	FanOut bool `json:"fanOut,omitempty"`
+}
+
+//virtualSensors holds the virtual sensors of a client, by id
+type virtualSensors struct {
+	mu   sync.Mutex
+	byID map[int]VirtualSensor
+}
+
+//AddVirtualSensor adds a virtual sensor, or replaces the one with the same id
+func (c *Client) AddVirtualSensor(v VirtualSensor) error {
+	if v.Id < FirstVirtualID {
+		return fmt.Errorf("invalid virtual sensor id %v: must be at least %v", v.Id, FirstVirtualID)
+	}
+	if len(v.Members) == 0 {
+		return fmt.Errorf("virtual sensor %v has no members", v.Id)
+	}
+	for _, id := range v.Members {
+		if id < 0 || id >= FirstVirtualID {
+			return fmt.Errorf("invalid member %v of virtual sensor %v: must be a physical sensor", id, v.Id)
+		}
+	}
+	if _, err := v.Aggregate.MarshalText(); err != nil {
+		return err
+	}
+	v.Members = append([]int{}, v.Members...)
+
+	c.virtual.mu.Lock()
+	defer c.virtual.mu.Unlock()
+	if c.virtual.byID == nil {
+		c.virtual.byID = make(map[int]VirtualSensor)
+	}
+	c.virtual.byID[v.Id] = v
+	return nil
+}
+
+//RemoveVirtualSensor removes a virtual sensor
+func (c *Client) RemoveVirtualSensor(id int) {
+	c.virtual.mu.Lock()
+	defer c.virtual.mu.Unlock()
+	delete(c.virtual.byID, id)
+}
+
+//VirtualSensors returns the virtual sensors, sorted by id
+func (c *Client) VirtualSensors() []VirtualSensor {
+	c.virtual.mu.Lock()
+	defer c.virtual.mu.Unlock()
+	sensors := make([]VirtualSensor, 0, len(c.virtual.byID))
+	for _, v := range c.virtual.byID {
+		sensors = append(sensors, v)
+	}
+	sort.Slice(sensors, func(i, j int) bool { return sensors[i].Id < sensors[j].Id })
+	return sensors
+}
+
+//virtualSensor returns a virtual sensor by id
+func (c *Client) virtualSensor(id int) (VirtualSensor, bool) {
+	c.virtual.mu.Lock()
+	defer c.virtual.mu.Unlock()
+	v, ok := c.virtual.byID[id]
+	return v, ok
+}
+
+//withVirtualSensors returns the physical sensors followed by the virtual sensors computed from
+//them. Virtual sensors already in the slice, e.g. of an earlier read, are replaced.
+func (c *Client) withVirtualSensors(sensors []Sensor) []Sensor {
+	virtual := c.VirtualSensors()
+	if len(virtual) == 0 {
+		return sensors
+	}
+	physical := make([]Sensor, 0, len(sensors)+len(virtual))
+	for _, s := range sensors {
+		if s.Id < FirstVirtualID {
+			physical = append(physical, s)
+		}
+	}
+	result := physical
+	for _, v := range virtual {
+		result = append(result, v.compute(physical))
+	}
+	return result
+}
+
+//compute derives the virtual sensor from the given physical sensors. The temperatures are
+//aggregated over the members reporting them, program and mode are set if all members agree.
+func (v VirtualSensor) compute(sensors []Sensor) Sensor {
+	s := Sensor{Id: v.Id, Name: v.Name, Valid: FieldName, Members: v.Members}
+	byID := make(map[int]Sensor, len(sensors))
+	for _, m := range sensors {
+		byID[m.Id] = m
+	}
+	var members []Sensor
+	for _, id := range v.Members {
+		if m, ok := byID[id]; ok {
+			members = append(members, m)
+		}
+	}
+	if len(members) == 0 {
+		return s
+	}
+
+	s.Unit = members[0].Unit
+	s.Valid |= FieldUnit
+	s.RoomTemperature, s.Valid = v.aggregate(members, FieldRoomTemperature, func(m Sensor) float32 { return m.RoomTemperature }, s.Valid)
+	s.TargetTemperature, s.Valid = v.aggregate(members, FieldTargetTemperature, func(m Sensor) float32 { return m.TargetTemperature }, s.Valid)
+
+	programs := true
+	modes := true
+	var readAt time.Time
+	for _, m := range members {
+		programs = programs && m.Valid.Has(FieldProgram) && m.Program == members[0].Program
+		modes = modes && m.Valid.Has(FieldMode) && m.Mode == members[0].Mode
+		if m.ReadAt.After(readAt) {
+			readAt = m.ReadAt
+		}
+		s.Stale = s.Stale || m.Stale
+	}
+	if programs {
+		s.Program = members[0].Program
+		s.Valid |= FieldProgram
+	}
+	if modes {
+		s.Mode = members[0].Mode
+		s.Valid |= FieldMode
+	}
+	s.ReadAt = readAt
+	return s
+}
+
+//aggregate combines a temperature of the members, and adds the field to valid if any member
+//reported it
+func (v VirtualSensor) aggregate(members []Sensor, field Field, value func(Sensor) float32, valid Field) (float32, Field) {
+	var result, sum float32
+	n := 0
+	for _, m := range members {
+		if !m.Valid.Has(field) {
+			continue
+		}
+		t := value(m)
+		switch {
+		case n == 0:
+			result = t
+		case v.Aggregate == AggregateMin && t < result:
+			result = t
+		case v.Aggregate == AggregateMax && t > result:
+			result = t
+		}
+		sum += t
+		n++
+	}
+	if n == 0 {
+		return 0, valid
+	}
+	if v.Aggregate == AggregateAverage {
+		result = sum / float32(n)
+	}
+	return result, valid | field
+}
+
+//splitVirtual returns the ids of the physical sensors to read for the given ids, with virtual
+//sensors replaced by their members, and whether there were any virtual sensors
+func (c *Client) splitVirtual(ids []int) (physical []int, virtual bool) {
+	seen := make(map[int]bool, len(ids))
+	add := func(id int) {
+		if !seen[id] {
+			seen[id] = true
+			physical = append(physical, id)
+		}
+	}
+	for _, id := range ids {
+		v, ok := c.virtualSensor(id)
+		if !ok {
+			add(id)
+			continue
+		}
+		virtual = true
+		for _, member := range v.Members {
+			add(member)
+		}
+	}
+	return physical, virtual
+}
+
+//selectSensors returns the sensors with the given ids, computing the virtual ones from the
+//physical sensors read. Physical ids not read are left out.
+func (c *Client) selectSensors(physical []Sensor, ids []int) []Sensor {
+	byID := make(map[int]Sensor, len(physical))
+	for _, s := range physical {
+		byID[s.Id] = s
+	}
+	sensors := make([]Sensor, 0, len(ids))
+	for _, id := range ids {
+		if v, ok := c.virtualSensor(id); ok {
+			sensors = append(sensors, v.compute(physical))
+		} else if s, ok := byID[id]; ok {
+			sensors = append(sensors, s)
+		}
+	}
+	return sensors
+}
+
+//expandVirtualWrites replaces the writes to virtual sensors by writes to their members
+func (c *Client) expandVirtualWrites(writes []datapointWrite) ([]datapointWrite, error) {
+	expanded := make([]datapointWrite, 0, len(writes))
+	for _, w := range writes {
+		if w.sensorID < FirstVirtualID {
+			expanded = append(expanded, w)
+			continue
+		}
+		v, ok := c.virtualSensor(w.sensorID)
+		if !ok {
+			return nil, fmt.Errorf("unknown virtual sensor %v", w.sensorID)
+		}
+		if !v.FanOut {
+			return nil, fmt.Errorf("virtual sensor %v is read-only", w.sensorID)
+		}
+		for _, id := range v.Members {
+			expanded = append(expanded, datapointWrite{id, w.datapoint, w.value})
+		}
+	}
+	return expanded, nil
+}
@@ -0,0 +1,246 @@
+package roth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//SensorChange describes a sensor whose values changed between two polls
+type SensorChange struct {
+	Previous Sensor `json:"previous"`
+	Current  Sensor `json:"current"`
+	//Fields is the set of fields which changed
+	Fields Field `json:"fields"`
+	//Origin is the origin of the last write to the sensor since the previous poll, see
+	//WithOrigin. It is empty for changes made on the thermostat, or by writes without an origin.
+	Origin string `json:"origin,omitempty"`
+}
+
+//Poll is the outcome of a single poll by a Watcher
+type Poll struct {
+	Time time.Time
+	//Sensors holds the readings, with the room temperature filtered if the watcher has filters
+	Sensors []Sensor
+	//Raw holds the readings as reported by the controller
+	Raw []Sensor
+	//Changes lists the sensors which changed since the previous successful poll. The first
+	//poll reports no changes, unless the last state was loaded with Watcher.Persist.
+	Changes []SensorChange
+	//Controller holds the state of the controller, if enabled with WatchControllerState
+	Controller *ControllerState
+	//ControllerChange is set if the state of the controller changed since the previous poll
+	ControllerChange *ControllerStateChange
+	//Err is set if the poll failed, in which case Sensors and Changes are empty
+	Err error
+}
+
+//ControllerStateChange describes a change of the controller state between two polls
+type ControllerStateChange struct {
+	Previous ControllerState `json:"previous"`
+	Current  ControllerState `json:"current"`
+}
+
+//Watcher polls the controller at a fixed interval, and passes every poll to its subscribers.
+//It is the basis for modules reacting to sensor state, like rules and alerts.
+type Watcher struct {
+	client   *Client
+	interval time.Duration
+
+	mu          sync.Mutex
+	subscribers []func(Poll)
+	last        map[int]Sensor
+	lastRaw     []Sensor
+	lastTime    time.Time
+	filters     filterSet
+	schedule    pollSchedule
+
+	watchController bool
+	lastController  *ControllerState
+
+	//changedAt holds when each field of a sensor last changed
+	changedAt map[int]map[Field]time.Time
+	storage   Storage
+
+	delta deltaState
+
+	runner Runner
+}
+
+//NewWatcher creates a watcher polling the controller at the given interval
+func NewWatcher(client *Client, interval time.Duration) *Watcher {
+	return &Watcher{client: client, interval: interval}
+}
+
+//Client returns the client used by the watcher
+func (w *Watcher) Client() *Client {
+	return w.client
+}
+
+//SetFilter installs a filter for the room temperature of a sensor, replacing any previous one
+func (w *Watcher) SetFilter(sensorID int, f Filter) {
+	w.filters.mu.Lock()
+	defer w.filters.mu.Unlock()
+	w.filters.set(sensorID, f)
+}
+
+//SetDefaultFilter installs filters for all sensors without a filter of their own. newFilter
+//is called once per sensor, as filters keep state.
+func (w *Watcher) SetDefaultFilter(newFilter func() Filter) {
+	w.filters.mu.Lock()
+	defer w.filters.mu.Unlock()
+	w.filters.newFilter = newFilter
+}
+
+//WatchControllerState makes every poll read the state of the controller as well, reported in
+//Poll.Controller and, when it changes, as a ControllerStateChanged event
+func (w *Watcher) WatchControllerState() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.watchController = true
+}
+
+//Subscribe registers a function called after every poll. Subscribers are called one at a time,
+//in the order they subscribed, and should return quickly.
+func (w *Watcher) Subscribe(fn func(Poll)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subscribers = append(w.subscribers, fn)
+}
+
+//Poll reads the controller once, and passes the result to all subscribers. With delta reads,
+//the sensors are only read if the controller reports changes, see SetDeltaReads.
+func (w *Watcher) Poll(ctx context.Context) Poll {
+	poll := Poll{Time: time.Now()}
+
+	token, delta := w.changeToken(ctx)
+	if delta && w.repeatLast(&poll, token) {
+		w.client.stats.update(func(s *Stats) { s.DeltaPolls++ })
+		return w.pollController(ctx, poll)
+	}
+	sensorCount, err := w.client.GetSensorCount(ctx)
+	if err == nil {
+		poll.Sensors, err = w.client.GetSensors(ctx, sensorCount)
+	}
+	if err != nil {
+		poll.Sensors = nil
+		poll.Err = err
+	} else {
+		if token != "" {
+			w.setToken(token, poll.Time, poll.Sensors)
+		}
+		poll.Raw = poll.Sensors
+		poll.Sensors = make([]Sensor, len(poll.Raw))
+		copy(poll.Sensors, poll.Raw)
+		w.filters.apply(poll.Sensors)
+		w.client.correctFrost(ctx, poll.Raw)
+	}
+	return w.pollController(ctx, poll)
+}
+
+//pollController reads the state of the controller into a poll if enabled, and delivers it
+func (w *Watcher) pollController(ctx context.Context, poll Poll) Poll {
+	w.mu.Lock()
+	watchController := w.watchController
+	w.mu.Unlock()
+	if watchController && poll.Err == nil {
+		//a failed read of the controller state does not fail the poll
+		if state, err := w.client.GetControllerState(ctx); err == nil {
+			poll.Controller = &state
+		} else if ctx.Err() == nil {
+			w.client.logf(LogWarning, "error reading controller state: %v", err)
+		}
+	}
+	return w.deliver(poll)
+}
+
+//deliver records a poll, and passes it to all subscribers
+func (w *Watcher) deliver(poll Poll) Poll {
+	w.mu.Lock()
+	var alarms []AlarmsChanged
+	if poll.Err == nil {
+		alarms = alarmChanges(poll, w.last, w.lastController)
+	}
+	//the state is stored when anything but the values of unchanged fields differs
+	changed := false
+	if poll.Controller != nil {
+		if w.lastController != nil && !w.lastController.Equal(*poll.Controller) {
+			poll.ControllerChange = &ControllerStateChange{Previous: *w.lastController, Current: *poll.Controller}
+		}
+		changed = w.lastController == nil || poll.ControllerChange != nil
+		w.lastController = poll.Controller
+	}
+	if poll.Err == nil {
+		current := make(map[int]Sensor, len(poll.Sensors))
+		for _, s := range poll.Sensors {
+			current[s.Id] = s
+			if previous, ok := w.last[s.Id]; ok {
+				if fields := changedFields(previous, s); fields != 0 {
+					origin := w.client.origins.since(s.Id, w.lastTime)
+					poll.Changes = append(poll.Changes, SensorChange{Previous: previous, Current: s, Fields: fields, Origin: origin})
+				}
+			} else {
+				changed = true
+			}
+		}
+		changed = changed || len(poll.Changes) > 0 || len(current) != len(w.last)
+		w.last = current
+		w.lastRaw = poll.Raw
+		w.lastTime = poll.Time
+		w.recordChanges(poll)
+	}
+	if changed {
+		w.save()
+	}
+	subscribers := make([]func(Poll), len(w.subscribers))
+	copy(subscribers, w.subscribers)
+	w.mu.Unlock()
+
+	for _, change := range poll.Changes {
+		w.client.Events().Publish(SensorChanged{Time: poll.Time, SensorChange: change})
+	}
+	if poll.ControllerChange != nil {
+		w.client.Events().Publish(ControllerStateChanged{Time: poll.Time, ControllerStateChange: *poll.ControllerChange})
+	}
+	for _, change := range alarms {
+		w.client.Events().Publish(change)
+	}
+	for _, fn := range subscribers {
+		fn(poll)
+	}
+	return poll
+}
+
+//changedFields returns the fields which differ between two readings of a sensor. A field
+//becoming valid or invalid counts as a change.
+func changedFields(a, b Sensor) Field {
+	var changed Field
+	for _, sf := range sensorFields {
+		f := sf.Field
+		if a.Valid.Has(f) != b.Valid.Has(f) {
+			changed |= f
+			continue
+		}
+		if !a.Valid.Has(f) {
+			continue
+		}
+
+		var differs bool
+		switch f {
+		case FieldName:
+			differs = a.Name != b.Name
+		case FieldRoomTemperature:
+			differs = a.RoomTemperature != b.RoomTemperature
+		case FieldTargetTemperature:
+			differs = a.TargetTemperature != b.TargetTemperature
+		case FieldProgram:
+			differs = a.Program != b.Program
+		case FieldMode:
+			differs = a.Mode != b.Mode
+		}
+		if differs {
+			changed |= f
+		}
+	}
+	return changed
+}
@@ -0,0 +1,85 @@
+package roth
+
+import (
+	"sort"
+	"time"
+)
+
+//watcherKey is the storage key of the last state seen by a Watcher
+const watcherKey = "watcher.json"
+
+//watcherState is the persisted form of the last state seen by a Watcher
+type watcherState struct {
+	Sensors    []Sensor         `json:"sensors"`
+	Controller *ControllerState `json:"controller,omitempty"`
+	//ChangedAt holds when each field of a sensor last changed, by sensor id and field
+	ChangedAt map[int]map[Field]time.Time `json:"changedAt,omitempty"`
+}
+
+//Persist loads the last state seen by the watcher from the storage, and stores it after every
+//poll with changes. After a restart, the first poll then only reports what changed while the
+//daemon was down, rather than nothing, and LastChanged survives restarts.
+func (w *Watcher) Persist(storage Storage) error {
+	var state watcherState
+	if _, err := LoadJSON(storage, watcherKey, &state); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(state.Sensors) > 0 {
+		w.last = make(map[int]Sensor, len(state.Sensors))
+		for _, s := range state.Sensors {
+			w.last[s.Id] = s
+		}
+	}
+	if state.Controller != nil {
+		w.lastController = state.Controller
+	}
+	if state.ChangedAt != nil {
+		w.changedAt = state.ChangedAt
+	}
+	w.storage = storage
+	return nil
+}
+
+//LastChanged returns when a field of a sensor last changed, as seen by the watcher. It is
+//false if no change was seen.
+func (w *Watcher) LastChanged(sensorID int, field Field) (time.Time, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	t, ok := w.changedAt[sensorID][field]
+	return t, ok
+}
+
+//recordChanges notes the time of the changes of a poll. The caller must hold w.mu.
+func (w *Watcher) recordChanges(poll Poll) {
+	for _, change := range poll.Changes {
+		id := change.Current.Id
+		if w.changedAt == nil {
+			w.changedAt = make(map[int]map[Field]time.Time)
+		}
+		if w.changedAt[id] == nil {
+			w.changedAt[id] = make(map[Field]time.Time)
+		}
+		for _, sf := range sensorFields {
+			if change.Fields.Has(sf.Field) {
+				w.changedAt[id][sf.Field] = poll.Time
+			}
+		}
+	}
+}
+
+//save stores the last state seen, if persisted. The caller must hold w.mu.
+func (w *Watcher) save() {
+	if w.storage == nil {
+		return
+	}
+	state := watcherState{Controller: w.lastController, ChangedAt: w.changedAt}
+	for _, s := range w.last {
+		state.Sensors = append(state.Sensors, s)
+	}
+	sort.Slice(state.Sensors, func(i, j int) bool { return state.Sensors[i].Id < state.Sensors[j].Id })
+	if err := StoreJSON(w.storage, watcherKey, state); err != nil {
+		w.client.logf(LogWarning, "error storing watcher state: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+//Package weather adjusts target temperatures to the outdoor temperature. The outdoor temperature
+//comes from any Source, e.g. a weather service or another sensor feed; the Compensator raises the
+//setpoints of configured rooms in cold weather and lowers them in mild weather.
+package weather
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+//Source provides the current outdoor temperature in °C
+type Source interface {
+	OutdoorTemperature(ctx context.Context) (float64, error)
+}
+
+//SourceFunc adapts an ordinary function to the Source interface
+type SourceFunc func(ctx context.Context) (float64, error)
+
+//OutdoorTemperature calls f(ctx)
+func (f SourceFunc) OutdoorTemperature(ctx context.Context) (float64, error) {
+	return f(ctx)
+}
+
+//Adjustment describes a setpoint computed by the compensator
+type Adjustment struct {
+	Time     time.Time
+	SensorID int
+	Outdoor  float64
+	Base     float32
+	Delta    float32
+	Setpoint float32
+	//Written is set if the setpoint differed from the controller and was written
+	Written bool
+	Err     error
+}
+
+//Compensator applies a linear heating curve: every degree the outdoor temperature is below
+//Reference raises the setpoints by Slope degrees, limited to MaxRaise; milder weather lowers
+//them, limited to MaxLower.
+type Compensator struct {
+	client *roth.Client
+	source Source
+
+	Reference float64
+	Slope     float64
+	MaxRaise  float64
+	MaxLower  float64
+
+	//OnAdjust is called for every computed adjustment, so decisions are transparent
+	OnAdjust func(Adjustment)
+
+	mu   sync.Mutex
+	base map[int]float32
+}
+
+//NewCompensator creates a compensator with a moderate curve: no adjustment at 5 °C outdoors,
+//0.1 °C per degree, at most 1.5 °C up and 1 °C down
+func NewCompensator(client *roth.Client, source Source) *Compensator {
+	return &Compensator{
+		client:    client,
+		source:    source,
+		Reference: 5,
+		Slope:     0.1,
+		MaxRaise:  1.5,
+		MaxLower:  1,
+		base:      make(map[int]float32),
+	}
+}
+
+//SetBase sets the setpoint of a sensor at the reference outdoor temperature, and includes the
+//sensor in compensation
+func (c *Compensator) SetBase(sensorID int, base float32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.base[sensorID] = base
+}
+
+//Remove excludes a sensor from compensation
+func (c *Compensator) Remove(sensorID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.base, sensorID)
+}
+
+//Delta returns the setpoint change for the given outdoor temperature
+func (c *Compensator) Delta(outdoor float64) float32 {
+	delta := (c.Reference - outdoor) * c.Slope
+	delta = math.Max(-c.MaxLower, math.Min(c.MaxRaise, delta))
+	//the controller works in tenths of a degree
+	return float32(math.Round(delta*10) / 10)
+}
+
+//Adjust reads the outdoor temperature and writes the compensated setpoint of every configured
+//sensor whose target temperature differs
+func (c *Compensator) Adjust(ctx context.Context) ([]Adjustment, error) {
+	outdoor, err := c.source.OutdoorTemperature(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sensorCount, err := c.client.GetSensorCount(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sensors, err := c.client.GetSensors(ctx, sensorCount)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	base := make(map[int]float32, len(c.base))
+	for id, b := range c.base {
+		base[id] = b
+	}
+	c.mu.Unlock()
+
+	delta := c.Delta(outdoor)
+	now := time.Now()
+	var adjustments []Adjustment
+	for _, s := range sensors {
+		b, ok := base[s.Id]
+		if !ok {
+			continue
+		}
+		a := Adjustment{Time: now, SensorID: s.Id, Outdoor: outdoor, Base: b, Delta: delta, Setpoint: b + delta}
+		if !s.Valid.Has(roth.FieldTargetTemperature) || math.Abs(float64(s.TargetTemperature-a.Setpoint)) >= 0.05 {
+			a.Err = c.client.SetTargetTemperature(ctx, s.Id, a.Setpoint)
+			a.Written = a.Err == nil
+		}
+		adjustments = append(adjustments, a)
+		if c.OnAdjust != nil {
+			c.OnAdjust(a)
+		}
+	}
+	return adjustments, nil
+}
+
+//Run adjusts the setpoints at the given interval until the context is cancelled
+func (c *Compensator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		c.Adjust(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,344 @@
+//Package webhook posts json payloads to configured urls whenever sensor values change or cross
+//a threshold. Payloads can be signed with HMAC-SHA256 and customized with templates, to connect
+//a Roth installation to services like Node-RED or IFTTT.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+//Event types
+const (
+	//EventSensorChanged is sent when any value of a sensor changes
+	EventSensorChanged = "sensor.changed"
+	//EventThresholdCrossed is sent when the room temperature of a sensor crosses a threshold
+	EventThresholdCrossed = "threshold.crossed"
+)
+
+//Event is the payload delivered to webhooks. Without a template, it is posted as json.
+type Event struct {
+	Type     string       `json:"type"`
+	Time     time.Time    `json:"time"`
+	Sensor   roth.Sensor  `json:"sensor"`
+	Previous *roth.Sensor `json:"previous,omitempty"`
+	//Fields lists the names of the changed fields, for EventSensorChanged
+	Fields []string `json:"fields,omitempty"`
+	//Origin is the origin of the write which caused the change, if any, e.g. "rule:night" or
+	//"user:alice", see roth.WithOrigin. It is empty for changes made on the thermostat.
+	Origin string `json:"origin,omitempty"`
+	//Threshold and Direction ("up" or "down") are set for EventThresholdCrossed
+	Threshold *float32 `json:"threshold,omitempty"`
+	Direction string   `json:"direction,omitempty"`
+}
+
+//Endpoint is a url receiving events
+type Endpoint struct {
+	URL string
+	//Secret signs the body with HMAC-SHA256. The hex encoded signature is sent in the
+	//X-Roth-Signature header as sha256=<signature>.
+	Secret string
+	//Template is a text/template rendering the body from an Event. If empty, the event is
+	//posted as json.
+	Template string
+	//ContentType of the body, application/json if empty
+	ContentType string
+	//Events lists the event types delivered to this endpoint; all types if empty
+	Events []string
+}
+
+type endpoint struct {
+	Endpoint
+	tmpl *template.Template
+}
+
+type threshold struct {
+	sensorID int
+	value    float32
+}
+
+//Dispatcher delivers events to endpoints from a background queue, retrying failed deliveries
+//with exponential backoff
+type Dispatcher struct {
+	endpoints []endpoint
+
+	//HTTPClient is used for the requests. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+	//MaxRetries is the number of retries after a failed delivery
+	MaxRetries int
+	//RetryDelay is the delay before the first retry, doubled for every further retry
+	RetryDelay time.Duration
+	//OnError is called when an event could not be delivered, or was dropped because the queue
+	//was full
+	OnError func(url string, e Event, err error)
+
+	queue chan Event
+	//queued counts the events queued and not yet delivered, for draining the queue on Stop
+	queued sync.WaitGroup
+	runner roth.Runner
+
+	mu         sync.Mutex
+	thresholds []threshold
+	stopped    bool
+}
+
+//NewDispatcher creates a dispatcher for the given endpoints. Templates are parsed here, so
+//errors in them are reported immediately.
+func NewDispatcher(endpoints ...Endpoint) (*Dispatcher, error) {
+	d := &Dispatcher{
+		MaxRetries: 3,
+		RetryDelay: time.Second,
+		queue:      make(chan Event, 100),
+	}
+	for _, e := range endpoints {
+		ep := endpoint{Endpoint: e}
+		if e.Template != "" {
+			tmpl, err := template.New(e.URL).Parse(e.Template)
+			if err != nil {
+				return nil, fmt.Errorf("invalid template for %v: %v", e.URL, err)
+			}
+			ep.tmpl = tmpl
+		}
+		d.endpoints = append(d.endpoints, ep)
+	}
+	return d, nil
+}
+
+//AddThreshold sends EventThresholdCrossed whenever the room temperature of the sensor crosses
+//the given value
+func (d *Dispatcher) AddThreshold(sensorID int, value float32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.thresholds = append(d.thresholds, threshold{sensorID, value})
+}
+
+//Attach queues events for the changes found by every poll of the watcher
+func (d *Dispatcher) Attach(w *roth.Watcher) {
+	w.Subscribe(func(p roth.Poll) {
+		for _, e := range d.Events(p) {
+			d.Enqueue(e)
+		}
+	})
+}
+
+//Events returns the events caused by the changes in a poll
+func (d *Dispatcher) Events(p roth.Poll) []Event {
+	d.mu.Lock()
+	thresholds := d.thresholds
+	d.mu.Unlock()
+
+	var events []Event
+	for _, c := range p.Changes {
+		previous := c.Previous
+		events = append(events, Event{
+			Type:     EventSensorChanged,
+			Time:     p.Time,
+			Sensor:   c.Current,
+			Previous: &previous,
+			Fields:   strings.Split(c.Fields.String(), "|"),
+			Origin:   c.Origin,
+		})
+
+		if !c.Fields.Has(roth.FieldRoomTemperature) || !c.Previous.Valid.Has(roth.FieldRoomTemperature) || !c.Current.Valid.Has(roth.FieldRoomTemperature) {
+			continue
+		}
+		for _, t := range thresholds {
+			if t.sensorID != c.Current.Id {
+				continue
+			}
+			before, after := c.Previous.RoomTemperature, c.Current.RoomTemperature
+			direction := ""
+			if before < t.value && after >= t.value {
+				direction = "up"
+			} else if before >= t.value && after < t.value {
+				direction = "down"
+			}
+			if direction != "" {
+				value := t.value
+				events = append(events, Event{
+					Type:      EventThresholdCrossed,
+					Time:      p.Time,
+					Sensor:    c.Current,
+					Previous:  &previous,
+					Threshold: &value,
+					Direction: direction,
+					Origin:    c.Origin,
+				})
+			}
+		}
+	}
+	return events
+}
+
+//Enqueue queues an event for delivery by Run. The event is dropped if the queue is full, or the
+//dispatcher was stopped.
+func (d *Dispatcher) Enqueue(e Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped {
+		if d.OnError != nil {
+			d.OnError("", e, roth.ErrStopped)
+		}
+		return
+	}
+	d.queued.Add(1)
+	select {
+	case d.queue <- e:
+	default:
+		d.queued.Done()
+		if d.OnError != nil {
+			d.OnError("", e, errors.New("webhook queue full, event dropped"))
+		}
+	}
+}
+
+//Run delivers queued events until the context is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-d.queue:
+			d.Send(ctx, e)
+			d.queued.Done()
+		}
+	}
+}
+
+//Start delivers queued events in the background, see Run. It accepts events again after Stop.
+func (d *Dispatcher) Start(ctx context.Context) error {
+	d.mu.Lock()
+	d.stopped = false
+	d.mu.Unlock()
+	return d.runner.Start(ctx, d.Run)
+}
+
+//Stop drops further events, waits until the queued events are delivered, and stops delivering.
+//Events still queued when ctx expires are not delivered.
+func (d *Dispatcher) Stop(ctx context.Context) error {
+	d.mu.Lock()
+	d.stopped = true
+	d.mu.Unlock()
+
+	if d.runner.Running() {
+		delivered := make(chan struct{})
+		go func() {
+			d.queued.Wait()
+			close(delivered)
+		}()
+		select {
+		case <-delivered:
+		case <-ctx.Done():
+			d.runner.Stop(ctx)
+			return ctx.Err()
+		}
+	}
+	return d.runner.Stop(ctx)
+}
+
+//Send delivers an event to all endpoints subscribed to its type, retrying failed deliveries
+func (d *Dispatcher) Send(ctx context.Context, e Event) {
+	for _, ep := range d.endpoints {
+		if !ep.wants(e.Type) {
+			continue
+		}
+
+		delay := d.RetryDelay
+		var err error
+		for attempt := 0; attempt <= d.MaxRetries; attempt++ {
+			if attempt > 0 {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return
+				}
+				delay *= 2
+			}
+			if err = d.deliver(ctx, ep, e); err == nil {
+				break
+			}
+		}
+		if err != nil && d.OnError != nil {
+			d.OnError(ep.URL, e, err)
+		}
+	}
+}
+
+func (ep endpoint) wants(eventType string) bool {
+	if len(ep.Events) == 0 {
+		return true
+	}
+	for _, t := range ep.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, ep endpoint, e Event) error {
+	var body []byte
+	if ep.tmpl != nil {
+		var b bytes.Buffer
+		if err := ep.tmpl.Execute(&b, e); err != nil {
+			return err
+		}
+		body = b.Bytes()
+	} else {
+		var err error
+		if body, err = json.Marshal(e); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	contentType := ep.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Roth-Event", e.Type)
+	if ep.Secret != "" {
+		req.Header.Set("X-Roth-Signature", "sha256="+Sign([]byte(ep.Secret), body))
+	}
+
+	client := d.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %v", resp.Status)
+	}
+	return nil
+}
+
+//Sign returns the hex encoded HMAC-SHA256 of body, as sent in the X-Roth-Signature header.
+//Receivers can use it to verify payloads.
+func Sign(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,15 @@
+package roth
+
+import "github.com/kvantetore/rothTouchline/protocol"
+
+//The wire format of the controller is implemented by the protocol package. These aliases keep
+//the names used throughout this package.
+type (
+	readRequest     = protocol.ReadRequest
+	readRequestItem = protocol.RequestItem
+	response        = protocol.Response
+	responseItem    = protocol.Item
+)
+
+//XMLError is returned when a controller response is not well-formed xml, see protocol.XMLError
+type XMLError = protocol.XMLError
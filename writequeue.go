@@ -0,0 +1,157 @@
+package roth
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//WriteQueue coalesces rapid successive writes to the same datapoint, e.g. from a slider in a
+//user interface. A value is only sent to the controller once no new value for the same
+//datapoint has been queued for the configured delay, so only the final value is written.
+type WriteQueue struct {
+	client *Client
+	delay  time.Duration
+
+	//OnError is called when a queued write fails. It must be set before values are queued.
+	OnError func(sensorID int, datapoint string, err error)
+
+	mu      sync.Mutex
+	pending map[writeKey]*pendingWrite
+	wg      sync.WaitGroup
+	stopped bool
+
+	runner Runner
+}
+
+type writeKey struct {
+	sensorID  int
+	datapoint string
+}
+
+type pendingWrite struct {
+	value    string
+	timer    *time.Timer
+	inFlight bool
+	//queued is set when a new value arrives while the previous one is being written
+	queued bool
+}
+
+//NewWriteQueue creates a write queue sending values through the given client after delay
+func NewWriteQueue(client *Client, delay time.Duration) *WriteQueue {
+	return &WriteQueue{
+		client:  client,
+		delay:   delay,
+		pending: make(map[writeKey]*pendingWrite),
+	}
+}
+
+//SetTargetTemperature queues a change of the target temperature of a given sensor. An invalid
+//target is reported to OnError without being queued.
+func (q *WriteQueue) SetTargetTemperature(sensorID int, targetTemperature float32) {
+	value, err := q.client.formatTarget(sensorID, targetTemperature)
+	if err != nil {
+		q.reject(sensorID, "SollTemp", err)
+		return
+	}
+	q.enqueue(sensorID, "SollTemp", value)
+}
+
+//SetProgram queues a change of the active week program of the thermostat. An invalid
+//program is reported to OnError without being queued.
+func (q *WriteQueue) SetProgram(sensorID int, program Program) {
+	if err := program.check(); err != nil {
+		q.reject(sensorID, "WeekProg", err)
+		return
+	}
+	q.enqueue(sensorID, "WeekProg", strconv.Itoa(int(program)))
+}
+
+//SetMode queues a change of the active operating mode. An invalid mode is reported to
+//OnError without being queued.
+func (q *WriteQueue) SetMode(sensorID int, mode Mode) {
+	if err := mode.check(); err != nil {
+		q.reject(sensorID, "OPMode", err)
+		return
+	}
+	q.enqueue(sensorID, "OPMode", strconv.Itoa(int(mode)))
+}
+
+func (q *WriteQueue) reject(sensorID int, datapoint string, err error) {
+	if q.OnError != nil {
+		q.OnError(sensorID, datapoint, err)
+	}
+}
+
+func (q *WriteQueue) enqueue(sensorID int, datapoint string, value string) {
+	q.mu.Lock()
+	if q.stopped {
+		q.mu.Unlock()
+		q.reject(sensorID, datapoint, ErrStopped)
+		return
+	}
+	defer q.mu.Unlock()
+
+	key := writeKey{sensorID, datapoint}
+	p, ok := q.pending[key]
+	if !ok {
+		p = &pendingWrite{}
+		q.pending[key] = p
+		q.wg.Add(1)
+	}
+	p.value = value
+	if p.inFlight {
+		p.queued = true
+		return
+	}
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	p.timer = time.AfterFunc(q.delay, func() { q.send(key) })
+}
+
+//send writes the pending value of a datapoint, and reschedules it if a newer value was
+//queued while writing
+func (q *WriteQueue) send(key writeKey) {
+	q.mu.Lock()
+	p, ok := q.pending[key]
+	if !ok || p.inFlight {
+		q.mu.Unlock()
+		return
+	}
+	p.inFlight = true
+	p.timer = nil
+	value := p.value
+	q.mu.Unlock()
+
+	err := q.client.writeValue(context.Background(), key.sensorID, key.datapoint, value)
+	if err != nil && q.OnError != nil {
+		q.OnError(key.sensorID, key.datapoint, err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	p.inFlight = false
+	if p.queued {
+		p.queued = false
+		p.timer = time.AfterFunc(q.delay, func() { q.send(key) })
+		return
+	}
+	delete(q.pending, key)
+	q.wg.Done()
+}
+
+//Flush sends all pending values immediately, and waits until they are written
+func (q *WriteQueue) Flush() {
+	q.mu.Lock()
+	for key, p := range q.pending {
+		if p.timer != nil && p.timer.Stop() {
+			key := key
+			go q.send(key)
+		}
+	}
+	q.mu.Unlock()
+
+	q.wg.Wait()
+}
@@ -0,0 +1,203 @@
+//Package zone groups Roth sensors into named zones ("Upstairs", "Ground floor"), with aggregate
+//statistics and setters fanning out to all members of a zone.
+package zone
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	roth "github.com/kvantetore/rothTouchline"
+)
+
+//Zone is a named group of sensors
+type Zone struct {
+	Name      string `json:"name"`
+	SensorIDs []int  `json:"sensors"`
+}
+
+//Stats are aggregate values over the sensors of a zone. Only sensors reporting a valid room
+//temperature are included in the temperature statistics.
+type Stats struct {
+	Zone string
+	//Sensors is the number of sensors included in the temperature statistics
+	Sensors                int
+	AverageRoomTemperature float32
+	MinRoomTemperature     float32
+	MaxRoomTemperature     float32
+	//OpenValves is the number of sensors whose valve is open
+	OpenValves int
+}
+
+//Compute aggregates the given sensors over the members of the zone
+func (z Zone) Compute(sensors []roth.Sensor) Stats {
+	members := make(map[int]bool, len(z.SensorIDs))
+	for _, id := range z.SensorIDs {
+		members[id] = true
+	}
+
+	stats := Stats{Zone: z.Name}
+	var sum float32
+	for _, s := range sensors {
+		if !members[s.Id] {
+			continue
+		}
+		if s.Valid.Has(roth.FieldRoomTemperature|roth.FieldTargetTemperature) && s.GetValveState() == roth.ValveOpen {
+			stats.OpenValves++
+		}
+		if !s.Valid.Has(roth.FieldRoomTemperature) {
+			continue
+		}
+
+		if stats.Sensors == 0 || s.RoomTemperature < stats.MinRoomTemperature {
+			stats.MinRoomTemperature = s.RoomTemperature
+		}
+		if stats.Sensors == 0 || s.RoomTemperature > stats.MaxRoomTemperature {
+			stats.MaxRoomTemperature = s.RoomTemperature
+		}
+		sum += s.RoomTemperature
+		stats.Sensors++
+	}
+	if stats.Sensors > 0 {
+		stats.AverageRoomTemperature = sum / float32(stats.Sensors)
+	}
+	return stats
+}
+
+//Registry holds the zones of a controller
+type Registry struct {
+	client *roth.Client
+
+	mu    sync.Mutex
+	zones map[string]Zone
+}
+
+//NewRegistry creates an empty registry for the given client
+func NewRegistry(client *roth.Client) *Registry {
+	return &Registry{client: client, zones: make(map[string]Zone)}
+}
+
+//LoadFile creates a registry with the zones defined in a json file, e.g.
+//[{"name": "Upstairs", "sensors": [0, 1, 2]}]
+func LoadFile(client *roth.Client, path string) (*Registry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var zones []Zone
+	if err := json.Unmarshal(data, &zones); err != nil {
+		return nil, fmt.Errorf("error parsing zones in %v: %v", path, err)
+	}
+
+	r := NewRegistry(client)
+	for _, z := range zones {
+		r.Define(z.Name, z.SensorIDs...)
+	}
+	return r, nil
+}
+
+//Define creates or replaces a zone
+func (r *Registry) Define(name string, sensorIDs ...int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := make([]int, len(sensorIDs))
+	copy(ids, sensorIDs)
+	r.zones[name] = Zone{Name: name, SensorIDs: ids}
+}
+
+//Remove deletes a zone
+func (r *Registry) Remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.zones, name)
+}
+
+//Zone returns the zone with the given name
+func (r *Registry) Zone(name string) (Zone, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	z, ok := r.zones[name]
+	if !ok {
+		return Zone{}, fmt.Errorf("unknown zone %v", name)
+	}
+	return z, nil
+}
+
+//Zones returns all zones, sorted by name
+func (r *Registry) Zones() []Zone {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	zones := make([]Zone, 0, len(r.zones))
+	for _, z := range r.zones {
+		zones = append(zones, z)
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].Name < zones[j].Name })
+	return zones
+}
+
+//ZonesOf returns the names of the zones the given sensor is a member of
+func (r *Registry) ZonesOf(sensorID int) []string {
+	var names []string
+	for _, z := range r.Zones() {
+		for _, id := range z.SensorIDs {
+			if id == sensorID {
+				names = append(names, z.Name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+//Stats reads the controller and returns the statistics of every zone, sorted by zone name
+func (r *Registry) Stats(ctx context.Context) ([]Stats, error) {
+	sensorCount, err := r.client.GetSensorCount(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sensors, err := r.client.GetSensors(ctx, sensorCount)
+	if err != nil {
+		return nil, err
+	}
+
+	zones := r.Zones()
+	stats := make([]Stats, len(zones))
+	for i, z := range zones {
+		stats[i] = z.Compute(sensors)
+	}
+	return stats, nil
+}
+
+func (r *Registry) send(ctx context.Context, name string, add func(b *roth.Batch, id int)) (roth.BulkResult, error) {
+	z, err := r.Zone(name)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := r.client.NewBatch()
+	for _, id := range z.SensorIDs {
+		add(batch, id)
+	}
+	return batch.Send(ctx), nil
+}
+
+//SetTargetTemperature changes the target temperature of every sensor in the zone
+func (r *Registry) SetTargetTemperature(ctx context.Context, name string, targetTemperature float32) (roth.BulkResult, error) {
+	return r.send(ctx, name, func(b *roth.Batch, id int) { b.SetTargetTemperature(id, targetTemperature) })
+}
+
+//SetMode changes the operating mode of every sensor in the zone
+func (r *Registry) SetMode(ctx context.Context, name string, mode roth.Mode) (roth.BulkResult, error) {
+	return r.send(ctx, name, func(b *roth.Batch, id int) { b.SetMode(id, mode) })
+}
+
+//SetProgram changes the week program of every sensor in the zone
+func (r *Registry) SetProgram(ctx context.Context, name string, program roth.Program) (roth.BulkResult, error) {
+	return r.send(ctx, name, func(b *roth.Batch, id int) { b.SetProgram(id, program) })
+}